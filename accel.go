@@ -0,0 +1,139 @@
+package sakura
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultAcceleratorBatch is the number of leaves per batch if
+// Encoder.AcceleratorBatch is zero, rounded up to a multiple of the lanes of a
+// LaneAccelerator.
+const defaultAcceleratorBatch = 64
+
+// Accelerator hashes batches of leaves on hardware such as a GPU or an FPGA.
+// It only sees the exact inputs of the hash function, the leaves coded as
+// inner nodes by the encoder, so that offloading them leaves the tree logic,
+// and the hashes, as they are.
+type Accelerator interface {
+	// Submit starts hashing nodes with the hash function of the mode and
+	// returns without waiting for it. It must eventually call done exactly
+	// once, from any goroutine, with the hash of every node in order, or
+	// with an error. Nodes must not be modified, nor retained once done has
+	// been called.
+	Submit(nodes [][]byte, done func(sums [][]byte, err error))
+}
+
+// codedLeaf returns the coding of data as an inner node, which is the input of
+// the hash function for a leaf.
+func (e *Encoder) codedLeaf(data []byte) ([]byte, error) {
+	if _, ok := e.mode.Padding.(HashPadding); ok {
+		return nil, errors.New("sakura: accelerator cannot hash nodes padded by the hash function")
+	}
+	c := &nodeBytes{b: make([]byte, 0, len(data)+8)}
+	w := &bitWriter{h: c}
+	writeLeaf(w, e.mode, data)
+	if cd := e.mode.Coding; cd != nil {
+		cd.Inner(w)
+	} else {
+		w.writeBit(1) // pad_simple, which needs no alignment here.
+		w.writeBit(0)
+	}
+	return w.sum(nil, e.mode.Padding), nil
+}
+
+// nodeBytes is a hash.Hash whose sum is its input.
+type nodeBytes struct{ b []byte }
+
+func (n *nodeBytes) Write(p []byte) (int, error) {
+	n.b = append(n.b, p...)
+	return len(p), nil
+}
+
+func (n *nodeBytes) Sum(b []byte) []byte { return append(b, n.b...) }
+func (n *nodeBytes) Reset()              { n.b = n.b[:0] }
+func (n *nodeBytes) Size() int           { return 0 }
+func (n *nodeBytes) BlockSize() int      { return 1 }
+
+// pendingLeaf is a leaf waiting for its batch to be submitted.
+type pendingLeaf struct {
+	i    int
+	n    int    // Length of the leaf.
+	node []byte // Leaf coded as an inner node.
+}
+
+// batch adds leaf i to the current batch of the accelerator, submitting the
+// batch once it is full. A full batch waits for a free slot like a leaf
+// waits for a worker.
+func (p *leafPool) batch(i int, data []byte, pooled, wait bool, deadline time.Time) error {
+	node, err := p.e.codedLeaf(data)
+	if err != nil {
+		return err
+	}
+	full := len(p.pending)+1 >= p.batchSize
+	if full {
+		if err := p.acquire(wait, deadline); err != nil {
+			return err
+		}
+	}
+	p.grow(i)
+	p.pending = append(p.pending, pendingLeaf{i: i, n: len(data), node: node})
+	if pooled {
+		p.e.Buffers.put(data) // The coded node holds a copy.
+	}
+	if full {
+		p.submit()
+	}
+	return nil
+}
+
+// submit hands the pending leaves to the accelerator, which holds a slot.
+func (p *leafPool) submit() {
+	batch := p.pending
+	p.pending = nil
+	nodes := make([][]byte, len(batch))
+	for k, l := range batch {
+		nodes[k] = l.node
+	}
+	p.wg.Add(1)
+	p.e.Accelerator.Submit(nodes, func(sums [][]byte, err error) {
+		p.finish(batch, sums, err)
+	})
+}
+
+// finish records the hashes of a batch returned by the accelerator.
+func (p *leafPool) finish(batch []pendingLeaf, sums [][]byte, err error) {
+	defer func() { <-p.sem; p.wg.Done() }()
+	if err != nil {
+		err = fmt.Errorf("sakura: accelerator: %w", err)
+	} else if len(sums) != len(batch) {
+		err = fmt.Errorf("sakura: accelerator returned %d hashes for %d leaves", len(sums), len(batch))
+	} else {
+		size := p.e.mode.Hash().Size()
+		for _, s := range sums {
+			if len(s) != size {
+				err = fmt.Errorf("sakura: accelerator returned a hash of %d bytes, not %d", len(s), size)
+				break
+			}
+		}
+	}
+	if err == nil && p.onLeaf != nil {
+		p.emitMu.Lock()
+		for k, l := range batch {
+			p.onLeaf(l.i, int64(l.i)*int64(p.leafSize), l.n, sums[k])
+		}
+		p.emitMu.Unlock()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		if p.err == nil {
+			p.err = err
+			close(p.done)
+		}
+		return
+	}
+	for k, l := range batch {
+		p.cvs[l.i-p.base] = sums[k]
+	}
+}
@@ -0,0 +1,282 @@
+package sakura
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// ArchiveEntry is an entry of an archive hashed by HashTar or HashZip.
+type ArchiveEntry struct {
+	Name string      // Name of the entry, as stored in the archive.
+	Type fs.FileMode // fs.ModeDir, fs.ModeSymlink, or zero for a regular file.
+	Size int64       // Number of bytes of the contents of the entry.
+
+	// ChainingValue is the chaining value of the message hop of the entry,
+	// as it would be computed were the entry hashed on its own by Inner.
+	ChainingValue []byte
+}
+
+// HashTar hashes the tar archive read from r, without extracting it, and
+// returns the root of the archive along with the entries it holds, in
+// archive order. The archive is a chaining hop with one message hop per
+// entry, whose bits are the header of the entry, as in HashFS, prefixed with
+// its length as an unsigned varint, followed by the contents of the entry:
+// the data of a regular file, the target of a symbolic link, and nothing for
+// a directory. Entries of other types fail with ErrUnsupportedFile, and
+// global headers are skipped.
+//
+// The archive is read once, in order, and the entries are hashed as they are
+// read, on the calling goroutine whatever the parallelism of e, so that the
+// memory of HashTar does not grow with the size of the entries. Under
+// Kangaroo hopping the first entry is nested in the final node, and its own
+// chaining value is computed alongside.
+func (e *Encoder) HashTar(r io.Reader) ([]byte, []ArchiveEntry, error) {
+	s := &tarStream{e: e, tr: tar.NewReader(r)}
+	sum, err := e.finalSerial(s)
+	if s.first != nil {
+		if ferr := s.first.wait(); err == nil {
+			err = ferr
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return sum, archiveEntries(s.kids), nil
+}
+
+// HashZip hashes the zip archive of size bytes read from r, without
+// extracting it, and returns the root of the archive along with the entries
+// it holds, in archive order. The archive is hashed as HashTar hashes a tar
+// archive with the same entries, to the same root. Since the entries of a zip
+// archive can be read in any order, they are hashed with the parallelism of
+// e, and the first entry is read again under Kangaroo hopping to compute its
+// own chaining value.
+func (e *Encoder) HashZip(r io.ReaderAt, size int64) ([]byte, []ArchiveEntry, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	kids := make([]*archiveLeaf, 0, len(zr.File))
+	hops := make([]Hop, 0, len(zr.File))
+	for _, f := range zr.File {
+		l, err := zipLeaf(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		kids = append(kids, l)
+		hops = append(hops, l)
+	}
+	sum, err := e.Final(&chainingLeaves{kids: hops})
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.mode.Kangaroo && len(kids) > 0 {
+		l, err := zipLeaf(zr.File[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		if kids[0].cv, err = e.Inner(l); err != nil {
+			return nil, nil, err
+		}
+	}
+	return sum, archiveEntries(kids), nil
+}
+
+// archiveEntries returns the entries of the hashed leaves of an archive.
+func archiveEntries(kids []*archiveLeaf) []ArchiveEntry {
+	entries := make([]ArchiveEntry, len(kids))
+	for i, l := range kids {
+		entries[i] = ArchiveEntry{Name: l.name, Type: l.typ, Size: l.size, ChainingValue: l.cv}
+	}
+	return entries
+}
+
+// archiveLeaf is the message hop of an archive entry.
+type archiveLeaf struct {
+	io.Reader
+	name string
+	typ  fs.FileMode
+	size int64
+	cv   []byte
+}
+
+// newArchiveLeaf returns the leaf of an entry whose contents, of size bytes,
+// are read from contents.
+func newArchiveLeaf(name string, typ fs.FileMode, size int64, contents io.Reader) (*archiveLeaf, error) {
+	var kind byte
+	switch typ {
+	case 0:
+		kind = entryFile
+	case fs.ModeDir:
+		kind = entryDir
+	case fs.ModeSymlink:
+		kind = entrySymlink
+	default:
+		return nil, &fs.PathError{Op: "hash", Path: name, Err: ErrUnsupportedFile}
+	}
+	header := bytes.NewReader(appendBytes(nil, entryHeader(kind, name)))
+	return &archiveLeaf{Reader: io.MultiReader(header, contents), name: name, typ: typ, size: size}, nil
+}
+
+func (l *archiveLeaf) Label() string                { return l.name }
+func (l *archiveLeaf) ChainingValue() []byte        { return l.cv }
+func (l *archiveLeaf) SetChainingValue(hash []byte) { l.cv = hash }
+
+// zipLeaf returns the leaf of a zip entry, which opens the entry on the first
+// read.
+func zipLeaf(f *zip.File) (*archiveLeaf, error) {
+	var typ fs.FileMode
+	switch m := f.Mode(); {
+	case m.IsDir():
+		typ = fs.ModeDir
+	case m&fs.ModeSymlink != 0:
+		typ = fs.ModeSymlink
+	case !m.IsRegular():
+		typ = m.Type()
+	}
+	size := int64(f.UncompressedSize64)
+	if typ == fs.ModeDir {
+		size = 0
+	}
+	return newArchiveLeaf(f.Name, typ, size, &zipContents{f: f, dir: typ == fs.ModeDir})
+}
+
+// zipContents reads the contents of a zip entry, opening it on the first read
+// and closing it at the end.
+type zipContents struct {
+	f    *zip.File
+	dir  bool
+	rc   io.ReadCloser
+	done bool
+}
+
+func (z *zipContents) Read(p []byte) (int, error) {
+	if z.done || z.dir {
+		return 0, io.EOF
+	}
+	if z.rc == nil {
+		rc, err := z.f.Open()
+		if err != nil {
+			return 0, err
+		}
+		z.rc = rc
+	}
+	n, err := z.rc.Read(p)
+	if err == io.EOF {
+		z.done = true
+		if cerr := z.rc.Close(); cerr != nil {
+			err = cerr
+		}
+		z.rc = nil
+	}
+	return n, err
+}
+
+// tarStream is the ChildStream of the entries of a tar archive.
+type tarStream struct {
+	e     *Encoder
+	tr    *tar.Reader
+	kids  []*archiveLeaf
+	first *teeLeaf // Hashes the first entry on its own under Kangaroo hopping.
+	cv    []byte
+}
+
+func (s *tarStream) Label() string                { return "tar archive" }
+func (s *tarStream) ChainingValue() []byte        { return s.cv }
+func (s *tarStream) SetChainingValue(hash []byte) { s.cv = hash }
+
+// Next returns the leaf of the next entry, whose contents are read from the
+// archive, so it must be read to the end before Next is called again.
+func (s *tarStream) Next() (Hop, error) {
+	for {
+		hdr, err := s.tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		var l *archiveLeaf
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg:
+			l, err = newArchiveLeaf(hdr.Name, 0, hdr.Size, s.tr)
+		case tar.TypeDir:
+			l, err = newArchiveLeaf(hdr.Name, fs.ModeDir, 0, strings.NewReader(""))
+		case tar.TypeSymlink:
+			l, err = newArchiveLeaf(hdr.Name, fs.ModeSymlink, int64(len(hdr.Linkname)), strings.NewReader(hdr.Linkname))
+		default:
+			err = &fs.PathError{Op: "hash", Path: hdr.Name, Err: ErrUnsupportedFile}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(s.kids) == 0 && s.e.mode.Kangaroo {
+			s.first = newTeeLeaf(s.e, l)
+		}
+		s.kids = append(s.kids, l)
+		return l, nil
+	}
+}
+
+// errArchiveAbandoned stops the hashing of the first entry of a tar archive
+// whose root failed before the entry was read to the end.
+var errArchiveAbandoned = errors.New("sakura: archive hashing stopped")
+
+// teeLeaf hashes a leaf on its own, on another goroutine, from the bits that
+// the final node reads from it.
+type teeLeaf struct {
+	l    *archiveLeaf
+	pw   *io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+// newTeeLeaf starts hashing l, whose reader it replaces with one that copies
+// the bits read to the hashing goroutine.
+func newTeeLeaf(e *Encoder, l *archiveLeaf) *teeLeaf {
+	pr, pw := io.Pipe()
+	t := &teeLeaf{l: l, pw: pw, done: make(chan struct{})}
+	src := l.Reader
+	go func() {
+		defer close(t.done)
+		cv, err := e.Inner(&archiveLeaf{Reader: pr, name: l.name})
+		pr.CloseWithError(err)
+		t.l.cv, t.err = cv, err
+	}()
+	l.Reader = &teeReader{r: src, pw: pw}
+	return t
+}
+
+// wait returns once the leaf is hashed, stopping it if it was not read to
+// the end.
+func (t *teeLeaf) wait() error {
+	t.pw.CloseWithError(errArchiveAbandoned)
+	<-t.done
+	return t.err
+}
+
+// teeReader copies the bits read from r to pw, which it closes at the end.
+type teeReader struct {
+	r  io.Reader
+	pw *io.PipeWriter
+}
+
+func (t *teeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if _, werr := t.pw.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	switch {
+	case err == io.EOF:
+		t.pw.Close()
+	case err != nil:
+		t.pw.CloseWithError(err)
+	}
+	return n, err
+}
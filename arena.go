@@ -0,0 +1,37 @@
+package sakura
+
+import "sync"
+
+// Sizes of the blocks of an arena, which double from the smallest to the
+// largest.
+const (
+	minArenaBlock = 512
+	maxArenaBlock = 64 << 10
+)
+
+// arena hands out the chaining values computed by a job from a few large
+// blocks instead of allocating every value on its own, which matters for trees
+// of many tiny leaves. It only grows: a block is freed as a whole by the
+// garbage collector once none of the values cut from it is referenced, which
+// is after the call returns unless hops keep the values passed to
+// SetChainingValue. The final hash is not taken from the arena, so holding on
+// to a root does not keep the values of its tree alive.
+type arena struct {
+	mu    sync.Mutex
+	block []byte // Unused part of the current block.
+	size  int    // Size of the current block.
+}
+
+// alloc returns an empty slice with a capacity of n bytes, which does not
+// overlap any other slice handed out by a.
+func (a *arena) alloc(n int) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.block) < n {
+		a.size = min(max(2*a.size, minArenaBlock), maxArenaBlock)
+		a.block = make([]byte, max(a.size, n))
+	}
+	v := a.block[:0:n]
+	a.block = a.block[n:]
+	return v
+}
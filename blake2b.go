@@ -0,0 +1,147 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// blake2bIV is the initialization vector of BLAKE2b, that of SHA-512.
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+// blake2bSigma are the message permutations of the rounds of BLAKE2b, of which
+// the last two repeat the first two.
+var blake2bSigma = [12][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+}
+
+// blake2bParams is the parameter block of BLAKE2b, of section 2.5 of RFC 7693
+// and section 2.8 of the BLAKE2 paper, without a key.
+type blake2bParams struct {
+	size        byte // Digest length.
+	fanout      byte
+	depth       byte
+	leafLength  uint32
+	nodeOffset  uint64
+	nodeDepth   byte
+	innerLength byte
+	salt        [16]byte
+	personal    [16]byte
+	last        bool // Whether the node is the last of its level.
+}
+
+// blake2b is BLAKE2b as a hash.Hash, for the nodes of BLAKE2Tree. It is only
+// written for those, not for speed.
+type blake2b struct {
+	p    blake2bParams
+	h    [8]uint64
+	t    uint64 // Bytes compressed; inputs here stay below 2^64 bytes.
+	buf  [128]byte
+	nbuf int
+}
+
+func newBLAKE2b(p blake2bParams) *blake2b {
+	b := &blake2b{p: p}
+	b.Reset()
+	return b
+}
+
+func (b *blake2b) Reset() {
+	var block [64]byte
+	block[0], block[2], block[3] = b.p.size, b.p.fanout, b.p.depth
+	binary.LittleEndian.PutUint32(block[4:], b.p.leafLength)
+	binary.LittleEndian.PutUint64(block[8:], b.p.nodeOffset)
+	block[16], block[17] = b.p.nodeDepth, b.p.innerLength
+	copy(block[32:], b.p.salt[:])
+	copy(block[48:], b.p.personal[:])
+	for i := range b.h {
+		b.h[i] = blake2bIV[i] ^ binary.LittleEndian.Uint64(block[8*i:])
+	}
+	b.t, b.nbuf = 0, 0
+}
+
+func (b *blake2b) Size() int      { return int(b.p.size) }
+func (b *blake2b) BlockSize() int { return len(b.buf) }
+
+func (b *blake2b) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// The last block is only compressed by Sum, which flags it as such.
+		if b.nbuf == len(b.buf) {
+			b.t += uint64(len(b.buf))
+			b.compress(&b.h, false)
+			b.nbuf = 0
+		}
+		k := copy(b.buf[b.nbuf:], p)
+		b.nbuf += k
+		p = p[k:]
+	}
+	return n, nil
+}
+
+func (b *blake2b) Sum(in []byte) []byte {
+	c := *b
+	c.t += uint64(c.nbuf)
+	clear(c.buf[c.nbuf:])
+	c.compress(&c.h, true)
+	var out [64]byte
+	for i, v := range c.h {
+		binary.LittleEndian.PutUint64(out[8*i:], v)
+	}
+	return append(in, out[:b.p.size]...)
+}
+
+// compress compresses the buffered block into h, as the final block of the
+// node if final is set.
+func (b *blake2b) compress(h *[8]uint64, final bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(b.buf[8*i:])
+	}
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= b.t
+	if final {
+		v[14] = ^v[14]
+		if b.p.last {
+			v[15] = ^v[15]
+		}
+	}
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for _, s := range blake2bSigma {
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
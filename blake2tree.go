@@ -0,0 +1,304 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// BLAKE2Tree describes a tree hash of BLAKE2b, as of section 2.10 of the
+// BLAKE2 paper, so that digests computed by other implementations of BLAKE2
+// tree hashing can be verified, whole or leaf by leaf. Such trees do not code
+// their nodes the Sakura way: every node is a BLAKE2b hash whose parameter
+// block holds the tree parameters and the position of the node, so a
+// HashingMode cannot describe them, whatever its Hasher.
+//
+// The message is cut into leaves of LeafSize bytes, the last one shorter and
+// at least one. A node of level k above the leaves hashes the digests of
+// Fanout consecutive nodes of level k-1, the last one of a level fewer, and
+// the level that is the only one or the MaxDepth-th hashes all the nodes
+// below it; that single node is the root. Every node has the node offset of
+// its index in its level, the node depth of its level and the last node flag
+// if it is the last of its level, and all but the root digests of InnerSize
+// bytes. A message of a single leaf is hashed as that leaf alone, the root.
+//
+// This is the layout of the tree hashing example of the Python hashlib
+// documentation, and of BLAKE2 implementations hashing contiguous leaves. The
+// interleaved leaves of BLAKE2bp are not covered.
+type BLAKE2Tree struct {
+	Size      int // Bytes of the root digest, 1 to 64, or 64 if zero.
+	InnerSize int // Bytes of the digests of the other nodes, 1 to 64, or 64 if zero.
+	Fanout    int // Children of a node, 2 to 255, or 0 for no limit.
+	MaxDepth  int // Levels of the tree, 1 to 255, or 255, for no limit, if zero.
+	LeafSize  int // Bytes of a leaf, up to 2^32-1, or 0 for a single leaf.
+
+	// Salt and Personal are the salt and personalization of every node, of up
+	// to 16 bytes each.
+	Salt     []byte
+	Personal []byte
+}
+
+// check returns an error if t is not a valid tree.
+func (t BLAKE2Tree) check() error {
+	switch {
+	case t.Size < 0 || t.Size > 64 || t.InnerSize < 0 || t.InnerSize > 64:
+		return errors.New("sakura: BLAKE2 digest size out of range")
+	case t.Fanout < 0 || t.Fanout == 1 || t.Fanout > 255:
+		return errors.New("sakura: BLAKE2 fanout out of range")
+	case t.MaxDepth < 0 || t.MaxDepth > 255:
+		return errors.New("sakura: BLAKE2 depth out of range")
+	case t.LeafSize < 0 || int64(t.LeafSize) > math.MaxUint32:
+		return errors.New("sakura: BLAKE2 leaf size out of range")
+	case len(t.Salt) > 16 || len(t.Personal) > 16:
+		return errors.New("sakura: BLAKE2 salt or personalization longer than 16 bytes")
+	}
+	return nil
+}
+
+func (t BLAKE2Tree) innerSize() int {
+	if t.InnerSize == 0 {
+		return 64
+	}
+	return t.InnerSize
+}
+
+func (t BLAKE2Tree) depth() int {
+	if t.MaxDepth == 0 {
+		return 255
+	}
+	return t.MaxDepth
+}
+
+// leaves returns the number of leaves of a message of n bytes.
+func (t BLAKE2Tree) leaves(n int) int {
+	if t.LeafSize == 0 || n == 0 {
+		return 1
+	}
+	return (n + t.LeafSize - 1) / t.LeafSize
+}
+
+// group returns the number of children of the nodes of level k.
+func (t BLAKE2Tree) group(k int) int {
+	if t.Fanout == 0 || k == t.depth()-1 {
+		return math.MaxInt
+	}
+	return t.Fanout
+}
+
+// widths returns the number of nodes of every level of a tree of the given
+// leaves, from the leaves up to the root. It fails if the tree needs more
+// levels than MaxDepth.
+func (t BLAKE2Tree) widths(leaves int) ([]int, error) {
+	ws := []int{leaves}
+	for w := leaves; w > 1; {
+		k := len(ws)
+		if k == t.depth() {
+			return nil, errors.New("sakura: BLAKE2 tree deeper than its MaxDepth")
+		}
+		w = (w-1)/min(t.group(k), w) + 1
+		ws = append(ws, w)
+	}
+	return ws, nil
+}
+
+// node returns the hash of the node at offset i of level k of a tree of the
+// given widths.
+func (t BLAKE2Tree) node(ws []int, k, i int) *blake2b {
+	p := blake2bParams{
+		size:        byte(t.innerSize()),
+		fanout:      byte(t.Fanout),
+		depth:       byte(t.depth()),
+		leafLength:  uint32(t.LeafSize),
+		nodeOffset:  uint64(i),
+		nodeDepth:   byte(k),
+		innerLength: byte(t.innerSize()),
+		last:        i == ws[k]-1,
+	}
+	if k == len(ws)-1 {
+		p.size = 64
+		if t.Size != 0 {
+			p.size = byte(t.Size)
+		}
+	}
+	copy(p.salt[:], t.Salt)
+	copy(p.personal[:], t.Personal)
+	return newBLAKE2b(p)
+}
+
+// levels returns the digests of every level of the tree of data, from the
+// leaves up.
+func (t BLAKE2Tree) levels(data []byte) ([][][]byte, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+	ws, err := t.widths(t.leaves(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	level := make([][]byte, ws[0])
+	for i := range level {
+		h := t.node(ws, 0, i)
+		if t.LeafSize == 0 {
+			h.Write(data)
+		} else {
+			h.Write(data[min(i*t.LeafSize, len(data)):min((i+1)*t.LeafSize, len(data))])
+		}
+		level[i] = h.Sum(nil)
+	}
+	levels := [][][]byte{level}
+	for k := 1; k < len(ws); k++ {
+		below, g := level, min(t.group(k), len(level))
+		level = make([][]byte, ws[k])
+		for i := range level {
+			h := t.node(ws, k, i)
+			for _, d := range below[i*g : min((i+1)*g, len(below))] {
+				h.Write(d)
+			}
+			level[i] = h.Sum(nil)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// Sum returns the root digest of the tree of data.
+func (t BLAKE2Tree) Sum(data []byte) ([]byte, error) {
+	levels, err := t.levels(data)
+	if err != nil {
+		return nil, err
+	}
+	return levels[len(levels)-1][0], nil
+}
+
+// VerifyBLAKE2Tree checks that data hashes to root in the BLAKE2 tree t, with
+// the same care as Verify.
+func VerifyBLAKE2Tree(t BLAKE2Tree, root, data []byte) error {
+	got, err := t.Sum(data)
+	if err != nil {
+		return err
+	}
+	return compareRoots(got, root, ErrRootMismatch)
+}
+
+// BLAKE2TreeProof is the proof of a leaf of a BLAKE2 tree: the digests of the
+// other children of every node on the path from the leaf to the root.
+type BLAKE2TreeProof struct {
+	Tree   BLAKE2Tree // Parameters of the tree.
+	Index  int        // Index of the leaf.
+	Leaves int        // Number of leaves of the tree.
+	Path   [][]byte   // Digests of the siblings along the path, from the leaf up, in child order.
+}
+
+// Prove returns the proof of leaf i of the tree of data, which
+// VerifyBLAKE2TreeProof checks against the root returned by Sum.
+func (t BLAKE2Tree) Prove(data []byte, i int) (*BLAKE2TreeProof, error) {
+	levels, err := t.levels(data)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= len(levels[0]) {
+		return nil, ErrInvalidNodeID
+	}
+	p := &BLAKE2TreeProof{Tree: t, Index: i, Leaves: len(levels[0])}
+	for k := 1; k < len(levels); k++ {
+		below := levels[k-1]
+		g := min(t.group(k), len(below))
+		start := i / g * g
+		for j := start; j < min(start+g, len(below)); j++ {
+			if j != i {
+				p.Path = append(p.Path, below[j])
+			}
+		}
+		i /= g
+	}
+	return p, nil
+}
+
+// VerifyBLAKE2TreeProof checks that leaf is leaf p.Index of the BLAKE2 tree of
+// p.Leaves leaves with the given root. Every leaf but the last must have
+// LeafSize bytes, and the last one at most that many and, unless it is the
+// only one, at least one. The node offsets bind the index of the leaf to the
+// root, but p.Leaves only as far as it changes the shape of the path, so a
+// verifier that relies on the size of the tree must know it itself.
+func VerifyBLAKE2TreeProof(root []byte, p *BLAKE2TreeProof, leaf []byte) error {
+	t := p.Tree
+	if err := t.check(); err != nil {
+		return errors.Join(ErrMalformedProof, err)
+	}
+	if p.Index < 0 || p.Index >= p.Leaves || t.LeafSize == 0 && p.Leaves != 1 {
+		return ErrMalformedProof
+	}
+	if t.LeafSize != 0 && (len(leaf) > t.LeafSize || p.Index < p.Leaves-1 && len(leaf) != t.LeafSize || p.Leaves > 1 && len(leaf) == 0) {
+		return ErrMalformedProof
+	}
+	ws, err := t.widths(p.Leaves)
+	if err != nil {
+		return errors.Join(ErrMalformedProof, err)
+	}
+	h := t.node(ws, 0, p.Index)
+	h.Write(leaf)
+	d, i, path := h.Sum(nil), p.Index, p.Path
+	for k := 1; k < len(ws); k++ {
+		g := min(t.group(k), ws[k-1])
+		start := i / g * g
+		end := min(start+g, ws[k-1])
+		if len(path) < end-start-1 {
+			return ErrMalformedProof
+		}
+		h := t.node(ws, k, i/g)
+		for j := start; j < end; j++ {
+			if j == i {
+				h.Write(d)
+				continue
+			}
+			if len(path[0]) != t.innerSize() {
+				return ErrMalformedProof
+			}
+			h.Write(path[0])
+			path = path[1:]
+		}
+		d, i = h.Sum(nil), i/g
+	}
+	if len(path) != 0 {
+		return ErrMalformedProof
+	}
+	return compareRoots(d, root, ErrProofMismatch)
+}
+
+// MarshalBinary encodes the proof.
+func (p *BLAKE2TreeProof) MarshalBinary() ([]byte, error) {
+	t := p.Tree
+	var b []byte
+	for _, v := range []int{t.Size, t.InnerSize, t.Fanout, t.MaxDepth, t.LeafSize, p.Index, p.Leaves} {
+		b = binary.AppendUvarint(b, uint64(v))
+	}
+	b = appendBytes(b, t.Salt)
+	b = appendBytes(b, t.Personal)
+	b = binary.AppendUvarint(b, uint64(len(p.Path)))
+	for _, d := range p.Path {
+		b = append(b, d...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary.
+func (p *BLAKE2TreeProof) UnmarshalBinary(data []byte) error {
+	d := newDecoder("BLAKE2 tree proof", data)
+	var q BLAKE2TreeProof
+	for _, v := range []*int{&q.Tree.Size, &q.Tree.InnerSize, &q.Tree.Fanout, &q.Tree.MaxDepth, &q.Tree.LeafSize, &q.Index, &q.Leaves} {
+		*v = d.int()
+	}
+	q.Tree.Salt, q.Tree.Personal = d.bytes(), d.bytes()
+	if d.err == nil && q.Tree.check() != nil {
+		d.fail("invalid tree parameters")
+	}
+	q.Path = make([][]byte, d.count())
+	for i := range q.Path {
+		q.Path[i] = d.fixed(q.Tree.innerSize())
+	}
+	if err := d.end(); err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
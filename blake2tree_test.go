@@ -0,0 +1,135 @@
+package sakura_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/chlin501/sakura"
+)
+
+// blake2Vectors are roots of BLAKE2 trees computed with the tree parameters of
+// Python's hashlib.blake2b, the first being the tree hashing example of its
+// documentation over 6000 zero bytes, the others over sakura.Pattern.
+var blake2Vectors = []struct {
+	name string
+	tree sakura.BLAKE2Tree
+	data []byte
+	root string
+}{
+	{"hashlib", sakura.BLAKE2Tree{Size: 32, Fanout: 2, MaxDepth: 2, LeafSize: 4096}, make([]byte, 6000),
+		"3ad2a9b37c6070e374c7a8c508fe20ca86b6ed54e286e93a0318e95e881db5aa"},
+	{"fanout 4", sakura.BLAKE2Tree{InnerSize: 32, Fanout: 4, LeafSize: 128}, sakura.Pattern(1000),
+		"1e3b63391d385f06f327024a0939031b686de6c33cc2aed179f4864a38f65caf6be1917cb8fb8f2d8e3cc9cc054399cfdc5d45ff07dadd312b3fe7f0adf13dfb"},
+	{"depth 3", sakura.BLAKE2Tree{Size: 20, Fanout: 3, MaxDepth: 3, LeafSize: 10}, sakura.Pattern(200),
+		"d3aa2cf7887699dab31b902e3dd7286212c3338b"},
+	{"no fanout", sakura.BLAKE2Tree{LeafSize: 100}, sakura.Pattern(250),
+		"afd67a6e4d375dffb6c389007890cd68216dc6690a87c2ff14d0b53747e35867acc598a896a6f14da9dff96b2efa4711582453c0655a945bc471f4d3aac6bc43"},
+	{"single leaf", sakura.BLAKE2Tree{Fanout: 2, LeafSize: 64}, sakura.Pattern(10),
+		"81a8e5dedf25b14e208c6ae4b499f408f9345d82c6eb00bc6fa1bd360c82442265c245073bb09a92e093bca469172107fe041d61fa6a8a865b6990ed4e8c445c"},
+	{"empty", sakura.BLAKE2Tree{Fanout: 2, LeafSize: 64, Salt: []byte("salt"), Personal: []byte("sakura")}, nil,
+		"635f3b69dfd468e0cdaa2a49b1cfe9183b7720547181b901eaf7d61e6beb329123075f7080a724996eaef75ba2d7a4c4c34af3f9362240dfd790258c90d016e6"},
+	{"unlimited leaf", sakura.BLAKE2Tree{Size: 48, Fanout: 2}, sakura.Pattern(300),
+		"9d06ac6e0cef2e795116023b8bdc23975418da5fac090dd7450e9aa02b7c0dd378be7821f7a06ad0c994af7a2f6d0c8b"},
+	{"full leaves", sakura.BLAKE2Tree{Size: 16, InnerSize: 16, Fanout: 2, LeafSize: 128}, sakura.Pattern(512),
+		"a2cde7fee36d4c5ebe76cbb492d0fa96"},
+}
+
+// blake2Leaf returns leaf i of data in the tree t.
+func blake2Leaf(t sakura.BLAKE2Tree, data []byte, i int) []byte {
+	if t.LeafSize == 0 {
+		return data
+	}
+	return data[min(i*t.LeafSize, len(data)):min((i+1)*t.LeafSize, len(data))]
+}
+
+func TestBLAKE2Tree(t *testing.T) {
+	v := sakura.NewVerifier(sakura.Mode128())
+	for _, tc := range blake2Vectors {
+		t.Run(tc.name, func(t *testing.T) {
+			root, err := tc.tree.Sum(tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(root) != tc.root {
+				t.Fatalf("got %x, want %s", root, tc.root)
+			}
+			if err := sakura.VerifyBLAKE2Tree(tc.tree, root, append(tc.data, 0)); !errors.Is(err, sakura.ErrRootMismatch) {
+				t.Errorf("longer data: got %v, want ErrRootMismatch", err)
+			}
+			p, err := tc.tree.Prove(tc.data, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < p.Leaves; i++ {
+				p, err := tc.tree.Prove(tc.data, i)
+				if err != nil {
+					t.Fatalf("Prove(%d): %v", i, err)
+				}
+				b, err := sakura.FrameProof(sakura.FormatBLAKE2Tree, p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				leaf := blake2Leaf(tc.tree, tc.data, i)
+				if err := v.Verify(root, b, leaf); err != nil {
+					t.Fatalf("leaf %d: %v", i, err)
+				}
+				if len(leaf) > 0 {
+					forged := append([]byte(nil), leaf...)
+					forged[0] ^= 1
+					if err := v.Verify(root, b, forged); !errors.Is(err, sakura.ErrProofMismatch) {
+						t.Fatalf("forged leaf %d: got %v, want ErrProofMismatch", i, err)
+					}
+				}
+			}
+			if _, err := tc.tree.Prove(tc.data, p.Leaves); !errors.Is(err, sakura.ErrInvalidNodeID) {
+				t.Errorf("Prove past the last leaf: got %v, want ErrInvalidNodeID", err)
+			}
+		})
+	}
+}
+
+func TestBLAKE2TreeProofMalformed(t *testing.T) {
+	tree := sakura.BLAKE2Tree{Fanout: 3, LeafSize: 10}
+	data := sakura.Pattern(95)
+	root, err := tree.Sum(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := tree.Prove(data, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := blake2Leaf(tree, data, 4)
+	if err := sakura.VerifyBLAKE2TreeProof(root, p, leaf[:9]); !errors.Is(err, sakura.ErrMalformedProof) {
+		t.Errorf("short inner leaf: got %v, want ErrMalformedProof", err)
+	}
+	q := *p
+	q.Path = q.Path[1:]
+	if err := sakura.VerifyBLAKE2TreeProof(root, &q, leaf); !errors.Is(err, sakura.ErrMalformedProof) {
+		t.Errorf("missing sibling: got %v, want ErrMalformedProof", err)
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(b); n++ {
+		var q sakura.BLAKE2TreeProof
+		malformed(t, "truncated BLAKE2 tree proof", q.UnmarshalBinary(b[:n]))
+	}
+}
+
+func TestBLAKE2TreeInvalid(t *testing.T) {
+	for name, tree := range map[string]sakura.BLAKE2Tree{
+		"size":     {Size: 65},
+		"inner":    {InnerSize: -1},
+		"fanout":   {Fanout: 1},
+		"depth":    {MaxDepth: 256},
+		"salt":     {Salt: make([]byte, 17)},
+		"too deep": {MaxDepth: 1, LeafSize: 10},
+	} {
+		if _, err := tree.Sum(sakura.Pattern(30)); err == nil {
+			t.Errorf("%s: Sum succeeded", name)
+		}
+	}
+}
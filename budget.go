@@ -0,0 +1,147 @@
+package sakura
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// progressVersion is the version of the encoding of a Progress.
+const progressVersion = 1
+
+// Progress is the state of a tree whose hashing by FinalBudget ran out of
+// time: the chaining values of the largest subtrees hashed so far, from which
+// the next call on the same tree, or on one built again from the same data,
+// resumes. Its binary encoding lets scanners keep it between runs.
+type Progress struct {
+	Mode      ModeHeader // Mode of the encoder that hashed the subtrees.
+	Completed []CompletedSubtree
+	Leaves    int // Leaves of the tree within the completed subtrees.
+	Total     int // Leaves of the tree.
+}
+
+// Percent returns the share of the leaves of the tree within the completed
+// subtrees, from 0 to 100.
+func (p *Progress) Percent() float64 {
+	if p.Total == 0 {
+		return 0
+	}
+	return 100 * float64(p.Leaves) / float64(p.Total)
+}
+
+// FinalBudget is like Final, but hashes for at most budget, resuming from the
+// progress of an earlier call unless from is nil, so that cron-style integrity
+// scans spread a huge tree over several maintenance windows. It returns the
+// root if the tree is hashed in time, and otherwise a nil root with the
+// progress made, which includes that of from. Subtrees completed when the
+// budget runs out are kept, while the nodes being hashed are abandoned as by
+// FinalContext.
+//
+// The tree must have the shape of the one that made from, with hops that keep
+// the chaining values they are given, and chaining hops that can be looked up
+// by index, as CanceledError.Apply requires.
+func (e *Encoder) FinalBudget(hop Hop, budget time.Duration, from *Progress) ([]byte, *Progress, error) {
+	if budget <= 0 {
+		return nil, nil, errors.New("sakura: non-positive time budget")
+	}
+	if err := e.checkMode(); err != nil {
+		return nil, nil, err
+	}
+	j := newJob(e)
+	j.partial = new(partialSet)
+	if from != nil {
+		if !from.Mode.Matches(e.mode) {
+			return nil, nil, ErrModeMismatch
+		}
+		if err := applyCompleted(hop, from.Completed); err != nil {
+			return nil, nil, err
+		}
+		for _, c := range from.Completed {
+			j.partial.add(c.Node, c.ChainingValue)
+		}
+	}
+	total, err := CountLeaves(hop)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), budget)
+	defer cancel()
+	j.ctx = ctx
+	j.done = ctx.Done()
+	sum, err := j.traced("sakura.Final", hop, func() ([]byte, error) {
+		return j.run(hop, true)
+	})
+	if err == nil {
+		return sum, nil, nil
+	}
+	if ctx.Err() == nil {
+		return nil, nil, err
+	}
+	p := &Progress{Mode: e.mode.Header(), Completed: j.partial.maximal(), Total: total}
+	for _, c := range p.Completed {
+		h, err := descendant(hop, c.Node)
+		if err != nil {
+			return nil, nil, err
+		}
+		n, err := CountLeaves(h)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.Leaves += n
+	}
+	return nil, p, nil
+}
+
+// MarshalBinary encodes the progress.
+func (p *Progress) MarshalBinary() ([]byte, error) {
+	b := appendModeHeader([]byte{progressVersion}, p.Mode)
+	b = binary.AppendUvarint(b, uint64(p.Leaves))
+	b = binary.AppendUvarint(b, uint64(p.Total))
+	b = binary.AppendUvarint(b, uint64(len(p.Completed)))
+	for _, c := range p.Completed {
+		b = binary.AppendUvarint(b, uint64(len(c.Node)))
+		for _, i := range c.Node {
+			b = binary.AppendUvarint(b, uint64(i))
+		}
+		b = appendBytes(b, c.ChainingValue)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes progress encoded by MarshalBinary, within
+// DefaultDecodeLimits. The length of every node ID counts against MaxDepth,
+// and the number of subtrees against MaxNodes.
+func (p *Progress) UnmarshalBinary(data []byte) error {
+	l := DefaultDecodeLimits
+	d := newDecoder("progress", data)
+	d.limit(l.check("bytes", l.MaxBytes, int64(len(data)), nil))
+	d.version(progressVersion)
+	var q Progress
+	q.Mode, _ = d.modeHeader()
+	q.Leaves, q.Total = d.int(), d.int()
+	if d.err == nil && q.Leaves > q.Total {
+		d.fail("more leaves completed than in the tree")
+	}
+	q.Completed = make([]CompletedSubtree, d.count())
+	d.limit(l.check("nodes", int64(l.MaxNodes), int64(len(q.Completed)), nil))
+	for k := range q.Completed {
+		c := &q.Completed[k]
+		c.Node = make(NodeID, d.count())
+		d.limit(l.check("depth", int64(l.MaxDepth), int64(len(c.Node)), nil))
+		for m := range c.Node {
+			c.Node[m] = d.int()
+		}
+		if c.ChainingValue = d.bytes(); d.err == nil && len(c.ChainingValue) != q.Mode.HashSize {
+			d.fail("chaining value of the wrong size")
+		}
+		if d.err != nil {
+			break
+		}
+	}
+	if err := d.end(); err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
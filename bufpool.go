@@ -0,0 +1,80 @@
+package sakura
+
+import (
+	"errors"
+	"sync"
+)
+
+// BufferPool holds the buffers that encoders reuse between calls: the buffers
+// that message hops are read through and the leaf buffers of Writers, which
+// are otherwise allocated as needed and left to the garbage collector. A pool
+// set as the Buffers of one or more encoders bounds the memory they keep idle
+// and reports how much they use, for long-running services that must bound
+// and observe their footprint. The chaining values of trees are not pooled,
+// since they outlive the calls that compute them.
+//
+// A BufferPool is safe for concurrent use.
+type BufferPool struct {
+	bufferSize  int
+	maxRetained int64
+
+	mu    sync.Mutex
+	free  map[int][][]byte // Idle buffers by size.
+	stats BufferStats
+}
+
+// BufferStats describes the memory of a BufferPool.
+type BufferStats struct {
+	InUse     int64 // Bytes of buffers handed out and not yet returned.
+	Retained  int64 // Bytes of idle buffers kept for reuse.
+	Allocated int64 // Bytes of all buffers allocated by the pool.
+	Dropped   int64 // Bytes of returned buffers not kept, as they would exceed the cap.
+}
+
+// NewBufferPool returns a pool whose read buffers have bufferSize bytes, or
+// the default of 32 KiB if it is zero, and which keeps at most maxRetained
+// bytes of idle buffers, without a cap if it is zero. Buffers in use are not
+// capped, as they are bounded by the parallelism of the encoders and by their
+// MaxBufferedBytes.
+func NewBufferPool(bufferSize int, maxRetained int64) (*BufferPool, error) {
+	if bufferSize < 0 || maxRetained < 0 {
+		return nil, errors.New("sakura: negative buffer pool size")
+	}
+	return &BufferPool{bufferSize: bufferSize, maxRetained: maxRetained, free: make(map[int][][]byte)}, nil
+}
+
+// Stats returns the current statistics of p.
+func (p *BufferPool) Stats() BufferStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// get returns a buffer of n bytes.
+func (p *BufferPool) get(n int) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.InUse += int64(n)
+	if free := p.free[n]; len(free) > 0 {
+		b := free[len(free)-1]
+		p.free[n] = free[:len(free)-1]
+		p.stats.Retained -= int64(n)
+		return b
+	}
+	p.stats.Allocated += int64(n)
+	return make([]byte, n)
+}
+
+// put returns a buffer obtained from get, which must no longer be used.
+func (p *BufferPool) put(b []byte) {
+	n := cap(b)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.InUse -= int64(n)
+	if p.maxRetained > 0 && p.stats.Retained+int64(n) > p.maxRetained {
+		p.stats.Dropped += int64(n)
+		return
+	}
+	p.free[n] = append(p.free[n], b[:n])
+	p.stats.Retained += int64(n)
+}
@@ -0,0 +1,96 @@
+package sakura
+
+import "math"
+
+// maxFanout is the largest fanout chosen by AdaptiveFanout, which keeps any
+// node down to a few thousand chaining values.
+const maxFanout = 1024
+
+// BuildTree returns a tree over the given leaves, in order, in which chaining
+// hops have at most fanout children: the leaves are grouped fanout at a time
+// under chaining hops, which are grouped in turn up to a single root. A fanout
+// below 2 gives the two-level shape of Writer, a single chaining hop over all
+// leaves, which a single leaf replaces. BuildTree panics if there are no
+// leaves.
+func BuildTree(leaves []Hop, fanout int) Hop {
+	if len(leaves) == 0 {
+		panic("sakura: no leaves")
+	}
+	if fanout < 2 {
+		return sequentialTree(leaves)
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = group(level, fanout)
+	}
+	return level[0]
+}
+
+// AdaptiveFanout returns a fanout for BuildTree over the given number of
+// leaves. Up to 1024 leaves give a single level, so that small inputs pay for
+// no more than one extra node. Beyond that, the tree gets the fewest levels
+// for which no hop has more than 1024 children, and the same fanout on every
+// level: taller trees hash more nodes, while wider ones grow the nodes near
+// the root, which are hashed alone once all parallel work below them is done.
+func AdaptiveFanout(leaves int) int {
+	if leaves <= maxFanout {
+		return 0
+	}
+	height, capacity := 1, maxFanout
+	for capacity < leaves {
+		height++
+		capacity *= maxFanout
+	}
+	// Smallest fanout f with f^height >= leaves.
+	lo, hi := 2, maxFanout
+	for lo < hi {
+		f := (lo + hi) / 2
+		if pow(f, height) >= leaves {
+			hi = f
+		} else {
+			lo = f + 1
+		}
+	}
+	return lo
+}
+
+// pow returns x^n, saturating at the largest int.
+func pow(x, n int) int {
+	const maxInt = int(^uint(0) >> 1)
+	p := 1
+	for ; n > 0; n-- {
+		if p > maxInt/x {
+			return maxInt
+		}
+		p *= x
+	}
+	return p
+}
+
+// RangeLeaves returns the leaves that HashRanger hashes for the first size
+// bytes of the object read by r: message hops of leafSize bytes each, the last
+// of which may be shorter, fetched from r when first read. Zero bytes give a
+// single empty leaf. It panics if leafSize is not positive. The leaves may be
+// arranged with BuildTree and AdaptiveFanout:
+//
+//	leaves := sakura.RangeLeaves(r, size, leafSize)
+//	root, err := e.Final(sakura.BuildTree(leaves, sakura.AdaptiveFanout(len(leaves))))
+func RangeLeaves(r Ranger, size int64, leafSize int) []Hop {
+	if leafSize <= 0 {
+		panic("sakura: non-positive leaf size")
+	}
+	var leaves []Hop
+	for off := int64(0); off < size || off == 0; off += int64(leafSize) {
+		leaves = append(leaves, &rangeLeaf{r: r, off: off, n: min(int64(leafSize), size-off)})
+	}
+	return leaves
+}
+
+// leafCount returns the number of leaves, at least one, of size bytes cut into
+// leaves of leafSize bytes. Counts that do not fit in an int, as on 32-bit
+// platforms with large sizes and small leaves, are the largest int, which no
+// slice of leaves matches.
+func leafCount(size int64, leafSize int) int {
+	n := max((size+int64(leafSize)-1)/int64(leafSize), 1)
+	return int(min(n, math.MaxInt))
+}
@@ -0,0 +1,78 @@
+package sakura
+
+import (
+	"runtime"
+	"time"
+)
+
+// calibrationSample is the number of bytes hashed by every measurement of
+// Calibrate.
+const calibrationSample = 4 << 20
+
+// Calibration holds the settings that Calibrate recommends for a mode on the
+// host.
+type Calibration struct {
+	LeafSize    int     // Leaf size for NewWriter and the shapes built like it.
+	Parallelism int     // Value for Encoder.Parallelism.
+	Throughput  float64 // Bytes per second hashed with these settings.
+}
+
+// Calibrate measures how fast a Writer hashes in mode on the host and returns
+// the leaf size and parallelism that hash fastest. Small leaves cost more in
+// the dispatch of nodes to workers and larger leaves in the work left to the
+// final node, so the best leaf size depends on the hash function and the
+// machine. The leaf size is chosen first among powers of two from 1 KiB to 64
+// KiB with all processors, and then the smallest parallelism, among powers of
+// two, within 5% of the fastest, so that no workers are spent for nothing.
+//
+// Every measurement hashes a few MiB from memory, about a hundred MiB in all,
+// which makes Calibrate meant for startup or tools rather than calls on a hot
+// path. Results vary between runs with the load of the host.
+func Calibrate(mode HashingMode) (Calibration, error) {
+	if err := New(mode).checkMode(); err != nil {
+		return Calibration{}, err
+	}
+	data := Pattern(calibrationSample)
+	procs := runtime.GOMAXPROCS(0)
+	best := Calibration{Parallelism: procs}
+	for size := 1 << 10; size <= 64<<10; size <<= 1 {
+		t, err := measure(mode, procs, size, data)
+		if err != nil {
+			return Calibration{}, err
+		}
+		if t > best.Throughput {
+			best.LeafSize, best.Throughput = size, t
+		}
+	}
+	for p := 1; p < procs; p <<= 1 {
+		t, err := measure(mode, p, best.LeafSize, data)
+		if err != nil {
+			return Calibration{}, err
+		}
+		if t >= 0.95*best.Throughput {
+			best.Parallelism, best.Throughput = p, t
+			break
+		}
+	}
+	return best, nil
+}
+
+// measure returns the best throughput, in bytes per second, of three runs of a
+// Writer with the given parallelism and leaf size over data.
+func measure(mode HashingMode, parallelism, leafSize int, data []byte) (float64, error) {
+	e := New(mode)
+	e.Parallelism = parallelism
+	var best float64
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		w := NewWriter(e, leafSize)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			return 0, err
+		}
+		if t := float64(len(data)) / time.Since(start).Seconds(); t > best {
+			best = t
+		}
+	}
+	return best, nil
+}
@@ -0,0 +1,52 @@
+package sakura
+
+import (
+	"context"
+	"io"
+)
+
+// ChanLeaf is a message hop whose bits are the chunks received from a channel,
+// in order, until the channel is closed, so that event-driven producers such as
+// message queue consumers or streaming RPCs can feed the encoder directly. Its
+// chunks must not be modified once sent.
+//
+// A read waits for the next chunk, and fails with the error of the context if
+// it is done first, so a stalled producer cannot block the encoder forever.
+type ChanLeaf struct {
+	ctx context.Context
+	c   <-chan []byte
+	cur []byte // Rest of the chunk being read.
+	cv  []byte
+}
+
+// NewChanLeaf returns a message hop reading from c within ctx.
+func NewChanLeaf(ctx context.Context, c <-chan []byte) *ChanLeaf {
+	return &ChanLeaf{ctx: ctx, c: c}
+}
+
+// Read implements MessageHop.
+func (l *ChanLeaf) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for len(l.cur) == 0 {
+		select {
+		case chunk, ok := <-l.c:
+			if !ok {
+				return 0, io.EOF
+			}
+			l.cur = chunk
+		case <-l.ctx.Done():
+			return 0, l.ctx.Err()
+		}
+	}
+	n := copy(p, l.cur)
+	l.cur = l.cur[n:]
+	return n, nil
+}
+
+// ChainingValue implements Hop.
+func (l *ChanLeaf) ChainingValue() []byte { return l.cv }
+
+// SetChainingValue implements Hop.
+func (l *ChanLeaf) SetChainingValue(hash []byte) { l.cv = hash }
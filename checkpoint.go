@@ -0,0 +1,186 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointVersion is the version of the checkpoint file format.
+const checkpointVersion = 2
+
+// ErrCheckpointMismatch is returned when resuming from a checkpoint written
+// with a different leaf size.
+var ErrCheckpointMismatch = errors.New("sakura: checkpoint does not match the writer")
+
+// checkpoint records where and how often a Writer saves its state.
+type checkpoint struct {
+	path     string
+	bytes    int64
+	interval time.Duration
+	written  int64     // Length of the stream at the last checkpoint.
+	at       time.Time // Time of the last checkpoint.
+}
+
+// SetCheckpoint makes w save its state to the file at path whenever another
+// bytes bytes have been written or interval has passed since the last save,
+// checked on every Write. A zero bytes or interval disables the respective
+// trigger. The file is replaced atomically, so it always holds a complete
+// state, and it is removed once Close succeeds.
+//
+// The state holds the chaining values of the completed leaves and the data
+// of the leaves not yet hashed, so a stream of any size is saved in a few
+// leaves' worth of bytes. ResumeWriter continues from it.
+func (w *Writer) SetCheckpoint(path string, bytes int64, interval time.Duration) {
+	w.ck = &checkpoint{path: path, bytes: bytes, interval: interval, written: w.written, at: time.Now()}
+}
+
+// Written returns the number of bytes written to w, including those written
+// before the checkpoint it was resumed from.
+func (w *Writer) Written() int64 { return w.written }
+
+// maybeCheckpoint saves the state of w if a checkpoint is due.
+func (w *Writer) maybeCheckpoint() error {
+	ck := w.ck
+	if ck == nil {
+		return nil
+	}
+	if (ck.bytes <= 0 || w.written-ck.written < ck.bytes) && (ck.interval <= 0 || time.Since(ck.at) < ck.interval) {
+		return nil
+	}
+	if err := w.Checkpoint(ck.path); err != nil {
+		return err
+	}
+	ck.written, ck.at = w.written, time.Now()
+	return nil
+}
+
+// Checkpoint saves the state of w to the file at path, replacing it
+// atomically.
+func (w *Writer) Checkpoint(path string) error {
+	if w.closed || w.closing {
+		return ErrClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.parity != nil {
+		return errors.New("sakura: checkpoints do not hold parity")
+	}
+	if w.pool.spillMax > 0 {
+		return errors.New("sakura: checkpoints do not hold spilled chaining values")
+	}
+	if w.pool.final != nil {
+		return errors.New("sakura: checkpoints do not hold a streamed final node")
+	}
+	if w.expired() {
+		return os.ErrDeadlineExceeded
+	}
+	cvs, err := w.pool.wait(w.deadline)
+	if err == os.ErrDeadlineExceeded {
+		return err
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	if w.e.mode.Kangaroo && len(cvs) > 0 {
+		cvs = cvs[1:] // The first leaf is nested, not hashed.
+	}
+	b := appendModeHeader([]byte{checkpointVersion}, w.e.mode.Header())
+	b = binary.AppendUvarint(b, uint64(w.leafSize))
+	b = binary.AppendUvarint(b, uint64(w.written))
+	b = binary.AppendUvarint(b, uint64(w.leaves))
+	b = appendBytes(b, w.first)
+	b = appendBytes(b, w.buf)
+	b = binary.AppendUvarint(b, uint64(len(cvs)))
+	for _, cv := range cvs {
+		b = append(b, cv...)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ResumeWriter returns a Writer that hashes with e, cutting the stream into
+// leaves of leafSize bytes and saving checkpoints to the file at path as set
+// by SetCheckpoint. If the file exists, the writer continues from the state
+// saved in it, and the caller must continue the stream at offset Written.
+// Otherwise the writer starts an empty stream. Checkpoints of version 1 are
+// read too, and replaced by the current version at the next checkpoint.
+func ResumeWriter(e *Encoder, leafSize int, path string, bytes int64, interval time.Duration) (*Writer, error) {
+	w := NewWriter(e, leafSize)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := w.restore(data); err != nil {
+			return nil, err
+		}
+	}
+	w.SetCheckpoint(path, bytes, interval)
+	return w, nil
+}
+
+// restore sets the state of the new writer w from a checkpoint.
+func (w *Writer) restore(data []byte) error {
+	d := newDecoder("checkpoint", data)
+	size := w.e.mode.Hash().Size()
+	var leafSize int
+	if d.versions(1, checkpointVersion) == 1 {
+		// Version 1 recorded the hash size after the leaf size, not the mode.
+		if leafSize = d.int(); d.int() != size && d.err == nil {
+			return ErrModeMismatch
+		}
+	} else {
+		if err := d.checkModeHeader(w.e.mode); err != nil {
+			return err
+		}
+		leafSize = d.int()
+	}
+	if d.err == nil && leafSize != w.leafSize {
+		return ErrCheckpointMismatch
+	}
+	written, leaves := d.int64(), d.int()
+	first, buf := d.bytes(), d.bytes()
+	cvs := make([][]byte, d.count())
+	for i := range cvs {
+		if len(d.b) < size {
+			d.fail("truncated chaining value")
+			break
+		}
+		cvs[i], d.b = d.b[:size:size], d.b[size:]
+	}
+	if d.err == nil && (len(first) > leafSize || len(buf) > leafSize) {
+		d.fail("leaf data exceeds the leaf size")
+	}
+	if err := d.end(); err != nil {
+		return err
+	}
+	if w.e.mode.Kangaroo && len(cvs) > 0 {
+		cvs = append([][]byte{nil}, cvs...)
+	}
+	w.written, w.leaves, w.first, w.buf = written, leaves, first, buf
+	w.handed = max(leaves-1, 0)
+	w.pool.restore(cvs)
+	return nil
+}
@@ -0,0 +1,144 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// ChildStream is a chaining hop whose children are produced one at a time,
+// such as rows read from a database or entries received from the network, so
+// that they are generated as the encoder needs them and failures to produce
+// them are reported. The encoder detects it and uses Next in preference to
+// the methods of ChainingHop and ChainingHop64, which a ChildStream need not
+// implement.
+//
+// The number of children need not be known in advance: the encoder codes the
+// children as they are read and the count, which ends the coding of a
+// chaining hop, once Next returns io.EOF. A stream is read no further than
+// MaxDegree children. The encoder reads the children of a stream once per
+// call, in order. With a Parallelism of 2 or more, it remembers them until
+// the call returns, as the nodes of a tree hashed in parallel are visited more
+// than once; otherwise it holds only the child being hashed, so that a stream
+// of any length is hashed in constant memory. Streams are told apart by identity, so
+// a ChildStream must be comparable, typically a pointer. Errors from Next are
+// returned as a *ChildError. Functions that look children up by index, such
+// as Walk and Prove, do not read streams and fail on a hop that implements
+// neither ChainingHop nor ChainingHop64.
+type ChildStream interface {
+	Hop
+	// Next returns the next child, or io.EOF once all children have been
+	// returned.
+	Next() (Hop, error)
+}
+
+// errNotIndexed is returned when the children of a ChildStream are looked up
+// by index outside the encoder.
+var errNotIndexed = errors.New("sakura: the children of a child stream cannot be looked up by index")
+
+// expand returns the children read so far from hop, initially none, if it is
+// a ChildStream, and hop otherwise. The hop must have been checked by
+// checkHop.
+func (j *job) expand(hop Hop) Hop {
+	s, ok := hop.(ChildStream)
+	if !ok {
+		return hop
+	}
+	j.streamMu.Lock()
+	defer j.streamMu.Unlock()
+	if j.streams == nil {
+		j.streams = make(map[ChildStream]*streamedHop)
+	}
+	h := j.streams[s]
+	if h == nil {
+		// Serial jobs code every node once, reading its children in order.
+		h = &streamedHop{s: s, live: j.e.Parallelism < 2}
+		j.streams[s] = h
+	}
+	return h
+}
+
+// checkStream fails with ErrInvalidHop if hop is a ChildStream that cannot be
+// told apart from others.
+func checkStream(hop Hop) error {
+	if _, ok := hop.(ChildStream); ok && !reflect.TypeOf(hop).Comparable() {
+		return ErrInvalidHop
+	}
+	return nil
+}
+
+// streamedHop holds the children read from a ChildStream by a job. Unless
+// live, it keeps them all for the job to look up again. A live streamedHop,
+// for a job that reads every stream once in order, keeps only the last child
+// read, so that the memory of a stream does not grow with its degree.
+type streamedHop struct {
+	s    ChildStream
+	live bool
+	mu   sync.Mutex
+	kids []Hop // Children from index off.
+	off  int
+	done bool  // Whether the stream has ended.
+	err  error // Error reading the stream.
+}
+
+func (h *streamedHop) ChainingValue() []byte        { return h.s.ChainingValue() }
+func (h *streamedHop) SetChainingValue(hash []byte) { h.s.SetChainingValue(hash) }
+
+// pull reads children from the stream of h, whose ID is id, until it has read
+// n of them or the stream ends. h.mu must be held.
+func (h *streamedHop) pull(id NodeID, n int) error {
+	for h.err == nil && !h.done && h.off+len(h.kids) < n {
+		c, err := h.s.Next()
+		switch {
+		case err == io.EOF:
+			h.done = true
+		case err != nil:
+			h.err = &ChildError{Node: id.Child(h.off + len(h.kids)), Err: err}
+		default:
+			h.kids = append(h.kids, c)
+		}
+	}
+	return h.err
+}
+
+// next returns child i, reading the stream up to it, or false if the stream
+// ends before it.
+func (h *streamedHop) next(id NodeID, i int) (Hop, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.live && i >= h.off+len(h.kids) {
+		h.off += len(h.kids)
+		h.kids = h.kids[:0]
+	}
+	if err := h.pull(id, i+1); err != nil {
+		return nil, false, err
+	}
+	if i < h.off {
+		return nil, false, &ChildError{Node: id.Child(i), Err: errNotIndexed}
+	}
+	if i-h.off >= len(h.kids) {
+		return nil, false, nil
+	}
+	return h.kids[i-h.off], true, nil
+}
+
+// degree reads the whole stream and returns the number of children.
+func (h *streamedHop) degree(id NodeID) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.pull(id, math.MaxInt); err != nil {
+		return 0, err
+	}
+	return h.off + len(h.kids), nil
+}
+
+// child returns child i, reading the stream up to it.
+func (h *streamedHop) child(id NodeID, i int) (Hop, error) {
+	c, ok, err := h.next(id, i)
+	if err == nil && !ok {
+		err = &ChildError{Node: id.Child(i), Err: io.ErrUnexpectedEOF}
+	}
+	return c, err
+}
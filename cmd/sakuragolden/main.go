@@ -0,0 +1,132 @@
+//go:build golden
+
+// Command sakuragolden compares the roots of sakura with those of a reference
+// implementation of KangarooTwelve, such as the one of the Keccak team in
+// XKCP, across randomized inputs. It is built only with the golden build tag:
+//
+//	go run -tags golden ./cmd/sakuragolden -ref 'python3 k12.py'
+//
+// KangarooTwelve is the sakura mode with kangaroo hopping, an alignment of 8
+// bytes, no interleaving and HashPadding over TurboSHAKE128, hashed in the
+// shape of Writer with leaves of 8192 bytes over the message followed by the
+// customization string and its length encoding. The reference command is run
+// once per input, with the message on its standard input and the
+// customization string in hexadecimal as its last argument, and must print
+// the first 32 bytes of KangarooTwelve output in hexadecimal. Without -ref,
+// only the published test vectors are checked; go test -tags golden checks
+// these and the longer vectors of RFC 9861 without a reference command.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/chlin501/sakura"
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+var mode = sakura.HashingMode{
+	Hash:       newTurboSHAKE128,
+	Kangaroo:   true,
+	Alignment:  8,
+	Interleave: sakura.NoInterleave,
+	Padding:    sakura.HashPadding{},
+}
+
+// k12 returns the first 32 bytes of KangarooTwelve of msg with the
+// customization string custom.
+func k12(msg, custom []byte) ([]byte, error) {
+	e := sakura.New(mode)
+	w := sakura.NewWriter(e, 8192)
+	w.Write(msg)
+	w.Write(custom)
+	w.Write(sakuracoding.LengthEncode(uint64(len(custom))))
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Root(), nil
+}
+
+// vectors are published KangarooTwelve outputs for the pattern of RFC 9861:
+// byte i has the value i % 251, as in sakura.Pattern.
+var vectors = []struct {
+	n    int
+	root string
+}{
+	{0, "1ac2d450fc3b4205d19da7bfca1b37513c0803577ac7167f06fe2ce1f0ef39e5"},
+	{1, "2bda92450e8b147f8a7cb629e784a058efca7cf7d8218e02d345dfaa65244a1f"},
+	{17, "6bf75fa2239198db4772e36478f8e19b0f371205f6a9a93a273f51df37122888"},
+	{289, "0c315ebcdedbf61426de7dcf8fb725d1e74675d7f5327a5067f367b108ecb67c"},
+}
+
+func main() {
+	ref := flag.String("ref", "", "reference command, split on spaces")
+	n := flag.Int("n", 100, "number of random inputs")
+	maxLen := flag.Int("max", 100000, "maximum length of random inputs")
+	seed := flag.Uint64("seed", 1, "seed of the random inputs")
+	customHex := flag.String("custom", "", "customization string in hexadecimal")
+	flag.Parse()
+	custom, err := hex.DecodeString(*customHex)
+	if err != nil {
+		fail("invalid -custom: %v", err)
+	}
+
+	bad := 0
+	for _, v := range vectors {
+		got, err := k12(sakura.Pattern(v.n), nil)
+		if err != nil {
+			fail("%v", err)
+		}
+		if hex.EncodeToString(got) != v.root {
+			fmt.Printf("test vector of length %d: got %x, want %s\n", v.n, got, v.root)
+			bad++
+		}
+	}
+	if *ref != "" {
+		args := strings.Fields(*ref)
+		r := rand.New(rand.NewPCG(*seed, 0))
+		// Lengths around the leaf boundaries are tried first.
+		lengths := []int{0, 1, 8191, 8192, 8193, 16383, 16384, 16385}
+		for range *n {
+			lengths = append(lengths, r.IntN(*maxLen+1))
+		}
+		for _, l := range lengths {
+			msg := make([]byte, l)
+			for i := range msg {
+				msg[i] = byte(r.Uint32())
+			}
+			got, err := k12(msg, custom)
+			if err != nil {
+				fail("%v", err)
+			}
+			cmd := exec.Command(args[0], append(args[1:], hex.EncodeToString(custom))...)
+			cmd.Stdin = bytes.NewReader(msg)
+			cmd.Stderr = os.Stderr
+			out, err := cmd.Output()
+			if err != nil {
+				fail("reference command: %v", err)
+			}
+			want := strings.ToLower(strings.TrimSpace(string(out)))
+			if hex.EncodeToString(got) != want {
+				fmt.Printf("length %d: got %x, reference %s\n", l, got, want)
+				bad++
+			}
+		}
+		fmt.Printf("compared %d inputs with %s\n", len(lengths), *ref)
+	}
+	if bad > 0 {
+		fail("%d mismatches", bad)
+	}
+	fmt.Println("ok")
+}
+
+func fail(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "sakuragolden: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,53 @@
+//go:build golden
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/chlin501/sakura"
+)
+
+func TestVectors(t *testing.T) {
+	for _, v := range vectors {
+		got, err := k12(sakura.Pattern(v.n), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != v.root {
+			t.Errorf("length %d: got %x, want %s", v.n, got, v.root)
+		}
+	}
+}
+
+// TestVectorsRFC checks the published vectors of RFC 9861 that span several
+// leaves or have a customization string, whose messages are the pattern or
+// bytes 0xff.
+func TestVectorsRFC(t *testing.T) {
+	ff := func(n int) []byte { return bytes.Repeat([]byte{0xff}, n) }
+	for _, v := range []struct {
+		msg, custom []byte
+		root        string
+	}{
+		{sakura.Pattern(17 * 17 * 17), nil, "cb552e2ec77d9910701d578b457ddf772c12e322e4ee7fe417f92c758f0d59d0"},
+		{sakura.Pattern(17 * 17 * 17 * 17), nil, "8701045e22205345ff4dda05555cbb5c3af1a771c2b89baef37db43d9998b9fe"},
+		{sakura.Pattern(17 * 17 * 17 * 17 * 17), nil, "844d610933b1b9963cbdeb5ae3b6b05cc7cbd67ceedf883eb678a0a8e0371682"},
+		{nil, sakura.Pattern(1), "fab658db63e94a246188bf7af69a133045f46ee984c56e3c3328caaf1aa1a583"},
+		{ff(1), sakura.Pattern(41), "d848c5068ced736f4462159b9867fd4c20b808acc3d5bc48e0b06ba0a3762ec4"},
+		{ff(3), sakura.Pattern(41 * 41), "c389e5009ae57120854c2e8c64670ac01358cf4c1baf89447a724234dc7ced74"},
+		{sakura.Pattern(8191), nil, "1b577636f723643e990cc7d6a659837436fd6a103626600eb8301cd1dbe553d6"},
+		{sakura.Pattern(8192), nil, "48f256f6772f9edfb6a8b661ec92dc93b95ebd05a08a17b39ae3490870c926c3"},
+		{sakura.Pattern(8192), sakura.Pattern(8189), "3ed12f70fb05ddb58689510ab3e4d23c6c6033849aa01e1d8c220a297fedcd0b"},
+		{sakura.Pattern(8192), sakura.Pattern(8190), "6a7c1b6a5cd0d8c9ca943a4a216cc64604559a2ea45f78570a15253d67ba00ae"},
+	} {
+		got, err := k12(v.msg, v.custom)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != v.root {
+			t.Errorf("message of %d bytes, customization of %d: got %x, want %s", len(v.msg), len(v.custom), got, v.root)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+//go:build golden
+
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// turboSHAKE128 is TurboSHAKE128 with 32 bytes of output as a hash.Hash, with
+// the domain separation byte taken from the frame bits of the Sakura node
+// through sakura.BitHash. It is written for the comparison only and is not
+// meant to be fast.
+type turboSHAKE128 struct {
+	a      [25]uint64
+	buf    []byte // Input not yet absorbed, shorter than the rate.
+	suffix byte   // Delimited suffix from WriteBits, 0 if none.
+}
+
+const turboRate = 168
+
+func newTurboSHAKE128() hash.Hash { return &turboSHAKE128{} }
+
+func (t *turboSHAKE128) Write(p []byte) (int, error) {
+	n := len(p)
+	t.buf = append(t.buf, p...)
+	for len(t.buf) >= turboRate {
+		t.absorb(t.buf[:turboRate])
+		t.buf = t.buf[turboRate:]
+	}
+	t.buf = append([]byte(nil), t.buf...)
+	return n, nil
+}
+
+func (t *turboSHAKE128) WriteBits(b byte, n int) { t.suffix = b | 1<<n }
+
+func (t *turboSHAKE128) absorb(block []byte) {
+	for i := 0; i < turboRate/8; i++ {
+		t.a[i] ^= binary.LittleEndian.Uint64(block[8*i:])
+	}
+	keccakP12(&t.a)
+}
+
+func (t *turboSHAKE128) Sum(b []byte) []byte {
+	c := *t
+	block := make([]byte, turboRate)
+	copy(block, c.buf)
+	d := c.suffix
+	if d == 0 {
+		d = 0x1f
+	}
+	block[len(c.buf)] ^= d
+	block[turboRate-1] ^= 0x80
+	c.absorb(block)
+	out := make([]byte, 32)
+	for i := range 4 {
+		binary.LittleEndian.PutUint64(out[8*i:], c.a[i])
+	}
+	return append(b, out...)
+}
+
+func (t *turboSHAKE128) Reset()         { *t = turboSHAKE128{} }
+func (t *turboSHAKE128) Size() int      { return 32 }
+func (t *turboSHAKE128) BlockSize() int { return turboRate }
+
+// Round constants of the last 12 rounds of Keccak-f[1600].
+var roundConstants = [12]uint64{
+	0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+var rotations = [25]int{
+	0, 1, 62, 28, 27,
+	36, 44, 6, 55, 20,
+	3, 10, 43, 25, 39,
+	41, 45, 15, 21, 8,
+	18, 2, 61, 56, 14,
+}
+
+// keccakP12 applies Keccak-p[1600, 12] to a, whose lane (x, y) is a[x+5y].
+func keccakP12(a *[25]uint64) {
+	for _, rc := range roundConstants {
+		// θ
+		var c [5]uint64
+		for x := range 5 {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := range 5 {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[x+y] ^= d
+			}
+		}
+		// ρ and π
+		var b [25]uint64
+		for x := range 5 {
+			for y := range 5 {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], rotations[x+5*y])
+			}
+		}
+		// χ
+		for y := 0; y < 25; y += 5 {
+			for x := range 5 {
+				a[x+y] = b[x+y] ^ (^b[(x+1)%5+y] & b[(x+2)%5+y])
+			}
+		}
+		// ι
+		a[0] ^= rc
+	}
+}
@@ -0,0 +1,870 @@
+package sakura
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+// The coding implemented here follows the Sakura grammar:
+//
+//   final node    ::= node '1'
+//   inner node    ::= node pad_simple '0'
+//   node          ::= message hop | chaining hop | kangaroo hopping
+//   kangaroo hop  ::= node pad_simple chaining hop
+//   message hop   ::= message bits '1'
+//   chaining hop  ::= CV* coded nrCVs interleaving block size '0'
+//   pad_simple    ::= '1' '0'*
+//
+// Bit strings are mapped to bytes in the Keccak convention: bit i of the
+// string is bit i%8, counting from the least significant, of byte i/8. Since a
+// hash.Hash only absorbs whole bytes, the trailing frame bits of every node are
+// followed by a single '1' and zeros up to the next byte boundary, which keeps
+// the coding injective.
+
+var (
+	// ErrInvalidHop is returned when a hop implements neither or both of
+	// ChainingHop and MessageHop.
+	ErrInvalidHop = errors.New("sakura: hop must implement exactly one of ChainingHop and MessageHop")
+
+	// ErrNoHash is returned when the hashing mode has no hash function.
+	ErrNoHash = errors.New("sakura: hashing mode has no hash function")
+
+	// errCanceled stops the work of a job that has already failed.
+	errCanceled = errors.New("sakura: canceled")
+)
+
+// NoInterleave is the block size that denotes the absence of interleaving, such
+// that every child of a chaining hop covers a contiguous part of the message.
+var NoInterleave = BlockSize{Mantissa: 0xff, Exponent: 0xff}
+
+// bitWriter writes a bit string to a hash.
+//
+// Whole bytes are gathered in a buffer and handed to the hash in batches, so
+// that the frame bits, trailers and chaining values of a node, and the bits of
+// small leaves, do not each cost a call through the hash.Hash interface: for
+// trees of tiny leaves, these calls would otherwise dominate the cost of
+// hashing. Writes larger than the buffer go to the hash directly.
+type bitWriter struct {
+	h     hash.Hash
+	n     int64 // Number of whole bytes written.
+	bits  byte  // Pending bits that do not yet form a whole byte.
+	nbits uint  // Number of pending bits.
+	nbuf  int   // Number of bytes in buf.
+	buf   [bitBufferSize]byte
+}
+
+// bitBufferSize is the size of the buffer of a bitWriter, which holds eight
+// chaining values of 256 bits.
+const bitBufferSize = 256
+
+// zeros holds the padding written by padSimple, whose alignment is at most
+// 255 bytes.
+var zeros [255]byte
+
+// writeByte appends a whole byte to the buffer.
+func (w *bitWriter) writeByte(b byte) {
+	if w.nbuf == len(w.buf) {
+		w.flush()
+	}
+	w.buf[w.nbuf] = b
+	w.nbuf++
+}
+
+// flush writes the buffered bytes to h.
+func (w *bitWriter) flush() {
+	if w.nbuf > 0 {
+		w.h.Write(w.buf[:w.nbuf])
+		w.nbuf = 0
+	}
+}
+
+// Write writes p to the bit string.
+func (w *bitWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	if w.nbits == 0 {
+		if w.nbuf+len(p) <= len(w.buf) {
+			w.nbuf += copy(w.buf[w.nbuf:], p)
+			return len(p), nil
+		}
+		w.flush()
+		return w.h.Write(p)
+	}
+	for _, b := range p {
+		w.writeByte(w.bits | b<<w.nbits)
+		w.bits = b >> (8 - w.nbits)
+	}
+	return len(p), nil
+}
+
+// WriteBit appends the least significant bit of b, for a Coding.
+func (w *bitWriter) WriteBit(b byte) { w.writeBit(b & 1) }
+
+// Bits returns the number of bits written.
+func (w *bitWriter) Bits() int64 { return w.n*8 + int64(w.nbits) }
+
+// writeBit appends a single bit, which must be 0 or 1.
+func (w *bitWriter) writeBit(b byte) {
+	w.bits |= b << w.nbits
+	w.nbits++
+	if w.nbits == 8 {
+		w.writeByte(w.bits)
+		w.n++
+		w.bits, w.nbits = 0, 0
+	}
+}
+
+// padSimple appends a '1' followed by as many zeros as needed to reach a
+// multiple of align bytes. An align of zero only pads to the next byte.
+func (w *bitWriter) padSimple(align int) {
+	w.writeBit(1)
+	for w.nbits != 0 {
+		w.writeBit(0)
+	}
+	if align > 1 {
+		if r := int(w.n % int64(align)); r != 0 {
+			w.Write(zeros[:align-r])
+		}
+	}
+}
+
+// sum terminates the bit string with pad, simple padding if nil, and appends
+// the hash to dst.
+func (w *bitWriter) sum(dst []byte, pad Padding) []byte {
+	if pad != nil {
+		w.flush()
+		pad.Pad(w.h, w.bits, int(w.nbits))
+		w.bits, w.nbits = 0, 0
+		return w.h.Sum(dst)
+	}
+	w.writeBit(1)
+	for w.nbits != 0 {
+		w.writeBit(0)
+	}
+	w.flush()
+	return w.h.Sum(dst)
+}
+
+// writeLeaf writes the message hop of a leaf holding data to w.
+func writeLeaf(w *bitWriter, mode HashingMode, data []byte) {
+	w.Write(data)
+	writeMessageEnd(w, mode, int64(len(data)))
+}
+
+// writeMessageEnd ends a message hop of n bytes.
+func writeMessageEnd(w *bitWriter, mode HashingMode, n int64) {
+	if cd := mode.Coding; cd != nil {
+		codeMessage(cd, w, n)
+	} else {
+		w.writeBit(1)
+	}
+}
+
+// writeKangaroo ends a message hop nested in the node of its parent, which
+// kangaroo hopping follows with the chaining values of the other children.
+func writeKangaroo(w *bitWriter, mode HashingMode) {
+	if cd := mode.Coding; cd != nil {
+		cd.Kangaroo(w, int(mode.Alignment))
+	} else {
+		w.padSimple(int(mode.Alignment))
+	}
+}
+
+// writeChaining ends the chaining hop of a node coding n chaining values,
+// coding its trailer into trailer, which is grown as needed.
+func writeChaining(w *bitWriter, mode HashingMode, n int, trailer *[]byte) {
+	if cd := mode.Coding; cd != nil {
+		cd.Chaining(w, n, mode.Interleave)
+		return
+	}
+	*trailer = appendTrailer((*trailer)[:0], mode, n)
+	w.Write(*trailer)
+	w.writeBit(0)
+}
+
+// sumFinal ends the final node written to w and appends its hash to dst.
+func sumFinal(w *bitWriter, mode HashingMode, dst []byte) []byte {
+	if cd := mode.Coding; cd != nil {
+		cd.Final(w)
+	} else {
+		w.writeBit(1)
+	}
+	return w.sum(dst, mode.Padding)
+}
+
+// hashLeaf appends the chaining value of a leaf holding data, hashed with w as
+// an inner node, to dst, and resets w.
+func hashLeaf(w *bitWriter, mode HashingMode, data, dst []byte) []byte {
+	writeLeaf(w, mode, data)
+	if cd := mode.Coding; cd != nil {
+		cd.Inner(w)
+	} else {
+		w.writeBit(1) // pad_simple, which needs no alignment here.
+		w.writeBit(0)
+	}
+	dst = w.sum(dst, mode.Padding)
+	w.reset()
+	return dst
+}
+
+// reset empties w for the next node, keeping its hash.
+func (w *bitWriter) reset() {
+	w.h.Reset()
+	w.n, w.bits, w.nbits, w.nbuf = 0, 0, 0, 0
+}
+
+// isChaining reports whether hop is a ChainingHop, ChainingHop64 or
+// ChildStream, returning ErrInvalidHop if it is not exactly one kind of hop.
+func isChaining(hop Hop) (bool, error) {
+	_, chaining := hop.(ChainingHop)
+	switch hop.(type) {
+	case ChainingHop64, ChildStream, *streamedHop:
+		chaining = true
+	}
+	_, message := hop.(MessageHop)
+	if chaining == message {
+		return false, ErrInvalidHop
+	}
+	return chaining, nil
+}
+
+// CacheError records an error loading the cached chaining value of a hop that
+// implements ChainingValueErr.
+type CacheError struct {
+	Node NodeID // ID of the hop.
+	Err  error
+}
+
+func (e *CacheError) Error() string {
+	return fmt.Sprintf("sakura: loading chaining value of %v: %v", e.Node, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *CacheError) Unwrap() error { return e.Err }
+
+// cachedValue returns the cached chaining value of hop, whose ID is id,
+// preferring ChainingValueErr over ChainingValue.
+func cachedValue(hop Hop, id NodeID) ([]byte, error) {
+	h, ok := hop.(ChainingValueErr)
+	if !ok {
+		if hop == nil {
+			// Left for the coding to report as an invalid hop.
+			return nil, nil
+		}
+		return hop.ChainingValue(), nil
+	}
+	cv, err := h.ChainingValueErr()
+	if err != nil {
+		return nil, &CacheError{Node: id, Err: err}
+	}
+	return cv, nil
+}
+
+// ChildError records an error looking up a child of a ChainingHop64.
+type ChildError struct {
+	Node NodeID // ID of the child.
+	Err  error
+}
+
+func (e *ChildError) Error() string {
+	return fmt.Sprintf("sakura: looking up child %v: %v", e.Node, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ChildError) Unwrap() error { return e.Err }
+
+// degree returns the degree of the chaining hop hop, whose ID is id.
+func degree(hop Hop, id NodeID) (int, error) {
+	if s, ok := hop.(*streamedHop); ok {
+		return s.degree(id)
+	}
+	h, ok := hop.(ChainingHop64)
+	if !ok {
+		c, ok := hop.(ChainingHop)
+		if !ok {
+			return 0, errNotIndexed
+		}
+		return c.Degree(), nil
+	}
+	d := h.Degree64()
+	if maxInt := int64(^uint(0) >> 1); d > maxInt {
+		return 0, &LimitError{Node: id, Limit: "degree", Max: maxInt, Value: d}
+	}
+	return int(d), nil
+}
+
+// child returns child i of the chaining hop hop, whose ID is id.
+func child(hop Hop, id NodeID, i int) (Hop, error) {
+	if s, ok := hop.(*streamedHop); ok {
+		return s.child(id, i)
+	}
+	h, ok := hop.(ChainingHop64)
+	if !ok {
+		c, ok := hop.(ChainingHop)
+		if !ok {
+			return nil, errNotIndexed
+		}
+		return c.Child(i), nil
+	}
+	c, err := h.ChildErr(int64(i))
+	if err != nil {
+		return nil, &ChildError{Node: id.Child(i), Err: err}
+	}
+	return c, nil
+}
+
+// LimitError is returned when a tree exceeds a limit set on the Encoder.
+type LimitError struct {
+	Node  NodeID // ID of the hop that exceeds the limit.
+	Limit string // Name of the limit, such as "depth" or "degree".
+	Max   int64  // Value of the limit.
+	Value int64  // Value that exceeds the limit.
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("sakura: %s %d at %v exceeds the limit of %d", e.Limit, e.Value, e.Node, e.Max)
+}
+
+// checkHop checks hop, whose ID is id, against the limits of the encoder and
+// reports whether it is a ChainingHop.
+func (j *job) checkHop(hop Hop, id NodeID) (bool, error) {
+	chaining, err := isChaining(hop)
+	if err == nil {
+		err = checkStream(hop)
+	}
+	if err != nil {
+		return false, err
+	}
+	if max := j.e.MaxDepth; max > 0 && id.Depth() > max {
+		return false, &LimitError{Node: id, Limit: "depth", Max: int64(max), Value: int64(id.Depth())}
+	}
+	_, stream := hop.(ChildStream)
+	if max := j.e.MaxDegree; max > 0 && chaining && !stream {
+		// Streams are checked as they are read, by nthChild.
+		d, err := degree(hop, id)
+		if err != nil {
+			return false, err
+		}
+		if d > max {
+			return false, &LimitError{Node: id, Limit: "degree", Max: int64(max), Value: int64(d)}
+		}
+	}
+	if max := j.e.MaxNodeBytes; max > 0 && chaining && !stream {
+		d, err := degree(hop, id)
+		if err != nil {
+			return false, err
+		}
+		values := d
+		if j.mode.Kangaroo && d > 0 {
+			values-- // The first child is nested.
+		}
+		if size := int64(values) * int64(j.cvSize); size > max {
+			return false, &NodeSizeError{Node: id, Degree: d, Size: size, Max: max}
+		}
+	}
+	return chaining, nil
+}
+
+// NodeSizeError is returned when the coded input of a node exceeds the
+// MaxNodeBytes of the encoder, typically because a chaining hop has so many
+// children that their chaining values alone exceed it.
+type NodeSizeError struct {
+	Node   NodeID // ID of the hop coded when the limit was exceeded.
+	Degree int    // Degree of the chaining hop, if it was checked by it.
+	Size   int64  // Bytes of the node, as far as they were known.
+	Max    int64  // Value of the limit.
+}
+
+func (e *NodeSizeError) Error() string {
+	if e.Degree > 0 {
+		return fmt.Sprintf("sakura: node at %v with %d children codes %d bytes, beyond the limit of %d; spread the children over more chaining hops for a deeper tree, as BuildTree does", e.Node, e.Degree, e.Size, e.Max)
+	}
+	return fmt.Sprintf("sakura: node at %v codes at least %d bytes, beyond the limit of %d; spread its children over more chaining hops for a deeper tree, as BuildTree does", e.Node, e.Size, e.Max)
+}
+
+// checkSize fails with a *NodeSizeError if the node coded so far exceeds the
+// MaxNodeBytes of the encoder.
+func (c *nodeCoder) checkSize() error {
+	if max := c.j.e.MaxNodeBytes; max > 0 && c.w.n > max {
+		return &NodeSizeError{Node: c.id, Size: c.w.n, Max: max}
+	}
+	return nil
+}
+
+// open returns the hop whose children the job reads for the chaining hop hop,
+// whose ID is id, and its degree, or -1 for a ChildStream, whose degree is
+// only known once it ends.
+func (j *job) open(hop Hop, id NodeID) (Hop, int, error) {
+	hop = j.expand(hop)
+	if _, ok := hop.(*streamedHop); ok {
+		return hop, -1, nil
+	}
+	n, err := degree(hop, id)
+	return hop, n, err
+}
+
+// nthChild returns child i of the hop returned by open with degree n, or false
+// past the last child. Children are requested in order, and a stream in
+// particular is read no further than child i.
+func (j *job) nthChild(hop Hop, id NodeID, n, i int) (Hop, bool, error) {
+	if s, ok := hop.(*streamedHop); ok {
+		c, ok, err := s.next(id, i)
+		if ok && j.e.MaxDegree > 0 && i >= j.e.MaxDegree {
+			return nil, false, &LimitError{Node: id, Limit: "degree", Max: int64(j.e.MaxDegree), Value: int64(i + 1)}
+		}
+		return c, ok, err
+	}
+	if i >= n {
+		return nil, false, nil
+	}
+	c, err := child(hop, id, i)
+	return c, err == nil, err
+}
+
+// trailer returns the whole bytes that end a chaining hop coding n chaining
+// values: the coded number of values and the interleaving block size. Unless
+// precomputed, the trailer is coded into buf, which is grown as needed.
+func (j *job) trailer(n int, buf *[]byte) []byte {
+	if t, ok := j.trailers[n]; ok {
+		return t
+	}
+	*buf = appendTrailer((*buf)[:0], j.mode, n)
+	return *buf
+}
+
+// appendTrailer appends the trailer of a chaining hop coding n chaining values
+// to dst.
+func appendTrailer(dst []byte, mode HashingMode, n int) []byte {
+	return sakuracoding.AppendChainingTrailer(dst, uint64(n), mode.Interleave.Mantissa, mode.Interleave.Exponent)
+}
+
+// cvFunc returns the chaining value of a child whose value is coded in its
+// parent's node. Slot is the position of the value among all chaining values
+// of the node, in coding order.
+type cvFunc func(child Hop, id NodeID, slot int) ([]byte, error)
+
+// edges calls fn for every child of hop whose chaining value is coded in the
+// node of hop, in the order that the coding places them. It visits children in
+// exactly the order in which writeNode requests them through a cvFunc.
+// Hops are checked against the limits of the encoder on the way.
+func (j *job) edges(hop Hop, id NodeID, path ancestors, fn func(child Hop, id NodeID) error) error {
+	chaining, err := j.checkHop(hop, id)
+	if err != nil || !chaining {
+		return err
+	}
+	hop, n, err := j.open(hop, id)
+	if err != nil {
+		return err
+	}
+	first := 0
+	if j.mode.Kangaroo {
+		c, ok, err := j.nthChild(hop, id, n, 0)
+		if err != nil || !ok {
+			return err
+		}
+		if err := path.enter(c, id.Child(0)); err != nil {
+			return err
+		}
+		err = j.edges(c, id.Child(0), path, fn)
+		path.exit(c)
+		if err != nil {
+			return err
+		}
+		first = 1
+	}
+	for i := first; ; i++ {
+		c, ok, err := j.nthChild(hop, id, n, i)
+		if err != nil || !ok {
+			return err
+		}
+		if err := fn(c, id.Child(i)); err != nil {
+			return err
+		}
+	}
+}
+
+// nodeCoder writes the coding of a single node.
+type nodeCoder struct {
+	j    *job
+	s    *scratch
+	w    *bitWriter
+	cv   cvFunc
+	id   NodeID // ID of the hop being written.
+	slot int    // Number of chaining values written so far.
+	leaf *int   // Tree order index of the next message hop to be read.
+	path ancestors
+
+	message int64 // Bytes of message hops written so far.
+
+	childFailed bool // Whether an error came from hashing a child.
+}
+
+// writeNode writes the node production for hop, reading message hops through
+// the job and obtaining chaining values from c.cv.
+func (c *nodeCoder) writeNode(hop Hop) error {
+	chaining, err := c.j.checkHop(hop, c.id)
+	if err != nil {
+		return err
+	}
+	if !chaining {
+		if err := c.j.countLeaf(c.id); err != nil {
+			return err
+		}
+		n, err := c.j.message(c.w, hop.(MessageHop), *c.leaf, &c.s.read, c.s.h.BlockSize())
+		if err != nil {
+			switch e := err.(type) {
+			case *LeafError:
+				e.Node = c.id
+				e.Label = label(hop)
+			case *LimitError:
+				e.Node = c.id
+			}
+			return err
+		}
+		*c.leaf++
+		c.message += n
+		writeMessageEnd(c.w, c.j.mode, n)
+		return c.checkSize()
+	}
+
+	hop, n, err := c.j.open(hop, c.id)
+	if err != nil {
+		return err
+	}
+	first := 0
+	kid, ok, err := c.j.nthChild(hop, c.id, n, 0)
+	if err != nil {
+		return err
+	}
+	if c.j.mode.Kangaroo && ok {
+		id := c.id
+		c.id = id.Child(0)
+		if err := c.path.enter(kid, c.id); err != nil {
+			return err
+		}
+		err = c.writeNode(kid)
+		c.path.exit(kid)
+		c.id = id
+		if err != nil {
+			return err
+		}
+		if cd := c.j.mode.Coding; cd != nil {
+			cd.Kangaroo(c.w, int(c.j.mode.Alignment))
+		} else {
+			c.w.padSimple(int(c.j.mode.Alignment))
+		}
+		first = 1
+	}
+	// The number of children is only known once they are all read, which
+	// suits the coding: it ends the chaining hop.
+	i := first
+	for ; ok; i++ {
+		if i > 0 {
+			if kid, ok, err = c.j.nthChild(hop, c.id, n, i); err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+		}
+		v, err := c.cv(kid, c.id.Child(i), c.slot)
+		if err != nil {
+			c.childFailed = true
+			return err
+		}
+		c.j.layer.add(kid, c.id.Child(i), v)
+		c.slot++
+		c.w.Write(v)
+		if err := c.checkSize(); err != nil {
+			return err
+		}
+	}
+	n = i
+	if cd := c.j.mode.Coding; cd != nil {
+		cd.Chaining(c.w, n-first, c.j.mode.Interleave)
+		return nil
+	}
+	c.w.Write(c.j.trailer(n-first, &c.s.trailer))
+	c.w.writeBit(0)
+	return nil
+}
+
+// node describes a node to be hashed.
+type node struct {
+	hop   Hop
+	final bool
+	id    NodeID // ID of the hop.
+	level int    // Distance from the root node.
+	leaf  *int   // Tree order index of the next message hop, advanced on reads.
+	cv    cvFunc // Source of the chaining values coded in the node.
+	asked bool   // Whether the Before hook already declined to supply the hash.
+
+	// Path holds the ancestors of hop, if nested hops are to be checked for
+	// cycles while coding.
+	path ancestors
+}
+
+// hashNode codes n as a final or inner node and returns its hash, calling the
+// encoder's Hooks around it.
+func (j *job) hashNode(n node) ([]byte, error) {
+	hooks := &j.e.Hooks
+	if !hooks.hooked() {
+		return j.codeNode(n, nil)
+	}
+	info := nodeInfo(n)
+	if !n.asked {
+		if sum := j.before(n, info); sum != nil {
+			return sum, nil
+		}
+	}
+	sum, err := j.codeNode(n, &info)
+	if hooks.After != nil {
+		hooks.After(info, sum, err)
+	}
+	return sum, err
+}
+
+// before returns the hash of n that the Before hook supplies, once passed to
+// After, or nil if there is no such hook or it supplies none.
+func (j *job) before(n node, info NodeInfo) []byte {
+	hooks := &j.e.Hooks
+	if hooks.Before == nil {
+		return nil
+	}
+	v := hooks.Before(info)
+	if v == nil {
+		return nil
+	}
+	sum := append(j.sumBuffer(n, len(v)), v...)
+	if hooks.After != nil {
+		hooks.After(info, sum, nil)
+	}
+	return sum
+}
+
+// sumBuffer returns the empty buffer that the hash of n, of size bytes, is
+// appended to.
+func (j *job) sumBuffer(n node, size int) []byte {
+	switch {
+	case !n.final:
+		return j.cvs.alloc(size)
+	case j.out != nil:
+		// The first final node of the job is the root of AppendFinal.
+		dst := j.out[len(j.out):]
+		j.out = nil
+		return dst
+	}
+	return nil
+}
+
+// codeNode codes n as a final or inner node and returns its hash, recording
+// the sizes of the coded node in info if it is not nil.
+func (j *job) codeNode(n node, info *NodeInfo) ([]byte, error) {
+	var start time.Time
+	if j.e.Tracer != nil {
+		start = time.Now()
+	}
+	s := j.e.getScratch()
+	defer j.e.putScratch(s)
+	var h hash.Hash = s.h
+	var input *bytes.Buffer
+	if j.e.Audit != nil && j.e.AuditInputs {
+		input = new(bytes.Buffer)
+		h = &teeHash{h, input}
+	}
+	s.w.h = h
+	c := &nodeCoder{j: j, s: s, w: &s.w, cv: n.cv, id: n.id, leaf: n.leaf, path: n.path}
+	if err := c.writeNode(n.hop); err != nil {
+		if l := j.e.Logger; l != nil && err != errCanceled && !c.childFailed {
+			l.Debug("sakura: node failed", "node", n.id.String(), "level", n.level, "final", n.final, "label", label(n.hop), "err", err)
+		}
+		return nil, err
+	}
+	switch cd := j.mode.Coding; {
+	case cd != nil && n.final:
+		cd.Final(c.w)
+	case cd != nil:
+		cd.Inner(c.w)
+	case n.final:
+		c.w.writeBit(1)
+	default:
+		c.w.writeBit(1) // pad_simple, which needs no alignment here.
+		c.w.writeBit(0)
+	}
+	sum := c.w.sum(j.sumBuffer(n, s.h.Size()), j.mode.Padding)
+	if info != nil {
+		info.MessageBytes, info.ChainingValues, info.CodedBytes = c.message, c.slot, c.w.n
+	}
+	if j.e.Tracer != nil {
+		j.traceNode(n.level, start, c.w.n)
+	}
+	if m := j.e.Metrics; m != nil {
+		m.Add(MetricNodesHashed, 1)
+		m.Add(MetricBytesHashed, c.w.n)
+	}
+	if j.e.Audit != nil {
+		if err := j.audit(n, sum, input); err != nil {
+			return nil, err
+		}
+	}
+	return sum, nil
+}
+
+// audit writes the transcript line of a hashed node to the encoder's Audit
+// writer. Input holds the coded node, if it is to be included.
+func (j *job) audit(n node, sum []byte, input *bytes.Buffer) error {
+	kind := "inner"
+	if n.final {
+		kind = "final"
+	}
+	line := fmt.Appendf(nil, "%v %s %x", n.id, kind, sum)
+	if input != nil {
+		line = fmt.Appendf(line, " %x", input.Bytes())
+	}
+	j.auditMu.Lock()
+	defer j.auditMu.Unlock()
+	_, err := j.e.Audit.Write(append(line, '\n'))
+	return err
+}
+
+// teeHash is a hash that copies everything it absorbs to w.
+type teeHash struct {
+	hash.Hash
+	w io.Writer
+}
+
+func (t *teeHash) Write(p []byte) (int, error) {
+	t.w.Write(p)
+	return t.Hash.Write(p)
+}
+
+// message copies the bits of a message hop to w through a buffer, taken from
+// the encoder's Buffers if it has any and otherwise the one at buf, which is
+// grown as needed. The buffer counts against the job's memory budget while in
+// use, and the copy runs at the job's rate limit. Read errors that the
+// encoder's Retry policy does not retry are reported as a *LeafError for the
+// given leaf index. The copy stops early if the job is cancelled. It returns
+// the number of bytes copied.
+//
+// The buffer is filled before it is written, whatever sizes the reads of the
+// hop return, and holds whole blocks of block bytes, the block size of the hash
+// function, if it is larger than one, so that a leaf is absorbed in large runs
+// of whole blocks.
+func (j *job) message(w io.Writer, r io.Reader, leaf int, buf *[]byte, block int) (int64, error) {
+	n := j.bufferSize()
+	if block > 1 && n > block {
+		n -= n % block
+	}
+	j.budget.acquire(n)
+	defer j.budget.release(n)
+
+	var b []byte
+	if p := j.e.Buffers; p != nil {
+		b = p.get(n)
+		defer p.put(b)
+	} else {
+		if cap(*buf) < n {
+			*buf = make([]byte, n)
+		}
+		b = (*buf)[:n]
+	}
+	retry := j.newLeafRetry(r)
+	var read int64
+	for {
+		m, err := j.fill(r, b)
+		if m > 0 {
+			w.Write(b[:m])
+			read += int64(m)
+			if err := j.countBytes(m); err != nil {
+				return read, err
+			}
+			if err := j.limit.wait(m, j.done); err != nil {
+				return read, err
+			}
+		}
+		if err == io.EOF {
+			return read, nil
+		}
+		if err != nil {
+			if err == errCanceled {
+				return read, err
+			}
+			if err = j.retry(retry, read, err); err == nil {
+				continue
+			}
+			if err == errCanceled {
+				return read, err
+			}
+			return read, &LeafError{Leaf: leaf, Offset: offsetOf(r) + read, Err: err}
+		}
+	}
+}
+
+// fill reads from r into b until b is full or a read fails, checking for
+// cancellation and waiting out pauses before every read.
+func (j *job) fill(r io.Reader, b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		select {
+		case <-j.done:
+			return n, errCanceled
+		default:
+		}
+		if err := j.pause.pass(j.done); err != nil {
+			return n, err
+		}
+		m, err := r.Read(b[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// LeafError records an error reading the bits of a message hop.
+type LeafError struct {
+	// Leaf is the index of the message hop in tree order, that is the order
+	// of a depth-first traversal visiting children by increasing index.
+	// Message hops below children with cached chaining values are not read by
+	// the encoder and are not counted. It is -1 for the leaves read by Prove
+	// and ProveRange, which do not visit the whole tree.
+	Leaf  int
+	Node  NodeID // ID of the message hop.
+	Label string // Label of the message hop, if it is a LabeledHop.
+
+	// Offset is the position of the byte at which the read failed: the
+	// number of bytes read from the hop before, plus its Offset if it is an
+	// OffsetHop, as the leaves of HashFile and HashRanger are. The files of
+	// HashFS and HashDir are leaves of their own, named by Label, so Offset
+	// is a position in the file.
+	Offset int64
+
+	Err error
+}
+
+func (e *LeafError) Error() string {
+	if e.Label != "" {
+		return fmt.Sprintf("sakura: leaf %d at %v (%s), byte %d: %v", e.Leaf, e.Node, e.Label, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("sakura: leaf %d at %v, byte %d: %v", e.Leaf, e.Node, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LeafError) Unwrap() error { return e.Err }
+
+// copyLeaf copies the bits of the message hop hop, whose ID is id and whose
+// index in tree order is leaf, to w, for the functions that read leaves
+// outside of hashing. Read errors are returned as a *LeafError.
+func copyLeaf(w io.Writer, hop Hop, id NodeID, leaf int) error {
+	n, err := io.Copy(w, hop.(MessageHop))
+	if err != nil {
+		return &LeafError{Leaf: leaf, Node: id, Label: label(hop), Offset: offsetOf(hop) + n, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package sakura
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// commitDomain begins the message of a leaf that commits to a value.
+const commitDomain = "sakura.commit"
+
+// CommitRandomness is the number of random bytes that hide each value of a
+// Commitment.
+const CommitRandomness = 32
+
+// Commitment is a vector commitment to a list of values, with which a party
+// publishes a root that binds it to the values and their order without
+// revealing them, and later opens single values against it. It is the root of
+// a PersistentTree of the given fanout whose leaf i commits to value i with
+// CommitRandomness fresh random bytes: the message bits of the leaf are a
+// fixed domain string, the randomness and the value. The randomness keeps
+// values that can be guessed, and the chaining values of the other leaves that
+// an opening reveals, from giving away any value that is not opened.
+type Commitment struct {
+	tree *PersistentTree
+	rand [][]byte
+}
+
+// Opening reveals value Index of a Commitment together with the randomness
+// that hid it and an inclusion proof of its leaf, which VerifyOpening checks.
+type Opening struct {
+	Index      int
+	Value      []byte
+	Randomness []byte
+	Proof      *Proof
+}
+
+// Commit returns a commitment to values, hashed with e in a tree of up to
+// fanout children per node. The values must not be modified afterwards. It
+// panics if fanout is less than 2.
+func (e *Encoder) Commit(values [][]byte, fanout int) (*Commitment, error) {
+	if len(values) == 0 {
+		return nil, errors.New("sakura: no values to commit to")
+	}
+	t, err := NewPersistentTree(e, fanout)
+	if err != nil {
+		return nil, err
+	}
+	c := &Commitment{rand: make([][]byte, len(values))}
+	for i, v := range values {
+		c.rand[i] = make([]byte, CommitRandomness)
+		if _, err := rand.Read(c.rand[i]); err != nil {
+			return nil, err
+		}
+		if t, err = t.Append(commitLeaf(v, c.rand[i])); err != nil {
+			return nil, err
+		}
+	}
+	c.tree = t
+	return c, nil
+}
+
+// commitLeaf returns the message bits of the leaf committing to value with the
+// given randomness, which has CommitRandomness bytes.
+func commitLeaf(value, randomness []byte) []byte {
+	b := append([]byte(commitDomain), randomness...)
+	return append(b, value...)
+}
+
+// Root returns the root of c, which is what is published.
+func (c *Commitment) Root() []byte { return c.tree.Root() }
+
+// Len returns the number of values of c.
+func (c *Commitment) Len() int { return c.tree.Len() }
+
+// Open returns the opening of value i of c.
+func (c *Commitment) Open(i int) (*Opening, error) {
+	proof, err := c.tree.Prove(i)
+	if err != nil {
+		return nil, err
+	}
+	leaf, _ := c.tree.Leaf(i)
+	return &Opening{
+		Index:      i,
+		Value:      leaf[len(commitDomain)+CommitRandomness:],
+		Randomness: c.rand[i],
+		Proof:      proof,
+	}, nil
+}
+
+// VerifyOpening checks that o opens value o.Index of a commitment to n values
+// with the given fanout whose root, hashed in mode, is root. The number of
+// values and the fanout must come from the verifier, like the root, since they
+// fix where each value sits in the tree. It returns ErrMalformedProof if the
+// proof is not one for the leaf of o.Index, and ErrProofMismatch if the value
+// or randomness do not match the root.
+func VerifyOpening(mode HashingMode, root []byte, n, fanout int, o *Opening) error {
+	if n < 1 || fanout < 2 || o.Index < 0 || o.Index >= n || len(o.Randomness) != CommitRandomness || o.Proof == nil {
+		return ErrMalformedProof
+	}
+	if !o.Proof.Leaf.Equal(completeID(n, fanout, o.Index)) {
+		return ErrMalformedProof
+	}
+	return VerifyProof(mode, root, o.Proof, commitLeaf(o.Value, o.Randomness))
+}
@@ -0,0 +1,48 @@
+package sakura
+
+import "github.com/chlin501/sakura/sakuracompact"
+
+// ErrCompactDone is returned by Compact.Write after Compact.Sum ended the
+// stream.
+var ErrCompactDone = sakuracompact.ErrCompactDone
+
+// Compact computes the root that a Writer with the same leaf size computes for
+// a stream, for constrained targets such as TinyGo, embedded systems and
+// WebAssembly in browsers. It is the Compact of package sakuracompact, which
+// builds without the rest of this package, with TinyGo among others, for
+// programs that cannot afford its dependencies.
+type Compact = sakuracompact.Compact
+
+// NewCompact returns a Compact for mode and leaves of leafSize bytes. It fails
+// if the mode has no hash function or needs a BitHash that it lacks.
+func NewCompact(mode HashingMode, leafSize int) (*Compact, error) {
+	if err := New(mode).checkMode(); err != nil {
+		return nil, err
+	}
+	m := sakuracompact.Mode{
+		Hash:       mode.Hash,
+		Kangaroo:   mode.Kangaroo,
+		Alignment:  mode.Alignment,
+		Interleave: sakuracompact.BlockSize(mode.Interleave),
+	}
+	if mode.Coding != nil {
+		m.Coding = compactCoding{mode.Coding}
+	}
+	if mode.Padding != nil {
+		m.Pad = mode.Padding.Pad
+	}
+	return sakuracompact.New(m, leafSize)
+}
+
+// compactCoding is the Coding of a mode as a sakuracompact.Coding.
+type compactCoding struct{ c Coding }
+
+func (c compactCoding) Message(w sakuracompact.BitWriter, n int64) { codeMessage(c.c, w, n) }
+
+func (c compactCoding) Chaining(w sakuracompact.BitWriter, n int, interleave sakuracompact.BlockSize) {
+	c.c.Chaining(w, n, BlockSize(interleave))
+}
+
+func (c compactCoding) Kangaroo(w sakuracompact.BitWriter, alignment int) { c.c.Kangaroo(w, alignment) }
+func (c compactCoding) Final(w sakuracompact.BitWriter)                   { c.c.Final(w) }
+func (c compactCoding) Inner(w sakuracompact.BitWriter)                   { c.c.Inner(w) }
@@ -0,0 +1,54 @@
+package sakura_test
+
+import (
+	"testing"
+
+	"github.com/chlin501/sakura"
+	"github.com/chlin501/sakura/sakuratest"
+)
+
+func TestCompact(t *testing.T) {
+	for seed := uint64(0); seed < 40; seed++ {
+		g := sakuratest.New(seed, sakuratest.Config{})
+		mode := g.Mode()
+		data := g.Bytes(3000)
+		leafSize := 1 + int(seed%7)*37
+
+		w := sakura.NewWriter(sakura.New(mode), leafSize)
+		write(t, w, data)
+		if err := w.Close(); err != nil {
+			t.Fatalf("seed %d: Writer: %v", seed, err)
+		}
+		c, err := sakura.NewCompact(mode, leafSize)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		for range 2 { // Again after Reset.
+			write(t, c, data)
+			if got := c.Sum(nil); string(got) != string(w.Root()) {
+				t.Fatalf("seed %d: Compact root differs from the Writer one", seed)
+			}
+			if _, err := c.Write(nil); err != sakura.ErrCompactDone {
+				t.Fatalf("seed %d: Write after Sum: got %v, want ErrCompactDone", seed, err)
+			}
+			c.Reset()
+		}
+	}
+}
+
+func TestCompactAllocs(t *testing.T) {
+	c, err := sakura.NewCompact(sakura.Mode128(), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := sakura.Pattern(1000)
+	root := make([]byte, 0, 32)
+	allocs := testing.AllocsPerRun(10, func() {
+		c.Reset()
+		c.Write(data)
+		root = c.Sum(root[:0])
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per stream, want none", allocs)
+	}
+}
@@ -0,0 +1,66 @@
+package sakura
+
+import "sync"
+
+// CPUFeatures are the vector instruction sets of the processor that a
+// LaneAccelerator can hash leaves with. A feature is only reported if the
+// operating system also saves the registers it uses.
+type CPUFeatures struct {
+	AVX2   bool // AVX2 on x86-64.
+	AVX512 bool // AVX-512 Foundation on x86-64.
+	NEON   bool // Advanced SIMD on ARM64, which every such processor has.
+}
+
+var detectOnce = sync.OnceValue(detectCPU)
+
+// DetectCPU returns the features of the processor the program runs on,
+// detected once at the first call. ARM64 always reports NEON. On x86-64 the
+// features are detected with CPUID by builds of the gc compiler without the
+// purego tag, and other builds report none, as do other architectures.
+func DetectCPU() CPUFeatures {
+	return detectOnce()
+}
+
+// LaneAccelerator is an Accelerator that hashes several leaves at once in the
+// lanes of vector registers, such as a multi-buffer hash on the processor.
+// Lanes returns the number of leaves it hashes at once with the given
+// features, as returned by DetectCPU, or zero if it does not depend on them.
+//
+// If Encoder.AcceleratorBatch is zero, the batches of an encoder with a
+// LaneAccelerator are sized to a multiple of its lanes, so that every batch
+// but the last of a stream fills them.
+type LaneAccelerator interface {
+	Accelerator
+	Lanes(f CPUFeatures) int
+}
+
+// AcceleratorStats describes how an encoder batches the leaves it hands to its
+// Accelerator.
+type AcceleratorStats struct {
+	Features CPUFeatures // Features of the processor, from DetectCPU.
+	Lanes    int         // Lanes of a LaneAccelerator with Features, or zero.
+	Batch    int         // Leaves per batch, or zero without an Accelerator.
+}
+
+// AcceleratorStats returns the batching of the leaves of Writers of e: the
+// number of leaves per batch, which is AcceleratorBatch if it is set, and
+// otherwise the smallest multiple of the lanes of a LaneAccelerator from 64
+// leaves up, or 64.
+func (e *Encoder) AcceleratorStats() AcceleratorStats {
+	s := AcceleratorStats{Features: DetectCPU()}
+	if e.Accelerator == nil {
+		return s
+	}
+	if la, ok := e.Accelerator.(LaneAccelerator); ok {
+		s.Lanes = max(la.Lanes(s.Features), 0)
+	}
+	switch {
+	case e.AcceleratorBatch > 0:
+		s.Batch = e.AcceleratorBatch
+	case s.Lanes > 0:
+		s.Batch = (defaultAcceleratorBatch + s.Lanes - 1) / s.Lanes * s.Lanes
+	default:
+		s.Batch = defaultAcceleratorBatch
+	}
+	return s
+}
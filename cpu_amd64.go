@@ -0,0 +1,29 @@
+//go:build gc && !purego
+
+package sakura
+
+// cpuid executes CPUID for the leaf eaxArg and subleaf ecxArg.
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+// xgetbv returns XCR0, the register states that the operating system saves.
+func xgetbv() (eax, edx uint32)
+
+func detectCPU() CPUFeatures {
+	var f CPUFeatures
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	if maxLeaf < 7 {
+		return f
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	if ecx1&(1<<27) == 0 { // OSXSAVE, without which XGETBV faults.
+		return f
+	}
+	xcr0, _ := xgetbv()
+	_, ebx7, _, _ := cpuid(7, 0)
+	// AVX needs the XMM and YMM states saved, AVX-512 the opmask and
+	// ZMM states too.
+	ymm := ecx1&(1<<28) != 0 && xcr0&0x6 == 0x6
+	f.AVX2 = ymm && ebx7&(1<<5) != 0
+	f.AVX512 = ymm && xcr0&0xe0 == 0xe0 && ebx7&(1<<16) != 0
+	return f
+}
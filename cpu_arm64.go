@@ -0,0 +1,6 @@
+package sakura
+
+// Advanced SIMD is part of every ARM64 processor.
+func detectCPU() CPUFeatures {
+	return CPUFeatures{NEON: true}
+}
@@ -0,0 +1,7 @@
+//go:build !(amd64 && gc && !purego) && !arm64
+
+package sakura
+
+func detectCPU() CPUFeatures {
+	return CPUFeatures{}
+}
@@ -0,0 +1,121 @@
+package sakura_test
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/chlin501/sakura"
+)
+
+// lanes is a LaneAccelerator hashing on the calling goroutine with the hash of
+// its mode, recording the size of every batch.
+type lanes struct {
+	mode  sakura.HashingMode
+	width func(sakura.CPUFeatures) int
+
+	mu      sync.Mutex
+	batches []int
+}
+
+func (a *lanes) Lanes(f sakura.CPUFeatures) int { return a.width(f) }
+
+func (a *lanes) Submit(nodes [][]byte, done func([][]byte, error)) {
+	a.mu.Lock()
+	a.batches = append(a.batches, len(nodes))
+	a.mu.Unlock()
+	sums := make([][]byte, len(nodes))
+	for i, n := range nodes {
+		h := a.mode.Hash()
+		h.Write(n)
+		sums[i] = h.Sum(nil)
+	}
+	done(sums, nil)
+}
+
+func TestDetectCPU(t *testing.T) {
+	f := sakura.DetectCPU()
+	if runtime.GOARCH == "arm64" && !f.NEON {
+		t.Error("no NEON on arm64")
+	}
+	if runtime.GOARCH != "arm64" && f.NEON {
+		t.Errorf("NEON on %s", runtime.GOARCH)
+	}
+	if runtime.GOARCH != "amd64" && (f.AVX2 || f.AVX512) {
+		t.Errorf("x86 features on %s", runtime.GOARCH)
+	}
+	if f != sakura.DetectCPU() {
+		t.Error("features changed between calls")
+	}
+	t.Logf("%+v", f)
+}
+
+func TestAcceleratorStats(t *testing.T) {
+	mode := sakura.Mode128()
+	for _, tc := range []struct {
+		name  string
+		width func(sakura.CPUFeatures) int
+		batch int // AcceleratorBatch.
+		lanes int
+		want  int
+	}{
+		{"fixed", func(sakura.CPUFeatures) int { return 0 }, 0, 0, 64},
+		{"eight", func(sakura.CPUFeatures) int { return 8 }, 0, 8, 64},
+		{"twelve", func(sakura.CPUFeatures) int { return 12 }, 0, 12, 72},
+		{"wide", func(sakura.CPUFeatures) int { return 100 }, 0, 100, 100},
+		{"set", func(sakura.CPUFeatures) int { return 12 }, 5, 12, 5},
+		{"features", func(f sakura.CPUFeatures) int {
+			switch {
+			case f.AVX512:
+				return 16
+			case f.AVX2:
+				return 8
+			}
+			return 4
+		}, 0, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &lanes{mode: mode, width: tc.width}
+			e, err := sakura.NewEncoder(sakura.WithMode(mode), sakura.WithAccelerator(a, tc.batch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := e.AcceleratorStats()
+			if tc.lanes == 0 {
+				tc.lanes = tc.width(s.Features)
+			}
+			if tc.want == 0 {
+				tc.want = (64 + tc.lanes - 1) / tc.lanes * tc.lanes
+			}
+			if s.Features != sakura.DetectCPU() || s.Lanes != tc.lanes || s.Batch != tc.want {
+				t.Fatalf("got %+v, want %d lanes and batches of %d", s, tc.lanes, tc.want)
+			}
+
+			data := sakura.Pattern(300*17 + 5)
+			w := sakura.NewWriter(e, 17)
+			w.Write(data)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			want := sakura.NewWriter(sakura.New(mode), 17)
+			want.Write(data)
+			if err := want.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if string(w.Root()) != string(want.Root()) {
+				t.Error("accelerated root differs")
+			}
+			for i, n := range a.batches[:len(a.batches)-1] {
+				if n != s.Batch {
+					t.Fatalf("batch %d has %d leaves, want %d", i, n, s.Batch)
+				}
+			}
+		})
+	}
+}
+
+func TestAcceleratorStatsNone(t *testing.T) {
+	if s := sakura.New(sakura.Mode128()).AcceleratorStats(); s.Batch != 0 || s.Lanes != 0 {
+		t.Errorf("got %+v without an accelerator", s)
+	}
+}
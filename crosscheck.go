@@ -0,0 +1,147 @@
+package sakura
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrDivergence is the error of a CrossCheckError for a path whose root
+// differs from that of the serial path.
+var ErrDivergence = errors.New("sakura: hashing paths diverge")
+
+// CrossCheckError reports a path of CrossCheck that failed or computed another
+// root than the serial path.
+type CrossCheckError struct {
+	Path string // Name of the path, such as "parallel" or "streaming".
+	Root []byte // Root computed by the path, nil if it failed.
+	Want []byte // Root computed by the serial path.
+	Err  error  // Error of the path, or ErrDivergence.
+}
+
+func (e *CrossCheckError) Error() string {
+	if e.Err == ErrDivergence {
+		return fmt.Sprintf("sakura: %s path computes %x instead of %x", e.Path, e.Root, e.Want)
+	}
+	return fmt.Sprintf("sakura: %s path failed: %v", e.Path, e.Err)
+}
+
+func (e *CrossCheckError) Unwrap() error { return e.Err }
+
+// crossCheckParallelism is the parallelism of the parallel paths of
+// CrossCheck, unless the encoder has a larger one.
+const crossCheckParallelism = 4
+
+// CrossCheck hashes input with a new encoder for mode and the leaves of
+// DefaultLeafSize bytes. See Encoder.CrossCheck.
+func CrossCheck(mode HashingMode, input []byte) error {
+	return New(mode).CrossCheck(input, DefaultLeafSize)
+}
+
+// CrossCheck hashes input, cut into leaves of leafSize bytes, through every
+// path of this package that computes the root of a Writer, and reports the
+// paths whose root differs from that of the tree of leaves hashed serially, as
+// a *CrossCheckError each, joined. The paths are the tree hashed in parallel,
+// by workers reading the leaves once with VerifyParallel and once without, a
+// Writer fed in writes of varied sizes, serially and in parallel, a streaming
+// Writer, Compact and SumBytes, and, if e has an Accelerator, a Writer whose
+// leaves it hashes. Meant for the CI of systems built on this package and for
+// validating custom Hashers and Accelerators, it hashes input about nine
+// times.
+//
+// Only the mode, the Parallelism and the Accelerator of e are used: every
+// path runs on an encoder of its own.
+func (e *Encoder) CrossCheck(input []byte, leafSize int) error {
+	if leafSize <= 0 {
+		return errors.New("sakura: non-positive leaf size")
+	}
+	if err := e.checkMode(); err != nil {
+		return err
+	}
+	par := max(e.Parallelism, crossCheckParallelism)
+	encoder := func(par int) *Encoder {
+		c := New(e.mode)
+		c.Parallelism = par
+		return c
+	}
+	tree := func(c *Encoder) ([]byte, error) {
+		leaves := []Hop{messageLeaf(input[:min(leafSize, len(input))])}
+		for off := leafSize; off < len(input); off += leafSize {
+			leaves = append(leaves, messageLeaf(input[off:min(off+leafSize, len(input))]))
+		}
+		return c.Final(sequentialTree(leaves))
+	}
+	want, err := tree(encoder(0))
+	if err != nil {
+		return err
+	}
+
+	paths := []struct {
+		name string
+		root func() ([]byte, error)
+	}{
+		{"parallel", func() ([]byte, error) { return tree(encoder(par)) }},
+		{"parallel verified", func() ([]byte, error) {
+			c := encoder(par)
+			c.VerifyParallel = true
+			return tree(c)
+		}},
+		{"writer", func() ([]byte, error) { return crossWrite(NewWriter(encoder(0), leafSize), input) }},
+		{"parallel writer", func() ([]byte, error) { return crossWrite(NewWriter(encoder(par), leafSize), input) }},
+		{"streaming", func() ([]byte, error) {
+			w := NewWriter(encoder(par), leafSize)
+			if err := w.SetStreaming(); err != nil {
+				return nil, err
+			}
+			return crossWrite(w, input)
+		}},
+		{"compact", func() ([]byte, error) {
+			c, err := NewCompact(e.mode, leafSize)
+			if err != nil {
+				return nil, err
+			}
+			c.Write(input)
+			return c.Sum(nil), nil
+		}},
+		{"sumbytes", func() ([]byte, error) { return encoder(par).SumBytes(input, leafSize) }},
+	}
+	if e.Accelerator != nil {
+		paths = append(paths, struct {
+			name string
+			root func() ([]byte, error)
+		}{"accelerator", func() ([]byte, error) {
+			c := encoder(par)
+			c.Accelerator, c.AcceleratorBatch = e.Accelerator, e.AcceleratorBatch
+			return crossWrite(NewWriter(c, leafSize), input)
+		}})
+	}
+
+	var errs []error
+	for _, p := range paths {
+		root, err := p.root()
+		switch {
+		case err != nil:
+			errs = append(errs, &CrossCheckError{Path: p.name, Want: want, Err: err})
+		case !bytes.Equal(root, want):
+			errs = append(errs, &CrossCheckError{Path: p.name, Root: root, Want: want, Err: ErrDivergence})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// crossWrite writes input to w in writes of varied sizes, so that leaf
+// boundaries fall inside and at the ends of writes, and returns the root.
+func crossWrite(w *Writer, input []byte) ([]byte, error) {
+	sizes := []int{1, 7, 64, 1000, 4096, 3}
+	for i := 0; len(input) > 0; i++ {
+		n := min(sizes[i%len(sizes)], len(input))
+		if _, err := w.Write(input[:n]); err != nil {
+			return nil, err
+		}
+		input = input[n:]
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return w.Root(), nil
+}
@@ -0,0 +1,47 @@
+package sakura
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CycleError is returned when a hop is its own ancestor, which would otherwise
+// make the tree infinitely deep.
+type CycleError struct {
+	Node NodeID // ID at which the ancestor was reached again.
+}
+
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("sakura: hop at %v is its own ancestor", e.Node)
+}
+
+// ancestors holds the hops on the path from the root to the hop being
+// visited. Hops are identified by pointer identity, so hops that are not
+// pointers are not tracked. A nil ancestors tracks nothing.
+type ancestors map[Hop]struct{}
+
+// enter adds hop, whose ID is id, to the path, failing with a *CycleError if
+// it is already on it.
+func (a ancestors) enter(hop Hop, id NodeID) error {
+	if !a.tracks(hop) {
+		return nil
+	}
+	if _, ok := a[hop]; ok {
+		return &CycleError{Node: id}
+	}
+	a[hop] = struct{}{}
+	return nil
+}
+
+// exit removes hop from the path.
+func (a ancestors) exit(hop Hop) {
+	if a.tracks(hop) {
+		delete(a, hop)
+	}
+}
+
+// tracks reports whether a tracks hop. Hops of other kinds than pointers may
+// not even be valid map keys, as slices are not.
+func (a ancestors) tracks(hop Hop) bool {
+	return a != nil && hop != nil && reflect.TypeOf(hop).Kind() == reflect.Pointer
+}
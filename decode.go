@@ -0,0 +1,184 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DecodeError describes input rejected by a decoder as malformed. It matches
+// ErrMalformed under errors.Is.
+type DecodeError struct {
+	Format string // Name of the format, such as "proof" or "tree file".
+	Offset int64  // Offset in the input at which the error was detected.
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("sakura: malformed %s at offset %d: %s", e.Format, e.Offset, e.Reason)
+}
+
+// Is reports whether target is ErrMalformed.
+func (e *DecodeError) Is(target error) bool { return target == ErrMalformed }
+
+// DecodeLimits bound the resources that decoding untrusted input may take.
+// Input that exceeds a limit fails with a *LimitError. Zero fields mean no
+// limit.
+//
+// Decoders are linear in the size of their input and never allocate much more
+// than it holds, so MaxBytes bounds both time and memory. MaxDepth bounds the
+// nesting of trees and proofs, which are hashed recursively, MaxNodes the
+// number of nodes rebuilt from the input and MaxLeaves the number of leaves
+// whose bits a verifier then hashes.
+type DecodeLimits struct {
+	MaxBytes  int64 // Size of the input.
+	MaxDepth  int   // Depth of a tree, in edges from the root, or of a node ID.
+	MaxNodes  int   // Number of nodes of a tree, or of hops of a proof.
+	MaxLeaves int   // Number of leaves of a tree, or proven by a proof.
+}
+
+// DefaultDecodeLimits are the limits of ReadTree, Restore and
+// Proof.UnmarshalBinary. The depth limit is far beyond any tree built by this
+// package, while services that decode untrusted input should also set
+// MaxBytes.
+var DefaultDecodeLimits = DecodeLimits{MaxDepth: 1 << 12}
+
+// check returns a *LimitError if value exceeds the limit max of the given
+// name at node, unless max is zero.
+func (l DecodeLimits) check(name string, max, value int64, node NodeID) error {
+	if max > 0 && value > max {
+		return &LimitError{Node: node, Limit: name, Max: max, Value: value}
+	}
+	return nil
+}
+
+// appendBytes appends v to b, prefixed with its length.
+func appendBytes(b, v []byte) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(v))), v...)
+}
+
+// decoder reads the fields of a serialized structure, recording the first
+// error.
+type decoder struct {
+	format string
+	size   int // Length of the input.
+	b      []byte
+	err    error
+}
+
+func newDecoder(format string, data []byte) *decoder {
+	return &decoder{format: format, size: len(data), b: data}
+}
+
+// fail records a *DecodeError for the given reason at the current offset,
+// unless an error is already recorded.
+func (d *decoder) fail(reason string) {
+	if d.err == nil {
+		d.err = &DecodeError{Format: d.format, Offset: int64(d.size - len(d.b)), Reason: reason}
+	}
+	d.b = nil
+}
+
+// limit records err, if not nil, unless an error is already recorded.
+func (d *decoder) limit(err error) {
+	if err != nil && d.err == nil {
+		d.err = err
+		d.b = nil
+	}
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) == 0 {
+		d.fail("unexpected end of input")
+		return 0
+	}
+	c := d.b[0]
+	d.b = d.b[1:]
+	return c
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail("invalid varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// int reads a non-negative int.
+func (d *decoder) int() int {
+	v := d.uvarint()
+	if v > uint64(^uint(0)>>1) {
+		d.fail("integer out of range")
+		return 0
+	}
+	return int(v)
+}
+
+// int64 reads a non-negative int64, such as a length in bytes, which may not
+// fit in an int on 32-bit platforms.
+func (d *decoder) int64() int64 {
+	v := d.uvarint()
+	if v > 1<<63-1 {
+		d.fail("integer out of range")
+		return 0
+	}
+	return int64(v)
+}
+
+// count reads the number of elements that follow, each of which takes at
+// least one byte, so that corrupt counts cannot cause huge allocations.
+func (d *decoder) count() int {
+	v := d.uvarint()
+	if v > uint64(len(d.b)) {
+		d.fail("count exceeds input")
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail("length exceeds input")
+		return nil
+	}
+	v := append([]byte(nil), d.b[:n]...)
+	d.b = d.b[n:]
+	return v
+}
+
+// fixed reads n bytes, such as a chaining value.
+func (d *decoder) fixed(n int) []byte {
+	if len(d.b) < n {
+		d.fail("unexpected end of input")
+		return nil
+	}
+	v := append([]byte(nil), d.b[:n]...)
+	d.b = d.b[n:]
+	return v
+}
+
+// version reads a format version byte and checks that it is want.
+func (d *decoder) version(want byte) {
+	d.versions(want, want)
+}
+
+// versions reads a format version byte, checks that it is from oldest to
+// current, and returns it.
+func (d *decoder) versions(oldest, current byte) byte {
+	v := d.byte()
+	if d.err == nil && (v < oldest || v > current) {
+		d.fail(fmt.Sprintf("unknown version %d", v))
+	}
+	return v
+}
+
+// end checks that the input is fully consumed and returns the recorded error.
+func (d *decoder) end() error {
+	if d.err == nil && len(d.b) != 0 {
+		d.fail("trailing data")
+	}
+	return d.err
+}
@@ -0,0 +1,95 @@
+package sakura_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/chlin501/sakura"
+)
+
+// malformed fails t unless err reports malformed input with a *DecodeError.
+func malformed(t *testing.T, what string, err error) {
+	t.Helper()
+	var de *sakura.DecodeError
+	if !errors.As(err, &de) || !errors.Is(err, sakura.ErrMalformed) {
+		t.Errorf("%s: got %v, want a *DecodeError", what, err)
+	}
+}
+
+// corrupt returns data with the byte at i changed.
+func corrupt(data []byte, i int) []byte {
+	b := append([]byte(nil), data...)
+	b[i] ^= 0xa5
+	return b
+}
+
+func testTree(t *testing.T) (sakura.HashingMode, []byte) {
+	t.Helper()
+	mode := sakura.Mode128()
+	data := []byte("a tree of a few leaves, cut small")
+	b, err := sakura.New(mode).MarshalTree(sakura.BuildTree(splitLeaves(data, 5), 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mode, b
+}
+
+func TestReadTreeTruncated(t *testing.T) {
+	mode, b := testTree(t)
+	for n := 0; n < len(b); n++ {
+		_, err := sakura.UnmarshalTree(b[:n], mode)
+		malformed(t, "truncated tree file", err)
+	}
+	_, err := sakura.UnmarshalTree(append(b, 0), mode)
+	malformed(t, "trailing data", err)
+}
+
+func TestReadTreeCorrupt(t *testing.T) {
+	mode, b := testTree(t)
+	for i := range b {
+		// A changed leaf byte still makes a valid tree, so only the error, if
+		// any, is checked.
+		_, err := sakura.UnmarshalTree(corrupt(b, i), mode)
+		if err != nil && !errors.Is(err, sakura.ErrMalformed) && !errors.Is(err, sakura.ErrModeMismatch) {
+			t.Errorf("byte %d changed: got %v", i, err)
+		}
+	}
+	if _, err := sakura.UnmarshalTree(b, sakura.Mode256()); !errors.Is(err, sakura.ErrModeMismatch) {
+		t.Errorf("other mode: got %v, want ErrModeMismatch", err)
+	}
+}
+
+func TestReadTreeLimits(t *testing.T) {
+	mode, b := testTree(t)
+	l := sakura.DefaultDecodeLimits
+	l.MaxLeaves = 2
+	if _, err := l.ReadTree(bytes.NewReader(b), mode); err == nil {
+		t.Error("tree beyond MaxLeaves was read")
+	}
+}
+
+func TestUnmarshalProofMalformed(t *testing.T) {
+	leaves := splitLeaves([]byte("a tree of a few leaves, cut small"), 5)
+	p, err := sakura.New(sakura.Mode128()).ProveLeaf(sakura.BuildTree(leaves, 3), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(b); n++ {
+		_, err := sakura.DefaultDecodeLimits.UnmarshalProof(b[:n])
+		malformed(t, "truncated proof", err)
+	}
+	_, err = sakura.DefaultDecodeLimits.UnmarshalProof(append(b, 0))
+	malformed(t, "trailing data", err)
+	for i := range b {
+		// Decoding must fail cleanly or yield a proof that fails cleanly.
+		q, err := sakura.DefaultDecodeLimits.UnmarshalProof(corrupt(b, i))
+		if err == nil {
+			sakura.VerifyProof(sakura.Mode128(), make([]byte, 32), q, nil)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package sakura
+
+import "bytes"
+
+// Delta describes how a new version of a tree differs from an old one, leaf
+// by leaf, so that a transfer tool holding the old version fetches only the
+// leaves it cannot rebuild, as rsync does. Leaves are numbered in tree order.
+type Delta struct {
+	OldLeaves int // Number of leaves of the old version.
+	NewLeaves int // Number of leaves of the new version.
+
+	// Changed holds the ranges of leaves of the new version that differ from
+	// the leaves at the same index in the old one, in increasing order and
+	// with adjacent ranges merged.
+	Changed []LeafRange
+
+	// Leaves describes every changed leaf, in increasing order.
+	Leaves []LeafDelta
+}
+
+// LeafDelta is a changed leaf of a Delta.
+type LeafDelta struct {
+	Leaf int // Index of the leaf in the new version.
+
+	// ChainingValue is the chaining value of the leaf in the new version,
+	// against which its fetched bits are checked, or nil if it is not known.
+	ChainingValue []byte
+
+	// Old is the index of a leaf of the old version with the same chaining
+	// value, whose bits can be copied instead of fetched, or -1 if there is
+	// none.
+	Old int
+}
+
+// DiffLayers returns the delta from the old version a of a stream to the new
+// version b, both given by the chaining values of their leaves, as returned
+// by Encoder.LeafHashes or HashLayer for the same leaf size, so that it is
+// computed from the manifests of the versions alone. Leaves without a value
+// are changed.
+func DiffLayers(a, b [][]byte) *Delta {
+	var d differ
+	for i, cv := range b {
+		if cv != nil && i < len(a) && bytes.Equal(cv, a[i]) {
+			d.leaf++
+		} else {
+			d.changed(1)
+		}
+	}
+	return newDelta(a, b, d.ranges)
+}
+
+// TreeDelta returns the delta from the tree a of an old version to the tree b
+// of a new one, with the changed ranges that Diff returns. Like Diff, it reads
+// no message bits and relies on cached chaining values, so both trees are
+// typically hashed, or loaded with their values, beforehand.
+func TreeDelta(a, b Hop) (*Delta, error) {
+	ranges, err := Diff(a, b)
+	if err != nil {
+		return nil, err
+	}
+	ol, err := treeLeafValues(a)
+	if err != nil {
+		return nil, err
+	}
+	nl, err := treeLeafValues(b)
+	if err != nil {
+		return nil, err
+	}
+	return newDelta(ol, nl, ranges), nil
+}
+
+// treeLeafValues returns the cached chaining values of the leaves of tree, in
+// tree order, nil where there is none.
+func treeLeafValues(tree Hop) ([][]byte, error) {
+	var cvs [][]byte
+	err := Walk(tree, func(id NodeID, hop Hop) error {
+		if chaining, _ := isChaining(hop); chaining {
+			return nil
+		}
+		cv, err := cachedValue(hop, id)
+		cvs = append(cvs, cv)
+		return err
+	}, nil)
+	return cvs, err
+}
+
+// newDelta returns the delta of the changed ranges of b, given the leaf values
+// of the old version a and of the new version b.
+func newDelta(a, b [][]byte, changed []LeafRange) *Delta {
+	index := make(map[string]int, len(a))
+	for i, cv := range a {
+		if _, ok := index[string(cv)]; cv != nil && !ok {
+			index[string(cv)] = i
+		}
+	}
+	d := &Delta{OldLeaves: len(a), NewLeaves: len(b), Changed: changed}
+	for _, r := range changed {
+		for i := r.Start; i < r.End; i++ {
+			ld := LeafDelta{Leaf: i, Old: -1}
+			if i < len(b) && b[i] != nil {
+				ld.ChainingValue = b[i]
+				if o, ok := index[string(b[i])]; ok {
+					ld.Old = o
+				}
+			}
+			d.Leaves = append(d.Leaves, ld)
+		}
+	}
+	return d
+}
+
+// Fetch returns the ranges of leaves of the new version that cannot be copied
+// from the old one, in increasing order and with adjacent ranges merged.
+func (d *Delta) Fetch() []LeafRange {
+	var f differ
+	for _, ld := range d.Leaves {
+		if ld.Old >= 0 {
+			continue
+		}
+		f.leaf = ld.Leaf
+		f.changed(1)
+	}
+	return f.ranges
+}
+
+// FetchBytes returns the byte ranges of the leaves returned by Fetch, for a new
+// version of size bytes cut into leaves of leafSize bytes, as the tree built
+// by Writer is.
+func (d *Delta) FetchBytes(size int64, leafSize int) []ByteRange {
+	var ranges []ByteRange
+	for _, r := range d.Fetch() {
+		off := int64(r.Start) * int64(leafSize)
+		ranges = append(ranges, ByteRange{Off: off, Len: min(int64(r.End)*int64(leafSize), size) - off})
+	}
+	return ranges
+}
@@ -0,0 +1,47 @@
+package sakura
+
+// Shape summarizes the shape of a tree of hops.
+type Shape struct {
+	Height   int         // Number of edges on the longest path from the root to a leaf.
+	Leaves   int         // Number of message hops.
+	Chaining int         // Number of chaining hops.
+	Degrees  map[int]int // Number of chaining hops with each degree.
+
+	// MessageBytes is the total size of the message hops that report their
+	// size through a Size() int64 method, as bytes.Reader, strings.Reader and
+	// io.SectionReader do. UnsizedLeaves counts those that do not.
+	MessageBytes  int64
+	UnsizedLeaves int
+}
+
+// Describe returns the shape of the tree rooted at hop. It reads no message
+// bits, so it can be used to check that a tree was built as intended before
+// hashing it.
+func Describe(hop Hop) (Shape, error) {
+	s := Shape{Degrees: make(map[int]int)}
+	err := Walk(hop, func(id NodeID, hop Hop) error {
+		if d := id.Depth(); d > s.Height {
+			s.Height = d
+		}
+		if chaining, _ := isChaining(hop); chaining {
+			d, err := degree(hop, id)
+			if err != nil {
+				return err
+			}
+			s.Chaining++
+			s.Degrees[d]++
+			return nil
+		}
+		s.Leaves++
+		if sz, ok := hop.(interface{ Size() int64 }); ok {
+			s.MessageBytes += sz.Size()
+		} else {
+			s.UnsizedLeaves++
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return Shape{}, err
+	}
+	return s, nil
+}
@@ -0,0 +1,154 @@
+package sakura
+
+import "bytes"
+
+// LeafRange is a half-open range [Start, End) of leaf indices. Leaves are the
+// message hops of a tree, numbered in tree order.
+type LeafRange struct {
+	Start, End int
+}
+
+// Len returns the number of leaves in the range.
+func (r LeafRange) Len() int { return r.End - r.Start }
+
+// Diff compares two trees by their cached chaining values and returns the
+// ranges of leaves of b that differ from a, in increasing order and with
+// adjacent ranges merged.
+//
+// Subtrees whose roots report equal chaining values are considered identical
+// without being visited further. Hops that report no chaining value, such as
+// hops that were never hashed or first children nested by kangaroo hopping,
+// are compared through their children, and leaves without a chaining value
+// on either side are reported as changed. Leaves of b beyond the shape of a
+// are changed as well. Diff reads no message bits.
+func Diff(a, b Hop) ([]LeafRange, error) {
+	d := differ{path: make(ancestors)}
+	if err := d.diff(a, b, NodeID{}); err != nil {
+		return nil, err
+	}
+	return d.ranges, nil
+}
+
+// DiffLeaves compares the leaves of tree with stored leaf chaining values,
+// given in tree order, and returns the ranges of leaves of tree whose values
+// are missing or differ.
+func DiffLeaves(tree Hop, leaves [][]byte) ([]LeafRange, error) {
+	var d differ
+	err := Walk(tree, func(id NodeID, hop Hop) error {
+		if chaining, _ := isChaining(hop); chaining {
+			return nil
+		}
+		i := d.leaf
+		cv, err := cachedValue(hop, id)
+		if err != nil {
+			return err
+		}
+		if cv != nil && i < len(leaves) && bytes.Equal(cv, leaves[i]) {
+			d.leaf++
+		} else {
+			d.changed(1)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return d.ranges, nil
+}
+
+// CountLeaves returns the number of message hops in the tree rooted at hop.
+func CountLeaves(hop Hop) (int, error) {
+	n := 0
+	err := Walk(hop, func(_ NodeID, hop Hop) error {
+		if _, ok := hop.(MessageHop); ok {
+			n++
+		}
+		return nil
+	}, nil)
+	return n, err
+}
+
+// differ accumulates the changed leaf ranges of a tree.
+type differ struct {
+	leaf   int // Index of the next leaf.
+	ranges []LeafRange
+	path   ancestors // Ancestors of the hop of b being compared.
+}
+
+// changed marks the next n leaves as changed.
+func (d *differ) changed(n int) {
+	if n == 0 {
+		return
+	}
+	if k := len(d.ranges); k > 0 && d.ranges[k-1].End == d.leaf {
+		d.ranges[k-1].End += n
+	} else {
+		d.ranges = append(d.ranges, LeafRange{d.leaf, d.leaf + n})
+	}
+	d.leaf += n
+}
+
+// diff compares the subtree b, whose ID is id, with its counterpart a, which is
+// nil if there is none.
+func (d *differ) diff(a, b Hop, id NodeID) error {
+	bChaining, err := isChaining(b)
+	if err != nil {
+		return err
+	}
+	if err := d.path.enter(b, id); err != nil {
+		return err
+	}
+	defer d.path.exit(b)
+	aChaining := false
+	if a != nil {
+		if aChaining, err = isChaining(a); err != nil {
+			return err
+		}
+		cva, err := cachedValue(a, id)
+		if err != nil {
+			return err
+		}
+		cvb, err := cachedValue(b, id)
+		if err != nil {
+			return err
+		}
+		if cva != nil && cvb != nil && bytes.Equal(cva, cvb) {
+			n, err := CountLeaves(b)
+			d.leaf += n
+			return err
+		}
+	}
+	if !bChaining {
+		d.changed(1)
+		return nil
+	}
+	if a == nil || !aChaining {
+		n, err := CountLeaves(b)
+		d.changed(n)
+		return err
+	}
+	na, err := degree(a, id)
+	if err != nil {
+		return err
+	}
+	nb, err := degree(b, id)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < nb; i++ {
+		var ca Hop
+		if i < na {
+			if ca, err = child(a, id, i); err != nil {
+				return err
+			}
+		}
+		cb, err := child(b, id, i)
+		if err != nil {
+			return err
+		}
+		if err := d.diff(ca, cb, id.Child(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
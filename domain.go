@@ -0,0 +1,92 @@
+package sakura
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// MaxDomainLength is the length of the longest domain of WithDomain.
+const MaxDomainLength = 255
+
+// WithDomain returns mode with a domain string of the application, such as
+// "myproto/v2", appended to the coding of its final nodes, so that the roots
+// of one protocol cannot be taken for those of another that hashes the same
+// data with the same parameters. Chaining values are those of mode, so
+// subtrees can still be shared across domains.
+//
+// After the frame bits of the final node, the coding of the final node
+// appends pad_simple up to the next byte, the domain and its length, coded as
+// the integers of the coding of mode are. The domain thus occupies whole bytes
+// of its own, never mixed with frame bits, and can be read back from the end
+// of the node. The domain is part of the name of the coding, and so of the
+// fingerprint of the mode. It must be valid for ValidDomain.
+func WithDomain(mode HashingMode, domain string) (HashingMode, error) {
+	if err := ValidDomain(domain); err != nil {
+		return HashingMode{}, err
+	}
+	c := mode.Coding
+	if c == nil {
+		c = SakuraCoding{}
+	}
+	mode.Coding = domainCoding{Coding: c, domain: domain}
+	return mode, nil
+}
+
+// ValidDomain checks that domain can be given to WithDomain: that it is not
+// empty, is at most MaxDomainLength bytes long and holds no zero byte, which
+// separates the names of the coding and the padding in the fingerprint of a
+// mode.
+func ValidDomain(domain string) error {
+	switch {
+	case domain == "":
+		return errors.New("sakura: empty domain")
+	case len(domain) > MaxDomainLength:
+		return errors.New("sakura: domain longer than " + strconv.Itoa(MaxDomainLength) + " bytes")
+	case strings.IndexByte(domain, 0) >= 0:
+		return errors.New("sakura: domain holds a zero byte")
+	}
+	return nil
+}
+
+// Domain returns the domain of mode set by WithDomain, or the empty string if
+// it has none.
+func Domain(mode HashingMode) string {
+	for c := mode.Coding; c != nil; {
+		switch cc := c.(type) {
+		case domainCoding:
+			return cc.domain
+		case saltCoding:
+			c = cc.Coding
+		case leafLengthCoding:
+			c = cc.Coding
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// domainCoding is a Coding that appends a domain to final nodes. Since every
+// final node ends with the same bytes, the coding stays as decodable as the
+// one it wraps.
+type domainCoding struct {
+	Coding
+	domain string
+}
+
+func (c domainCoding) Final(w BitWriter) {
+	c.Coding.Final(w)
+	w.WriteBit(1)
+	for w.Bits()%8 != 0 {
+		w.WriteBit(0)
+	}
+	w.Write(intFormatOf(c.Coding).Append([]byte(c.domain), uint64(len(c.domain))))
+}
+
+// MessageLength passes the length of a message hop on to the wrapped coding.
+func (c domainCoding) MessageLength(w BitWriter, n int64) { codeMessage(c.Coding, w, n) }
+
+func (c domainCoding) Name() string {
+	return c.Coding.Name() + "+sakura.domain:" + strconv.Quote(c.domain)
+}
@@ -0,0 +1,203 @@
+package sakura
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolExhausted is returned by EncoderPool.Get for a mode that the pool
+// does not hold when it holds MaxModes encoders, all of them in use.
+var ErrPoolExhausted = errors.New("sakura: encoder pool holds its maximum of modes, all in use")
+
+// EncoderPool holds warmed-up encoders, one per hashing mode, for services
+// that hash many concurrent requests in a few modes, so that a request pays
+// neither the validation of its mode nor the allocation of the encoder and of
+// its first hash states. Since an Encoder is safe for concurrent use, the
+// calls in one mode share its encoder, up to MaxCalls of them at once. Modes
+// with the same ModeHeader share an encoder.
+//
+// An EncoderPool is safe for concurrent use.
+type EncoderPool struct {
+	limits EncoderPoolLimits
+	opts   []Option
+
+	mu    sync.Mutex
+	modes map[ModeHeader]*pooledEncoder
+	stats EncoderPoolStats
+}
+
+// EncoderPoolLimits bound an EncoderPool. Zero fields set no bound.
+type EncoderPoolLimits struct {
+	// MaxModes is the number of encoders held at once. Getting another mode
+	// drops the encoder least recently used, unless all are in use.
+	MaxModes int
+
+	// MaxCalls is the number of calls that may use the encoder of a mode at
+	// once, beyond which Get waits.
+	MaxCalls int
+
+	// IdleTimeout is the time after which an encoder that no call uses is
+	// dropped, by the next call to Get or Reap.
+	IdleTimeout time.Duration
+}
+
+// EncoderPoolStats describes the encoders of an EncoderPool.
+type EncoderPoolStats struct {
+	Modes   int   // Encoders held.
+	Calls   int   // Calls using an encoder or waiting for one.
+	Created int64 // Encoders created.
+	Reaped  int64 // Encoders dropped for being idle.
+	Evicted int64 // Encoders dropped to make room for another mode.
+}
+
+// pooledEncoder is an encoder of an EncoderPool.
+type pooledEncoder struct {
+	e     *Encoder
+	sem   chan struct{} // Holds a token for every call, if MaxCalls is set.
+	calls int           // Calls using or waiting for the encoder.
+	used  time.Time     // End of the last call.
+}
+
+// NewEncoderPool returns a pool whose encoders are created, for the mode of
+// each, by NewEncoder with opts, followed by the mode itself, which overrides
+// the options that set parameters of the mode.
+func NewEncoderPool(limits EncoderPoolLimits, opts ...Option) (*EncoderPool, error) {
+	if limits.MaxModes < 0 || limits.MaxCalls < 0 || limits.IdleTimeout < 0 {
+		return nil, errors.New("sakura: negative encoder pool limit")
+	}
+	return &EncoderPool{limits: limits, opts: opts, modes: make(map[ModeHeader]*pooledEncoder)}, nil
+}
+
+// Get returns the encoder of mode, creating it if the pool does not hold it,
+// and the function that ends the call, which must be called once the encoder
+// is no longer used. If MaxCalls calls use the encoder, Get waits for one to
+// end, or for ctx to be done. The encoder must not be modified.
+func (p *EncoderPool) Get(ctx context.Context, mode HashingMode) (*Encoder, func(), error) {
+	if mode.Hash == nil {
+		return nil, nil, ErrNoHash
+	}
+	key := mode.Header()
+	p.mu.Lock()
+	p.reap(time.Now())
+	pe := p.modes[key]
+	if pe == nil {
+		// The encoder is created without the lock, as checking a strict
+		// mode runs its hash function.
+		p.mu.Unlock()
+		e, err := p.newEncoder(mode)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.mu.Lock()
+		if pe = p.modes[key]; pe == nil {
+			if max := p.limits.MaxModes; max > 0 && len(p.modes) >= max && !p.evict() {
+				p.mu.Unlock()
+				return nil, nil, ErrPoolExhausted
+			}
+			pe = &pooledEncoder{e: e}
+			if p.limits.MaxCalls > 0 {
+				pe.sem = make(chan struct{}, p.limits.MaxCalls)
+			}
+			p.modes[key] = pe
+			p.stats.Created++
+		}
+	}
+	pe.calls++
+	p.mu.Unlock()
+
+	if pe.sem != nil {
+		select {
+		case pe.sem <- struct{}{}:
+		case <-ctx.Done():
+			p.done(pe)
+			return nil, nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	return pe.e, func() {
+		once.Do(func() {
+			if pe.sem != nil {
+				<-pe.sem
+			}
+			p.done(pe)
+		})
+	}, nil
+}
+
+// newEncoder returns a validated encoder for mode with its first hash state
+// ready.
+func (p *EncoderPool) newEncoder(mode HashingMode) (*Encoder, error) {
+	e, err := NewEncoder(append(p.opts[:len(p.opts):len(p.opts)], WithMode(mode))...)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	e.putScratch(e.getScratch())
+	return e, nil
+}
+
+// done records the end of a call to the encoder pe.
+func (p *EncoderPool) done(pe *pooledEncoder) {
+	p.mu.Lock()
+	pe.calls--
+	pe.used = time.Now()
+	p.mu.Unlock()
+}
+
+// Reap drops the encoders that have been idle for IdleTimeout and returns
+// their number. Get reaps as well, so Reap is only needed to release the
+// memory of a pool that is no longer asked for encoders.
+func (p *EncoderPool) Reap() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reap(time.Now())
+}
+
+func (p *EncoderPool) reap(now time.Time) int {
+	if p.limits.IdleTimeout <= 0 {
+		return 0
+	}
+	n := 0
+	for key, pe := range p.modes {
+		if pe.calls == 0 && now.Sub(pe.used) >= p.limits.IdleTimeout {
+			delete(p.modes, key)
+			n++
+		}
+	}
+	p.stats.Reaped += int64(n)
+	return n
+}
+
+// evict drops the idle encoder that was used least recently, and reports
+// whether there was one.
+func (p *EncoderPool) evict() bool {
+	var key ModeHeader
+	var lru *pooledEncoder
+	for k, pe := range p.modes {
+		if pe.calls == 0 && (lru == nil || pe.used.Before(lru.used)) {
+			key, lru = k, pe
+		}
+	}
+	if lru == nil {
+		return false
+	}
+	delete(p.modes, key)
+	p.stats.Evicted++
+	return true
+}
+
+// Stats returns the current statistics of p.
+func (p *EncoderPool) Stats() EncoderPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	s.Modes = len(p.modes)
+	for _, pe := range p.modes {
+		s.Calls += pe.calls
+	}
+	return s
+}
@@ -0,0 +1,129 @@
+package sakura
+
+// leafOverhead is the memory a Writer takes per leaf besides its chaining
+// value, on 64-bit platforms: the slice of the value, the stored leaf that
+// codes it in the final node and the interface holding that leaf.
+const leafOverhead = 24 + 24 + 16
+
+// MemoryEstimate is the expected peak memory of a hashing job, in bytes.
+type MemoryEstimate struct {
+	// Buffers is the memory of the leaves held while they are filled and
+	// hashed and of the buffers that nodes are read through.
+	Buffers int64
+
+	// ChainingValues is the memory of the chaining values of the leaves,
+	// which are all kept until the final node codes them, and of the hops
+	// that code them there.
+	ChainingValues int64
+}
+
+// Total returns the sum of the parts of m.
+func (m MemoryEstimate) Total() int64 { return m.Buffers + m.ChainingValues }
+
+// EstimateMemory returns the expected peak memory of hashing a stream of
+// inputSize bytes in mode with a Writer of DefaultLeafSize leaves, as NewHash
+// and HashFile do, on parallelism goroutines, so that operators can size
+// containers before starting a large job. Buffers grow with the parallelism
+// and chaining values with the number of leaves, in the two-level shape of
+// Writer whose final node holds them all. The estimate leaves out the state
+// of the hash function and the Go runtime, and a BufferPool of the encoder,
+// which can only retain more.
+func EstimateMemory(mode HashingMode, inputSize int64, parallelism int) (MemoryEstimate, error) {
+	if mode.Hash == nil {
+		return MemoryEstimate{}, ErrNoHash
+	}
+	inputSize = max(inputSize, 0)
+	p := int64(max(parallelism, 1))
+	n := int64(leafCount(inputSize, DefaultLeafSize))
+	// A leaf per worker and the one being filled, and the first leaf, which
+	// is kept to be nested in the final node with kangaroo hopping.
+	held := p + 1
+	if mode.Kangaroo {
+		held++
+	}
+	var m MemoryEstimate
+	m.Buffers = min(held*DefaultLeafSize, min(n*DefaultLeafSize, inputSize))
+	// A read buffer for every node hashed at once: the final node, and the
+	// leaves on their own when there are several.
+	m.Buffers += defaultBufferSize
+	if n > 1 {
+		m.Buffers += min(p, n) * defaultBufferSize
+		m.ChainingValues = n * int64(mode.Hash().Size()+leafOverhead)
+	}
+	return m, nil
+}
+
+// WriterPlan describes the work of hashing a stream with a Writer, as
+// computed by PlanWriter without hashing anything.
+type WriterPlan struct {
+	// Shape is the shape of the tree: the leaves of DefaultLeafSize bytes,
+	// under a single chaining hop if there are several.
+	Shape Shape
+
+	// LeafNodes is the number of leaves hashed as nodes of their own, and so
+	// the number of chaining values coded in the final node. With kangaroo
+	// hopping, the first leaf is nested in the final node instead.
+	LeafNodes int
+
+	// HashCalls is the number of nodes hashed, one hash per node, and
+	// NodeBytes and Blocks the total size of the coded nodes, in bytes and in
+	// blocks of the hash function, which is what the hashing costs.
+	HashCalls int64
+	NodeBytes int64
+	Blocks    int64
+
+	// Workers is the number of goroutines that hash the leaf nodes, in Waves
+	// rounds of at most one leaf each, before the final node is hashed on
+	// the calling goroutine.
+	Workers int
+	Waves   int64
+
+	Memory MemoryEstimate
+}
+
+// PlanWriter returns the plan of hashing a stream of inputSize bytes in mode
+// with a Writer of DefaultLeafSize leaves on parallelism goroutines, for
+// capacity planning and for checking the geometry of a tree before a long job.
+// Node sizes are those of SakuraCoding with SimplePadding.
+func PlanWriter(mode HashingMode, inputSize int64, parallelism int) (WriterPlan, error) {
+	m, err := EstimateMemory(mode, inputSize, parallelism)
+	if err != nil {
+		return WriterPlan{}, err
+	}
+	inputSize = max(inputSize, 0)
+	h := mode.Hash()
+	block := int64(max(h.BlockSize(), 1))
+	n := leafCount(inputSize, DefaultLeafSize)
+	last := inputSize - int64(n-1)*DefaultLeafSize // Size of the last leaf.
+	p := WriterPlan{Memory: m, Shape: Shape{Leaves: n, Degrees: make(map[int]int), MessageBytes: inputSize}}
+	add := func(bytes int64) {
+		p.HashCalls++
+		p.NodeBytes += bytes
+		p.Blocks += max((bytes+block-1)/block, 1)
+	}
+	if n == 1 {
+		add(inputSize + 1)
+		return p, nil
+	}
+	p.Shape.Height, p.Shape.Chaining, p.Shape.Degrees[n] = 1, 1, 1
+	p.LeafNodes = n
+	final := int64(0)
+	if mode.Kangaroo {
+		p.LeafNodes--
+		// The nested first leaf and its frame bits, padded to the alignment.
+		final = DefaultLeafSize + 1
+		if a := int64(mode.Alignment); a > 1 {
+			final = (final + a - 1) / a * a
+		}
+	}
+	full := int64(p.LeafNodes - 1) // Leaf nodes before the last leaf.
+	p.HashCalls = full
+	p.NodeBytes = full * (DefaultLeafSize + 1)
+	p.Blocks = full * ((DefaultLeafSize + 1 + block - 1) / block)
+	add(last + 1)
+	final += int64(p.LeafNodes)*int64(h.Size()) + int64(len(appendTrailer(nil, mode, p.LeafNodes))) + 1
+	add(final)
+	p.Workers = min(max(parallelism, 1), p.LeafNodes)
+	p.Waves = (int64(p.LeafNodes) + int64(p.Workers) - 1) / int64(p.Workers)
+	return p, nil
+}
@@ -0,0 +1,296 @@
+package sakura
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+// Sizes of the runs of HashExternal: the bytes of leaves read and hashed at a
+// time, and the number of chaining nodes hashed at a time.
+const (
+	externalRunBytes = 16 << 20
+	externalRunNodes = 1024
+)
+
+// HashExternal returns the root of the stream read until EOF from r, cut into
+// leaves of leafSize bytes and arranged by BuildTree with the given fanout, for
+// streams whose leaf chaining values do not fit in memory. The root is the one
+// that Final returns for that tree, and with a fanout below 2 the one that a
+// Writer with the same leaf size computes.
+//
+// The leaves are read and hashed in runs of a few megabytes, on up to
+// Parallelism goroutines, and their chaining values written to a temporary
+// file in dir, or in the default directory for temporary files if dir is
+// empty. Every further level is then hashed from the file of the level below
+// it into a file of its own, up to the final node, which reads the values of
+// its children back from the last file. Memory stays that of a run and of a
+// buffer per goroutine, while the files take about the hash size per leaf.
+// With kangaroo hopping, the leaves that are nested in the node of their
+// parent are kept in a file as well, for the nodes that nest them. All files
+// are removed before HashExternal returns.
+func (e *Encoder) HashExternal(r io.Reader, leafSize, fanout int, dir string) ([]byte, error) {
+	if leafSize <= 0 {
+		return nil, errors.New("sakura: non-positive leaf size")
+	}
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	b := &externalBuild{e: e, dir: dir, leafSize: leafSize, fanout: math.MaxInt64, size: e.mode.Hash().Size()}
+	if fanout >= 2 {
+		b.fanout = int64(fanout)
+	}
+	defer b.remove()
+	return b.run(r)
+}
+
+// externalBuild is the state of HashExternal. Level 0 is that of the leaves,
+// and chaining nodes of level l group the nodes of level l-1.
+type externalBuild struct {
+	e        *Encoder
+	dir      string
+	leafSize int
+	fanout   int64 // Children per chaining node.
+	size     int   // Size of a chaining value.
+	total    int64 // Length of the stream.
+
+	counts []int64    // Number of nodes of every level.
+	files  []*os.File // Chaining values of the nodes of every level but the last.
+	heads  *os.File   // Nested leaves, leafSize bytes apart, with kangaroo hopping.
+}
+
+// nested reports whether node k of its level is nested in the node of its
+// parent, in which case its chaining value is neither computed nor read.
+func (b *externalBuild) nested(k int64) bool {
+	return b.e.mode.Kangaroo && k%b.fanout == 0
+}
+
+// run hashes the leaves read from r, then the levels above them.
+func (b *externalBuild) run(r io.Reader) ([]byte, error) {
+	per := max(externalRunBytes/b.leafSize, 1)
+	buf := make([]byte, per*b.leafSize)
+	var n int64
+	for {
+		m, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return nil, err
+		}
+		if n == 0 && last && m <= b.leafSize {
+			// A single leaf is the whole tree.
+			return b.e.Final(messageLeaf(buf[:m]))
+		}
+		if err := b.leaves(n, buf[:m]); err != nil {
+			return nil, err
+		}
+		n += int64((m + b.leafSize - 1) / b.leafSize)
+		b.total += int64(m)
+		if last {
+			break
+		}
+	}
+	if b.fanout == math.MaxInt64 {
+		b.fanout = n
+	}
+	b.counts = append(b.counts, n)
+	for n > b.fanout {
+		n = (n + b.fanout - 1) / b.fanout
+		b.counts = append(b.counts, n)
+	}
+	for l := 1; l < len(b.counts); l++ {
+		if err := b.level(l); err != nil {
+			return nil, err
+		}
+	}
+	return b.e.finalSerial(&externalNode{b: b, level: len(b.counts), id: NodeID{}})
+}
+
+// leaves hashes the leaves of data, the first of which is leaf first, and
+// appends their chaining values to the file of level 0.
+func (b *externalBuild) leaves(first int64, data []byte) error {
+	if len(b.files) == 0 {
+		f, err := b.create()
+		if err != nil {
+			return err
+		}
+		b.files = append(b.files, f)
+	}
+	n := (len(data) + b.leafSize - 1) / b.leafSize
+	cvs, err := b.hash(n, func(k int) ([]byte, error) {
+		i := first + int64(k)
+		leaf := data[k*b.leafSize : min((k+1)*b.leafSize, len(data))]
+		if b.nested(i) {
+			return nil, nil
+		}
+		j := newJob(b.e)
+		j.leaf = int(i)
+		return j.serial(messageLeaf(leaf), NodeID{int(i)}, false, 1)
+	})
+	if err != nil {
+		return err
+	}
+	for k := 0; k < n && b.e.mode.Kangaroo; k++ {
+		if !b.nested(first + int64(k)) {
+			continue
+		}
+		if b.heads == nil {
+			if b.heads, err = b.create(); err != nil {
+				return err
+			}
+		}
+		leaf := data[k*b.leafSize : min((k+1)*b.leafSize, len(data))]
+		if _, err := b.heads.Write(leaf); err != nil {
+			return err
+		}
+	}
+	return b.write(b.files[0], cvs)
+}
+
+// level hashes the chaining nodes of level l as inner nodes, a run at a time,
+// into a file of their chaining values.
+func (b *externalBuild) level(l int) error {
+	f, err := b.create()
+	if err != nil {
+		return err
+	}
+	b.files = append(b.files, f)
+	for start := int64(0); start < b.counts[l]; start += externalRunNodes {
+		n := int(min(externalRunNodes, b.counts[l]-start))
+		cvs, err := b.hash(n, func(k int) ([]byte, error) {
+			i := start + int64(k)
+			if b.nested(i) {
+				return nil, nil
+			}
+			j := newJob(b.e)
+			id := b.id(l, i)
+			return j.serial(&externalNode{b: b, level: l, index: i, id: id}, id, false, len(id))
+		})
+		if err != nil {
+			return err
+		}
+		if err := b.write(f, cvs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// id returns the ID of node i of level l.
+func (b *externalBuild) id(l int, i int64) NodeID {
+	id := make(NodeID, len(b.counts)-l)
+	for k := len(id) - 1; k >= 0; k-- {
+		id[k] = int(i % b.fanout)
+		i /= b.fanout
+	}
+	return id
+}
+
+// hash calls fn for 0 through n-1 on up to Parallelism goroutines and returns
+// the results in order.
+func (b *externalBuild) hash(n int, fn func(k int) ([]byte, error)) ([][]byte, error) {
+	cvs := make([][]byte, n)
+	errs := make([]error, n)
+	sem := make(chan struct{}, max(b.e.Parallelism, 1))
+	var wg sync.WaitGroup
+	for k := 0; k < n; k++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			cvs[k], errs[k] = fn(k)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cvs, nil
+}
+
+// write appends cvs to f, with zeros for the values of nested nodes, which are
+// nil.
+func (b *externalBuild) write(f *os.File, cvs [][]byte) error {
+	out := make([]byte, 0, len(cvs)*b.size)
+	for _, cv := range cvs {
+		if cv == nil {
+			cv = make([]byte, b.size)
+		}
+		out = append(out, cv...)
+	}
+	_, err := f.Write(out)
+	return err
+}
+
+// create creates a temporary file for b.
+func (b *externalBuild) create() (*os.File, error) {
+	return os.CreateTemp(b.dir, "sakura-level-*")
+}
+
+// remove closes and removes the temporary files.
+func (b *externalBuild) remove() {
+	files := b.files
+	if b.heads != nil {
+		files = append(files, b.heads)
+	}
+	for _, f := range files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// head returns the leaf nested in chaining node k of level 1.
+func (b *externalBuild) head(k int64) (Hop, error) {
+	off := k * b.fanout * int64(b.leafSize)
+	data := make([]byte, min(int64(b.leafSize), b.total-off))
+	if _, err := b.heads.ReadAt(data, k*int64(b.leafSize)); err != nil {
+		return nil, err
+	}
+	return messageLeaf(data), nil
+}
+
+// externalNode is chaining node index of a level of an externalBuild whose
+// children are read from the file of the level below it, in order, through a
+// buffer of its own.
+type externalNode struct {
+	b     *externalBuild
+	level int
+	index int64
+	id    NodeID
+	r     *bufio.Reader
+	next  int64 // Index of the child whose value r reads next.
+	cv    []byte
+}
+
+func (n *externalNode) Degree64() int64 {
+	return min(n.b.fanout, n.b.counts[n.level-1]-n.index*n.b.fanout)
+}
+
+func (n *externalNode) ChainingValue() []byte        { return n.cv }
+func (n *externalNode) SetChainingValue(hash []byte) { n.cv = hash }
+
+func (n *externalNode) ChildErr(i int64) (Hop, error) {
+	b := n.b
+	c := n.index*b.fanout + i
+	if i == 0 && b.e.mode.Kangaroo {
+		if n.level == 1 {
+			return b.head(n.index)
+		}
+		return &externalNode{b: b, level: n.level - 1, index: c, id: n.id.Child(0)}, nil
+	}
+	size := int64(b.size)
+	if n.r == nil || i != n.next {
+		end := (n.index*b.fanout + n.Degree64()) * size
+		sr := io.NewSectionReader(b.files[n.level-1], c*size, end-c*size)
+		n.r = bufio.NewReaderSize(sr, int(min(end-c*size, spillReadSize)))
+	}
+	cv := make([]byte, size)
+	if _, err := io.ReadFull(n.r, cv); err != nil {
+		n.r = nil
+		return nil, err
+	}
+	n.next = i + 1
+	return &storedLeaf{cv: cv}, nil
+}
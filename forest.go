@@ -0,0 +1,60 @@
+package sakura
+
+import (
+	"errors"
+
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+// ErrRootSize is returned when a root added to a Forest does not have the size
+// of the hash of the mode.
+var ErrRootSize = errors.New("sakura: root size does not match the hash size")
+
+// forestDomain is the message of the leaf that separates the super-root of a
+// forest from the roots of ordinary trees.
+const forestDomain = "sakura.forest"
+
+// Forest combines the roots of independent trees, such as one tree per volume,
+// into a single super-root.
+//
+// The super-root is the final node of a chaining hop whose first child is a
+// message hop holding a fixed domain string and the number of roots, followed
+// by one child per root whose chaining value is the root itself. Because roots
+// are final node hashes while ordinary chaining values are inner node hashes,
+// and because of the domain leaf, a super-root cannot be mistaken for the root
+// of a tree built over the same data.
+type Forest struct {
+	e     *Encoder
+	roots [][]byte
+}
+
+// NewForest returns an empty forest whose super-root is hashed by e.
+func NewForest(e *Encoder) *Forest {
+	return &Forest{e: e}
+}
+
+// Add appends the root of a tree. The super-root depends on the order in which
+// roots are added.
+func (f *Forest) Add(root []byte) error {
+	if f.e.mode.Hash == nil {
+		return ErrNoHash
+	}
+	if len(root) != f.e.mode.Hash().Size() {
+		return ErrRootSize
+	}
+	f.roots = append(f.roots, append([]byte(nil), root...))
+	return nil
+}
+
+// Len returns the number of roots in the forest.
+func (f *Forest) Len() int { return len(f.roots) }
+
+// Root returns the super-root of the forest.
+func (f *Forest) Root() ([]byte, error) {
+	domain := append([]byte(forestDomain), sakuracoding.LengthEncode(uint64(len(f.roots)))...)
+	c := &chainingLeaves{kids: []Hop{messageLeaf(domain)}}
+	for _, r := range f.roots {
+		c.kids = append(c.kids, &storedLeaf{cv: r})
+	}
+	return f.e.Final(c)
+}
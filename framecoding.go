@@ -0,0 +1,278 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+// BitWriter receives the bit string of a node from a Coding.
+type BitWriter interface {
+	// Write appends whole bytes, each of which is eight bits of the string in
+	// the Keccak convention.
+	io.Writer
+
+	// WriteBit appends a single bit, the least significant bit of b.
+	WriteBit(b byte)
+
+	// Bits returns the number of bits written so far.
+	Bits() int64
+}
+
+// Coding is the concrete coding of the frame bits of a node, which the Sakura
+// paper leaves open within the constraints that keep tree hashing sound: the
+// coding of a node must be decodable into its hops and tell final from inner
+// nodes. The grammar at the top of this package is SakuraCoding, which a
+// HashingMode uses if its Coding is nil.
+//
+// Every method appends the frame bits that end one production, in the order
+// the productions are coded: Message after the bits of a message hop, Chaining
+// after the n chaining values of a chaining hop, Kangaroo between a node
+// nested by kangaroo hopping and the chaining hop that follows it, and Final
+// or Inner after the whole node. A node of the tree machinery is never coded
+// otherwise, so a Coding fully defines the bit-level encoding. The bit string
+// of a node is then padded to whole bytes by the Padding of the mode.
+//
+// A coding that is not injective, or that lets a final node be taken for an
+// inner one, voids the security of the tree hash. Codings are part of the
+// mode, so Name must tell them apart: it is included in the fingerprint of
+// the mode unless it is empty, which only SakuraCoding may return. Codings
+// that embed SakuraCoding must therefore override Name.
+type Coding interface {
+	Message(w BitWriter)
+	Chaining(w BitWriter, n int, interleave BlockSize)
+	Kangaroo(w BitWriter, alignment int)
+	Final(w BitWriter)
+	Inner(w BitWriter)
+	Name() string
+}
+
+// SakuraCoding is the coding of the grammar at the top of this package. It
+// can be embedded by codings that change only some of its productions.
+type SakuraCoding struct{}
+
+// Message appends '1'.
+func (SakuraCoding) Message(w BitWriter) { w.WriteBit(1) }
+
+// Chaining appends the coded count n, the interleaving block size and '0'.
+func (SakuraCoding) Chaining(w BitWriter, n int, interleave BlockSize) {
+	w.Write(sakuracoding.AppendChainingTrailer(nil, uint64(n), interleave.Mantissa, interleave.Exponent))
+	w.WriteBit(0)
+}
+
+// Kangaroo appends pad_simple: '1' followed by as many zeros as needed to reach
+// a multiple of alignment bytes, or only the next byte for alignments below 2.
+func (SakuraCoding) Kangaroo(w BitWriter, alignment int) {
+	w.WriteBit(1)
+	for w.Bits()%8 != 0 {
+		w.WriteBit(0)
+	}
+	if alignment > 1 {
+		if r := int(w.Bits() / 8 % int64(alignment)); r != 0 {
+			w.Write(zeros[:alignment-r])
+		}
+	}
+}
+
+// Final appends '1'.
+func (SakuraCoding) Final(w BitWriter) { w.WriteBit(1) }
+
+// Inner appends pad_simple, which needs no alignment here, and '0'.
+func (SakuraCoding) Inner(w BitWriter) {
+	w.WriteBit(1)
+	w.WriteBit(0)
+}
+
+// Name returns the empty string, which leaves the fingerprints of modes as
+// they are without a Coding.
+func (SakuraCoding) Name() string { return "" }
+
+// SuffixCoding returns a coding that is SakuraCoding with bits, a string of
+// '0' and '1' characters, appended to every final and inner node after its
+// frame bits. Since every node ends with the same suffix, the coding stays as
+// decodable as SakuraCoding, and a protocol can reserve nodes ending with
+// other suffixes for node types it adds later without changing the package.
+// An empty suffix gives SakuraCoding itself.
+func SuffixCoding(bits string) (Coding, error) {
+	for _, b := range bits {
+		if b != '0' && b != '1' {
+			return nil, errors.New("sakura: suffix must consist of 0 and 1")
+		}
+	}
+	if bits == "" {
+		return SakuraCoding{}, nil
+	}
+	return suffixCoding{bits: bits}, nil
+}
+
+// suffixCoding is the coding returned by SuffixCoding.
+type suffixCoding struct {
+	SakuraCoding
+	bits string
+}
+
+func (c suffixCoding) Final(w BitWriter) {
+	c.SakuraCoding.Final(w)
+	c.suffix(w)
+}
+
+func (c suffixCoding) Inner(w BitWriter) {
+	c.SakuraCoding.Inner(w)
+	c.suffix(w)
+}
+
+func (c suffixCoding) suffix(w BitWriter) {
+	for _, b := range c.bits {
+		w.WriteBit(byte(b - '0'))
+	}
+}
+
+func (c suffixCoding) Name() string { return "sakura.suffix:" + c.bits }
+
+// LengthCoding is a Coding that binds the length of every message hop in
+// bytes into its coding. The encoder calls MessageLength on it instead of
+// Message, with the number of bytes of the hop.
+type LengthCoding interface {
+	Coding
+	MessageLength(w BitWriter, n int64)
+}
+
+// LeafLengthCoding returns a coding that is c, SakuraCoding if nil, with the
+// length encoding of the number of bytes of every message hop appended to its
+// bits before the frame bits of c. Protocols that cut messages into chunks of
+// variable size, such as content-defined chunks, thereby bind every leaf to
+// its exact length in the tree hash itself. The length is coded as the number
+// of chaining values of a chaining hop, so a decoder reading a node from its
+// end finds where the message bits start, and in the IntFormat of c if it is
+// a coding of IntFormatCoding.
+func LeafLengthCoding(c Coding) Coding {
+	if c == nil {
+		c = SakuraCoding{}
+	}
+	return leafLengthCoding{c}
+}
+
+// leafLengthCoding is the coding returned by LeafLengthCoding.
+type leafLengthCoding struct {
+	Coding
+}
+
+func (c leafLengthCoding) MessageLength(w BitWriter, n int64) {
+	w.Write(intFormatOf(c.Coding).Append(nil, uint64(n)))
+	codeMessage(c.Coding, w, n)
+}
+
+// codeMessage ends a message hop of n bytes in the coding c.
+func codeMessage(c Coding, w BitWriter, n int64) {
+	if lc, ok := c.(LengthCoding); ok {
+		lc.MessageLength(w, n)
+	} else {
+		c.Message(w)
+	}
+}
+
+func (c leafLengthCoding) Name() string { return c.Coding.Name() + "+sakura.leaflen" }
+
+// IntFormat is a format of the integers that a coding writes into nodes, the
+// numbers of chaining values of chaining hops and the lengths of messages of
+// LeafLengthCoding. Every format can be read from the end of a node, as a
+// decoder reads chaining hops.
+type IntFormat byte
+
+const (
+	// IntLengthEncode is the length_encode of the Sakura paper: the integer
+	// in big-endian order in the fewest bytes, followed by the number of
+	// bytes.
+	IntLengthEncode IntFormat = iota
+
+	// IntLittleEndian is IntLengthEncode with the bytes of the integer in
+	// little-endian order.
+	IntLittleEndian
+
+	// IntFixed64BE and IntFixed64LE are the integer in 8 bytes, in
+	// big-endian and little-endian order, as protocols with fixed-width
+	// fields define them.
+	IntFixed64BE
+	IntFixed64LE
+)
+
+// Append appends x in the format f to dst.
+func (f IntFormat) Append(dst []byte, x uint64) []byte {
+	switch f {
+	case IntLittleEndian:
+		n := 0
+		for ; x > 0; x >>= 8 {
+			dst = append(dst, byte(x))
+			n++
+		}
+		return append(dst, byte(n))
+	case IntFixed64BE:
+		return binary.BigEndian.AppendUint64(dst, x)
+	case IntFixed64LE:
+		return binary.LittleEndian.AppendUint64(dst, x)
+	}
+	return sakuracoding.AppendLengthEncode(dst, x)
+}
+
+// String returns the name of f.
+func (f IntFormat) String() string {
+	switch f {
+	case IntLengthEncode:
+		return "length_encode"
+	case IntLittleEndian:
+		return "le"
+	case IntFixed64BE:
+		return "be64"
+	case IntFixed64LE:
+		return "le64"
+	}
+	return "IntFormat(" + strconv.Itoa(int(f)) + ")"
+}
+
+// IntFormatCoding returns a coding that is SakuraCoding with the integers of
+// nodes written in the format f, for interoperating with protocols whose
+// definitions fix another integer format. The format is part of the name of
+// the coding, and so of the fingerprint of the mode. IntLengthEncode gives
+// SakuraCoding itself.
+func IntFormatCoding(f IntFormat) (Coding, error) {
+	switch f {
+	case IntLengthEncode:
+		return SakuraCoding{}, nil
+	case IntLittleEndian, IntFixed64BE, IntFixed64LE:
+		return intCoding{f: f}, nil
+	}
+	return nil, errors.New("sakura: unknown integer format")
+}
+
+// intCoding is the coding returned by IntFormatCoding.
+type intCoding struct {
+	SakuraCoding
+	f IntFormat
+}
+
+// Chaining appends the count n in the format of c, the interleaving block
+// size and '0'.
+func (c intCoding) Chaining(w BitWriter, n int, interleave BlockSize) {
+	w.Write(append(c.f.Append(nil, uint64(n)), interleave.Mantissa, interleave.Exponent))
+	w.WriteBit(0)
+}
+
+func (c intCoding) Name() string { return "sakura.int:" + c.f.String() }
+
+// intFormatOf returns the integer format of the coding c.
+func intFormatOf(c Coding) IntFormat {
+	switch c := c.(type) {
+	case intCoding:
+		return c.f
+	case saltCoding:
+		return intFormatOf(c.Coding)
+	case leafLengthCoding:
+		return intFormatOf(c.Coding)
+	case domainCoding:
+		return intFormatOf(c.Coding)
+	}
+	return IntLengthEncode
+}
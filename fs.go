@@ -0,0 +1,183 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"runtime"
+)
+
+// ErrUnsupportedFile is returned when hashing a file system that holds a file
+// that is neither a regular file, a directory nor a readable symbolic link.
+var ErrUnsupportedFile = errors.New("sakura: unsupported file type")
+
+// Entry types coded in the header leaf of a file system entry.
+const (
+	entryFile    = 'f'
+	entryDir     = 'd'
+	entrySymlink = 'l'
+)
+
+// HashFS returns the root of the file system fsys, hashed with a new encoder
+// for mode. See Encoder.HashFS.
+func HashFS(mode HashingMode, fsys fs.FS) ([]byte, error) {
+	return New(mode).HashFS(fsys)
+}
+
+// HashDir returns the root of the directory tree at the given path of the
+// operating system, hashed with a new encoder for mode. Symbolic links are
+// not followed.
+func HashDir(mode HashingMode, dir string) ([]byte, error) {
+	return HashFS(mode, os.DirFS(dir))
+}
+
+// HashFile returns the root of the contents of the file at path, cut into
+// leaves of DefaultLeafSize bytes in the shape of Writer, so that it equals
+// the sum of the hash returned by NewHash over the same bytes. The leaves are
+// read with ReadAt and hashed concurrently on one goroutine per CPU.
+func HashFile(mode HashingMode, path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &fs.PathError{Op: "hash", Path: path, Err: ErrUnsupportedFile}
+	}
+	e := New(mode)
+	e.Parallelism = runtime.GOMAXPROCS(0)
+	return e.HashRanger(readerAtRanger{f}, fi.Size(), DefaultLeafSize)
+}
+
+// readerAtRanger is a Ranger reading from an io.ReaderAt.
+type readerAtRanger struct {
+	r io.ReaderAt
+}
+
+func (r readerAtRanger) ReadRange(off, n int64) (io.ReadCloser, error) {
+	return io.NopCloser(io.NewSectionReader(r.r, off, n)), nil
+}
+
+// HashFS returns the root of the file system fsys, which may be an embedded
+// file system, a zip file, an fstest.MapFS or any other fs.FS.
+//
+// A directory is hashed as a chaining hop with one child per entry, in the
+// lexical order of their names. Each entry is a chaining hop with two
+// children: a header leaf holding the entry type and name, and the contents,
+// which are a message hop for a regular file, the target of a symbolic link
+// and a chaining hop for a directory. Symbolic links are hashed as such, and
+// only if fsys implements fs.ReadLinkFS. File contents are read when hashed,
+// each file being opened only while it is read. Metadata such as modes and
+// modification times is not hashed; HashFSWith can add it.
+func (e *Encoder) HashFS(fsys fs.FS) ([]byte, error) {
+	root, err := FSTree(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return e.Final(root)
+}
+
+// FSTree returns the tree of hops that HashFS hashes for fsys. The directory
+// structure is read immediately; file contents are read by the encoder.
+func FSTree(fsys fs.FS) (Hop, error) {
+	return dirTree(fsys, ".", FSOptions{})
+}
+
+// dirTree returns the hop of the directory with the given name, coded as
+// configured by opts.
+func dirTree(fsys fs.FS, name string, opts FSOptions) (Hop, error) {
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err = opts.Canonical.entries(name, entries); err != nil {
+		return nil, err
+	}
+	dir := &chainingLeaves{}
+	for _, ent := range entries {
+		p := path.Join(name, ent.Name())
+		var kind byte
+		var contents Hop
+		switch t := ent.Type(); {
+		case t.IsDir():
+			kind = entryDir
+			if contents, err = dirTree(fsys, p, opts); err != nil {
+				return nil, err
+			}
+		case t.IsRegular():
+			kind = entryFile
+			contents = &fileLeaf{fsys: fsys, name: p}
+		case t&fs.ModeSymlink != 0:
+			target, err := readLink(fsys, p)
+			if err != nil {
+				return nil, err
+			}
+			kind = entrySymlink
+			contents = messageLeaf([]byte(opts.Canonical.target(target)))
+		default:
+			return nil, &fs.PathError{Op: "hash", Path: p, Err: ErrUnsupportedFile}
+		}
+		meta, err := metaLeaves(fsys, p, ent, opts)
+		if err != nil {
+			return nil, err
+		}
+		header := messageLeaf(entryHeader(kind, opts.Canonical.name(ent.Name())))
+		dir.kids = append(dir.kids, &chainingLeaves{kids: append([]Hop{header, contents}, meta...)})
+	}
+	return dir, nil
+}
+
+// entryHeader returns the message bits of the header leaf of an entry.
+func entryHeader(kind byte, name string) []byte {
+	return append([]byte{kind}, name...)
+}
+
+// readLink returns the target of a symbolic link, if fsys can read it.
+func readLink(fsys fs.FS, name string) (string, error) {
+	if l, ok := fsys.(fs.ReadLinkFS); ok {
+		return l.ReadLink(name)
+	}
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: ErrUnsupportedFile}
+}
+
+// fileLeaf is a message hop that reads a file, opening it on the first read
+// and closing it at the end.
+type fileLeaf struct {
+	fsys fs.FS
+	name string
+	f    fs.File
+	done bool
+	cv   []byte
+}
+
+func (l *fileLeaf) Read(p []byte) (int, error) {
+	if l.done {
+		return 0, io.EOF
+	}
+	if l.f == nil {
+		f, err := l.fsys.Open(l.name)
+		if err != nil {
+			return 0, err
+		}
+		l.f = f
+	}
+	n, err := l.f.Read(p)
+	if err == io.EOF {
+		l.done = true
+		if cerr := l.f.Close(); cerr != nil {
+			err = cerr
+		}
+		l.f = nil
+	}
+	return n, err
+}
+
+func (l *fileLeaf) Label() string                { return l.name }
+func (l *fileLeaf) ChainingValue() []byte        { return l.cv }
+func (l *fileLeaf) SetChainingValue(hash []byte) { l.cv = hash }
@@ -0,0 +1,242 @@
+package sakura
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+// canonDomain begins the header leaf that codes a canonicalization policy.
+const canonDomain = "sakura.fscanon"
+
+var (
+	// ErrReservedName is returned when hashing a file system with
+	// ReservedReject that holds a name Windows reserves.
+	ErrReservedName = errors.New("sakura: name is reserved on Windows")
+
+	// ErrNameCollision is returned when two entries of a directory have the
+	// same canonical name.
+	ErrNameCollision = errors.New("sakura: entry names collide when canonicalized")
+)
+
+// EntryOrder is the order of the entries of a directory in its hop.
+type EntryOrder byte
+
+const (
+	// OrderName orders entries by the bytes of their names, as fs.ReadDir
+	// returns them.
+	OrderName EntryOrder = iota
+
+	// OrderFold orders entries by their names under Unicode case folding,
+	// breaking ties by the bytes of the names, so that file systems that
+	// differ only in whether they preserve case agree on the order.
+	OrderFold
+)
+
+// ReservedNames is how names that Windows cannot hold are handled: the device
+// names such as CON, NUL and COM1 with or without an extension, names ending
+// in a dot or space, and names with control characters or any of <>:"|?*\.
+type ReservedNames byte
+
+const (
+	// ReservedKeep codes reserved names like any other.
+	ReservedKeep ReservedNames = iota
+
+	// ReservedReject fails with ErrReservedName, so that a tree can only be
+	// hashed if it can be checked out on Windows.
+	ReservedReject
+
+	// ReservedSkip leaves entries with reserved names out of the tree, as if
+	// the file system were copied to Windows.
+	ReservedSkip
+)
+
+// NameForm is a Unicode normalization form of names.
+type NameForm byte
+
+const (
+	// FormNone leaves names as they are read.
+	FormNone NameForm = iota
+
+	// FormNFC is Normalization Form C, composed characters, which Linux and
+	// Windows file systems usually hold.
+	FormNFC
+
+	// FormNFD is Normalization Form D, decomposed characters, close to the
+	// form in which macOS file systems have stored names.
+	FormNFD
+)
+
+// Normalizer puts strings into a Unicode normalization form. The package has
+// no normalization tables of its own; the forms of
+// golang.org/x/text/unicode/norm, such as norm.NFC, are normalizers.
+type Normalizer interface {
+	String(s string) string
+}
+
+// Canonicalization is a policy that makes the tree of a file system the same
+// whichever platform it is read on, by coding a canonical form of what
+// platforms disagree on. The zero policy codes the file system as it is read.
+type Canonicalization struct {
+	// Separators codes backslashes in entry names and symbolic link targets
+	// as slashes, for trees read from archives written on Windows.
+	Separators bool
+
+	// Order is the order of the entries of a directory, taken after the
+	// names are canonicalized.
+	Order EntryOrder
+
+	// FoldCase codes entry names under Unicode case folding, so that trees
+	// read from case-insensitive file systems such as NTFS, which may report
+	// either case for a name, agree with each other and with case-preserving
+	// ones. Link targets keep their case.
+	FoldCase bool
+
+	// Reserved is how names that Windows reserves are handled. The check is
+	// made on the names as read, before separators are canonicalized.
+	Reserved ReservedNames
+
+	// Form is the normalization form that entry names and link targets are
+	// put into by Normalizer, which must be set unless Form is FormNone and
+	// must produce that form: only Form is coded in the header, so that the
+	// same tree read on macOS and Linux has the same root.
+	Form       NameForm
+	Normalizer Normalizer
+
+	// TimeResolution, if positive, truncates the modification times coded
+	// with MetaModTime to multiples of it since the Unix epoch, such as two
+	// seconds to agree with FAT file systems.
+	TimeResolution time.Duration
+}
+
+func (c Canonicalization) validate() error {
+	if c.Order > OrderFold || c.Reserved > ReservedSkip || c.Form > FormNFD || c.TimeResolution < 0 {
+		return fmt.Errorf("sakura: invalid canonicalization order %d, reserved names %d, form %d, resolution %v", c.Order, c.Reserved, c.Form, c.TimeResolution)
+	}
+	if c.Form != FormNone && c.Normalizer == nil {
+		return errors.New("sakura: normalization form without a normalizer")
+	}
+	return nil
+}
+
+// zero reports whether c is the zero policy, ignoring the normalizer, which
+// has no effect without a form.
+func (c Canonicalization) zero() bool {
+	c.Normalizer = nil
+	return c == Canonicalization{}
+}
+
+// header returns the message bits of the header leaf coding c.
+func (c Canonicalization) header() []byte {
+	b := []byte(canonDomain)
+	b = append(b, flag(c.Separators), byte(c.Order), flag(c.FoldCase), byte(c.Reserved), byte(c.Form))
+	return sakuracoding.AppendLengthEncode(b, uint64(c.TimeResolution))
+}
+
+func flag(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// target returns the canonical form of a link target.
+func (c Canonicalization) target(s string) string {
+	if c.Separators {
+		s = strings.ReplaceAll(s, `\`, "/")
+	}
+	if c.Form != FormNone {
+		s = c.Normalizer.String(s)
+	}
+	return s
+}
+
+// name returns the canonical form of an entry name.
+func (c Canonicalization) name(s string) string {
+	if s = c.target(s); c.FoldCase {
+		s = foldName(s)
+	}
+	return s
+}
+
+// entries returns the entries of the directory dir, which fs.ReadDir returned
+// for it, that the tree holds, ordered by their canonical names.
+func (c Canonicalization) entries(dir string, entries []fs.DirEntry) ([]fs.DirEntry, error) {
+	if c.zero() {
+		return entries, nil
+	}
+	if c.Reserved != ReservedKeep {
+		kept := entries[:0:0]
+		for _, ent := range entries {
+			switch {
+			case !windowsReserved(ent.Name()):
+				kept = append(kept, ent)
+			case c.Reserved == ReservedReject:
+				return nil, &fs.PathError{Op: "hash", Path: path.Join(dir, ent.Name()), Err: ErrReservedName}
+			}
+		}
+		entries = kept
+	}
+	key := func(ent fs.DirEntry) string { return c.name(ent.Name()) }
+	slices.SortStableFunc(entries, func(a, b fs.DirEntry) int {
+		x, y := key(a), key(b)
+		if c.Order == OrderFold {
+			if r := cmp.Compare(foldName(x), foldName(y)); r != 0 {
+				return r
+			}
+		}
+		return cmp.Compare(x, y)
+	})
+	for i := 1; i < len(entries); i++ {
+		if key(entries[i-1]) == key(entries[i]) {
+			return nil, &fs.PathError{Op: "hash", Path: path.Join(dir, entries[i].Name()), Err: ErrNameCollision}
+		}
+	}
+	return entries, nil
+}
+
+// windowsReserved reports whether Windows cannot hold a file named name.
+func windowsReserved(name string) bool {
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return true
+	}
+	if strings.ContainsFunc(name, func(r rune) bool { return r < ' ' || strings.ContainsRune(`<>:"|?*\`, r) }) {
+		return true
+	}
+	base, _, _ := strings.Cut(name, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+	if len(base) > 3 && (base[:3] == "COM" || base[:3] == "LPT") {
+		switch base[3:] {
+		case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "¹", "²", "³":
+			return true
+		}
+	}
+	return false
+}
+
+// foldName returns s under simple Unicode case folding, up to the choice of
+// representative, which does not change how names compare for equality.
+func foldName(s string) string {
+	return strings.ToLower(strings.ToUpper(s))
+}
+
+// modTime returns the modification time t in nanoseconds since the Unix epoch,
+// truncated to the time resolution of c.
+func (c Canonicalization) modTime(t time.Time) int64 {
+	ns := t.UnixNano()
+	if r := int64(c.TimeResolution); r > 0 {
+		ns -= (ns%r + r) % r
+	}
+	return ns
+}
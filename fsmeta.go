@@ -0,0 +1,155 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"slices"
+
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+// Metadata is a set of file metadata fields that HashFS can code for every
+// entry of a file system, each in a leaf of its own.
+type Metadata uint
+
+const (
+	// MetaMode codes the permission bits, with the setuid, setgid and sticky
+	// bits, in the Unix layout: 0o4000, 0o2000 and 0o1000 above 0o777.
+	MetaMode Metadata = 1 << iota
+
+	// MetaSize codes the size of regular files.
+	MetaSize
+
+	// MetaModTime codes the modification time, in nanoseconds since the Unix
+	// epoch, truncated as set by Canonicalization.TimeResolution.
+	MetaModTime
+
+	// MetaXattrs codes the extended attributes, which requires the file
+	// system to implement XattrFS.
+	MetaXattrs
+)
+
+// Tags that begin the metadata leaves of an entry.
+const (
+	metaMode    = 'm'
+	metaSize    = 's'
+	metaModTime = 't'
+	metaXattrs  = 'x'
+)
+
+// XattrFS is a file system that can read the extended attributes of its files,
+// as needed to hash them with MetaXattrs.
+type XattrFS interface {
+	fs.FS
+
+	// Xattrs returns the extended attributes of the named file, without
+	// following a symbolic link.
+	Xattrs(name string) (map[string][]byte, error)
+}
+
+// FSOptions configures Encoder.HashFSWith.
+type FSOptions struct {
+	// Metadata selects the metadata coded for every entry, none if zero. The
+	// fields follow the contents of the entry as further children, in the
+	// order of the Meta constants and each as a leaf beginning with a tag of
+	// its field, so that a manifest attests them as well as the contents.
+	// Fields that do not apply to an entry, such as the size of a directory,
+	// are left out.
+	Metadata Metadata
+
+	// Canonical is the canonicalization policy. Unless it is zero, the root
+	// is a chaining hop over a header leaf coding the policy, followed by
+	// the hop of the root directory, so that the root tells how the tree
+	// was canonicalized and can be reproduced on any platform.
+	Canonical Canonicalization
+}
+
+// HashFSWith is like HashFS, but codes the file system as configured by opts.
+// With zero options it returns the root HashFS returns, which is the only
+// tree that ProveFile and VerifyFile handle.
+func (e *Encoder) HashFSWith(fsys fs.FS, opts FSOptions) ([]byte, error) {
+	root, err := FSTreeWith(fsys, opts)
+	if err != nil {
+		return nil, err
+	}
+	return e.Final(root)
+}
+
+// FSTreeWith returns the tree of hops that HashFSWith hashes for fsys.
+func FSTreeWith(fsys fs.FS, opts FSOptions) (Hop, error) {
+	if err := opts.Canonical.validate(); err != nil {
+		return nil, err
+	}
+	dir, err := dirTree(fsys, ".", opts)
+	if err != nil || opts.Canonical.zero() {
+		return dir, err
+	}
+	return &chainingLeaves{kids: []Hop{messageLeaf(opts.Canonical.header()), dir}}, nil
+}
+
+// metaLeaves returns the leaves of the metadata selected by opts for the
+// entry ent at the path name of fsys.
+func metaLeaves(fsys fs.FS, name string, ent fs.DirEntry, opts FSOptions) ([]Hop, error) {
+	if opts.Metadata == 0 {
+		return nil, nil
+	}
+	fi, err := ent.Info()
+	if err != nil {
+		return nil, err
+	}
+	var leaves []Hop
+	if opts.Metadata&MetaMode != 0 {
+		leaves = append(leaves, messageLeaf(sakuracoding.AppendLengthEncode([]byte{metaMode}, unixMode(fi.Mode()))))
+	}
+	if opts.Metadata&MetaSize != 0 && fi.Mode().IsRegular() {
+		leaves = append(leaves, messageLeaf(sakuracoding.AppendLengthEncode([]byte{metaSize}, uint64(fi.Size()))))
+	}
+	if opts.Metadata&MetaModTime != 0 {
+		leaves = append(leaves, messageLeaf(binary.BigEndian.AppendUint64([]byte{metaModTime}, uint64(opts.Canonical.modTime(fi.ModTime())))))
+	}
+	if opts.Metadata&MetaXattrs != 0 {
+		x, ok := fsys.(XattrFS)
+		if !ok {
+			return nil, &fs.PathError{Op: "xattrs", Path: name, Err: errors.ErrUnsupported}
+		}
+		attrs, err := x.Xattrs(name)
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, messageLeaf(appendXattrs([]byte{metaXattrs}, attrs)))
+	}
+	return leaves, nil
+}
+
+// unixMode returns the permission and special bits of m in the Unix layout.
+func unixMode(m fs.FileMode) uint64 {
+	u := uint64(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		u |= 0o4000
+	}
+	if m&fs.ModeSetgid != 0 {
+		u |= 0o2000
+	}
+	if m&fs.ModeSticky != 0 {
+		u |= 0o1000
+	}
+	return u
+}
+
+// appendXattrs appends the extended attributes in the order of their names,
+// each name and value preceded by its length.
+func appendXattrs(b []byte, attrs map[string][]byte) []byte {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		b = sakuracoding.AppendLengthEncode(b, uint64(len(name)))
+		b = append(b, name...)
+		b = sakuracoding.AppendLengthEncode(b, uint64(len(attrs[name])))
+		b = append(b, attrs[name]...)
+	}
+	return b
+}
@@ -0,0 +1,115 @@
+package sakura
+
+import (
+	"bytes"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ProveFile returns an inclusion proof for the contents of the regular file
+// at the slash-separated path name of fsys, against the root that HashFS
+// returns for fsys. Together with a root taken from a signed release, such as
+// a SignedTreeHead, the proof lets a client verify a single file with
+// VerifyFile, without the rest of the file system.
+//
+// The whole file system is hashed to build the proof, except for the file
+// itself, which is read once.
+func (e *Encoder) ProveFile(fsys fs.FS, name string) (*Proof, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, &fs.PathError{Op: "prove", Path: name, Err: fs.ErrInvalid}
+	}
+	var leaf NodeID
+	dir := "."
+	elems := strings.Split(name, "/")
+	for k, elem := range elems {
+		entries, err := fs.ReadDir(fsys, dir)
+		if err != nil {
+			return nil, err
+		}
+		i := slices.IndexFunc(entries, func(ent fs.DirEntry) bool { return ent.Name() == elem })
+		if i < 0 {
+			return nil, &fs.PathError{Op: "prove", Path: name, Err: fs.ErrNotExist}
+		}
+		if k == len(elems)-1 && !entries[i].Type().IsRegular() {
+			return nil, &fs.PathError{Op: "prove", Path: name, Err: ErrUnsupportedFile}
+		}
+		leaf = append(leaf, i, 1) // The entry, then its contents.
+		dir = path.Join(dir, elem)
+	}
+	root, err := FSTree(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return e.Prove(root, leaf)
+}
+
+// VerifyFile checks that contents are the contents of the regular file at the
+// slash-separated path name of a file system whose HashFS root, in mode, is
+// root, using a proof made by Encoder.ProveFile. Beyond VerifyProof, it checks
+// that the entries on the path of the proof are the directories named by name
+// and, at the end, a regular file, so that a proof for one file cannot pass
+// for another. It returns ErrProofMismatch if either check fails.
+func VerifyFile(mode HashingMode, root []byte, name string, proof *Proof, contents []byte) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "verify", Path: name, Err: fs.ErrInvalid}
+	}
+	if !proof.Mode.Matches(mode) {
+		return ErrModeMismatch
+	}
+	elems := strings.Split(name, "/")
+	if len(proof.Leaf) != 2*len(elems) {
+		return ErrProofMismatch
+	}
+	segs := nodeSegments(mode, proof.Leaf)
+	if len(segs) != len(proof.Nodes) {
+		return ErrMalformedProof
+	}
+	for k, elem := range elems {
+		kind := byte(entryDir)
+		if k == len(elems)-1 {
+			kind = entryFile
+		}
+		header := entryHeader(kind, elem)
+		// The header is the first child of the entry, whose step to its
+		// contents, child 1, is at this position of the leaf ID.
+		step := 2*k + 1
+		if proof.Leaf[step] != 1 {
+			return ErrProofMismatch
+		}
+		n, level := proofStep(proof, segs, step)
+		if level >= len(n.Hops) || n.Hops[level].Degree != 2 {
+			return ErrProofMismatch
+		}
+		if mode.Kangaroo {
+			// The header is nested at the bottom of the node.
+			if len(n.Hops) != level+1 || !bytes.Equal(n.Message, header) {
+				return ErrProofMismatch
+			}
+			continue
+		}
+		cv, err := New(mode).Inner(messageLeaf(header))
+		if err != nil {
+			return err
+		}
+		if v := n.Hops[level].Values; len(v) != 2 || !bytes.Equal(v[0], cv) {
+			return ErrProofMismatch
+		}
+	}
+	return VerifyProof(mode, root, proof, contents)
+}
+
+// proofStep returns the node of the proof that holds the given step of the
+// path to its leaf, and the level of the hop within the node from which the
+// step is taken. Segs are the node segments of the path.
+func proofStep(p *Proof, segs []NodeID, step int) (*ProofNode, int) {
+	start := 0
+	for k, seg := range segs {
+		if step < start+len(seg) {
+			return &p.Nodes[len(p.Nodes)-1-k], step - start
+		}
+		start += len(seg)
+	}
+	return &ProofNode{}, 0
+}
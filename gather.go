@@ -0,0 +1,93 @@
+package sakura
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// GatherLeaf is a message hop whose bits are the concatenation of regions of
+// files or byte slices, read in order, so that a leaf assembled from fragments,
+// such as a database page, is hashed as one message hop without copying its
+// fragments together first. It implements io.Seeker over the concatenation.
+type GatherLeaf struct {
+	parts []*io.SectionReader
+	size  int64
+	off   int64 // Offset of the next read in the concatenation.
+	cv    []byte
+}
+
+// NewGatherLeaf returns a message hop over the concatenation of parts. The
+// parts are read through their ReadAt methods, so they may share an
+// underlying file with each other and with other readers.
+func NewGatherLeaf(parts ...*io.SectionReader) *GatherLeaf {
+	l := &GatherLeaf{parts: parts}
+	for _, p := range parts {
+		l.size += p.Size()
+	}
+	return l
+}
+
+// GatherBytes returns a message hop over the concatenation of parts, which must
+// not be modified while it is in use.
+func GatherBytes(parts ...[]byte) *GatherLeaf {
+	sections := make([]*io.SectionReader, len(parts))
+	for i, p := range parts {
+		sections[i] = io.NewSectionReader(bytes.NewReader(p), 0, int64(len(p)))
+	}
+	return NewGatherLeaf(sections...)
+}
+
+// Size returns the length of the concatenation.
+func (l *GatherLeaf) Size() int64 { return l.size }
+
+// Read implements MessageHop.
+func (l *GatherLeaf) Read(p []byte) (int, error) {
+	n := 0
+	base := int64(0)
+	for _, part := range l.parts {
+		end := base + part.Size()
+		if l.off < end && n < len(p) {
+			b := p[n:min(int64(len(p)), int64(n)+end-l.off)]
+			m, err := part.ReadAt(b, l.off-base)
+			n += m
+			l.off += int64(m)
+			if m < len(b) {
+				if err == nil || err == io.EOF {
+					// The part is shorter than its section.
+					err = io.ErrUnexpectedEOF
+				}
+				return n, err
+			}
+		}
+		base = end
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker.
+func (l *GatherLeaf) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += l.off
+	case io.SeekEnd:
+		offset += l.size
+	case io.SeekStart:
+	default:
+		return 0, errors.New("sakura: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("sakura: negative position")
+	}
+	l.off = offset
+	return offset, nil
+}
+
+// ChainingValue implements Hop.
+func (l *GatherLeaf) ChainingValue() []byte { return l.cv }
+
+// SetChainingValue implements Hop.
+func (l *GatherLeaf) SetChainingValue(hash []byte) { l.cv = hash }
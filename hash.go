@@ -0,0 +1,83 @@
+package sakura
+
+import "hash"
+
+// DefaultLeafSize is the leaf size of the hashes returned by NewHash, the
+// chunk size of KangarooTwelve.
+const DefaultLeafSize = 8192
+
+// NewHash returns a hash.Hash that computes the root of the written stream in
+// the given mode, cutting it into leaves of DefaultLeafSize bytes in the
+// two-level shape of Writer. It can be used wherever a hash.Hash constructor is
+// expected, such as with crypto/hmac. It panics if the mode has no hash
+// function.
+//
+// Size is that of the mode's hash function. BlockSize is the block size of the
+// mode's hash function as well, which is what constructions like HMAC pad
+// their keys to, while writes are most efficient in multiples of
+// DefaultLeafSize. NewHashWith chooses the leaf size and the block size.
+func NewHash(mode HashingMode) hash.Hash {
+	return NewHashWith(mode, DefaultLeafSize, HashBlockRate)
+}
+
+// HashBlockSize selects the block size that a hash of NewHashWith reports.
+type HashBlockSize byte
+
+const (
+	// HashBlockRate reports the block size of the mode's hash function, the
+	// rate of a sponge, as NewHash does. It is what HMAC pads its keys to.
+	HashBlockRate HashBlockSize = iota
+
+	// HashBlockLeaf reports the leaf size, so that generic code that buffers
+	// writes to whole blocks writes whole leaves, which the hash absorbs
+	// most efficiently.
+	HashBlockLeaf
+)
+
+// NewHashWith is like NewHash, but cuts the stream into leaves of leafSize
+// bytes and reports the block size selected by block. Like the hashes of the
+// standard library, the hash appends its Size bytes to the slice given to
+// Sum without changing its state, so that writing may go on, and Reset
+// returns it to the state of the empty stream. It panics if the mode has no
+// hash function or leafSize is not positive.
+func NewHashWith(mode HashingMode, leafSize int, block HashBlockSize) hash.Hash {
+	if mode.Hash == nil {
+		panic(ErrNoHash)
+	}
+	if leafSize <= 0 {
+		panic("sakura: non-positive leaf size")
+	}
+	h := mode.Hash()
+	d := &digest{e: New(mode), leafSize: leafSize, size: h.Size(), blockSize: h.BlockSize()}
+	if block == HashBlockLeaf {
+		d.blockSize = leafSize
+	}
+	d.Reset()
+	return d
+}
+
+// digest is the hash.Hash returned by NewHashWith.
+type digest struct {
+	e         *Encoder
+	w         *Writer
+	leafSize  int
+	size      int
+	blockSize int
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	// Leaves hashed from memory cannot fail.
+	return d.w.Write(p)
+}
+
+func (d *digest) Sum(b []byte) []byte {
+	root, err := d.w.sum()
+	if err != nil {
+		panic(err)
+	}
+	return append(b, root...)
+}
+
+func (d *digest) Reset()         { d.w = NewWriter(d.e, d.leafSize) }
+func (d *digest) Size() int      { return d.size }
+func (d *digest) BlockSize() int { return d.blockSize }
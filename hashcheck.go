@@ -0,0 +1,70 @@
+package sakura
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrBadHasher is returned by CheckHasher for a hash function that does not
+// behave like a hash function.
+var ErrBadHasher = errors.New("sakura: hash function misbehaves")
+
+// CheckHasher runs a quick self-test of the hash function of mode, meant to
+// run at startup, before a misconfigured Hasher can spoil a large job. It
+// checks that the size and block size are positive and sums have that size,
+// that hashing is deterministic and does not depend on how writes are split,
+// that Sum leaves the state unchanged and Reset empties it, that instances do
+// not share state, and that different inputs hash differently. It cannot tell
+// a weak hash function from a sound one. Failures wrap ErrBadHasher.
+func CheckHasher(mode HashingMode) error {
+	if mode.Hash == nil {
+		return ErrNoHash
+	}
+	fail := func(reason string) error { return fmt.Errorf("%w: %s", ErrBadHasher, reason) }
+	msg := make([]byte, 257)
+	for i := range msg {
+		msg[i] = byte(i * 7)
+	}
+	other := append([]byte{}, msg...)
+	other[100] ^= 1
+
+	a, b := mode.Hash(), mode.Hash()
+	if a == nil || b == nil {
+		return fail("Hasher returned nil")
+	}
+	size := a.Size()
+	switch {
+	case size <= 0 || a.BlockSize() <= 0:
+		return fail("size or block size is not positive")
+	case b.Size() != size:
+		return fail("instances differ in size")
+	case len(a.Sum(nil)) != size:
+		return fail("sum does not have the size of the hash")
+	}
+	a.Write(msg)
+	want := a.Sum(nil)
+	if !bytes.Equal(a.Sum(nil), want) {
+		return fail("Sum changes the state")
+	}
+	b.Write(other)
+	switch {
+	case !bytes.Equal(a.Sum(nil), want):
+		return fail("instances share state")
+	case bytes.Equal(b.Sum(nil), want):
+		return fail("different inputs have the same hash")
+	}
+	c := mode.Hash()
+	c.Write(msg[:1])
+	c.Write(msg[1:100])
+	c.Write(msg[100:])
+	if !bytes.Equal(c.Sum(nil), want) {
+		return fail("hash depends on how writes are split")
+	}
+	b.Reset()
+	b.Write(msg)
+	if !bytes.Equal(b.Sum(nil), want) {
+		return fail("Reset does not restore the initial state")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package sakura
+
+// NodeHooks are called around the hashing of every node of an Encoder, so that
+// custom metrics, tracing and caching layers can be built on the encoder
+// without forking it. Nodes whose hop reports a cached chaining value are not
+// hashed and so not hooked, nor are the leaves that an Accelerator hashes. The
+// hooks of a parallel run are called concurrently, Before by the goroutine that
+// walks the tree ahead of the workers and After by both, and must be safe for
+// concurrent use. The zero NodeHooks calls nothing.
+type NodeHooks struct {
+	// Before is called before a node is coded. If it returns a value, the
+	// node is neither coded nor hashed, nor traced, audited or counted, and
+	// the value is taken as its hash, so that a cache can supply the hashes
+	// of the nodes it holds. It must then be the hash that the node would
+	// have; the leaves of the subtree are not read, and the leaf indexes of
+	// later errors only count the leaves that were.
+	Before func(info NodeInfo) []byte
+
+	// After is called once a node is hashed, with its hash, or with the error
+	// that failed it. It is also called for the nodes whose hash Before
+	// supplied, with the sizes of info left zero.
+	After func(info NodeInfo, sum []byte, err error)
+}
+
+// NodeInfo describes a node given to NodeHooks. The sizes are only set for
+// After, once the node is coded.
+type NodeInfo struct {
+	Node     NodeID
+	Final    bool   // Whether the node is the final node, not an inner one.
+	Chaining bool   // Whether the hop of the node is a chaining hop.
+	Level    int    // Distance from the root node.
+	Label    string // Label of the hop, if it is a LabeledHop.
+
+	MessageBytes   int64 // Bytes of the message hops coded in the node.
+	ChainingValues int   // Number of chaining values coded in the node.
+	CodedBytes     int64 // Size of the coded node, the input of the hash function.
+}
+
+// hooked reports whether the encoder has node hooks.
+func (h *NodeHooks) hooked() bool {
+	return h.Before != nil || h.After != nil
+}
+
+// nodeInfo returns the description of n for the node hooks.
+func nodeInfo(n node) NodeInfo {
+	chaining, _ := isChaining(n.hop)
+	return NodeInfo{Node: n.id, Final: n.final, Chaining: chaining, Level: n.level, Label: label(n.hop)}
+}
@@ -0,0 +1,91 @@
+package sakura_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chlin501/sakura"
+)
+
+// counted is a message hop counting the reads of its leaf.
+type counted struct {
+	io.Reader
+	reads *atomic.Int32
+}
+
+func (c counted) Read(p []byte) (int, error) {
+	c.reads.Add(1)
+	return c.Reader.Read(p)
+}
+
+func (counted) ChainingValue() []byte   { return nil }
+func (counted) SetChainingValue([]byte) {}
+
+// plain is a mode without kangaroo hopping, in which every chaining hop below
+// the root is a node of its own.
+var plain = sakura.HashingMode{Hash: sha256.New, Interleave: sakura.NoInterleave}
+
+// countedTree returns a tree of two levels of chaining hops over counted
+// leaves of data.
+func countedTree(data []byte, reads *atomic.Int32) sakura.Hop {
+	var top chain
+	for _, group := range [][]byte{data[:200], data[200:400], data[400:]} {
+		var inner chain
+		for _, leaf := range splitLeaves(group, 16) {
+			inner = append(inner, counted{leaf.(io.Reader), reads})
+		}
+		top = append(top, inner)
+	}
+	return top
+}
+
+func TestHooksBeforeSkipsSubtree(t *testing.T) {
+	data := sakura.Pattern(600)
+	var reads atomic.Int32
+	e := encoder(t, plain, 1)
+	var mu sync.Mutex
+	cache := map[string][]byte{}
+	e.Hooks.After = func(info sakura.NodeInfo, sum []byte, err error) {
+		if info.Chaining && !info.Final && err == nil {
+			mu.Lock()
+			cache[fmt.Sprint(info.Node)] = append([]byte(nil), sum...)
+			mu.Unlock()
+		}
+	}
+	root, err := e.Final(countedTree(data, &reads))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache) != 3 {
+		t.Fatalf("cached %d inner nodes, want 3", len(cache))
+	}
+
+	for _, parallelism := range []int{1, 4} {
+		e := encoder(t, plain, parallelism)
+		var asked atomic.Int32
+		e.Hooks.Before = func(info sakura.NodeInfo) []byte {
+			asked.Add(1)
+			mu.Lock()
+			defer mu.Unlock()
+			return cache[fmt.Sprint(info.Node)]
+		}
+		reads.Store(0)
+		got, err := e.Final(countedTree(data, &reads))
+		if err != nil {
+			t.Fatalf("parallelism %d: %v", parallelism, err)
+		}
+		if string(got) != string(root) {
+			t.Errorf("parallelism %d: root differs from the unhooked one", parallelism)
+		}
+		if n := reads.Load(); n != 0 {
+			t.Errorf("parallelism %d: %d leaf reads, want none", parallelism, n)
+		}
+		if n := asked.Load(); n != 4 {
+			t.Errorf("parallelism %d: Before called %d times, want 4", parallelism, n)
+		}
+	}
+}
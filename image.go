@@ -0,0 +1,119 @@
+package sakura
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ImageLayer is a layer of a container image, as listed by its manifest.
+type ImageLayer struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// BlobStore holds the layer blobs of container images, as a registry does.
+type BlobStore interface {
+	// Blob returns the ranger of the blob with the given digest.
+	Blob(digest string) (Ranger, error)
+}
+
+// Image is the tree of a container image: a chaining hop over a leaf that
+// holds the manifest of the image, followed by one subtree per layer, in the
+// order of the manifest. Each layer is cut into leaves of LeafSize bytes,
+// under a hop of the shape Writer builds, so that a registry can serve any
+// range of a layer along with a RangeProof against the root of the image,
+// and a client can verify the range without pulling the rest. Under Kangaroo
+// hopping the manifest is nested in the final node itself.
+type Image struct {
+	Manifest []byte       // The OCI image manifest.
+	Layers   []ImageLayer // The layers listed by Manifest.
+	LeafSize int          // Number of bytes of the leaves of every layer.
+}
+
+// NewImage returns the image of an OCI image manifest, whose layers are cut
+// into leaves of leafSize bytes.
+func NewImage(manifest []byte, leafSize int) (*Image, error) {
+	if leafSize <= 0 {
+		return nil, errors.New("sakura: invalid leaf size")
+	}
+	var m struct {
+		Layers *[]ImageLayer `json:"layers"`
+	}
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, fmt.Errorf("sakura: decoding image manifest: %w", err)
+	}
+	if m.Layers == nil {
+		return nil, errors.New("sakura: not an image manifest")
+	}
+	for i, l := range *m.Layers {
+		if l.Digest == "" || l.Size < 0 {
+			return nil, fmt.Errorf("sakura: invalid layer %d in image manifest", i)
+		}
+	}
+	return &Image{Manifest: append([]byte{}, manifest...), Layers: *m.Layers, LeafSize: leafSize}, nil
+}
+
+// Tree returns the tree of im, whose layers are read from blobs. Every leaf is
+// fetched with its own call to ReadRange when it is hashed, as by
+// HashRanger. The message hops of the tree are read once, so a tree is built
+// for every hash or proof.
+func (im *Image) Tree(blobs BlobStore) (Hop, error) {
+	kids := make([]Hop, 0, 1+len(im.Layers))
+	kids = append(kids, messageLeaf(im.Manifest))
+	for _, l := range im.Layers {
+		r, err := blobs.Blob(l.Digest)
+		if err != nil {
+			return nil, err
+		}
+		kids = append(kids, sequentialTree(RangeLeaves(r, l.Size, im.LeafSize)))
+	}
+	return &chainingLeaves{kids: kids}, nil
+}
+
+// LayerLeaves returns the leaves of the tree of im that hold the n bytes at
+// offset off of the given layer, where n is positive. Leaves are numbered in
+// tree order from the manifest, which is leaf 0, as ProveRange and
+// VerifyRangeProof number them; the message bits of the range are the bytes
+// of the layer from the start of its first leaf to the end of its last.
+func (im *Image) LayerLeaves(layer int, off, n int64) (LeafRange, error) {
+	if layer < 0 || layer >= len(im.Layers) {
+		return LeafRange{}, fmt.Errorf("sakura: layer %d of an image of %d layers", layer, len(im.Layers))
+	}
+	if n <= 0 || off < 0 || off+n > im.Layers[layer].Size {
+		return LeafRange{}, errors.New("sakura: invalid layer range")
+	}
+	base := int64(1)
+	for _, l := range im.Layers[:layer] {
+		base += int64(leafCount(l.Size, im.LeafSize))
+	}
+	ls := int64(im.LeafSize)
+	return LeafRange{Start: int(base + off/ls), End: int(base + (off+n-1)/ls + 1)}, nil
+}
+
+// HashImage returns the root of the tree of im, whose layers are read from
+// blobs.
+func (e *Encoder) HashImage(im *Image, blobs BlobStore) ([]byte, error) {
+	tree, err := im.Tree(blobs)
+	if err != nil {
+		return nil, err
+	}
+	return e.Final(tree)
+}
+
+// ProveLayerRange returns a proof for the leaves of the tree of im that hold
+// the n bytes at offset off of the given layer, as returned by LayerLeaves. A
+// client checks the proof with VerifyRangeProof and the bits of those leaves,
+// cut from the layer at multiples of LeafSize.
+func (e *Encoder) ProveLayerRange(im *Image, blobs BlobStore, layer int, off, n int64) (*RangeProof, error) {
+	r, err := im.LayerLeaves(layer, off, n)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := im.Tree(blobs)
+	if err != nil {
+		return nil, err
+	}
+	return e.ProveRange(tree, r)
+}
@@ -0,0 +1,116 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrPartLengths is returned by the reader of Interleave when the lengths of
+// the parts cannot come from deinterleaving a single message.
+var ErrPartLengths = errors.New("sakura: interleaved parts have inconsistent lengths")
+
+// Deinterleave returns a writer that applies the block interleaving transform
+// of Sakura: the message written to it is cut into blocks of blockSize bytes,
+// and block i is written to ws[i%len(ws)]. The parts are then the messages of
+// the children of a chaining hop with that interleaving block size, as given by
+// BlockSize.Value. It panics if ws is empty or blockSize is not positive.
+func Deinterleave(ws []io.Writer, blockSize int) io.Writer {
+	if len(ws) == 0 || blockSize <= 0 {
+		panic("sakura: no parts or non-positive block size")
+	}
+	return &deinterleaver{ws: ws, size: blockSize}
+}
+
+type deinterleaver struct {
+	ws   []io.Writer
+	size int
+	part int // Part receiving the current block.
+	off  int // Number of bytes of the current block written.
+}
+
+func (d *deinterleaver) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		k := min(d.size-d.off, len(p))
+		m, err := d.ws[d.part].Write(p[:k])
+		n += m
+		d.off += m
+		if err != nil {
+			return n, err
+		}
+		p = p[k:]
+		if d.off == d.size {
+			d.part, d.off = (d.part+1)%len(d.ws), 0
+		}
+	}
+	return n, nil
+}
+
+// Interleave returns a reader that undoes Deinterleave: it reassembles the
+// message from its parts by reading blocks of blockSize bytes from rs in turn.
+// The message ends with the first part to end, and the reader fails with
+// ErrPartLengths if any part holds more data than it should. It panics if rs is
+// empty or blockSize is not positive.
+func Interleave(rs []io.Reader, blockSize int) io.Reader {
+	if len(rs) == 0 || blockSize <= 0 {
+		panic("sakura: no parts or non-positive block size")
+	}
+	return &interleaver{rs: rs, size: blockSize}
+}
+
+type interleaver struct {
+	rs   []io.Reader
+	size int
+	part int // Part holding the current block.
+	off  int // Number of bytes of the current block read.
+	err  error
+}
+
+func (r *interleaver) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && r.err == nil {
+		cur := r.part
+		k := min(r.size-r.off, len(p)-n)
+		m, err := r.rs[cur].Read(p[n : n+k])
+		if err == io.EOF && m > 0 {
+			// The part may hold no more, but the message only ends once
+			// the part at the current position has nothing to add.
+			err = nil
+		}
+		n += m
+		r.off += m
+		if r.off == r.size {
+			r.part, r.off = (cur+1)%len(r.rs), 0
+		}
+		switch {
+		case err == io.EOF:
+			r.err = r.end(cur)
+		case err != nil:
+			r.err = err
+		case m == 0:
+			// Let the caller retry rather than spinning on an empty read.
+			return n, nil
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, r.err
+}
+
+// end checks, once part ended has ended, that the other parts have ended as
+// well, and returns io.EOF if so.
+func (r *interleaver) end(ended int) error {
+	var b [1]byte
+	for i, part := range r.rs {
+		if i == ended {
+			continue
+		}
+		if m, err := io.ReadFull(part, b[:]); m > 0 {
+			return ErrPartLengths
+		} else if err != io.EOF {
+			return err
+		}
+	}
+	return io.EOF
+}
@@ -0,0 +1,66 @@
+package sakura_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/chlin501/sakura"
+)
+
+// deinterleave returns the parts of data cut into blocks of blockSize bytes.
+func deinterleave(t *testing.T, data []byte, parts, blockSize int) []*bytes.Buffer {
+	t.Helper()
+	bufs := make([]*bytes.Buffer, parts)
+	ws := make([]io.Writer, parts)
+	for i := range bufs {
+		bufs[i] = new(bytes.Buffer)
+		ws[i] = bufs[i]
+	}
+	if _, err := sakura.Deinterleave(ws, blockSize).Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return bufs
+}
+
+func TestInterleave(t *testing.T) {
+	const parts, blockSize = 3, 4
+	readers := map[string]func(io.Reader) io.Reader{
+		"plain":    func(r io.Reader) io.Reader { return r },
+		"data+EOF": iotest.DataErrReader,
+		"one byte": iotest.OneByteReader,
+		"half+EOF": func(r io.Reader) io.Reader { return iotest.DataErrReader(iotest.HalfReader(r)) },
+		"one+EOF":  func(r io.Reader) io.Reader { return iotest.DataErrReader(iotest.OneByteReader(r)) },
+	}
+	for name, wrap := range readers {
+		for size := 0; size <= 3*parts*blockSize; size++ {
+			data := sakura.Pattern(size)
+			var rs []io.Reader
+			for _, b := range deinterleave(t, data, parts, blockSize) {
+				rs = append(rs, wrap(b))
+			}
+			got, err := io.ReadAll(sakura.Interleave(rs, blockSize))
+			if err != nil {
+				t.Fatalf("%s, %d bytes: %v", name, size, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("%s, %d bytes: got %x, want %x", name, size, got, data)
+			}
+		}
+	}
+}
+
+func TestInterleavePartLengths(t *testing.T) {
+	data := sakura.Pattern(10)
+	bufs := deinterleave(t, data, 3, 4)
+	bufs[0].WriteByte(0) // The first part holds a block of the next round.
+	var rs []io.Reader
+	for _, b := range bufs {
+		rs = append(rs, iotest.DataErrReader(b))
+	}
+	if _, err := io.ReadAll(sakura.Interleave(rs, 4)); !errors.Is(err, sakura.ErrPartLengths) {
+		t.Errorf("got %v, want ErrPartLengths", err)
+	}
+}
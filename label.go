@@ -0,0 +1,29 @@
+package sakura
+
+import "github.com/chlin501/sakura/sakuracoding"
+
+// labelDomain begins the message of the header leaf of a labeled hop.
+const labelDomain = "sakura.label"
+
+// Labeled returns a hop that binds label, such as a file path or chunk ID, to
+// hop in the hashed data, unlike the labels of a LabeledHop, which are only
+// descriptive. The hop is a chaining hop over a header leaf, holding a fixed
+// domain string and the label followed by its length, and hop itself, so the
+// same hop under different labels, or unlabeled, has different chaining
+// values, and no label can be taken for the start of another.
+//
+// The returned hop is also a LabeledHop with the given label, so it names
+// the subtree in errors and traces as well.
+func Labeled(label string, hop Hop) Hop {
+	header := append([]byte(labelDomain), label...)
+	header = sakuracoding.AppendLengthEncode(header, uint64(len(label)))
+	return &labeledHop{chainingLeaves{kids: []Hop{messageLeaf(header), hop}}, label}
+}
+
+// labeledHop is the hop returned by Labeled.
+type labeledHop struct {
+	chainingLeaves
+	label string
+}
+
+func (l *labeledHop) Label() string { return l.label }
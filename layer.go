@@ -0,0 +1,106 @@
+package sakura
+
+import (
+	"io"
+	"slices"
+	"sync"
+)
+
+// FinalLayer is like Final, but also returns the leaf layer of the tree: the
+// chaining values of the message hops hashed as inner nodes, in tree order.
+// Protocols that transmit this layer let receivers check every leaf as it
+// arrives, as NewStreamVerifier does, before the whole message is known.
+//
+// Message hops whose chaining value is cached are included with that value.
+// A message hop that kangaroo hopping nests in its parent's node has no
+// chaining value of its own and is left out, so the layer of a tree built by
+// a Writer with kangaroo hopping lacks the first leaf.
+func (e *Encoder) FinalLayer(hop Hop) (root []byte, layer [][]byte, err error) {
+	if err := e.checkMode(); err != nil {
+		return nil, nil, err
+	}
+	j := newJob(e)
+	j.layer = new(leafLayer)
+	root, err = j.traced("sakura.Final", hop, func() ([]byte, error) {
+		return j.run(hop, true)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return root, j.layer.sorted(), nil
+}
+
+// HashLayer returns the root of the stream read until EOF from r, as computed
+// by a Writer with the given leaf size, together with its leaf layer, the
+// chaining values of the leaves concatenated in order as taken by LayerRoot
+// and NewStreamVerifier. Both come from a single pass over the data, so piece
+// tables need not be built by hashing twice or from a LeafFunc. As with
+// LeafHashes, and unlike FinalLayer, the layer holds every leaf, including a
+// first leaf nested in the final node, which is hashed on its own for it.
+func (e *Encoder) HashLayer(r io.Reader, leafSize int) (root, layer []byte, err error) {
+	if err := e.checkMode(); err != nil {
+		return nil, nil, err
+	}
+	var cvs [][]byte
+	w := NewWriter(e, leafSize)
+	w.SetLeafFunc(func(leaf int, off int64, n int, cv []byte) {
+		for len(cvs) <= leaf {
+			cvs = append(cvs, nil)
+		}
+		cvs[leaf] = cv
+	})
+	if _, err := io.Copy(w, r); err != nil {
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, nil, err
+	}
+	return w.Root(), slices.Concat(cvs...), nil
+}
+
+// leafLayer collects the chaining values of the message hops coded in the
+// nodes of a job, which may complete in any order.
+type leafLayer struct {
+	mu     sync.Mutex
+	leaves []layerLeaf
+}
+
+type layerLeaf struct {
+	id NodeID
+	cv []byte
+}
+
+// add records the chaining value of the child coded in a node, if it is a
+// message hop. A nil layer records nothing.
+func (l *leafLayer) add(child Hop, id NodeID, cv []byte) {
+	if l == nil {
+		return
+	}
+	if chaining, err := isChaining(child); chaining || err != nil {
+		return
+	}
+	l.mu.Lock()
+	l.leaves = append(l.leaves, layerLeaf{id, cv})
+	l.mu.Unlock()
+}
+
+// sorted returns the recorded chaining values in tree order.
+func (l *leafLayer) sorted() [][]byte {
+	slices.SortFunc(l.leaves, func(a, b layerLeaf) int { return compareIDs(a.id, b.id) })
+	cvs := make([][]byte, len(l.leaves))
+	for i, leaf := range l.leaves {
+		cvs[i] = leaf.cv
+	}
+	return cvs
+}
+
+// compareIDs orders two node IDs in tree order, that of a depth-first
+// traversal visiting children by increasing index.
+func compareIDs(a, b NodeID) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return len(a) - len(b)
+}
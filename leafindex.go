@@ -0,0 +1,103 @@
+package sakura
+
+import (
+	"slices"
+	"sync"
+)
+
+// LeafIndex maps the chaining values of the leaves of a stream to their
+// positions in it, so that dedup and forensics tools can answer where a chunk
+// occurs. It is built while the stream is hashed, by giving its Add method to
+// Writer.SetLeafFunc:
+//
+//	ix := sakura.NewLeafIndex()
+//	w := sakura.NewWriter(e, leafSize)
+//	w.SetLeafFunc(ix.Add)
+//
+// Leaves of equal data share a chaining value, so a value maps to all the
+// positions of its data. A LeafIndex is safe for concurrent use, and may be
+// queried while the stream is still written.
+type LeafIndex struct {
+	mu     sync.Mutex
+	leaves map[string][]LeafPosition
+	n      int
+}
+
+// LeafPosition is the position of a leaf in a stream.
+type LeafPosition struct {
+	Leaf   int   // Index of the leaf.
+	Offset int64 // Offset of the first byte of the leaf.
+	Length int   // Number of bytes of the leaf.
+}
+
+// NewLeafIndex returns an empty index.
+func NewLeafIndex() *LeafIndex {
+	return &LeafIndex{leaves: make(map[string][]LeafPosition)}
+}
+
+// Add records that leaf, of n bytes at offset off, has the chaining value cv.
+// It is a LeafFunc.
+func (x *LeafIndex) Add(leaf int, off int64, n int, cv []byte) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.leaves[string(cv)] = append(x.leaves[string(cv)], LeafPosition{Leaf: leaf, Offset: off, Length: n})
+	x.n++
+}
+
+// Lookup returns the positions of the leaves with chaining value cv, by
+// increasing index, or nil if there are none.
+func (x *LeafIndex) Lookup(cv []byte) []LeafPosition {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return sortedPositions(x.leaves[string(cv)])
+}
+
+// Find returns the positions of the leaves that hold chunk, a whole leaf of a
+// stream hashed in mode, by increasing index.
+func (x *LeafIndex) Find(mode HashingMode, chunk []byte) ([]LeafPosition, error) {
+	cv, err := New(mode).Inner(messageLeaf(chunk))
+	if err != nil {
+		return nil, err
+	}
+	return x.Lookup(cv), nil
+}
+
+// Duplicates returns the positions of the leaves whose data occurs more than
+// once, grouped by data and ordered by the first occurrence of each.
+func (x *LeafIndex) Duplicates() [][]LeafPosition {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var dups [][]LeafPosition
+	for _, p := range x.leaves {
+		if len(p) > 1 {
+			dups = append(dups, sortedPositions(p))
+		}
+	}
+	slices.SortFunc(dups, func(a, b []LeafPosition) int { return a[0].Leaf - b[0].Leaf })
+	return dups
+}
+
+// Len returns the number of leaves added to the index.
+func (x *LeafIndex) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.n
+}
+
+// Distinct returns the number of distinct chaining values in the index.
+func (x *LeafIndex) Distinct() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.leaves)
+}
+
+// sortedPositions returns a copy of p by increasing leaf index, since leaves
+// are added in the order they complete.
+func sortedPositions(p []LeafPosition) []LeafPosition {
+	if p == nil {
+		return nil
+	}
+	p = slices.Clone(p)
+	slices.SortFunc(p, func(a, b LeafPosition) int { return a.Leaf - b.Leaf })
+	return p
+}
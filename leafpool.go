@@ -0,0 +1,206 @@
+package sakura
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrWouldBlock is returned by a non-blocking Writer when a completed leaf
+// cannot be handed to a hashing worker because all of them are busy or the
+// writer is paused.
+var ErrWouldBlock = errors.New("sakura: all hashing workers are busy")
+
+// leafPool hashes the leaves of a Writer-shaped stream as inner nodes on a
+// bounded number of goroutines. Its methods other than the workers' own are
+// called from a single goroutine.
+type leafPool struct {
+	e   *Encoder
+	sem chan struct{} // Holds a token for every leaf being hashed.
+	wg  sync.WaitGroup
+
+	gate pauseGate // Holds back the workers while the writer is paused.
+
+	arena arena // Source of the chaining values of all leaves.
+
+	// Set by Writer.SetLeafFunc: the function called for every leaf hashed,
+	// serialized by emitMu, and the leaf size of the stream.
+	onLeaf   LeafFunc
+	leafSize int
+	emitMu   sync.Mutex
+
+	// Leaves for the Accelerator of the encoder that are not yet submitted,
+	// in batches of batchSize.
+	pending   []pendingLeaf
+	batchSize int
+
+	// Set by Writer.SetSpill: the limit on the memory of the chaining values
+	// in cvs, beyond which those of the leading leaves move to the temporary
+	// file spill in spillDir, holding the values of the leaves before base,
+	// and the size of a value. Nested is set when leaf 0 is nested in the
+	// final node, not hashed.
+	spillMax int64
+	spillDir string
+	spill    *os.File
+	cvSize   int
+
+	// Set by Writer.SetStreaming: the final node, into which the chaining
+	// values of the leaves before base are absorbed, and their number.
+	final  *bitWriter
+	values int
+
+	mu     sync.Mutex
+	cvs    [][]byte // Chaining values of the leaves from base, by index; nil while pending.
+	base   int
+	nested bool
+	err    error // First error.
+	done   chan struct{}
+}
+
+func newLeafPool(e *Encoder) *leafPool {
+	p := &leafPool{e: e, sem: make(chan struct{}, max(e.Parallelism, 1)), done: make(chan struct{})}
+	if e.Accelerator != nil {
+		p.batchSize = e.AcceleratorStats().Batch
+	}
+	return p
+}
+
+// hash hashes leaf i, whose data must no longer be modified, waiting for a
+// worker if all are busy, up to the deadline unless it is zero. With wait
+// unset, it fails with ErrWouldBlock instead. It returns the error of an
+// earlier leaf, if any. With an Accelerator, leaves are hashed in batches and
+// only a full batch waits. If pooled is set, data is returned to the Buffers
+// of the encoder once hashed.
+func (p *leafPool) hash(i int, data []byte, pooled, wait bool, deadline time.Time) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	if err := p.drain(); err != nil {
+		return err
+	}
+	if p.e.Accelerator != nil {
+		return p.batch(i, data, pooled, wait, deadline)
+	}
+	if err := p.acquire(wait, deadline); err != nil {
+		return err
+	}
+	p.grow(i)
+	p.wg.Add(1)
+	go func() {
+		defer func() { <-p.sem; p.wg.Done() }()
+		j := newJob(p.e)
+		j.leaf, j.cvs, j.pause = i, &p.arena, &p.gate
+		cv, err := j.serial(messageLeaf(data), NodeID{i}, false, 1)
+		n := len(data)
+		if pooled {
+			p.e.Buffers.put(data)
+		}
+		if err == nil && p.onLeaf != nil {
+			p.emitMu.Lock()
+			p.onLeaf(i, int64(i)*int64(p.leafSize), n, cv)
+			p.emitMu.Unlock()
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if err != nil {
+			if p.err == nil {
+				p.err = err
+				close(p.done)
+			}
+			return
+		}
+		p.cvs[i-p.base] = cv
+	}()
+	return nil
+}
+
+// acquire takes a slot for a leaf or batch of leaves as described by hash.
+func (p *leafPool) acquire(wait bool, deadline time.Time) error {
+	if err := p.unpaused(wait, deadline); err != nil {
+		return err
+	}
+	if wait {
+		expired, stop := after(deadline)
+		defer stop()
+		select {
+		case p.sem <- struct{}{}:
+		case <-p.done:
+			return p.failed()
+		case <-expired:
+			return os.ErrDeadlineExceeded
+		}
+	} else {
+		select {
+		case p.sem <- struct{}{}:
+		default:
+			return ErrWouldBlock
+		}
+	}
+	return nil
+}
+
+// grow makes room for the chaining value of leaf i.
+func (p *leafPool) grow(i int) {
+	p.mu.Lock()
+	for p.base+len(p.cvs) <= i {
+		p.cvs = append(p.cvs, nil)
+	}
+	p.mu.Unlock()
+}
+
+// failed returns the first error of a leaf.
+func (p *leafPool) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// wait waits for the leaves being hashed, up to the deadline unless it is
+// zero, and returns the chaining values of all leaves hashed so far that are
+// not spilled, from leaf base. Leaves pending for the accelerator are
+// submitted first.
+func (p *leafPool) wait(deadline time.Time) ([][]byte, error) {
+	if len(p.pending) > 0 {
+		if err := p.acquire(true, deadline); err != nil {
+			return nil, err
+		}
+		p.submit()
+	}
+	if !deadline.IsZero() {
+		idle := make(chan struct{})
+		go func() {
+			p.wg.Wait()
+			close(idle)
+		}()
+		expired, stop := after(deadline)
+		defer stop()
+		select {
+		case <-idle:
+		case <-expired:
+			return nil, os.ErrDeadlineExceeded
+		}
+	}
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cvs, p.err
+}
+
+// after returns a channel that receives once the deadline has passed, or nil
+// for the zero deadline, and a function releasing its timer.
+func after(deadline time.Time) (<-chan time.Time, func()) {
+	if deadline.IsZero() {
+		return nil, func() {}
+	}
+	t := time.NewTimer(time.Until(deadline))
+	return t.C, func() { t.Stop() }
+}
+
+// restore sets the chaining values of leaves hashed before a checkpoint. No
+// leaves may be in flight.
+func (p *leafPool) restore(cvs [][]byte) {
+	p.mu.Lock()
+	p.cvs = cvs
+	p.mu.Unlock()
+}
@@ -0,0 +1,43 @@
+package sakura
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter paces the reads of a job to a number of bytes per second. The pace is
+// shared by all workers, so it bounds the total bandwidth of the job.
+type limiter struct {
+	mu   sync.Mutex
+	rate float64   // Bytes per second.
+	next time.Time // Time at which the bytes read so far have been paid for.
+}
+
+// wait blocks until n more bytes may be read without exceeding the rate, or
+// until done is closed. A nil limiter never blocks.
+func (l *limiter) wait(n int, done <-chan struct{}) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+
+	d := time.Until(at)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-done:
+		return errCanceled
+	}
+}
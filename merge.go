@@ -0,0 +1,123 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrUnalignedPart is returned by Merge when the left part does not end on a
+// leaf boundary, so that the leaves of the concatenation are not those of the
+// parts.
+var ErrUnalignedPart = errors.New("sakura: left part does not end on a leaf boundary")
+
+// Part describes a hashed part of a stream, such as one part of a multi-part
+// upload, in the shape of the tree built by Writer. Parts hashed independently
+// are combined by Merge into the part of their concatenation without reading
+// their data again, and Root returns the root of the stream a part holds.
+type Part struct {
+	LeafSize int
+	Size     int64    // Size of the part in bytes.
+	Leaves   [][]byte // Chaining values of the leaves, as returned by Encoder.LeafHashes.
+	First    []byte   // Bits of the first leaf, which the final node may hold.
+}
+
+// HashPart returns the part of the first size bytes read from r, cut into
+// leaves of leafSize bytes.
+func (e *Encoder) HashPart(r io.ReaderAt, size int64, leafSize int) (*Part, error) {
+	leaves, err := e.LeafHashes(r, size, leafSize)
+	if err != nil {
+		return nil, err
+	}
+	first, err := readLeaf(r, size, leafSize, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Part{LeafSize: leafSize, Size: size, Leaves: leaves, First: first}, nil
+}
+
+// check validates the fields of p against each other.
+func (p *Part) check() error {
+	if p.LeafSize <= 0 || p.Size < 0 {
+		return errors.New("sakura: invalid size or leaf size")
+	}
+	if len(p.Leaves) != leafCount(p.Size, p.LeafSize) {
+		return ErrLengthMismatch
+	}
+	if int64(len(p.First)) != min(int64(p.LeafSize), p.Size) {
+		return errors.New("sakura: first leaf does not have the length of the leaf")
+	}
+	return nil
+}
+
+// Merge returns the part of the concatenation of left and right, which must
+// have the same leaf size. The leaves of the concatenation are those of the
+// parts only if left ends on a leaf boundary, so Merge fails with
+// ErrUnalignedPart unless the size of left is a multiple of the leaf size. An
+// empty part merges with any other. The chaining values of the result are
+// shared with the parts.
+func Merge(left, right *Part) (*Part, error) {
+	if err := left.check(); err != nil {
+		return nil, err
+	}
+	if err := right.check(); err != nil {
+		return nil, err
+	}
+	if left.LeafSize != right.LeafSize {
+		return nil, errors.New("sakura: parts have different leaf sizes")
+	}
+	switch {
+	case left.Size == 0:
+		return right, nil
+	case right.Size == 0:
+		return left, nil
+	case left.Size%int64(left.LeafSize) != 0:
+		return nil, ErrUnalignedPart
+	}
+	leaves := make([][]byte, 0, len(left.Leaves)+len(right.Leaves))
+	leaves = append(append(leaves, left.Leaves...), right.Leaves...)
+	return &Part{LeafSize: left.LeafSize, Size: left.Size + right.Size, Leaves: leaves, First: left.First}, nil
+}
+
+// Root returns the root in mode of the stream that p holds, which equals the
+// root a Writer with the same leaf size returns for it.
+func (p *Part) Root(mode HashingMode) ([]byte, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	return New(mode).Final(layerTree(mode, p.Leaves, p.First))
+}
+
+// Split returns the parts of the bytes of p before and after leaf, which must
+// be neither the first leaf nor beyond the last, so that both parts are
+// non-empty. The final node of the right part may hold the bits of its first
+// leaf, which p does not keep, so they must be given as first. They are checked
+// against the chaining value of the leaf in mode and Split returns a
+// *LeafMismatchError if they do not match. The chaining values of the parts
+// are shared with p.
+func Split(mode HashingMode, p *Part, leaf int, first []byte) (left, right *Part, err error) {
+	if err := p.check(); err != nil {
+		return nil, nil, err
+	}
+	if leaf <= 0 || leaf >= len(p.Leaves) {
+		return nil, nil, errors.New("sakura: leaf index out of range")
+	}
+	off := int64(leaf) * int64(p.LeafSize)
+	r := ByteRange{Off: off, Len: min(int64(p.LeafSize), p.Size-off)}
+	if int64(len(first)) != r.Len {
+		return nil, nil, errors.New("sakura: first leaf does not have the length of the leaf")
+	}
+	e := New(mode)
+	if err := e.checkMode(); err != nil {
+		return nil, nil, err
+	}
+	cv, err := e.leafValue(leaf, first)
+	if err != nil {
+		return nil, nil, err
+	}
+	if compareRoots(cv, p.Leaves[leaf], ErrRootMismatch) != nil {
+		return nil, nil, &LeafMismatchError{Leaf: leaf, Range: r}
+	}
+	left = &Part{LeafSize: p.LeafSize, Size: off, Leaves: p.Leaves[:leaf:leaf], First: p.First}
+	right = &Part{LeafSize: p.LeafSize, Size: p.Size - off, Leaves: p.Leaves[leaf:], First: first}
+	return left, right, nil
+}
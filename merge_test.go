@@ -0,0 +1,127 @@
+package sakura_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/chlin501/sakura"
+)
+
+// partModes are the modes the part tests run in, with and without kangaroo
+// hopping, under which the final node holds the first leaf.
+var partModes = map[string]sakura.HashingMode{"Mode128": sakura.Mode128(), "plain": plain}
+
+// hashPart returns the part of data cut into leaves of leafSize bytes.
+func hashPart(t *testing.T, mode sakura.HashingMode, data []byte, leafSize int) *sakura.Part {
+	t.Helper()
+	p, err := sakura.New(mode).HashPart(bytes.NewReader(data), int64(len(data)), leafSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// writerRoot returns the root that a Writer returns for data.
+func writerRoot(t *testing.T, mode sakura.HashingMode, data []byte, leafSize int) []byte {
+	t.Helper()
+	w := sakura.NewWriter(sakura.New(mode), leafSize)
+	write(t, w, data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.Root()
+}
+
+func TestMerge(t *testing.T) {
+	const leafSize = 16
+	for name, mode := range partModes {
+		t.Run(name, func(t *testing.T) {
+			for _, size := range []int{0, 5, 16, 17, 48, 100} {
+				data := sakura.Pattern(size)
+				want := writerRoot(t, mode, data, leafSize)
+				for cut := 0; cut <= size; cut += leafSize {
+					merged, err := sakura.Merge(hashPart(t, mode, data[:cut], leafSize), hashPart(t, mode, data[cut:], leafSize))
+					if err != nil {
+						t.Fatalf("size %d, cut %d: %v", size, cut, err)
+					}
+					if merged.Size != int64(size) {
+						t.Fatalf("size %d, cut %d: merged part has %d bytes", size, cut, merged.Size)
+					}
+					got, err := merged.Root(mode)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, want) {
+						t.Fatalf("size %d, cut %d: root differs from the Writer one", size, cut)
+					}
+				}
+			}
+		})
+	}
+
+	data := sakura.Pattern(40)
+	mode := sakura.Mode128()
+	if _, err := sakura.Merge(hashPart(t, mode, data[:20], 16), hashPart(t, mode, data[20:], 16)); !errors.Is(err, sakura.ErrUnalignedPart) {
+		t.Errorf("unaligned left part: got %v, want ErrUnalignedPart", err)
+	}
+	if _, err := sakura.Merge(hashPart(t, mode, data[:16], 16), hashPart(t, mode, data[16:], 8)); err == nil {
+		t.Error("Merge of parts of different leaf sizes succeeded")
+	}
+	broken := hashPart(t, mode, data, 16)
+	broken.Leaves = broken.Leaves[1:]
+	if _, err := sakura.Merge(broken, hashPart(t, mode, nil, 16)); !errors.Is(err, sakura.ErrLengthMismatch) {
+		t.Errorf("part missing a leaf: got %v, want ErrLengthMismatch", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	const leafSize = 16
+	for name, mode := range partModes {
+		t.Run(name, func(t *testing.T) {
+			data := sakura.Pattern(100)
+			p := hashPart(t, mode, data, leafSize)
+			for leaf := 1; leaf < len(p.Leaves); leaf++ {
+				off := leaf * leafSize
+				left, right, err := sakura.Split(mode, p, leaf, data[off:min(off+leafSize, len(data))])
+				if err != nil {
+					t.Fatalf("leaf %d: %v", leaf, err)
+				}
+				for _, c := range []struct {
+					part *sakura.Part
+					data []byte
+				}{{left, data[:off]}, {right, data[off:]}} {
+					got, err := c.part.Root(mode)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, writerRoot(t, mode, c.data, leafSize)) {
+						t.Fatalf("leaf %d: part of %d bytes has another root", leaf, len(c.data))
+					}
+				}
+				merged, err := sakura.Merge(left, right)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, _ := merged.Root(mode); !bytes.Equal(got, writerRoot(t, mode, data, leafSize)) {
+					t.Fatalf("leaf %d: merged parts have another root", leaf)
+				}
+			}
+
+			forged := append([]byte(nil), data[16:32]...)
+			forged[0] ^= 1
+			var mismatch *sakura.LeafMismatchError
+			if _, _, err := sakura.Split(mode, p, 1, forged); !errors.As(err, &mismatch) || mismatch.Leaf != 1 {
+				t.Errorf("forged first leaf: got %v, want a LeafMismatchError of leaf 1", err)
+			}
+			for _, leaf := range []int{0, len(p.Leaves)} {
+				if _, _, err := sakura.Split(mode, p, leaf, data[:leafSize]); err == nil {
+					t.Errorf("Split at leaf %d succeeded", leaf)
+				}
+			}
+			if _, _, err := sakura.Split(mode, p, 1, data[16:31]); err == nil {
+				t.Error("Split with a short first leaf succeeded")
+			}
+		})
+	}
+}
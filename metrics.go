@@ -0,0 +1,24 @@
+package sakura
+
+// Metrics receives counters about the work of this package, for production
+// monitoring. The method set is that of *expvar.Map, which can be used as is,
+// and an adapter for another system, such as a Prometheus counter vector with
+// a name label, only needs to map Add onto its own counters. Add is called
+// from every goroutine doing the counted work, so it must be safe for
+// concurrent use and should be cheap.
+type Metrics interface {
+	// Add adds delta to the counter of the given name, one of the Metric
+	// constants.
+	Add(name string, delta int64)
+}
+
+// Names of the counters reported to Metrics. The hit rate of a VerifyCache is
+// MetricVerifyCacheHits over the sum of hits and misses.
+const (
+	MetricBytesHashed       = "sakura.bytes_hashed" // Bytes of coded nodes given to the hash function.
+	MetricNodesHashed       = "sakura.nodes_hashed"
+	MetricLeafRetries       = "sakura.leaf_retries"    // Failed reads of message hops that were retried.
+	MetricVerifyFailures    = "sakura.verify_failures" // Proofs that a Verifier or VerifyCache rejected.
+	MetricVerifyCacheHits   = "sakura.verify_cache_hits"
+	MetricVerifyCacheMisses = "sakura.verify_cache_misses"
+)
@@ -0,0 +1,346 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// mmrVersion is the version of the serialized peaks of an MMR.
+const mmrVersion = 1
+
+// ErrPruned is returned when proving a leaf of an MMR that was resumed from
+// its peaks and appended to before.
+var ErrPruned = errors.New("sakura: leaf precedes the peaks the tree was resumed from")
+
+// MMR is a Merkle Mountain Range: an append-only list of leaves hashed as a
+// sequence of perfect binary trees, the peaks, one for every bit set in the
+// number of leaves, in decreasing order of size. Appending a leaf merges the
+// peaks of equal size it completes, so it costs a logarithmic number of nodes
+// amortized and never rebalances the tree, and older inclusion proofs stay
+// valid within their peak as the range grows. The root is the final node of a
+// chaining hop over the peaks, which bags them, and the empty range has the
+// root of the empty message, like a Writer closed without writes.
+//
+// An MMR keeps the chaining values of all subtrees, for proofs, and with
+// kangaroo hopping also the bits of the leaves with an even index, which are
+// nested in the nodes of their parents. MarshalPeaks saves only what is
+// needed to continue appending.
+type MMR struct {
+	e     *Encoder
+	n     int
+	nodes map[mmrKey][]byte // Chaining values of the subtrees that are known.
+	data  map[int][]byte    // Bits of the leaves nested in their parents' nodes.
+	root  []byte
+}
+
+// mmrKey names the perfect subtree of height h whose leaves start at j<<h.
+type mmrKey struct{ h, j int }
+
+// NewMMR returns an empty range whose nodes are hashed with e.
+func NewMMR(e *Encoder) (*MMR, error) {
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	m := &MMR{e: e, nodes: make(map[mmrKey][]byte), data: make(map[int][]byte)}
+	var err error
+	if m.root, err = e.Final(messageLeaf(nil)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Len returns the number of leaves of m.
+func (m *MMR) Len() int { return m.n }
+
+// Root returns the root of m.
+func (m *MMR) Root() []byte { return m.root }
+
+// peaks returns the peaks of a range of n leaves, from the left.
+func peaks(n int) []mmrKey {
+	var ps []mmrKey
+	start := 0
+	for h := bits.Len(uint(n)) - 1; h >= 0; h-- {
+		if n&(1<<h) != 0 {
+			ps = append(ps, mmrKey{h, start >> h})
+			start += 1 << h
+		}
+	}
+	return ps
+}
+
+// Peaks returns the chaining values of the peaks of m, from the left.
+func (m *MMR) Peaks() [][]byte {
+	var cvs [][]byte
+	for _, p := range peaks(m.n) {
+		cvs = append(cvs, m.nodes[p])
+	}
+	return cvs
+}
+
+// Append adds data, which must not be modified afterwards, as the last leaf
+// of m and returns its index. If hashing fails, m is left as it was.
+func (m *MMR) Append(data []byte) (int, error) {
+	i := m.n
+	added, err := m.add(i, data)
+	if err == nil {
+		m.n++
+		var root []byte
+		if root, err = m.e.Final(m.bag(m.n, nil)); err == nil {
+			m.root = root
+			return i, nil
+		}
+		m.n--
+	}
+	for _, k := range added {
+		delete(m.nodes, k)
+	}
+	delete(m.data, i)
+	return 0, err
+}
+
+// add hashes leaf i and the subtrees it completes, returning those it added.
+func (m *MMR) add(i int, data []byte) ([]mmrKey, error) {
+	if m.e.mode.Kangaroo && i%2 == 0 {
+		m.data[i] = data
+	}
+	var added []mmrKey
+	k, hop := mmrKey{0, i}, Hop(messageLeaf(data))
+	for {
+		cv, err := m.e.Inner(hop)
+		if err != nil {
+			return added, err
+		}
+		m.nodes[k] = cv
+		added = append(added, k)
+		if k.j%2 == 0 {
+			return added, nil
+		}
+		k = mmrKey{k.h + 1, k.j / 2}
+		if hop, err = m.view(k, nil); err != nil {
+			return added, err
+		}
+	}
+}
+
+// bag returns the hop of the final node over the peaks of the first n leaves,
+// with the nodes on path expanded as by view.
+func (m *MMR) bag(n int, path NodeID) Hop {
+	c := &chainingLeaves{}
+	for p, k := range peaks(n) {
+		switch {
+		case len(path) > 0 && path[0] == p:
+			hop, _ := m.view(k, path[1:])
+			c.kids = append(c.kids, hop)
+		case p == 0 && m.e.mode.Kangaroo:
+			hop, _ := m.view(k, nil)
+			c.kids = append(c.kids, hop)
+		default:
+			c.kids = append(c.kids, &storedLeaf{cv: m.nodes[k]})
+		}
+	}
+	return c
+}
+
+// view returns a hop for hashing or proving the subtree k. The subtrees that
+// are nested in their parents' nodes under kangaroo hopping and those on path
+// are expanded, while all others are given by their chaining value. path is
+// nil for subtrees off the path, and the leaf at its end is left empty, since
+// Prove only reads the proven leaf. It returns ErrPruned if a chaining value
+// that it needs is not known.
+func (m *MMR) view(k mmrKey, path NodeID) (Hop, error) {
+	if k.h == 0 {
+		if path != nil {
+			return messageLeaf(nil), nil
+		}
+		return messageLeaf(m.data[k.j]), nil
+	}
+	c := &chainingLeaves{}
+	for i := 0; i < 2; i++ {
+		kid := mmrKey{k.h - 1, 2*k.j + i}
+		var hop Hop
+		var err error
+		switch {
+		case len(path) > 0 && path[0] == i:
+			hop, err = m.view(kid, path[1:])
+		case i == 0 && m.e.mode.Kangaroo:
+			hop, err = m.view(kid, nil)
+		case m.nodes[kid] == nil:
+			err = ErrPruned
+		default:
+			hop = &storedLeaf{cv: m.nodes[kid]}
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.kids = append(c.kids, hop)
+	}
+	return c, nil
+}
+
+// prune forgets the chaining values and leaf bits of m that are not needed to
+// append further leaves or to prove the leaf target, if it is not negative.
+// What is left is logarithmic in the number of leaves.
+func (m *MMR) prune(target int) {
+	nodes := make(map[mmrKey][]byte)
+	data := make(map[int][]byte)
+	keep := func(k mmrKey, nested bool) {
+		nodes[k] = m.nodes[k]
+		if !nested || !m.e.mode.Kangaroo {
+			return
+		}
+		// The node of k codes the chain of its first children.
+		if d, ok := m.data[k.j<<k.h]; ok {
+			data[k.j<<k.h] = d
+		}
+		for h := 0; h < k.h; h++ {
+			kid := mmrKey{h, k.j<<(k.h-h) + 1}
+			nodes[kid] = m.nodes[kid]
+		}
+	}
+	for _, k := range peaks(m.n) {
+		keep(k, true)
+		if target < k.j<<k.h || target >= (k.j+1)<<k.h {
+			continue
+		}
+		for h := 0; h < k.h; h++ {
+			sib := mmrKey{h, target>>h ^ 1}
+			keep(sib, sib.j%2 == 0)
+		}
+	}
+	m.nodes, m.data = nodes, data
+}
+
+// mmrLeafID returns the ID of leaf i in a range of n leaves, and the peak that
+// holds it.
+func mmrLeafID(n, i int) (NodeID, mmrKey) {
+	for p, k := range peaks(n) {
+		if i >= (k.j+1)<<k.h {
+			continue
+		}
+		id := NodeID{p}
+		for h := k.h - 1; h >= 0; h-- {
+			id = append(id, i>>h&1)
+		}
+		return id, k
+	}
+	return nil, mmrKey{}
+}
+
+// Prove returns an inclusion proof of leaf i in m, which VerifyMMRLeaf checks
+// against Root.
+func (m *MMR) Prove(i int) (*Proof, error) {
+	return m.ProveAt(i, m.n)
+}
+
+// ProveAt is like Prove, but proves leaf i in the range of the first n leaves
+// of m, against the root that m had after appending them, so that clients
+// holding an older root are served proofs they can check.
+func (m *MMR) ProveAt(i, n int) (*Proof, error) {
+	if n < 0 || n > m.n {
+		return nil, errors.New("sakura: range size out of range")
+	}
+	if i < 0 || i >= n {
+		return nil, errors.New("sakura: leaf index out of range")
+	}
+	id, k := mmrLeafID(n, i)
+	if _, err := m.view(k, id[1:]); err != nil {
+		return nil, err
+	}
+	return m.e.Prove(m.bag(n, id), id)
+}
+
+// VerifyMMRLeaf checks that leaf i of a range of n leaves whose root, hashed
+// in mode, is root holds the given message bits, using a proof made by
+// MMR.Prove. The number of leaves must come from the verifier, like the root,
+// since it fixes where each leaf sits. It returns ErrMalformedProof if the
+// proof is not one for leaf i.
+func VerifyMMRLeaf(mode HashingMode, root []byte, n, i int, leaf []byte, proof *Proof) error {
+	if i < 0 || i >= n {
+		return ErrMalformedProof
+	}
+	if id, _ := mmrLeafID(n, i); !proof.Leaf.Equal(id) {
+		return ErrMalformedProof
+	}
+	return VerifyProof(mode, root, proof, leaf)
+}
+
+// MarshalPeaks encodes what is needed to continue appending to m: the number
+// of leaves and, for every peak, its chaining value or, with kangaroo hopping,
+// the bits of its first leaf and the chaining values of the second children
+// on the path down to it, from which its node is coded. ResumeMMR decodes it.
+func (m *MMR) MarshalPeaks() []byte {
+	b := appendModeHeader([]byte{mmrVersion}, m.e.mode.Header())
+	b = binary.AppendUvarint(b, uint64(m.n))
+	for _, k := range peaks(m.n) {
+		if !m.e.mode.Kangaroo {
+			b = append(b, m.nodes[k]...)
+			continue
+		}
+		b = appendBytes(b, m.data[k.j<<k.h])
+		for h := 0; h < k.h; h++ {
+			b = append(b, m.nodes[mmrKey{h, k.j<<(k.h-h) + 1}]...)
+		}
+	}
+	return b
+}
+
+// ResumeMMR returns a range whose nodes are hashed with e, continuing from
+// peaks encoded by MarshalPeaks in the mode of e. Leaves appended to the
+// resumed range can be proven, while proofs of those before fail with
+// ErrPruned unless they only need what the peaks hold.
+func ResumeMMR(e *Encoder, data []byte) (*MMR, error) {
+	m, err := NewMMR(e)
+	if err != nil {
+		return nil, err
+	}
+	d := newDecoder("mmr peaks", data)
+	d.version(mmrVersion)
+	if err := d.checkModeHeader(e.mode); err != nil {
+		return nil, err
+	}
+	size := e.mode.Hash().Size()
+	n := d.int()
+	for _, k := range peaks(n) {
+		if d.err != nil {
+			break
+		}
+		if !e.mode.Kangaroo {
+			m.nodes[k] = d.fixed(size)
+			continue
+		}
+		// The first leaf of every peak has an even index and is nested.
+		m.data[k.j<<k.h] = d.bytes()
+		for h := 0; h < k.h; h++ {
+			m.nodes[mmrKey{h, k.j<<(k.h-h) + 1}] = d.fixed(size)
+		}
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	if err := m.settle(n); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// settle makes m a range of n leaves once what its peaks need is known: the
+// chaining values of the peaks or, with kangaroo hopping, what their nodes
+// are coded from. It hashes the peaks that are nested and the root.
+func (m *MMR) settle(n int) error {
+	if n == 0 {
+		return nil
+	}
+	m.n = n
+	var err error
+	if m.e.mode.Kangaroo {
+		for _, k := range peaks(n) {
+			hop, _ := m.view(k, nil)
+			if m.nodes[k], err = m.e.Inner(hop); err != nil {
+				return err
+			}
+		}
+	}
+	m.root, err = m.e.Final(m.bag(n, nil))
+	return err
+}
@@ -0,0 +1,149 @@
+package sakura_test
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"testing"
+
+	"github.com/chlin501/sakura"
+)
+
+// mmrTree returns fresh hops of the tree that a range of the first n leaves of
+// mmrLeaf hashes: the chaining hop over its peaks, perfect binary trees of the
+// leaves in decreasing order of size.
+func mmrTree(n int) chain {
+	var perfect func(start, size int) sakura.Hop
+	perfect = func(start, size int) sakura.Hop {
+		if size == 1 {
+			return sakura.GatherBytes(mmrLeaf(start))
+		}
+		return chain{perfect(start, size/2), perfect(start+size/2, size/2)}
+	}
+	var peaks chain
+	start := 0
+	for h := bits.Len(uint(n)) - 1; h >= 0; h-- {
+		if n&(1<<h) != 0 {
+			peaks = append(peaks, perfect(start, 1<<h))
+			start += 1 << h
+		}
+	}
+	return peaks
+}
+
+func TestMMRRoot(t *testing.T) {
+	for name, mode := range mmrModes {
+		t.Run(name, func(t *testing.T) {
+			e := sakura.New(mode)
+			_, roots := growMMR(t, mode, 17)
+			empty, err := e.Final(sakura.GatherBytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(roots[0], empty) {
+				t.Error("empty range: root is not that of the empty message")
+			}
+			for n := 1; n <= 17; n++ {
+				want, err := e.Final(mmrTree(n))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(roots[n], want) {
+					t.Fatalf("%d leaves: root differs from that of the tree of peaks", n)
+				}
+				m, _ := growMMR(t, mode, n)
+				if m.Len() != n {
+					t.Fatalf("Len = %d, want %d", m.Len(), n)
+				}
+				got, peaks := m.Peaks(), mmrTree(n)
+				if len(got) != len(peaks) {
+					t.Fatalf("%d leaves: %d peaks, want %d", n, len(got), len(peaks))
+				}
+				for i, p := range peaks {
+					cv, err := e.Inner(p)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got[i], cv) {
+						t.Fatalf("%d leaves: peak %d has another chaining value", n, i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMMRProve(t *testing.T) {
+	const n = 13
+	for name, mode := range mmrModes {
+		t.Run(name, func(t *testing.T) {
+			m, _ := growMMR(t, mode, n)
+			for i := 0; i < n; i++ {
+				p, err := m.Prove(i)
+				if err != nil {
+					t.Fatalf("Prove(%d): %v", i, err)
+				}
+				if err := sakura.VerifyMMRLeaf(mode, m.Root(), n, i, mmrLeaf(i), p); err != nil {
+					t.Fatalf("VerifyMMRLeaf(%d): %v", i, err)
+				}
+				forged := append([]byte("x"), mmrLeaf(i)...)
+				if err := sakura.VerifyMMRLeaf(mode, m.Root(), n, i, forged, p); !errors.Is(err, sakura.ErrProofMismatch) {
+					t.Fatalf("forged leaf %d: got %v, want ErrProofMismatch", i, err)
+				}
+				if err := sakura.VerifyMMRLeaf(mode, m.Root(), n, (i+1)%n, mmrLeaf(i), p); !errors.Is(err, sakura.ErrMalformedProof) {
+					t.Fatalf("leaf %d as another leaf: got %v, want ErrMalformedProof", i, err)
+				}
+			}
+			if _, err := m.Prove(n); err == nil {
+				t.Error("Prove past the last leaf succeeded")
+			}
+		})
+	}
+}
+
+func TestMMRResume(t *testing.T) {
+	for name, mode := range mmrModes {
+		t.Run(name, func(t *testing.T) {
+			short, _ := growMMR(t, mode, 11)
+			full, _ := growMMR(t, mode, 16)
+			b := short.MarshalPeaks()
+			m, err := sakura.ResumeMMR(sakura.New(mode), b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Len() != 11 || !bytes.Equal(m.Root(), short.Root()) {
+				t.Fatal("resumed range differs from the saved one")
+			}
+			for i := 11; i < 16; i++ {
+				if j, err := m.Append(mmrLeaf(i)); err != nil || j != i {
+					t.Fatalf("Append = %d, %v, want %d", j, err, i)
+				}
+			}
+			if !bytes.Equal(m.Root(), full.Root()) {
+				t.Fatal("resumed range has another root")
+			}
+			for i := 11; i < 16; i++ {
+				p, err := m.Prove(i)
+				if err != nil {
+					t.Fatalf("Prove(%d) of an appended leaf: %v", i, err)
+				}
+				if err := sakura.VerifyMMRLeaf(mode, m.Root(), 16, i, mmrLeaf(i), p); err != nil {
+					t.Fatalf("VerifyMMRLeaf(%d): %v", i, err)
+				}
+			}
+			// Leaf 5 needs the pruned subtree of leaf 4, under the first peak.
+			if _, err := m.Prove(5); !errors.Is(err, sakura.ErrPruned) {
+				t.Errorf("Prove of a leaf before the peaks: got %v, want ErrPruned", err)
+			}
+			for n := 0; n < len(b); n++ {
+				if _, err := sakura.ResumeMMR(sakura.New(mode), b[:n]); err == nil {
+					t.Fatalf("ResumeMMR of %d of %d bytes succeeded", n, len(b))
+				}
+			}
+		})
+	}
+	short, _ := growMMR(t, sakura.Mode128(), 5)
+	if _, err := sakura.ResumeMMR(sakura.New(sakura.Mode256()), short.MarshalPeaks()); err == nil {
+		t.Error("ResumeMMR in another mode succeeded")
+	}
+}
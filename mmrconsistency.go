@@ -0,0 +1,278 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// consistencyVersion is the version of the serialized consistency proof.
+const consistencyVersion = 1
+
+// ConsistencyProof proves that a Merkle Mountain Range is a prefix of a longer
+// one: that the later root was reached from the earlier one by appending
+// leaves only. It holds what the peaks of the shorter range are coded from, as
+// MarshalPeaks saves them, from which the verifier rebuilds the earlier root,
+// and the chaining values of the perfect subtrees covering the leaves appended
+// since, which it grafts onto those peaks to rebuild the later root.
+//
+// With kangaroo hopping the bits of the first leaf of every earlier peak are
+// part of the proof, since they are nested in the node of the peak.
+type ConsistencyProof struct {
+	Mode   ModeHeader        // Header of the mode of the ranges.
+	Peaks  []ConsistencyPeak // Peaks of the shorter range, from the left.
+	Blocks [][]byte          // Values of the appended subtrees, from the left.
+}
+
+// ConsistencyPeak describes a peak of the shorter range of a ConsistencyProof.
+// Without kangaroo hopping, Values holds its chaining value and Leaf is nil.
+// With it, Leaf holds the bits of its first leaf and Values the chaining
+// values of the second children on the path down to that leaf, from the
+// bottom up.
+type ConsistencyPeak struct {
+	Leaf   []byte
+	Values [][]byte
+}
+
+// mmrBlocks returns the perfect subtrees that cover the leaves from old up to
+// n of a range, from the left, each the largest whose leaves start where the
+// previous one ends and end by leaf n. Each either completes a subtree with
+// the one before it or is a peak of the range of n leaves.
+func mmrBlocks(old, n int) []mmrKey {
+	var ks []mmrKey
+	for i := old; i < n; {
+		h := 0
+		for i&(1<<h) == 0 && i+2<<h <= n {
+			h++
+		}
+		ks = append(ks, mmrKey{h, i >> h})
+		i += 1 << h
+	}
+	return ks
+}
+
+// ProveConsistency returns a proof that the range of the first old leaves of m
+// is a prefix of the range of its first n leaves, which VerifyMMRConsistency
+// checks against the roots that m had after appending them. It returns
+// ErrPruned if m lacks a value that the proof needs, as when it was resumed
+// from peaks after old leaves.
+func (m *MMR) ProveConsistency(old, n int) (*ConsistencyProof, error) {
+	if old < 0 || old > n || n > m.n {
+		return nil, errors.New("sakura: range size out of range")
+	}
+	p := &ConsistencyProof{Mode: m.e.mode.Header()}
+	if old == 0 {
+		return p, nil
+	}
+	value := func(k mmrKey) ([]byte, error) {
+		cv := m.nodes[k]
+		if cv == nil {
+			return nil, ErrPruned
+		}
+		return cv, nil
+	}
+	for _, k := range peaks(old) {
+		var pk ConsistencyPeak
+		if !m.e.mode.Kangaroo {
+			cv, err := value(k)
+			if err != nil {
+				return nil, err
+			}
+			pk.Values = [][]byte{cv}
+		} else {
+			leaf, ok := m.data[k.j<<k.h]
+			if !ok {
+				return nil, ErrPruned
+			}
+			pk.Leaf = leaf
+			for h := 0; h < k.h; h++ {
+				cv, err := value(mmrKey{h, k.j<<(k.h-h) + 1})
+				if err != nil {
+					return nil, err
+				}
+				pk.Values = append(pk.Values, cv)
+			}
+		}
+		p.Peaks = append(p.Peaks, pk)
+	}
+	for _, k := range mmrBlocks(old, n) {
+		cv, err := value(k)
+		if err != nil {
+			return nil, err
+		}
+		p.Blocks = append(p.Blocks, cv)
+	}
+	return p, nil
+}
+
+// VerifyMMRConsistency checks that the range of old leaves whose root, hashed
+// in mode, is oldRoot is a prefix of the range of n leaves whose root is root,
+// using a proof made by MMR.ProveConsistency. The sizes must come from the
+// verifier, like the roots, since they fix the shape of both ranges. Every
+// range extends the empty one, whose proof is empty, so only oldRoot is
+// checked when old is zero. It returns ErrModeMismatch if the proof was made
+// for another mode, ErrMalformedProof if its shape does not fit the sizes and
+// ErrProofMismatch if it does not lead to both roots.
+func VerifyMMRConsistency(mode HashingMode, oldRoot []byte, old int, root []byte, n int, proof *ConsistencyProof) error {
+	if mode.Hash == nil {
+		return ErrNoHash
+	}
+	if !proof.Mode.Matches(mode) {
+		return ErrModeMismatch
+	}
+	if old < 0 || old > n {
+		return ErrMalformedProof
+	}
+	m, err := NewMMR(New(mode))
+	if err != nil {
+		return err
+	}
+	ps, blocks := peaks(old), mmrBlocks(old, n)
+	if old == 0 {
+		if len(proof.Peaks) > 0 || len(proof.Blocks) > 0 {
+			return ErrMalformedProof
+		}
+		return compareRoots(m.root, oldRoot, ErrProofMismatch)
+	}
+	if len(proof.Peaks) != len(ps) || len(proof.Blocks) != len(blocks) {
+		return ErrMalformedProof
+	}
+	size := mode.Hash().Size()
+	fits := func(values [][]byte) bool {
+		for _, v := range values {
+			if len(v) != size {
+				return false
+			}
+		}
+		return true
+	}
+	if !fits(proof.Blocks) {
+		return ErrMalformedProof
+	}
+	for p, k := range ps {
+		pk := proof.Peaks[p]
+		if !fits(pk.Values) {
+			return ErrMalformedProof
+		}
+		if !mode.Kangaroo {
+			if pk.Leaf != nil || len(pk.Values) != 1 {
+				return ErrMalformedProof
+			}
+			m.nodes[k] = pk.Values[0]
+			continue
+		}
+		if len(pk.Values) != k.h {
+			return ErrMalformedProof
+		}
+		m.data[k.j<<k.h] = pk.Leaf
+		for h, cv := range pk.Values {
+			m.nodes[mmrKey{h, k.j<<(k.h-h) + 1}] = cv
+		}
+	}
+	if err := m.settle(old); err != nil {
+		return err
+	}
+	if err := compareRoots(m.root, oldRoot, ErrProofMismatch); err != nil {
+		return err
+	}
+	for b, k := range blocks {
+		if err := m.graft(k, proof.Blocks[b]); err != nil {
+			return err
+		}
+	}
+	got, err := m.e.Final(m.bag(n, nil))
+	if err != nil {
+		return err
+	}
+	return compareRoots(got, root, ErrProofMismatch)
+}
+
+// graft adds the subtree k with the chaining value cv, and hashes the subtrees
+// it completes, as add does for a leaf.
+func (m *MMR) graft(k mmrKey, cv []byte) error {
+	m.nodes[k] = cv
+	for k.j%2 == 1 {
+		k = mmrKey{k.h + 1, k.j / 2}
+		hop, err := m.view(k, nil)
+		if err != nil {
+			return err
+		}
+		if m.nodes[k], err = m.e.Inner(hop); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes the proof.
+func (p *ConsistencyProof) MarshalBinary() ([]byte, error) {
+	b := appendModeHeader([]byte{consistencyVersion}, p.Mode)
+	b = binary.AppendUvarint(b, uint64(len(p.Peaks)))
+	for _, pk := range p.Peaks {
+		if pk.Leaf == nil {
+			b = append(b, 0)
+		} else {
+			b = appendBytes(append(b, 1), pk.Leaf)
+		}
+		b = binary.AppendUvarint(b, uint64(len(pk.Values)))
+		for _, v := range pk.Values {
+			b = appendBytes(b, v)
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(p.Blocks)))
+	for _, v := range p.Blocks {
+		b = appendBytes(b, v)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary, within
+// DefaultDecodeLimits.
+func (p *ConsistencyProof) UnmarshalBinary(data []byte) error {
+	q, err := DefaultDecodeLimits.UnmarshalConsistencyProof(data)
+	if err != nil {
+		return err
+	}
+	*p = *q
+	return nil
+}
+
+// UnmarshalConsistencyProof decodes a proof encoded by
+// ConsistencyProof.MarshalBinary within the limits l. The values of all peaks
+// and blocks count against MaxNodes. It returns a *DecodeError for malformed
+// input.
+func (l DecodeLimits) UnmarshalConsistencyProof(data []byte) (*ConsistencyProof, error) {
+	d := newDecoder("consistency proof", data)
+	d.limit(l.check("bytes", l.MaxBytes, int64(len(data)), nil))
+	d.version(consistencyVersion)
+	var q ConsistencyProof
+	q.Mode, _ = d.modeHeader()
+	values := 0
+	q.Peaks = make([]ConsistencyPeak, d.count())
+	for k := range q.Peaks {
+		pk := &q.Peaks[k]
+		switch d.byte() {
+		case 0:
+		case 1:
+			if pk.Leaf = d.bytes(); pk.Leaf == nil {
+				pk.Leaf = []byte{}
+			}
+		default:
+			d.fail("invalid leaf flag")
+		}
+		pk.Values = make([][]byte, d.count())
+		values += len(pk.Values)
+		d.limit(l.check("nodes", int64(l.MaxNodes), int64(values), nil))
+		for i := range pk.Values {
+			pk.Values[i] = d.bytes()
+		}
+	}
+	q.Blocks = make([][]byte, d.count())
+	d.limit(l.check("nodes", int64(l.MaxNodes), int64(values+len(q.Blocks)), nil))
+	for i := range q.Blocks {
+		q.Blocks[i] = d.bytes()
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
@@ -0,0 +1,160 @@
+package sakura_test
+
+import (
+	"bytes"
+	"crypto/sha3"
+	"errors"
+	"fmt"
+	"hash"
+	"testing"
+
+	"github.com/chlin501/sakura"
+)
+
+// mmrModes are the modes the range tests run in: with and without kangaroo
+// hopping, which changes what a peak is coded from.
+var mmrModes = map[string]sakura.HashingMode{
+	"Mode128": sakura.Mode128(),
+	"SHA256":  sakura.SHA256Mode(),
+	"flat": {
+		Hash:       func() hash.Hash { return sha3.New256() },
+		Interleave: sakura.NoInterleave,
+	},
+}
+
+// growMMR appends n leaves to a new range and returns it with its root after
+// every size, the empty one first.
+func growMMR(t *testing.T, mode sakura.HashingMode, n int) (*sakura.MMR, [][]byte) {
+	t.Helper()
+	m, err := sakura.NewMMR(sakura.New(mode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := [][]byte{m.Root()}
+	for i := 0; i < n; i++ {
+		if _, err := m.Append(mmrLeaf(i)); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, m.Root())
+	}
+	return m, roots
+}
+
+func mmrLeaf(i int) []byte {
+	if i%5 == 3 {
+		return nil // Empty leaves are leaves too.
+	}
+	return []byte(fmt.Sprintf("leaf %d", i))
+}
+
+func TestMMRConsistency(t *testing.T) {
+	const n = 21
+	for name, mode := range mmrModes {
+		t.Run(name, func(t *testing.T) {
+			m, roots := growMMR(t, mode, n)
+			for old := 0; old <= n; old++ {
+				for size := old; size <= n; size++ {
+					p, err := m.ProveConsistency(old, size)
+					if err != nil {
+						t.Fatalf("ProveConsistency(%d, %d): %v", old, size, err)
+					}
+					b, err := p.MarshalBinary()
+					if err != nil {
+						t.Fatal(err)
+					}
+					var q sakura.ConsistencyProof
+					if err := q.UnmarshalBinary(b); err != nil {
+						t.Fatalf("UnmarshalBinary(%d, %d): %v", old, size, err)
+					}
+					if err := sakura.VerifyMMRConsistency(mode, roots[old], old, roots[size], size, &q); err != nil {
+						t.Fatalf("VerifyMMRConsistency(%d, %d): %v", old, size, err)
+					}
+					if old < size && old > 0 {
+						err := sakura.VerifyMMRConsistency(mode, roots[old], old, roots[size-1], size, &q)
+						if !errors.Is(err, sakura.ErrProofMismatch) {
+							t.Fatalf("wrong new root (%d, %d): got %v", old, size, err)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMMRConsistencyTampered(t *testing.T) {
+	mode := sakura.Mode128()
+	m, roots := growMMR(t, mode, 13)
+	p, err := m.ProveConsistency(5, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sakura.VerifyMMRConsistency(mode, roots[4], 5, roots[13], 13, p); !errors.Is(err, sakura.ErrProofMismatch) {
+		t.Errorf("wrong old root: got %v, want ErrProofMismatch", err)
+	}
+	p.Blocks[0][0] ^= 1
+	if err := sakura.VerifyMMRConsistency(mode, roots[5], 5, roots[13], 13, p); !errors.Is(err, sakura.ErrProofMismatch) {
+		t.Errorf("tampered block: got %v, want ErrProofMismatch", err)
+	}
+	p.Blocks[0][0] ^= 1
+	p.Blocks = p.Blocks[1:]
+	if err := sakura.VerifyMMRConsistency(mode, roots[5], 5, roots[13], 13, p); !errors.Is(err, sakura.ErrMalformedProof) {
+		t.Errorf("missing block: got %v, want ErrMalformedProof", err)
+	}
+	if err := sakura.VerifyMMRConsistency(sakura.Mode256(), roots[5], 5, roots[13], 13, p); !errors.Is(err, sakura.ErrModeMismatch) {
+		t.Errorf("other mode: got %v, want ErrModeMismatch", err)
+	}
+}
+
+func TestMMRProveAt(t *testing.T) {
+	const n = 19
+	for name, mode := range mmrModes {
+		t.Run(name, func(t *testing.T) {
+			m, roots := growMMR(t, mode, n)
+			for size := 1; size <= n; size++ {
+				for i := 0; i < size; i++ {
+					p, err := m.ProveAt(i, size)
+					if err != nil {
+						t.Fatalf("ProveAt(%d, %d): %v", i, size, err)
+					}
+					if err := sakura.VerifyMMRLeaf(mode, roots[size], size, i, mmrLeaf(i), p); err != nil {
+						t.Fatalf("VerifyMMRLeaf(%d, %d): %v", i, size, err)
+					}
+				}
+			}
+			if _, err := m.ProveAt(0, n+1); err == nil {
+				t.Error("ProveAt beyond the range succeeded")
+			}
+		})
+	}
+}
+
+func TestMMRConsistencyResumed(t *testing.T) {
+	for name, mode := range mmrModes {
+		t.Run(name, func(t *testing.T) {
+			full, roots := growMMR(t, mode, 20)
+			short, _ := growMMR(t, mode, 11)
+			m, err := sakura.ResumeMMR(sakura.New(mode), short.MarshalPeaks())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 11; i < 20; i++ {
+				if _, err := m.Append(mmrLeaf(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !bytes.Equal(m.Root(), full.Root()) {
+				t.Fatal("resumed range has another root")
+			}
+			p, err := m.ProveConsistency(11, 20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sakura.VerifyMMRConsistency(mode, roots[11], 11, roots[20], 20, p); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.ProveConsistency(3, 20); !errors.Is(err, sakura.ErrPruned) {
+				t.Errorf("ProveConsistency before the peaks: got %v, want ErrPruned", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// ErrModeMismatch is returned when reading data that was written for a
+// different hashing mode.
+var ErrModeMismatch = errors.New("sakura: data was written for a different hashing mode")
+
+// modeHeaderVersion is the version of the mode header encoding.
+const modeHeaderVersion = 2
+
+// ModeHeader describes the parameters of a hashing mode that serialized
+// artifacts record, so that they cannot be used with another mode.
+type ModeHeader struct {
+	Kangaroo    bool
+	Alignment   uint8
+	Interleave  BlockSize
+	HashSize    int
+	Fingerprint Fingerprint
+}
+
+// Header returns the header of mode. The mode must have a hash function.
+func (mode HashingMode) Header() ModeHeader {
+	h := ModeHeader{
+		Kangaroo:   mode.Kangaroo,
+		Alignment:  mode.Alignment,
+		Interleave: mode.Interleave,
+		HashSize:   mode.Hash().Size(),
+	}
+	var names []byte
+	if mode.Coding != nil {
+		names = append(names, mode.Coding.Name()...)
+	}
+	if mode.Padding != nil && mode.Padding.Name() != "" {
+		names = append(append(names, 0), mode.Padding.Name()...)
+	}
+	h.Fingerprint = h.fingerprint(mode.Hash, names)
+	return h
+}
+
+// Fingerprint is a short identifier of a hashing mode.
+type Fingerprint [8]byte
+
+// String returns the fingerprint in hexadecimal.
+func (f Fingerprint) String() string { return hex.EncodeToString(f[:]) }
+
+// Fingerprint returns the fingerprint of mode, which identifies all of its
+// parameters including the hash function: it is the start of the hash, under
+// the mode's own hash function, of a domain string and the other parameters.
+// Two modes that differ in any parameter have different fingerprints, but for
+// a negligible probability, so checking the fingerprint keeps data made under
+// one mode from being taken for data of another. The mode must have a hash
+// function.
+//
+// Leaf sizes are not part of a mode; artifacts that depend on one, such as
+// Writer checkpoints, record it next to the mode header.
+func (mode HashingMode) Fingerprint() Fingerprint {
+	return mode.Header().Fingerprint
+}
+
+// fingerprint returns the fingerprint of the mode of h, whose hash function is
+// hash, with names naming its coding and padding unless they are the default.
+func (h ModeHeader) fingerprint(hash Hasher, names []byte) Fingerprint {
+	h.Fingerprint = Fingerprint{}
+	x := hash()
+	x.Write([]byte("sakura.mode"))
+	x.Write(appendModeHeader(nil, h))
+	if len(names) > 0 {
+		x.Write(appendBytes(nil, names))
+	}
+	var f Fingerprint
+	copy(f[:], x.Sum(nil))
+	return f
+}
+
+// Matches reports whether h is the header of mode.
+func (h ModeHeader) Matches(mode HashingMode) bool {
+	return mode.Hash != nil && h == mode.Header()
+}
+
+// EncodeModeHeader returns the encoding of the header of mode, with which
+// serialized trees, proofs and checkpoints begin.
+func EncodeModeHeader(mode HashingMode) []byte {
+	return appendModeHeader(nil, mode.Header())
+}
+
+func appendModeHeader(b []byte, h ModeHeader) []byte {
+	var k byte
+	if h.Kangaroo {
+		k = 1
+	}
+	b = append(b, modeHeaderVersion, k, h.Alignment, h.Interleave.Mantissa, h.Interleave.Exponent)
+	b = binary.AppendUvarint(b, uint64(h.HashSize))
+	return append(b, h.Fingerprint[:]...)
+}
+
+// DecodeModeHeader decodes a mode header at the start of data and returns it
+// together with the bytes that follow it. Headers of version 1, which record
+// no fingerprint, are decoded with a zero Fingerprint, which no mode matches.
+func DecodeModeHeader(data []byte) (ModeHeader, []byte, error) {
+	d := newDecoder("mode header", data)
+	h, _, err := d.readModeHeader(1)
+	return h, d.b, err
+}
+
+// modeHeader reads a mode header of the current version.
+func (d *decoder) modeHeader() (ModeHeader, error) {
+	h, _, err := d.readModeHeader(modeHeaderVersion)
+	return h, err
+}
+
+// readModeHeader reads a mode header of version oldest or later, and returns
+// it with its version. Version 1 had no fingerprint.
+func (d *decoder) readModeHeader(oldest byte) (ModeHeader, byte, error) {
+	var h ModeHeader
+	v := d.versions(oldest, modeHeaderVersion)
+	switch d.byte() {
+	case 0:
+	case 1:
+		h.Kangaroo = true
+	default:
+		d.fail("invalid kangaroo flag")
+	}
+	h.Alignment = d.byte()
+	h.Interleave = BlockSize{Mantissa: d.byte(), Exponent: d.byte()}
+	if h.HashSize = d.int(); d.err == nil && h.HashSize == 0 {
+		d.fail("zero hash size")
+	}
+	if v > 1 {
+		for i := range h.Fingerprint {
+			h.Fingerprint[i] = d.byte()
+		}
+	}
+	return h, v, d.err
+}
+
+// checkModeHeader reads a mode header and checks that it is the header of
+// mode. A header of version 1 is only checked against the parameters it
+// records, which identify the hash function by its size alone, so that data
+// written before fingerprints remains readable.
+func (d *decoder) checkModeHeader(mode HashingMode) error {
+	h, v, err := d.readModeHeader(1)
+	if err != nil {
+		return err
+	}
+	if v == 1 && mode.Hash != nil {
+		h.Fingerprint = mode.Header().Fingerprint
+	}
+	if !h.Matches(mode) {
+		return ErrModeMismatch
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package sakura
+
+import (
+	"sync"
+
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+// nestedDomain begins the message of the header leaf of a nested tree, and
+// nestedModeDomain that of a nested tree of a given mode.
+const (
+	nestedDomain     = "sakura.nested"
+	nestedModeDomain = "sakura.nestmode"
+)
+
+// Nested returns a hop that stands for a whole tree with the given root, such
+// as an object within a bucket made of chunks, so that hierarchical systems can
+// compose roots. The hop is a chaining hop over a header leaf, holding a fixed
+// domain string and meta, and a child whose chaining value is root. Since roots
+// are final node hashes and the header leaf is coded first, the root of the
+// outer tree cannot be mistaken for that of a tree over the inner data itself,
+// and meta, such as the name or size of the inner object, is bound to it.
+func Nested(root, meta []byte) Hop {
+	header := append([]byte(nestedDomain), meta...)
+	return nestedHop(header, meta, &storedLeaf{cv: root})
+}
+
+// NestedMode is like Nested for a root computed in mode, which may differ from
+// the mode of the outer tree. The header leaf then also holds the encoded mode
+// header of mode, including its fingerprint, so that a root of one mode cannot
+// be passed off as the root of another: without it, trees of modes that share
+// the size of their roots but code their nodes differently could be nested into
+// the same outer tree with the same root.
+func NestedMode(mode HashingMode, root, meta []byte) Hop {
+	header := append([]byte(nestedModeDomain), EncodeModeHeader(mode)...)
+	header = append(header, meta...)
+	return nestedHop(header, meta, &storedLeaf{cv: root})
+}
+
+// Nest is like NestedMode for the root of hop under e, in the mode of e, which
+// is computed when the outer tree is first hashed and kept. The outer tree may
+// be hashed by an encoder of any mode.
+func (e *Encoder) Nest(hop Hop, meta []byte) Hop {
+	header := append([]byte(nestedModeDomain), EncodeModeHeader(e.mode)...)
+	header = append(header, meta...)
+	return nestedHop(header, meta, &nestedRoot{e: e, hop: hop})
+}
+
+// nestedHop returns the hop of a nested tree whose header leaf begins with
+// header, which ends with meta.
+func nestedHop(header, meta []byte, root Hop) Hop {
+	header = sakuracoding.AppendLengthEncode(header, uint64(len(meta)))
+	return &chainingLeaves{kids: []Hop{messageLeaf(header), root}}
+}
+
+// nestedRoot is a hop whose chaining value is the root of another tree,
+// computed on first use.
+type nestedRoot struct {
+	e    *Encoder
+	hop  Hop
+	once sync.Once
+	root []byte
+	err  error
+}
+
+func (n *nestedRoot) ChainingValueErr() ([]byte, error) {
+	n.once.Do(func() { n.root, n.err = n.e.Final(n.hop) })
+	return n.root, n.err
+}
+
+func (n *nestedRoot) ChainingValue() []byte {
+	cv, _ := n.ChainingValueErr()
+	return cv
+}
+
+func (n *nestedRoot) SetChainingValue([]byte)  {}
+func (n *nestedRoot) Read([]byte) (int, error) { return 0, ErrNoData }
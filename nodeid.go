@@ -0,0 +1,85 @@
+package sakura
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidNodeID is returned by ParseNodeID for malformed strings.
+var ErrInvalidNodeID = errors.New("sakura: invalid node ID")
+
+// NodeID identifies a hop within a tree by the indices of the children leading
+// to it from the root. The root is identified by the empty NodeID.
+//
+// A NodeID depends only on the shape of the tree, so the same ID refers to the
+// same hop in errors, walks, proofs and stored trees.
+type NodeID []int
+
+// Child returns the ID of the i-th child of the hop identified by id. The
+// result never shares its backing array with id.
+func (id NodeID) Child(i int) NodeID {
+	c := make(NodeID, len(id)+1)
+	copy(c, id)
+	c[len(id)] = i
+	return c
+}
+
+// Parent returns the ID of the parent of the hop identified by id, or nil for
+// the root.
+func (id NodeID) Parent() NodeID {
+	if len(id) == 0 {
+		return nil
+	}
+	return id[:len(id)-1]
+}
+
+// Depth returns the distance of the hop from the root.
+func (id NodeID) Depth() int { return len(id) }
+
+// Equal reports whether id and other identify the same hop.
+func (id NodeID) Equal(other NodeID) bool {
+	if len(id) != len(other) {
+		return false
+	}
+	for i := range id {
+		if id[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the ID as a slash-separated path of child indices, such as
+// "/0/3/1". The root is "/".
+func (id NodeID) String() string {
+	if len(id) == 0 {
+		return "/"
+	}
+	var b strings.Builder
+	for _, i := range id {
+		b.WriteByte('/')
+		b.WriteString(strconv.Itoa(i))
+	}
+	return b.String()
+}
+
+// ParseNodeID parses an ID in the format produced by NodeID.String.
+func ParseNodeID(s string) (NodeID, error) {
+	if s == "/" {
+		return NodeID{}, nil
+	}
+	if !strings.HasPrefix(s, "/") {
+		return nil, ErrInvalidNodeID
+	}
+	parts := strings.Split(s[1:], "/")
+	id := make(NodeID, len(parts))
+	for k, p := range parts {
+		i, err := strconv.Atoi(p)
+		if err != nil || i < 0 || strconv.Itoa(i) != p {
+			return nil, ErrInvalidNodeID
+		}
+		id[k] = i
+	}
+	return id, nil
+}
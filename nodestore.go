@@ -0,0 +1,199 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// NodeStore holds the nodes of persistent trees by key, such as in a file
+// system or a key-value database.
+type NodeStore interface {
+	// Get returns the value stored under key, or an error matching
+	// fs.ErrNotExist if there is none.
+	Get(key []byte) ([]byte, error)
+
+	// Put stores value under key. It must not retain value.
+	Put(key, value []byte) error
+}
+
+// MapNodeStore is a NodeStore in memory.
+type MapNodeStore map[string][]byte
+
+// Get implements NodeStore.
+func (m MapNodeStore) Get(key []byte) ([]byte, error) {
+	v, ok := m[string(key)]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return v, nil
+}
+
+// Put implements NodeStore.
+func (m MapNodeStore) Put(key, value []byte) error {
+	m[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+// Kinds of values in a node store.
+const (
+	storeLeaf     = 1 // kind bits
+	storeChaining = 2 // kind count CV*
+	storeVersion  = 3 // kind fanout version leaves [CV]
+)
+
+// Save writes t to store, from which LoadPersistentTree reads it back by its
+// root. Every node is stored under its chaining value, so nodes are stored
+// once by content: the subtrees that a version shares with versions saved
+// before are found in the store and not written again, and storing many
+// versions takes little more than the nodes that changed between them.
+// Children are written before their parent, so a node found in the store
+// always has its whole subtree there.
+func (t *PersistentTree) Save(store NodeStore) error {
+	if t.top != nil {
+		if err := saveNode(store, t.top); err != nil {
+			return err
+		}
+	}
+	b := binary.AppendUvarint([]byte{storeVersion}, uint64(t.fanout))
+	b = binary.AppendUvarint(b, uint64(t.version))
+	b = binary.AppendUvarint(b, uint64(t.n))
+	if t.top != nil {
+		b = append(b, t.top.cv...)
+	}
+	return store.Put(t.root, b)
+}
+
+// saveNode writes p and its subtree to store, unless p is there already.
+func saveNode(store NodeStore, p *pnode) error {
+	if _, err := store.Get(p.cv); err == nil {
+		return nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var b []byte
+	if p.kids == nil {
+		b = append([]byte{storeLeaf}, p.data...)
+	} else {
+		b = binary.AppendUvarint([]byte{storeChaining}, uint64(len(p.kids)))
+		for _, kid := range p.kids {
+			if err := saveNode(store, kid); err != nil {
+				return err
+			}
+			b = append(b, kid.cv...)
+		}
+	}
+	return store.Put(p.cv, b)
+}
+
+// LoadPersistentTree reads the version of a tree with the given root saved to
+// store by PersistentTree.Save, with nodes hashed by e. Every node is checked
+// against its key and the shape of the tree against the saved number of
+// leaves, so that a damaged or forged store fails with a *DecodeError or
+// ErrRootMismatch instead of yielding a tree with a different root.
+func LoadPersistentTree(e *Encoder, store NodeStore, root []byte) (*PersistentTree, error) {
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	data, err := store.Get(root)
+	if err != nil {
+		return nil, err
+	}
+	d := newDecoder("node store", data)
+	if d.byte() != storeVersion && d.err == nil {
+		d.fail("not a tree version")
+	}
+	t := &PersistentTree{e: e, fanout: d.int(), version: d.int(), n: d.int()}
+	var top []byte
+	if t.n > 0 {
+		top = d.b
+		d.b = nil
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	if t.fanout < 2 {
+		return nil, &DecodeError{Format: "node store", Offset: 1, Reason: "fanout less than 2"}
+	}
+	if t.n == 0 {
+		t.root, err = e.Final(messageLeaf(nil))
+	} else {
+		for span := 1; span < t.n; t.height++ {
+			if span > t.n/t.fanout {
+				t.height++ // The next span exceeds t.n, and might overflow.
+				break
+			}
+			span *= t.fanout
+		}
+		var n int
+		if t.top, n, err = t.load(store, top, t.height); err != nil {
+			return nil, err
+		}
+		if n != t.n {
+			return nil, &DecodeError{Format: "node store", Reason: fmt.Sprintf("tree holds %d leaves, not %d", n, t.n)}
+		}
+		t.root, err = e.Final(t.view(t.top, nil))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := compareRoots(t.root, root, ErrRootMismatch); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// load reads the node of height h stored under key and returns it with the
+// number of leaves below it. All children of a node but the last must be full.
+func (t *PersistentTree) load(store NodeStore, key []byte, h int) (*pnode, int, error) {
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	d := newDecoder("node store", data)
+	p := &pnode{}
+	n := 1
+	switch kind := d.byte(); {
+	case d.err != nil:
+	case kind == storeLeaf && h == 0:
+		p.data = append([]byte{}, d.b...)
+		d.b = nil
+	case kind == storeChaining && h > 0:
+		count := d.int()
+		if d.err == nil && (count == 0 || count > t.fanout) {
+			d.fail("degree out of range")
+		}
+		size := t.e.mode.Hash().Size()
+		n = 0
+		for i := 0; i < count && d.err == nil; i++ {
+			if len(d.b) < size {
+				d.fail("truncated chaining value")
+				break
+			}
+			k := d.b[:size]
+			d.b = d.b[size:]
+			kid, m, err := t.load(store, k, h-1)
+			if err != nil {
+				return nil, 0, err
+			}
+			if i < count-1 && m != t.span(h-1) {
+				return nil, 0, &DecodeError{Format: "node store", Reason: "inner subtree is not full"}
+			}
+			p.kids = append(p.kids, kid)
+			n += m
+		}
+	default:
+		d.fail(fmt.Sprintf("unexpected node kind %d at height %d", kind, h))
+	}
+	if err := d.end(); err != nil {
+		return nil, 0, err
+	}
+	if p.cv, err = t.e.Inner(t.view(p, nil)); err != nil {
+		return nil, 0, err
+	}
+	if err := compareRoots(p.cv, key, ErrRootMismatch); err != nil {
+		return nil, 0, err
+	}
+	return p, n, nil
+}
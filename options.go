@@ -0,0 +1,264 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+)
+
+// An Option configures an Encoder created by NewEncoder.
+type Option func(*Encoder) error
+
+// NewEncoder returns a new encoder configured by the given options, applied in
+// order. Unlike New, it validates the resulting configuration and returns an
+// error if it is incomplete or inconsistent, so that a misconfigured encoder is
+// caught at construction rather than on first use.
+func NewEncoder(opts ...Option) (*Encoder, error) {
+	e := &Encoder{}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.validate(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// validate checks the configuration of an encoder built from options.
+func (e *Encoder) validate() error {
+	switch {
+	case e.mode.Hash == nil:
+		return ErrNoHash
+	case e.mode.Alignment > 1 && !e.mode.Kangaroo:
+		return errors.New("sakura: alignment has no effect without kangaroo hopping")
+	case e.Parallelism < 0 || e.MaxBufferedBytes < 0 || e.BytesPerSecond < 0 ||
+		e.MaxDepth < 0 || e.MaxDegree < 0 || e.AcceleratorBatch < 0 ||
+		e.MaxLeaves < 0 || e.MaxBytes < 0 || e.MaxProofBytes < 0 || e.MaxNodeBytes < 0 ||
+		e.Retry.MaxRetries < 0 || e.Retry.Backoff < 0 || e.Retry.MaxBackoff < 0:
+		return errors.New("sakura: negative encoder limit")
+	case e.VerifyParallel && e.Parallelism < 2:
+		return errors.New("sakura: parallel verification requires parallelism")
+	}
+	if e.Strict {
+		if err := CheckStrict(e.mode); err != nil {
+			return err
+		}
+		if err := CheckHasher(e.mode); err != nil {
+			return err
+		}
+	}
+	e.warn(Template{})
+	return nil
+}
+
+// WithMode sets all parameters of the hashing mode at once.
+func WithMode(mode HashingMode) Option {
+	return func(e *Encoder) error {
+		e.mode = mode
+		return nil
+	}
+}
+
+// WithHasher sets the hash function of the hashing mode.
+func WithHasher(h Hasher) Option {
+	return func(e *Encoder) error {
+		e.mode.Hash = h
+		return nil
+	}
+}
+
+// WithKangaroo enables kangaroo hopping in the hashing mode.
+func WithKangaroo() Option {
+	return func(e *Encoder) error {
+		e.mode.Kangaroo = true
+		return nil
+	}
+}
+
+// WithAlignment sets the number of bytes that nodes are aligned to. It
+// requires kangaroo hopping.
+func WithAlignment(n uint8) Option {
+	return func(e *Encoder) error {
+		e.mode.Alignment = n
+		return nil
+	}
+}
+
+// WithInterleave sets the interleaving block size of the hashing mode.
+func WithInterleave(bs BlockSize) Option {
+	return func(e *Encoder) error {
+		e.mode.Interleave = bs
+		return nil
+	}
+}
+
+// WithInterleaveBytes sets the interleaving block size of the hashing mode to
+// the representable size nearest to n bytes, as chosen by NearestBlockSize. It
+// fails if n is not positive.
+func WithInterleaveBytes(n int) Option {
+	return func(e *Encoder) error {
+		if n <= 0 {
+			return errors.New("sakura: non-positive interleaving block size")
+		}
+		e.mode.Interleave = NearestBlockSize(n)
+		return nil
+	}
+}
+
+// WithParallelism sets Encoder.Parallelism.
+func WithParallelism(n int) Option {
+	return func(e *Encoder) error {
+		e.Parallelism = n
+		return nil
+	}
+}
+
+// WithSchedule sets Encoder.Schedule.
+func WithSchedule(s Schedule) Option {
+	return func(e *Encoder) error {
+		if s > ScheduleLatency {
+			return errors.New("sakura: unknown schedule " + s.String())
+		}
+		e.Schedule = s
+		return nil
+	}
+}
+
+// WithVerifyParallel sets Encoder.VerifyParallel. It requires parallelism.
+func WithVerifyParallel() Option {
+	return func(e *Encoder) error {
+		e.VerifyParallel = true
+		return nil
+	}
+}
+
+// WithMaxBufferedBytes sets Encoder.MaxBufferedBytes.
+func WithMaxBufferedBytes(n int) Option {
+	return func(e *Encoder) error {
+		e.MaxBufferedBytes = n
+		return nil
+	}
+}
+
+// WithBytesPerSecond sets Encoder.BytesPerSecond.
+func WithBytesPerSecond(n int) Option {
+	return func(e *Encoder) error {
+		e.BytesPerSecond = n
+		return nil
+	}
+}
+
+// WithRetry sets Encoder.Retry.
+func WithRetry(p RetryPolicy) Option {
+	return func(e *Encoder) error {
+		e.Retry = p
+		return nil
+	}
+}
+
+// WithLimits sets Encoder.MaxDepth and Encoder.MaxDegree.
+func WithLimits(depth, degree int) Option {
+	return func(e *Encoder) error {
+		e.MaxDepth, e.MaxDegree = depth, degree
+		return nil
+	}
+}
+
+// WithQuotas sets Encoder.MaxLeaves, Encoder.MaxBytes and
+// Encoder.MaxProofBytes.
+func WithQuotas(leaves int, bytes int64, proofBytes int) Option {
+	return func(e *Encoder) error {
+		e.MaxLeaves, e.MaxBytes, e.MaxProofBytes = leaves, bytes, proofBytes
+		return nil
+	}
+}
+
+// WithMaxNodeBytes sets Encoder.MaxNodeBytes.
+func WithMaxNodeBytes(n int64) Option {
+	return func(e *Encoder) error {
+		e.MaxNodeBytes = n
+		return nil
+	}
+}
+
+// WithBufferPool sets Encoder.Buffers.
+func WithBufferPool(p *BufferPool) Option {
+	return func(e *Encoder) error {
+		e.Buffers = p
+		return nil
+	}
+}
+
+// WithAccelerator sets Encoder.Accelerator and Encoder.AcceleratorBatch.
+func WithAccelerator(a Accelerator, batch int) Option {
+	return func(e *Encoder) error {
+		e.Accelerator, e.AcceleratorBatch = a, batch
+		return nil
+	}
+}
+
+// WithAudit sets Encoder.Audit and Encoder.AuditInputs.
+func WithAudit(w io.Writer, inputs bool) Option {
+	return func(e *Encoder) error {
+		e.Audit, e.AuditInputs = w, inputs
+		return nil
+	}
+}
+
+// WithMetrics sets Encoder.Metrics.
+func WithMetrics(m Metrics) Option {
+	return func(e *Encoder) error {
+		e.Metrics = m
+		return nil
+	}
+}
+
+// WithHooks sets Encoder.Hooks.
+func WithHooks(h NodeHooks) Option {
+	return func(e *Encoder) error {
+		e.Hooks = h
+		return nil
+	}
+}
+
+// WithValues sets Encoder.Values.
+func WithValues(ch chan<- CompletedSubtree) Option {
+	return func(e *Encoder) error {
+		e.Values = ch
+		return nil
+	}
+}
+
+// WithStrict sets Encoder.Strict.
+func WithStrict() Option {
+	return func(e *Encoder) error {
+		e.Strict = true
+		return nil
+	}
+}
+
+// WithWarn sets Encoder.Warn.
+func WithWarn(fn func(Warning)) Option {
+	return func(e *Encoder) error {
+		e.Warn = fn
+		return nil
+	}
+}
+
+// WithTracer sets Encoder.Tracer.
+func WithTracer(t Tracer) Option {
+	return func(e *Encoder) error {
+		e.Tracer = t
+		return nil
+	}
+}
+
+// WithLogger sets Encoder.Logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(e *Encoder) error {
+		e.Logger = l
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package sakura
+
+import (
+	"errors"
+	"hash"
+)
+
+// ErrNoBitHash is returned when a mode with HashPadding has a hash function
+// that does not implement BitHash.
+var ErrNoBitHash = errors.New("sakura: hash function does not absorb bits")
+
+// Padding turns the bit string of a node into the bytes given to the hash
+// function at the node boundary, since a hash.Hash only absorbs whole bytes.
+// Different primitives need different treatment there, so the step is part of
+// the mode, SimplePadding if the Padding of the mode is nil.
+//
+// Pad is called once per node, after all whole bytes of the node have been
+// written to h, with the n < 8 remaining bits held in the low bits of bits in
+// the Keccak convention. The padding must keep the mapping from bit strings to
+// hash inputs injective. Name identifies the padding in the fingerprint of the
+// mode like Coding.Name, and is empty only for SimplePadding.
+type Padding interface {
+	Pad(h hash.Hash, bits byte, n int)
+	Name() string
+}
+
+// BitHash is implemented by hash functions that absorb a bit string whose
+// length is not a multiple of eight and pad it themselves, such as the
+// delimited suffix of Keccak-based functions.
+type BitHash interface {
+	hash.Hash
+
+	// WriteBits absorbs the n < 8 low bits of bits, in the Keccak
+	// convention, as the end of the input.
+	WriteBits(bits byte, n int)
+}
+
+// SimplePadding appends '1' and as many zeros as needed to reach a byte
+// boundary, which is how KangarooTwelve hands its frame bits to Keccak as a
+// delimited suffix.
+type SimplePadding struct{}
+
+// Pad implements Padding.
+func (SimplePadding) Pad(h hash.Hash, bits byte, n int) {
+	h.Write([]byte{bits | 1<<n})
+}
+
+// Name returns the empty string, which leaves the fingerprints of modes as
+// they are without a Padding.
+func (SimplePadding) Name() string { return "" }
+
+// MultiRatePadding appends the pad10*1 rule of the sponge construction, '1',
+// zeros and '1', up to the next byte boundary, which takes another byte if
+// only one bit is left in the last.
+type MultiRatePadding struct{}
+
+// Pad implements Padding.
+func (MultiRatePadding) Pad(h hash.Hash, bits byte, n int) {
+	bits |= 1 << n
+	if n == 7 {
+		h.Write([]byte{bits, 0x80})
+		return
+	}
+	h.Write([]byte{bits | 0x80})
+}
+
+// Name implements Padding.
+func (MultiRatePadding) Name() string { return "sakura.pad10*1" }
+
+// HashPadding adds no padding and hands the remaining bits to the hash
+// function, which must implement BitHash and pad its input itself.
+type HashPadding struct{}
+
+// Pad implements Padding.
+func (HashPadding) Pad(h hash.Hash, bits byte, n int) {
+	h.(BitHash).WriteBits(bits, n)
+}
+
+// Name implements Padding.
+func (HashPadding) Name() string { return "sakura.hashpad" }
+
+// WriteBits passes the bits to the underlying hash, which must implement
+// BitHash, and copies the bits with simple padding to w.
+func (t *teeHash) WriteBits(bits byte, n int) {
+	t.w.Write([]byte{bits | 1<<n})
+	t.Hash.(BitHash).WriteBits(bits, n)
+}
@@ -0,0 +1,281 @@
+package sakura
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PageLayout is the shape of a PageTree: Pages pages of PageSize bytes each,
+// under a complete tree of chaining hops with up to Fanout children. It is all
+// that a verifier needs to know of the tree besides its mode and root.
+type PageLayout struct {
+	Pages    int
+	PageSize int
+	Fanout   int
+}
+
+func (l PageLayout) validate() error {
+	if l.Pages < 1 || l.PageSize < 1 || l.Fanout < 2 {
+		return fmt.Errorf("sakura: invalid page layout %d×%d with fanout %d", l.Pages, l.PageSize, l.Fanout)
+	}
+	return nil
+}
+
+// height returns the number of edges from the root to the pages.
+func (l PageLayout) height() int { return completeHeight(l.Pages, l.Fanout) }
+
+// PageID returns the ID of the hop of page i, which is a message hop if the
+// page is present and a Hole otherwise.
+func (l PageLayout) PageID(i int) NodeID { return completeID(l.Pages, l.Fanout, i) }
+
+// completeHeight returns the height of the complete tree of the given fanout
+// over n leaves, filled from the left as a PersistentTree is: the least that
+// holds all leaves.
+func completeHeight(n, fanout int) int {
+	h := 0
+	for ; n > 1; n = (n + fanout - 1) / fanout {
+		h++
+	}
+	return h
+}
+
+// completeID returns the ID of leaf i in the complete tree of the given
+// fanout over n leaves.
+func completeID(n, fanout, i int) NodeID {
+	id := make(NodeID, completeHeight(n, fanout))
+	for k := len(id) - 1; k >= 0; k-- {
+		id[k], i = i%fanout, i/fanout
+	}
+	return id
+}
+
+// VerifyPage checks that page i of the tree with the given root, hashed in
+// mode, holds data, or is absent if data is nil, given a proof returned by
+// PageTree.ProvePage. It returns ErrMalformedProof if the proof is not one for
+// page i in the layout l.
+func (l PageLayout) VerifyPage(mode HashingMode, root []byte, i int, data []byte, proof *Proof) error {
+	if err := l.validate(); err != nil {
+		return err
+	}
+	if i < 0 || i >= l.Pages {
+		return errPageIndex
+	}
+	id, leaf := l.PageID(i), data
+	switch {
+	case data == nil:
+		id, leaf = id.Child(0), holeMessage(int64(l.PageSize))
+	case len(data) != l.PageSize:
+		return errPageLength
+	}
+	if !proof.Leaf.Equal(id) {
+		return ErrMalformedProof
+	}
+	return VerifyProof(mode, root, proof, leaf)
+}
+
+var (
+	errPageIndex  = errors.New("sakura: page index out of range")
+	errPageLength = errors.New("sakura: data does not have the length of a page")
+)
+
+// PageTree is a hashed tree over a fixed number of fixed-size pages addressed
+// by page number, such as the blocks of a device or the pages of a database
+// file. Pages start out absent, coded as a Hole of the page size, and are set
+// or made absent again in any order, each update hashing only the nodes on
+// the path from the page to the root. Once every page is present, the root is
+// that of a PersistentTree of the same fanout holding the pages in order.
+//
+// The tree keeps the chaining values of its nodes but not the pages, except
+// for those whose bits are nested in the node of their parent: with kangaroo
+// hopping, the first page of every chaining hop, which is one page in Fanout,
+// and the page of a tree of a single page.
+type PageTree struct {
+	e      *Encoder
+	l      PageLayout
+	levels [][][]byte     // Chaining values of the nodes, level by level from the pages up; nil for absent pages.
+	data   map[int][]byte // Bits of the present pages that are nested in their parents' nodes.
+	hole   []byte         // Chaining value of an absent page.
+	root   []byte
+}
+
+// NewPageTree returns a tree of absent pages in the layout l, whose nodes are
+// hashed with e.
+func NewPageTree(e *Encoder, l PageLayout) (*PageTree, error) {
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	t := &PageTree{e: e, l: l, data: make(map[int][]byte)}
+	var err error
+	if t.hole, err = e.Inner(Hole(int64(l.PageSize))); err != nil {
+		return nil, err
+	}
+	t.levels = [][][]byte{make([][]byte, l.Pages)}
+	for h := 1; h <= l.height(); h++ {
+		n := (len(t.levels[h-1]) + l.Fanout - 1) / l.Fanout
+		t.levels = append(t.levels, make([][]byte, n))
+		if h == l.height() {
+			break
+		}
+		// All nodes of a level but the last are alike while the pages are
+		// absent, so only the first and the last need hashing.
+		for _, j := range []int{0, n - 1} {
+			if t.levels[h][j], err = e.Inner(t.view(h, j, nil)); err != nil {
+				return nil, err
+			}
+		}
+		for j := 1; j < n-1; j++ {
+			t.levels[h][j] = t.levels[h][0]
+		}
+	}
+	if t.root, err = e.Final(t.view(l.height(), 0, nil)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Layout returns the layout of t.
+func (t *PageTree) Layout() PageLayout { return t.l }
+
+// Root returns the root of t.
+func (t *PageTree) Root() []byte { return t.root }
+
+// Present reports whether page i is present.
+func (t *PageTree) Present(i int) bool {
+	return i >= 0 && i < t.l.Pages && t.levels[0][i] != nil
+}
+
+// nested reports whether the bits of page i are nested in its parent's node.
+func (t *PageTree) nested(i int) bool {
+	return t.l.Pages == 1 || t.e.mode.Kangaroo && i%t.l.Fanout == 0
+}
+
+// UpdatePage sets page i to data, which must have the length of a page, or
+// makes it absent if data is nil, and rehashes the path to the root. If
+// hashing fails, t is left as it was.
+func (t *PageTree) UpdatePage(i int, data []byte) error {
+	if i < 0 || i >= t.l.Pages {
+		return errPageIndex
+	}
+	if data != nil && len(data) != t.l.PageSize {
+		return errPageLength
+	}
+	var cv []byte
+	if data != nil {
+		var err error
+		if cv, err = t.e.Inner(messageLeaf(data)); err != nil {
+			return err
+		}
+	}
+	oldCV, oldData := t.levels[0][i], t.data[i]
+	old := make([][]byte, len(t.levels))
+	t.levels[0][i] = cv
+	t.setData(i, data)
+	err := t.rehash(i, old)
+	if err != nil {
+		t.levels[0][i] = oldCV
+		t.setData(i, oldData)
+		for h, cv := range old {
+			if cv != nil {
+				t.levels[h][i/t.span(h)] = cv
+			}
+		}
+	}
+	return err
+}
+
+// setData keeps a copy of the bits of page i if they are nested.
+func (t *PageTree) setData(i int, data []byte) {
+	switch {
+	case !t.nested(i):
+	case data == nil:
+		delete(t.data, i)
+	default:
+		t.data[i] = append([]byte{}, data...)
+	}
+}
+
+// span returns the number of pages under a full node at level h.
+func (t *PageTree) span(h int) int {
+	n := 1
+	for ; h > 0; h-- {
+		n *= t.l.Fanout
+	}
+	return n
+}
+
+// rehash hashes the nodes above page i and the root, saving the values they
+// replace in old.
+func (t *PageTree) rehash(i int, old [][]byte) error {
+	top := len(t.levels) - 1
+	for h := 1; h < top; h++ {
+		j := i / t.span(h)
+		cv, err := t.e.Inner(t.view(h, j, nil))
+		if err != nil {
+			return err
+		}
+		old[h], t.levels[h][j] = t.levels[h][j], cv
+	}
+	root, err := t.e.Final(t.view(top, 0, nil))
+	if err != nil {
+		return err
+	}
+	t.root = root
+	return nil
+}
+
+// ProvePage returns an inclusion proof of page i in t, which VerifyPage checks
+// against Root with the data of the page, or with nil if it is absent. The
+// proof of a present page does not hold the page, so the tree need not have
+// it.
+func (t *PageTree) ProvePage(i int) (*Proof, error) {
+	if i < 0 || i >= t.l.Pages {
+		return nil, errPageIndex
+	}
+	id := t.l.PageID(i)
+	if t.levels[0][i] == nil {
+		id = id.Child(0)
+	}
+	return t.e.Prove(t.view(len(t.levels)-1, 0, id), id)
+}
+
+// VerifyPage checks that page i of t holds data, or is absent if data is nil,
+// as PageLayout.VerifyPage does with the layout, mode and current root of t.
+func (t *PageTree) VerifyPage(i int, data []byte, proof *Proof) error {
+	return t.l.VerifyPage(t.e.mode, t.root, i, data, proof)
+}
+
+// view returns a hop for hashing or proving node j at level h. The nodes that
+// are nested in their parents' nodes under kangaroo hopping and those on path
+// are expanded, while all others are given by their chaining value. path is
+// nil for nodes off the path, and the page at its end is left empty, since
+// Prove only reads the proven leaf.
+func (t *PageTree) view(h, j int, path NodeID) Hop {
+	if h == 0 {
+		switch {
+		case t.levels[0][j] == nil:
+			return Hole(int64(t.l.PageSize))
+		case path != nil:
+			return messageLeaf(nil)
+		}
+		return messageLeaf(t.data[j])
+	}
+	below := t.levels[h-1]
+	c := &chainingLeaves{}
+	for k := j * t.l.Fanout; k < min((j+1)*t.l.Fanout, len(below)); k++ {
+		i := k - j*t.l.Fanout
+		switch {
+		case len(path) > 0 && path[0] == i:
+			c.kids = append(c.kids, t.view(h-1, k, path[1:]))
+		case i == 0 && t.e.mode.Kangaroo:
+			c.kids = append(c.kids, t.view(h-1, k, nil))
+		case below[k] == nil:
+			c.kids = append(c.kids, &storedLeaf{cv: t.hole})
+		default:
+			c.kids = append(c.kids, &storedLeaf{cv: below[k]})
+		}
+	}
+	return c
+}
@@ -0,0 +1,507 @@
+package sakura
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrNondeterministic is returned when Encoder.VerifyParallel is set and
+	// the parallel and serial encodings of a tree differ.
+	ErrNondeterministic = errors.New("sakura: parallel and serial hashes differ")
+
+	// ErrNotSeekable is returned when Encoder.VerifyParallel is set and a
+	// message hop cannot be read a second time.
+	ErrNotSeekable = errors.New("sakura: message hop does not implement io.Seeker")
+)
+
+// defaultBufferSize is the size of the buffer used to read a message hop.
+const defaultBufferSize = 32 << 10
+
+// job holds the state of a single call to Final or Inner.
+type job struct {
+	e      *Encoder
+	ctx    context.Context // Set by FinalContext and InnerContext.
+	mode   HashingMode
+	budget *budget
+	limit  *limiter
+	pause  *pauseGate      // Set for the leaves of a Writer.
+	done   <-chan struct{} // Closed when a parallel job fails or the context is done.
+	leaf   int             // Index of the next message hop in a serial job.
+	path   ancestors       // Ancestors of the hop visited by a serial job.
+	trace  tracer
+	cvs    *arena     // Source of the chaining values computed by the job.
+	out    []byte     // Buffer to append the root to, for AppendFinal.
+	layer  *leafLayer // Set by FinalLayer.
+
+	partial *partialSet // Values of the inner nodes hashed, for PartialResults.
+
+	// Message hops read by the job and bytes read from them, for the
+	// MaxLeaves and MaxBytes of the encoder.
+	leaves, bytes atomic.Int64
+
+	cvSize int // Size of a chaining value, if the encoder sets MaxNodeBytes.
+
+	// Set by a Plan: precomputed chaining hop trailers by number of values,
+	// which must not be modified, and the preferred read buffer size.
+	trailers map[int][]byte
+	readSize int
+
+	auditMu sync.Mutex // Serializes writes to Encoder.Audit.
+
+	// Children read from the ChildStream hops of the job.
+	streamMu sync.Mutex
+	streams  map[ChildStream]*streamedHop
+}
+
+func newJob(e *Encoder) *job {
+	j := &job{e: e, mode: e.mode, path: make(ancestors), cvs: new(arena)}
+	if e.MaxBufferedBytes > 0 {
+		j.budget = &budget{max: e.MaxBufferedBytes}
+		j.budget.cond.L = &j.budget.mu
+	}
+	if e.BytesPerSecond > 0 {
+		j.limit = &limiter{rate: float64(e.BytesPerSecond)}
+	}
+	if e.MaxNodeBytes > 0 && e.mode.Hash != nil {
+		j.cvSize = e.mode.Hash().Size()
+	}
+	return j
+}
+
+// bufferSize returns the size of the buffer used to read a message hop.
+func (j *job) bufferSize() int {
+	n := defaultBufferSize
+	if b := j.e.Buffers; b != nil && b.bufferSize > 0 {
+		n = b.bufferSize
+	}
+	if j.readSize > 0 {
+		n = j.readSize
+	}
+	if j.budget != nil && j.budget.max < n {
+		n = j.budget.max
+	}
+	if j.limit != nil && int(j.limit.rate) < n {
+		// Smaller reads keep slow rates from being exceeded in bursts.
+		n = max(int(j.limit.rate), 1)
+	}
+	return n
+}
+
+// run encodes hop, serially or in parallel as configured.
+func (j *job) run(hop Hop, final bool) ([]byte, error) {
+	if j.e.Parallelism < 2 {
+		return j.serial(hop, NodeID{}, final, 0)
+	}
+	return j.parallel(hop, final)
+}
+
+// serial hashes hop on the calling goroutine. Inner nodes that report a cached
+// chaining value are not hashed again.
+func (j *job) serial(hop Hop, id NodeID, final bool, level int) ([]byte, error) {
+	if !final {
+		if cv, err := cachedValue(hop, id); cv != nil || err != nil {
+			return cv, err
+		}
+	}
+	select {
+	case <-j.done:
+		return nil, errCanceled
+	default:
+	}
+	if err := j.path.enter(hop, id); err != nil {
+		return nil, err
+	}
+	defer j.path.exit(hop)
+	sum, err := j.hashNode(node{
+		hop:   hop,
+		final: final,
+		id:    id,
+		level: level,
+		leaf:  &j.leaf,
+		path:  j.path,
+		cv: func(child Hop, id NodeID, _ int) ([]byte, error) {
+			return j.serial(child, id, false, level+1)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !final {
+		hop.SetChainingValue(sum)
+		if err := j.completed(id, sum); err != nil {
+			return nil, err
+		}
+	}
+	return sum, nil
+}
+
+// task is a node of the tree that is hashed by the parallel scheduler.
+type task struct {
+	hop     Hop
+	id      NodeID
+	final   bool
+	parent  *task
+	slot    int      // Index of this task's value in parent.cvs.
+	cvs     [][]byte // Chaining values coded in this task's node.
+	kids    []*task  // Tasks that compute cvs, or nil where a value was cached.
+	pending int32    // Number of chaining values not yet computed.
+	leaf    int      // Tree order index of the message hop in this task's node.
+	level   int      // Distance from the root node.
+	asked   bool     // Whether the Before hook declined to supply the hash.
+	// Priority of the task, and its position among the tasks queued, by
+	// which the queue orders tasks of equal priority.
+	priority int
+	seq      int
+}
+
+// node returns the node hashed by t, with chaining values taken from cv.
+func (t *task) node(cv cvFunc) node {
+	leaf := t.leaf
+	return node{hop: t.hop, final: t.final, id: t.id, level: t.level, leaf: &leaf, cv: cv, asked: t.asked}
+}
+
+// ask asks the Before hook for the hash of the node of t, completing t with
+// it if supplied, and otherwise marks t as asked, so that its worker does not
+// ask again.
+func (j *job) ask(t *task) ([]byte, error) {
+	if j.e.Hooks.Before == nil {
+		return nil, nil
+	}
+	n := t.node(nil)
+	cv := j.before(n, nodeInfo(n))
+	if cv == nil {
+		t.asked = true
+		return nil, nil
+	}
+	if t.final {
+		return cv, nil
+	}
+	t.hop.SetChainingValue(cv)
+	return cv, j.completed(t.id, cv)
+}
+
+// parallel encodes hop using a pool of Parallelism workers.
+//
+// The calling goroutine walks the tree and hands every node whose chaining
+// values are all known to the pool, which takes them by priority and then in
+// the order of the encoder's Schedule. A worker that completes the last
+// missing value of a parent goes on to hash the parent itself, so workers
+// never wait on one another and the result does not depend on the order of
+// completion.
+//
+// The first error cancels the job: queued nodes are dropped, message hops being
+// read are abandoned and the error is returned once the workers have stopped.
+func (j *job) parallel(hop Hop, final bool) ([]byte, error) {
+	if !final {
+		if cv, err := cachedValue(hop, NodeID{}); cv != nil || err != nil {
+			return cv, err
+		}
+	}
+
+	var (
+		ready = newReadyQueue(j.e.Schedule)
+		done  = make(chan struct{})
+		leaf  int
+		once  sync.Once
+		first error
+		root  []byte
+		wg    sync.WaitGroup
+	)
+	j.done = done
+	fail := func(err error) {
+		once.Do(func() {
+			first = err
+			close(done)
+		})
+	}
+	if j.ctx != nil {
+		stop := context.AfterFunc(j.ctx, func() { fail(j.ctx.Err()) })
+		defer stop()
+	}
+
+	log := j.e.Logger
+	for i := 0; i < j.e.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if log != nil {
+				log.Debug("sakura: worker started", "worker", i)
+				defer log.Debug("sakura: worker stopped", "worker", i)
+			}
+			for t := ready.pop(); t != nil; t = ready.pop() {
+				for t != nil {
+					select {
+					case <-done:
+						t = nil
+						continue
+					default:
+					}
+					cv, err := j.hashNode(t.node(func(_ Hop, _ NodeID, slot int) ([]byte, error) {
+						return t.cvs[slot], nil
+					}))
+					if err != nil {
+						fail(err)
+						break
+					}
+					if t.final {
+						root = cv
+						break
+					}
+					t.hop.SetChainingValue(cv)
+					if err := j.completed(t.id, cv); err != nil {
+						fail(err)
+						break
+					}
+					if t.parent == nil {
+						root = cv
+						break
+					}
+					t.parent.cvs[t.slot] = cv
+					if atomic.AddInt32(&t.parent.pending, -1) != 0 {
+						break
+					}
+					t = t.parent
+				}
+			}
+		}()
+	}
+
+	var walk func(t *task) error
+	walk = func(t *task) error {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		var children []*task
+		t.leaf = leaf
+		err := j.edges(t.hop, t.id, j.path, func(child Hop, id NodeID) error {
+			slot := len(t.cvs)
+			t.cvs = append(t.cvs, nil)
+			t.kids = append(t.kids, nil)
+			cv, err := cachedValue(child, id)
+			if err != nil {
+				return err
+			}
+			if cv != nil {
+				t.cvs[slot] = cv
+				return nil
+			}
+			c := &task{hop: child, id: id, parent: t, slot: slot, level: t.level + 1, priority: priority(child, t.priority)}
+			// Before is asked here, not by the worker, so that the subtree
+			// of a supplied hash is not walked and its leaves not read.
+			if cv, err := j.ask(c); cv != nil || err != nil {
+				t.cvs[slot] = cv
+				return err
+			}
+			t.kids[slot] = c
+			children = append(children, c)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if j.readsMessage(t.hop) {
+			leaf++
+		}
+		t.pending = int32(len(children))
+		if len(children) == 0 {
+			ready.push(t)
+		}
+		for _, c := range children {
+			if err := j.path.enter(c.hop, c.id); err != nil {
+				return err
+			}
+			err := walk(c)
+			j.path.exit(c.hop)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var offsets []offset
+	if j.e.VerifyParallel {
+		var err error
+		if offsets, err = j.offsets(hop); err != nil {
+			return nil, err
+		}
+	}
+
+	top := &task{hop: hop, id: NodeID{}, final: final, priority: priority(hop, 0)}
+	j.path.enter(hop, top.id)
+	cv, err := j.ask(top)
+	switch {
+	case err != nil:
+		fail(err)
+	case cv != nil:
+		root = cv
+	default:
+		if err := walk(top); err != nil {
+			fail(err)
+		}
+	}
+	ready.close()
+	wg.Wait()
+	// The context may fail the job concurrently, after the workers stop.
+	once.Do(func() {})
+	if first != nil {
+		return nil, first
+	}
+
+	// A root supplied by Before was not hashed, so there is nothing to verify.
+	if j.e.VerifyParallel && cv == nil {
+		for _, o := range offsets {
+			if _, err := o.s.Seek(o.pos, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		layer := j.layer
+		j.layer = nil // The layer was recorded by the parallel run.
+		again, err := j.serialTask(top)
+		j.layer = layer
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(again, root) {
+			return nil, ErrNondeterministic
+		}
+	}
+	return root, nil
+}
+
+// serialTask hashes the tree of tasks built by a parallel run on the calling
+// goroutine, reusing the chaining values that the run found cached.
+func (j *job) serialTask(t *task) ([]byte, error) {
+	return j.hashNode(t.node(func(_ Hop, _ NodeID, slot int) ([]byte, error) {
+		if t.kids[slot] == nil {
+			return t.cvs[slot], nil
+		}
+		return j.serialTask(t.kids[slot])
+	}))
+}
+
+// readsMessage reports whether the node of hop contains a message hop, which
+// is either hop itself or, with kangaroo hopping, the end of its chain of first
+// children. The node must have been visited by edges, which reports errors
+// looking up its hops.
+func (j *job) readsMessage(hop Hop) bool {
+	for {
+		if chaining, _ := isChaining(hop); !chaining {
+			return true
+		}
+		if !j.mode.Kangaroo {
+			return false
+		}
+		h, n, err := j.open(hop, nil)
+		if err != nil {
+			return false
+		}
+		c, ok, err := j.nthChild(h, nil, n, 0)
+		if err != nil || !ok {
+			return false
+		}
+		hop = c
+	}
+}
+
+// offset is the read position of a message hop.
+type offset struct {
+	s   io.Seeker
+	pos int64
+}
+
+// offsets returns the read positions of the message hops that hashing hop will
+// read, so that they can be read again. It fails with ErrNotSeekable if one of
+// them does not implement io.Seeker.
+func (j *job) offsets(hop Hop) ([]offset, error) {
+	var offsets []offset
+	path := make(ancestors)
+	var visit func(hop Hop, id NodeID) error
+	visit = func(hop Hop, id NodeID) error {
+		chaining, err := j.checkHop(hop, id)
+		if err != nil {
+			return err
+		}
+		if err := path.enter(hop, id); err != nil {
+			return err
+		}
+		defer path.exit(hop)
+		if !chaining {
+			s, ok := hop.(io.Seeker)
+			if !ok {
+				return ErrNotSeekable
+			}
+			pos, err := s.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			offsets = append(offsets, offset{s, pos})
+			return nil
+		}
+		hop, n, err := j.open(hop, id)
+		if err != nil {
+			return err
+		}
+		for i := 0; ; i++ {
+			c, ok, err := j.nthChild(hop, id, n, i)
+			if err != nil || !ok {
+				return err
+			}
+			if i > 0 || !j.mode.Kangaroo {
+				cv, err := cachedValue(c, id.Child(i))
+				if err != nil {
+					return err
+				}
+				if cv != nil {
+					continue
+				}
+			}
+			if err := visit(c, id.Child(i)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := visit(hop, NodeID{}); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// budget limits the total size of the buffers used by a job.
+type budget struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	max  int
+	used int
+}
+
+// acquire blocks until n bytes are available. A nil budget never blocks.
+func (b *budget) acquire(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	for b.used+n > b.max {
+		b.cond.Wait()
+	}
+	b.used += n
+	b.mu.Unlock()
+}
+
+// release returns n bytes to the budget.
+func (b *budget) release(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
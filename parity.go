@@ -0,0 +1,321 @@
+package sakura
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+// ErrTooDamaged is returned by Parity.Reconstruct when a stripe has lost more
+// leaves than it has parity leaves.
+var ErrTooDamaged = errors.New("sakura: too many damaged leaves to reconstruct")
+
+// parityDomain begins the header leaf of the parity hop, which separates
+// parity leaves from data leaves.
+const parityDomain = "sakura.parity"
+
+// Parity is a systematic Reed–Solomon code over the leaves of a stream, which
+// lets a bounded number of damaged leaves be reconstructed. The data leaves
+// are split into stripes of Data consecutive leaves, the last of which may be
+// shorter, and every stripe gets Parity parity leaves of the leaf size. Any
+// Parity leaves of a stripe, data or parity, can be lost and reconstructed
+// from the others. Data plus Parity must not exceed 256.
+//
+// The code works in GF(2^8) with a Cauchy matrix, byte by byte across the
+// leaves of a stripe, with shorter leaves padded with zeros.
+type Parity struct {
+	Data   int // Number of data leaves per stripe.
+	Parity int // Number of parity leaves per stripe.
+}
+
+func (p Parity) validate() error {
+	if p.Data < 1 || p.Parity < 1 || p.Data+p.Parity > 256 {
+		return fmt.Errorf("sakura: invalid parity %d+%d", p.Data, p.Parity)
+	}
+	return nil
+}
+
+// Leaves returns the number of parity leaves for the given number of data
+// leaves.
+func (p Parity) Leaves(data int) int {
+	return (data + p.Data - 1) / p.Data * p.Parity
+}
+
+// coefficient returns the element of the Cauchy matrix for parity leaf j and
+// data leaf i of a stripe.
+func (p Parity) coefficient(j, i int) byte {
+	return gfInv(byte(p.Data+j) ^ byte(i))
+}
+
+// Encode returns the parity leaves of the given data leaves, of leafSize bytes
+// each, stripe by stripe. Data leaves must not be longer than leafSize.
+func (p Parity) Encode(leaves [][]byte, leafSize int) ([][]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	c := newParityCoder(p, leafSize)
+	for _, l := range leaves {
+		if len(l) > leafSize {
+			return nil, errors.New("sakura: leaf exceeds the leaf size")
+		}
+		c.add(l)
+	}
+	return c.finish(), nil
+}
+
+// Reconstruct fills in the damaged leaves of a stream of size bytes cut into
+// leaves of leafSize bytes, of which the data leaves are given in leaves and
+// the parity leaves, as returned by Encode, in parity. Damaged leaves are nil;
+// they are reconstructed in place, data leaves with their original length. It
+// returns ErrTooDamaged, leaving all leaves as they were, if a stripe has more
+// damaged leaves than parity leaves. Reconstructed leaves should be verified
+// against the root, for which they are as good as the leaves they came from.
+func (p Parity) Reconstruct(leaves, parity [][]byte, leafSize int, size int64) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	n := leafCount(size, leafSize)
+	if len(leaves) != n || len(parity) != p.Leaves(n) {
+		return errors.New("sakura: leaf count does not match the size")
+	}
+	// Solve all stripes before changing any.
+	stripes := (n + p.Data - 1) / p.Data
+	solved := make([][][]byte, stripes)
+	for s := range solved {
+		data := leaves[s*p.Data : min((s+1)*p.Data, n)]
+		par := parity[s*p.Parity : (s+1)*p.Parity]
+		full, err := p.solve(data, par, leafSize)
+		if err != nil {
+			return err
+		}
+		solved[s] = full
+	}
+	for s, full := range solved {
+		if full == nil {
+			continue
+		}
+		data := leaves[s*p.Data : min((s+1)*p.Data, n)]
+		par := parity[s*p.Parity : (s+1)*p.Parity]
+		for i := range data {
+			if data[i] == nil {
+				off := int64(s*p.Data+i) * int64(leafSize)
+				data[i] = full[i][:max(min(int64(leafSize), size-off), 0)]
+			}
+		}
+		if damaged(par) {
+			c := newParityCoder(p, leafSize)
+			for _, l := range full {
+				c.add(l)
+			}
+			for j, v := range c.finish() {
+				if par[j] == nil {
+					par[j] = v
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// damaged reports whether any of the leaves is nil.
+func damaged(leaves [][]byte) bool {
+	for _, l := range leaves {
+		if l == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// solve returns all data leaves of a stripe, padded to leafSize, if any leaf
+// of the stripe is damaged, and nil otherwise. Data leaves beyond the end of a
+// short stripe are zero.
+func (p Parity) solve(data, par [][]byte, leafSize int) ([][]byte, error) {
+	if !damaged(data) && !damaged(par) {
+		return nil, nil
+	}
+	pad := func(l []byte) []byte {
+		v := make([]byte, leafSize)
+		copy(v, l)
+		return v
+	}
+	full := make([][]byte, p.Data)
+	var lost []int
+	for i := range full {
+		switch {
+		case i >= len(data):
+			full[i] = make([]byte, leafSize)
+		case data[i] == nil:
+			lost = append(lost, i)
+		default:
+			full[i] = pad(data[i])
+		}
+	}
+	if len(lost) == 0 {
+		return full, nil
+	}
+	// Every lost data leaf is solved for from an intact parity leaf: the
+	// parity leaves, less the contributions of the known data leaves, form a
+	// square system in the lost leaves, whose matrix is a Cauchy matrix and
+	// hence invertible.
+	var rows []int
+	for j, v := range par {
+		if v != nil && len(rows) < len(lost) {
+			rows = append(rows, j)
+		}
+	}
+	if len(rows) < len(lost) {
+		return nil, ErrTooDamaged
+	}
+	m := make([][]byte, len(lost))
+	rhs := make([][]byte, len(lost))
+	for r, j := range rows {
+		m[r] = make([]byte, len(lost))
+		for c, i := range lost {
+			m[r][c] = p.coefficient(j, i)
+		}
+		rhs[r] = pad(par[j])
+		for i, l := range full {
+			if l != nil {
+				gfMulAdd(rhs[r], l, p.coefficient(j, i))
+			}
+		}
+	}
+	inv := gfInvert(m)
+	for c, i := range lost {
+		v := make([]byte, leafSize)
+		for r := range rows {
+			gfMulAdd(v, rhs[r], inv[c][r])
+		}
+		full[i] = v
+	}
+	return full, nil
+}
+
+// parityCoder computes parity leaves incrementally as data leaves complete.
+type parityCoder struct {
+	p        Parity
+	leafSize int
+	n        int      // Number of data leaves added.
+	i        int      // Index of the next data leaf within its stripe.
+	acc      [][]byte // Parity leaves of the current stripe.
+	done     [][]byte // Parity leaves of the completed stripes.
+}
+
+func newParityCoder(p Parity, leafSize int) *parityCoder {
+	return &parityCoder{p: p, leafSize: leafSize}
+}
+
+// add adds the next data leaf, which must not be longer than the leaf size.
+func (c *parityCoder) add(leaf []byte) {
+	if c.acc == nil {
+		c.acc = make([][]byte, c.p.Parity)
+		for j := range c.acc {
+			c.acc[j] = make([]byte, c.leafSize)
+		}
+	}
+	for j, a := range c.acc {
+		gfMulAdd(a, leaf, c.p.coefficient(j, c.i))
+	}
+	c.n++
+	if c.i++; c.i == c.p.Data {
+		c.done = append(c.done, c.acc...)
+		c.acc, c.i = nil, 0
+	}
+}
+
+// finish returns the parity leaves of all stripes.
+func (c *parityCoder) finish() [][]byte {
+	if c.acc != nil {
+		c.done = append(c.done, c.acc...)
+		c.acc, c.i = nil, 0
+	}
+	return c.done
+}
+
+// parityHop returns the hop that holds parity leaves in a tree: a chaining
+// hop over a header leaf, which codes the parameters of the code, and the
+// parity leaves.
+func parityHop(p Parity, leafSize int, parity [][]byte) Hop {
+	header := []byte(parityDomain)
+	header = sakuracoding.AppendLengthEncode(header, uint64(p.Data))
+	header = sakuracoding.AppendLengthEncode(header, uint64(p.Parity))
+	header = sakuracoding.AppendLengthEncode(header, uint64(leafSize))
+	hop := &chainingLeaves{kids: []Hop{messageLeaf(header)}}
+	for _, v := range parity {
+		hop.kids = append(hop.kids, messageLeaf(v))
+	}
+	return hop
+}
+
+// Arithmetic in GF(2^8) modulo x^8+x^4+x^3+x^2+1, with 2 as generator.
+var gfExp, gfLog = gfTables()
+
+func gfTables() (exp [510]byte, log [256]byte) {
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = byte(x), byte(x)
+		log[x] = byte(i)
+		if x <<= 1; x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	return exp, log
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte { return gfExp[255-int(gfLog[a])] }
+
+// gfMulAdd adds c times src to dst, which must be at least as long.
+func gfMulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	var row [256]byte
+	for b := range row {
+		row[b] = gfMul(c, byte(b))
+	}
+	for i, b := range src {
+		dst[i] ^= row[b]
+	}
+}
+
+// gfInvert returns the inverse of the invertible square matrix m, which it
+// destroys.
+func gfInvert(m [][]byte) [][]byte {
+	n := len(m)
+	inv := make([][]byte, n)
+	for i := range inv {
+		inv[i] = make([]byte, n)
+		inv[i][i] = 1
+	}
+	for c := 0; c < n; c++ {
+		r := c
+		for m[r][c] == 0 {
+			r++
+		}
+		m[c], m[r] = m[r], m[c]
+		inv[c], inv[r] = inv[r], inv[c]
+		s := gfInv(m[c][c])
+		for k := 0; k < n; k++ {
+			m[c][k] = gfMul(m[c][k], s)
+			inv[c][k] = gfMul(inv[c][k], s)
+		}
+		for r := 0; r < n; r++ {
+			if f := m[r][c]; r != c && f != 0 {
+				for k := 0; k < n; k++ {
+					m[r][k] ^= gfMul(f, m[c][k])
+					inv[r][k] ^= gfMul(f, inv[c][k])
+				}
+			}
+		}
+	}
+	return inv
+}
@@ -0,0 +1,98 @@
+package sakura_test
+
+import (
+	"errors"
+	"math/bits"
+	"testing"
+
+	"github.com/chlin501/sakura"
+)
+
+// erase returns copies of leaves and parity in which the leaves of stripe s
+// chosen by the bits of mask, data leaves first, are nil.
+func erase(p sakura.Parity, leaves, parity [][]byte, s int, mask uint) ([][]byte, [][]byte) {
+	leaves, parity = append([][]byte(nil), leaves...), append([][]byte(nil), parity...)
+	data := leaves[s*p.Data : min((s+1)*p.Data, len(leaves))]
+	for i := range data {
+		if mask&(1<<i) != 0 {
+			data[i] = nil
+		}
+	}
+	for j := range p.Parity {
+		if mask&(1<<(len(data)+j)) != 0 {
+			parity[s*p.Parity+j] = nil
+		}
+	}
+	return leaves, parity
+}
+
+func TestParityReconstruct(t *testing.T) {
+	const leafSize = 16
+	for _, p := range []sakura.Parity{{Data: 1, Parity: 1}, {Data: 4, Parity: 2}, {Data: 3, Parity: 3}, {Data: 5, Parity: 1}} {
+		// Two full stripes and a short one, whose last leaf is short too.
+		size := int64(2*p.Data*leafSize + leafSize + 5)
+		data := sakura.Pattern(int(size))
+		var orig [][]byte
+		for off := 0; off < len(data); off += leafSize {
+			orig = append(orig, data[off:min(off+leafSize, len(data))])
+		}
+		parity, err := p.Encode(orig, leafSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parity) != p.Leaves(len(orig)) {
+			t.Fatalf("%+v: %d parity leaves, want %d", p, len(parity), p.Leaves(len(orig)))
+		}
+		stripes := (len(orig) + p.Data - 1) / p.Data
+		for s := range stripes {
+			n := min(p.Data, len(orig)-s*p.Data) + p.Parity
+			for mask := uint(1); mask < 1<<n; mask++ {
+				l, par := erase(p, orig, parity, s, mask)
+				err := p.Reconstruct(l, par, leafSize, size)
+				if bits.OnesCount(mask) > p.Parity {
+					if !errors.Is(err, sakura.ErrTooDamaged) {
+						t.Fatalf("%+v: stripe %d, mask %b: got %v, want ErrTooDamaged", p, s, mask, err)
+					}
+					if l2, par2 := erase(p, orig, parity, s, mask); !equalLeaves(l, l2) || !equalLeaves(par, par2) {
+						t.Fatalf("%+v: stripe %d, mask %b: failed Reconstruct changed the leaves", p, s, mask)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%+v: stripe %d, mask %b: %v", p, s, mask, err)
+				}
+				if !equalLeaves(l, orig) || !equalLeaves(par, parity) {
+					t.Fatalf("%+v: stripe %d, mask %b: leaves not restored", p, s, mask)
+				}
+			}
+		}
+	}
+}
+
+// equalLeaves reports whether a and b hold the same leaves, nil ones included.
+func equalLeaves(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) || string(a[i]) != string(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParityInvalid(t *testing.T) {
+	for _, p := range []sakura.Parity{{Data: 0, Parity: 1}, {Data: 1, Parity: 0}, {Data: 200, Parity: 57}} {
+		if _, err := p.Encode([][]byte{{1}}, 16); err == nil {
+			t.Errorf("%+v: Encode succeeded", p)
+		}
+	}
+	p := sakura.Parity{Data: 2, Parity: 1}
+	if _, err := p.Encode([][]byte{make([]byte, 17)}, 16); err == nil {
+		t.Error("Encode of a leaf longer than the leaf size succeeded")
+	}
+	if err := p.Reconstruct([][]byte{nil}, nil, 16, 16); err == nil {
+		t.Error("Reconstruct with a parity leaf missing succeeded")
+	}
+}
@@ -0,0 +1,155 @@
+package sakura
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// FinalContext is like Final, but stops hashing once ctx is done and fails
+// with a *CanceledError. A message hop being read is abandoned between two
+// reads of its buffer.
+func (e *Encoder) FinalContext(ctx context.Context, hop Hop) ([]byte, error) {
+	return e.runContext(ctx, "sakura.Final", hop, true)
+}
+
+// InnerContext is like Inner, but stops hashing once ctx is done and fails
+// with a *CanceledError, as FinalContext does.
+func (e *Encoder) InnerContext(ctx context.Context, hop Hop) ([]byte, error) {
+	return e.runContext(ctx, "sakura.Inner", hop, false)
+}
+
+func (e *Encoder) runContext(ctx context.Context, name string, hop Hop, final bool) ([]byte, error) {
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, &CanceledError{Err: err}
+	}
+	j := newJob(e)
+	j.ctx = ctx
+	j.done = ctx.Done()
+	if e.PartialResults {
+		j.partial = new(partialSet)
+	}
+	sum, err := j.traced(name, hop, func() ([]byte, error) {
+		return j.run(hop, final)
+	})
+	if err != nil && ctx.Err() != nil {
+		return nil, &CanceledError{Err: ctx.Err(), Completed: j.partial.maximal()}
+	}
+	return sum, err
+}
+
+// CanceledError is returned by FinalContext and InnerContext when the context
+// is done before the tree is hashed. If the encoder keeps PartialResults,
+// Completed holds the chaining values of the largest subtrees that the call
+// hashed, so that a retry on the same tree, or on one built again from the
+// same data, can resume from them through Apply instead of starting over.
+type CanceledError struct {
+	Err       error // Error of the context.
+	Completed []CompletedSubtree
+}
+
+// CompletedSubtree is the chaining value of a hop hashed as an inner node, as
+// found in a CanceledError and sent to Encoder.Values.
+type CompletedSubtree struct {
+	Node          NodeID
+	ChainingValue []byte
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("sakura: canceled with %d subtrees hashed: %v", len(e.Completed), e.Err)
+}
+
+func (e *CanceledError) Unwrap() error { return e.Err }
+
+// Apply sets the chaining values of e.Completed on the hops of the tree rooted
+// at hop with the same node IDs, which the next call then does not hash
+// again. The tree must have the shape of the one that was canceled, with hops
+// that keep the chaining values they are given, and chaining hops that can be
+// looked up by index.
+func (e *CanceledError) Apply(hop Hop) error {
+	return applyCompleted(hop, e.Completed)
+}
+
+// applyCompleted sets the chaining values of completed on the hops of the
+// tree rooted at hop with the same node IDs.
+func applyCompleted(hop Hop, completed []CompletedSubtree) error {
+	for _, c := range completed {
+		h, err := descendant(hop, c.Node)
+		if err != nil {
+			return err
+		}
+		h.SetChainingValue(c.ChainingValue)
+	}
+	return nil
+}
+
+// descendant returns the hop whose ID is id in the tree rooted at hop.
+func descendant(hop Hop, id NodeID) (Hop, error) {
+	for k, i := range id {
+		var err error
+		if hop, err = child(hop, id[:k], i); err != nil {
+			return nil, err
+		}
+	}
+	return hop, nil
+}
+
+// completed records the chaining value of the hop whose ID is id, hashed as an
+// inner node, for PartialResults and the Values of the encoder. It returns
+// errCanceled if the job stops while the value waits to be received.
+func (j *job) completed(id NodeID, cv []byte) error {
+	j.partial.add(id, cv)
+	if ch := j.e.Values; ch != nil {
+		select {
+		case ch <- CompletedSubtree{Node: id, ChainingValue: cv}:
+		case <-j.done:
+			return errCanceled
+		}
+	}
+	return nil
+}
+
+// partialSet collects the chaining values computed by a job, for a
+// CanceledError. A nil set collects nothing.
+type partialSet struct {
+	mu     sync.Mutex
+	values []CompletedSubtree
+}
+
+// add records the chaining value of the hop whose ID is id.
+func (s *partialSet) add(id NodeID, cv []byte) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.values = append(s.values, CompletedSubtree{Node: id, ChainingValue: cv})
+	s.mu.Unlock()
+}
+
+// maximal returns the recorded values in tree order, leaving out those of the
+// descendants of other recorded hops.
+func (s *partialSet) maximal() []CompletedSubtree {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slices.SortFunc(s.values, func(a, b CompletedSubtree) int { return compareIDs(a.Node, b.Node) })
+	var out []CompletedSubtree
+	for _, v := range s.values {
+		if n := len(out); n > 0 && isPrefix(out[n-1].Node, v.Node) {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// isPrefix reports whether a is b or one of its ancestors.
+func isPrefix(a, b NodeID) bool {
+	return len(a) <= len(b) && a.Equal(b[:len(a)])
+}
@@ -0,0 +1,139 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ApplyDelta patches the file at path, a verified copy of the old version of
+// a stream, into the new version of size bytes whose root is root, given the
+// delta between their leaf layers, as returned by DiffLayers for leaves of
+// leafSize bytes. The leaves that the delta marks as fetched are read from
+// src, in the byte ranges returned by Delta.FetchBytes, and those it marks as
+// copied from the old version are read from the file.
+//
+// Every changed leaf whose chaining value the delta holds is checked against
+// it as soon as it is read, and fails the patch with a *LeafMismatchError
+// otherwise; the leaves that did not change are read from the file again and
+// hashed. The new version is written to a temporary file next to path, which
+// replaces the file only once the leaves hash to root, so a partial or
+// corrupted delta fails with ErrRootMismatch or a LeafMismatchError and
+// leaves the old copy in place.
+func (e *Encoder) ApplyDelta(path string, d *Delta, root []byte, src Ranger, size int64, leafSize int) error {
+	if leafSize <= 0 || size < 0 {
+		return errors.New("sakura: invalid size or leaf size")
+	}
+	if err := e.checkMode(); err != nil {
+		return err
+	}
+	n := leafCount(size, leafSize)
+	if d.NewLeaves != n {
+		return ErrLengthMismatch
+	}
+	old, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	fi, err := old.Stat()
+	if err != nil {
+		return err
+	}
+	t, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(t.Name())
+	err = e.patch(t, old, fi.Size(), d, root, src, size, leafSize)
+	if err == nil {
+		err = t.Sync()
+	}
+	if cerr := t.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(t.Name(), path)
+}
+
+// patch writes to w the new version of the old copy of oldSize bytes read
+// from old, as described by ApplyDelta.
+func (e *Encoder) patch(w io.Writer, old io.ReaderAt, oldSize int64, d *Delta, root []byte, src Ranger, size int64, leafSize int) error {
+	changed := make(map[int]LeafDelta, len(d.Leaves))
+	for _, ld := range d.Leaves {
+		changed[ld.Leaf] = ld
+	}
+	cvs := make([][]byte, d.NewLeaves)
+	var first []byte
+	for i := range cvs {
+		off := int64(i) * int64(leafSize)
+		ln := min(int64(leafSize), size-off)
+		ld, ok := changed[i]
+		var data []byte
+		var err error
+		switch {
+		case !ok:
+			data, err = readLeafLen(old, oldSize, off, ln)
+		case ld.Old >= 0:
+			data, err = readLeafLen(old, oldSize, int64(ld.Old)*int64(leafSize), ln)
+		default:
+			data, err = fetchLeaf(src, off, ln)
+		}
+		if err != nil {
+			return err
+		}
+		if cvs[i], err = e.leafValue(i, data); err != nil {
+			return err
+		}
+		if ok && ld.ChainingValue != nil && compareRoots(cvs[i], ld.ChainingValue, ErrRootMismatch) != nil {
+			return &LeafMismatchError{Leaf: i, Range: ByteRange{Off: off, Len: ln}}
+		}
+		if i == 0 {
+			first = data
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	got, err := e.Final(layerTree(e.mode, cvs, first))
+	if err != nil {
+		return err
+	}
+	return compareRoots(got, root, ErrRootMismatch)
+}
+
+// readLeafLen reads the n bytes at offset off of the old copy of size bytes
+// read from r, failing with io.ErrUnexpectedEOF if they are not all in it.
+func readLeafLen(r io.ReaderAt, size, off, n int64) ([]byte, error) {
+	if off+n > size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(io.NewSectionReader(r, off, n), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// fetchLeaf reads the n bytes at offset off of the new version from src.
+func fetchLeaf(src Ranger, off, n int64) ([]byte, error) {
+	data := make([]byte, n)
+	if n == 0 {
+		return data, nil
+	}
+	rc, err := src.ReadRange(off, n)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(rc, data)
+	if cerr := rc.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,95 @@
+package sakura
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Pause stops w from hashing until Resume, so that a background scan can be
+// quiesced during peak load without losing its progress. Leaves being hashed
+// stop at their next read, within one buffer of data, and no further leaf is
+// handed to a worker: Write then blocks once the leaf it fills is complete,
+// or returns ErrWouldBlock if w is non-blocking, and Close, as well as
+// Checkpoint while leaves are in flight, waits, all of them up to the
+// deadline of w. Batches already submitted to an Accelerator run to
+// completion. Pause and Resume may be called from any goroutine, concurrently
+// with the other methods of w, and pausing a paused writer does nothing.
+func (w *Writer) Pause() { w.pool.gate.pause() }
+
+// Resume resumes the hashing stopped by Pause.
+func (w *Writer) Resume() { w.pool.gate.resume() }
+
+// Paused reports whether w is paused.
+func (w *Writer) Paused() bool { return w.pool.gate.wait() != nil }
+
+// pauseGate holds back the workers of a paused Writer. A nil gate is never
+// paused.
+type pauseGate struct {
+	mu      sync.Mutex
+	resumed chan struct{} // Closed by resume; nil unless paused.
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+	g.mu.Unlock()
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+	g.mu.Unlock()
+}
+
+// wait returns a channel that is closed once the gate is resumed, or nil if
+// it is not paused.
+func (g *pauseGate) wait() <-chan struct{} {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed
+}
+
+// pass waits until the gate is not paused, and returns errCanceled if done is
+// closed first.
+func (g *pauseGate) pass(done <-chan struct{}) error {
+	if r := g.wait(); r != nil {
+		select {
+		case <-r:
+		case <-done:
+			return errCanceled
+		}
+	}
+	return nil
+}
+
+// unpaused waits until the pool is not paused, up to the deadline unless it
+// is zero. With wait unset, it fails with ErrWouldBlock instead. It returns
+// the error of a leaf that failed meanwhile.
+func (p *leafPool) unpaused(wait bool, deadline time.Time) error {
+	r := p.gate.wait()
+	if r == nil {
+		return nil
+	}
+	if !wait {
+		return ErrWouldBlock
+	}
+	expired, stop := after(deadline)
+	defer stop()
+	select {
+	case <-r:
+		return nil
+	case <-p.done:
+		return p.failed()
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	}
+}
@@ -0,0 +1,180 @@
+package sakura
+
+import "errors"
+
+// PersistentTree is an immutable, hashed tree of leaves. Updates return a new
+// tree and leave the old one intact, queryable and provable, so that every
+// version of a dataset remains available for integrity history at the cost of
+// the nodes that changed: a new version shares all unchanged subtrees with the
+// one it was derived from.
+//
+// The leaves are message hops under a complete tree of chaining hops of the
+// given fanout, filled from the left, whose height is the least that holds all
+// leaves. A tree of a single leaf is that leaf, and the empty tree has the
+// root of the empty message, like a Writer closed without writes. Every update
+// hashes the nodes on the path from the changed leaf to the root, so it costs
+// the height of the tree in nodes whatever the number of leaves.
+//
+// A PersistentTree is safe for concurrent use by multiple goroutines.
+type PersistentTree struct {
+	e       *Encoder
+	fanout  int
+	n       int    // Number of leaves.
+	height  int    // Number of edges from the root to the leaves.
+	top     *pnode // Root node, nil for the empty tree.
+	root    []byte
+	version int
+}
+
+// pnode is a node of a PersistentTree, which is never modified once hashed.
+type pnode struct {
+	kids []*pnode // Children of a chaining hop, nil for a leaf.
+	data []byte   // Message bits of a leaf.
+	cv   []byte
+}
+
+// NewPersistentTree returns an empty tree whose nodes are hashed with e and
+// have up to fanout children. It panics if fanout is less than 2.
+func NewPersistentTree(e *Encoder, fanout int) (*PersistentTree, error) {
+	if fanout < 2 {
+		panic("sakura: fanout less than 2")
+	}
+	t := &PersistentTree{e: e, fanout: fanout}
+	var err error
+	if t.root, err = e.Final(messageLeaf(nil)); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Len returns the number of leaves of t.
+func (t *PersistentTree) Len() int { return t.n }
+
+// Root returns the root of t.
+func (t *PersistentTree) Root() []byte { return t.root }
+
+// Version returns the number of updates that led from the empty tree to t.
+func (t *PersistentTree) Version() int { return t.version }
+
+// Leaf returns the message bits of leaf i, which must not be modified.
+func (t *PersistentTree) Leaf(i int) ([]byte, error) {
+	if i < 0 || i >= t.n {
+		return nil, errors.New("sakura: leaf index out of range")
+	}
+	p := t.top
+	for h := t.height; h > 0; h-- {
+		span := t.span(h - 1)
+		p, i = p.kids[i/span], i%span
+	}
+	return p.data, nil
+}
+
+// LeafID returns the ID of the hop of leaf i in t.
+func (t *PersistentTree) LeafID(i int) NodeID {
+	id := make(NodeID, t.height)
+	for h := t.height; h > 0; h-- {
+		span := t.span(h - 1)
+		id[t.height-h], i = i/span, i%span
+	}
+	return id
+}
+
+// span returns the number of leaves under a full node of height h.
+func (t *PersistentTree) span(h int) int {
+	n := 1
+	for ; h > 0; h-- {
+		n *= t.fanout
+	}
+	return n
+}
+
+// Set returns a tree in which leaf i holds data, which must not be modified
+// afterwards.
+func (t *PersistentTree) Set(i int, data []byte) (*PersistentTree, error) {
+	if i < 0 || i >= t.n {
+		return nil, errors.New("sakura: leaf index out of range")
+	}
+	return t.update(t.top, t.n, t.height, i, data)
+}
+
+// Append returns a tree with data, which must not be modified afterwards, as
+// an additional last leaf.
+func (t *PersistentTree) Append(data []byte) (*PersistentTree, error) {
+	top, height := t.top, t.height
+	if t.n > 0 && t.n == t.span(height) {
+		// The tree is full and grows a level, under which it is the first child.
+		top, height = &pnode{kids: []*pnode{top}}, height+1
+	}
+	return t.update(top, t.n+1, height, t.n, data)
+}
+
+// update returns the tree of n leaves and the given height whose root node is
+// top with leaf i set to data.
+func (t *PersistentTree) update(top *pnode, n, height, i int, data []byte) (*PersistentTree, error) {
+	p, err := t.set(top, height, i, data)
+	if err != nil {
+		return nil, err
+	}
+	root, err := t.e.Final(t.view(p, nil))
+	if err != nil {
+		return nil, err
+	}
+	return &PersistentTree{e: t.e, fanout: t.fanout, n: n, height: height, top: p, root: root, version: t.version + 1}, nil
+}
+
+// set returns a copy of the node p of height h, which is nil if it does not
+// exist yet, with leaf i below it set to data. The nodes on the path to the
+// leaf are new and hashed, and all others are shared with p.
+func (t *PersistentTree) set(p *pnode, h, i int, data []byte) (*pnode, error) {
+	q := &pnode{data: data}
+	if h > 0 {
+		if p != nil {
+			q.kids = append([]*pnode(nil), p.kids...)
+		}
+		span := t.span(h - 1)
+		k := i / span
+		if k == len(q.kids) {
+			q.kids = append(q.kids, nil)
+		}
+		kid, err := t.set(q.kids[k], h-1, i%span, data)
+		if err != nil {
+			return nil, err
+		}
+		q.kids[k], q.data = kid, nil
+	}
+	var err error
+	q.cv, err = t.e.Inner(t.view(q, nil))
+	return q, err
+}
+
+// view returns a hop for hashing or proving the node p. The nodes that are
+// nested in the node of p under kangaroo hopping and those on path are
+// expanded, while all others are given by their chaining value. Views are
+// built afresh for every use, so that hashing never modifies shared nodes.
+func (t *PersistentTree) view(p *pnode, path NodeID) Hop {
+	if p.kids == nil {
+		return messageLeaf(p.data)
+	}
+	c := &chainingLeaves{kids: make([]Hop, len(p.kids))}
+	for i, kid := range p.kids {
+		switch {
+		case len(path) > 0 && path[0] == i:
+			c.kids[i] = t.view(kid, path[1:])
+		case i == 0 && t.e.mode.Kangaroo:
+			c.kids[i] = t.view(kid, nil)
+		default:
+			c.kids[i] = &storedLeaf{cv: kid.cv}
+		}
+	}
+	return c
+}
+
+// Prove returns an inclusion proof of leaf i in t, which VerifyProof checks
+// against Root.
+func (t *PersistentTree) Prove(i int) (*Proof, error) {
+	if i < 0 || i >= t.n {
+		return nil, errors.New("sakura: leaf index out of range")
+	}
+	id := t.LeafID(i)
+	return t.e.Prove(t.view(t.top, id), id)
+}
@@ -0,0 +1,70 @@
+package sakura
+
+import "io"
+
+// Result is the outcome of hashing a stream with Encoder.Pipeline or
+// Encoder.Pipe.
+type Result struct {
+	Root []byte
+	Err  error
+}
+
+// Pipeline hashes a stream whose bytes are sent on in, in chunks of any size,
+// and delivers the root on the returned channel once in is closed. It panics
+// if leafSize is not positive.
+//
+// The chunks are written to a Writer with the given leaf size, so the root is
+// the one such a Writer computes, and completed leaves are hashed on up to
+// Parallelism goroutines while further chunks are received. When all of them
+// are busy, receiving waits, which bounds the memory held to about one leaf
+// per worker. Chunks are copied into leaves, but must not be modified after
+// they are sent.
+//
+// The returned channel receives a single Result and is then closed. After a
+// failure, the remaining chunks are received and discarded, so that the sender
+// is never blocked.
+func (e *Encoder) Pipeline(in <-chan []byte, leafSize int) <-chan Result {
+	w := NewWriter(e, leafSize)
+	out := make(chan Result, 1)
+	go func() {
+		var err error
+		for chunk := range in {
+			if err == nil {
+				_, err = w.Write(chunk)
+			}
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		out <- Result{w.Root(), err}
+		close(out)
+	}()
+	return out
+}
+
+// Pipe returns the writing end of a pipe whose other end is hashed on a new
+// goroutine by a Writer with the given leaf size, and a channel that receives
+// the Result once the pipe is closed, like that of Pipeline. It panics if
+// leafSize is not positive.
+//
+// This suits producers that need an io.Writer and run on their own, such as
+// an encoder of some format or a goroutine copying from a network connection:
+// writes to the pipe block until the hashing side has consumed them. Closing
+// the writer ends the stream, and CloseWithError aborts it, in which case the
+// Result holds that error. If hashing fails, later writes to the pipe fail
+// with the error of the hash, so that the producer is never blocked.
+func (e *Encoder) Pipe(leafSize int) (*io.PipeWriter, <-chan Result) {
+	w := NewWriter(e, leafSize)
+	pr, pw := io.Pipe()
+	out := make(chan Result, 1)
+	go func() {
+		_, err := io.Copy(w, pr)
+		if err == nil {
+			err = w.Close()
+		}
+		pr.CloseWithError(err)
+		out <- Result{w.Root(), err}
+		close(out)
+	}()
+	return pw, out
+}
@@ -0,0 +1,85 @@
+package sakura
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxReadSize caps the read buffer of a Plan, so that huge leaves are still
+// read in pieces.
+const maxReadSize = 1 << 20
+
+// Plan is a Template compiled for an Encoder. It validates the template once
+// and precomputes what hashing its trees needs, so that hashing many inputs of
+// the same geometry does no repeated work: the frame bytes ending the chaining
+// hops of every fanout of the template, and a read buffer that holds a whole
+// leaf. A Plan is safe for concurrent use if its encoder is.
+type Plan struct {
+	e        *Encoder
+	t        Template
+	trailers map[int][]byte
+	readSize int
+}
+
+// Compile checks that t describes sound trees for the encoder and compiles it
+// into a Plan.
+//
+// Beyond Template.Validate, the mode must not declare interleaving, since a
+// template cuts the input into contiguous leaves and the coding of every
+// chaining hop states how its children divide the message, and the fanouts
+// must respect the encoder's MaxDegree. The template is copied, so later
+// changes to t do not affect the plan.
+func (e *Encoder) Compile(t Template) (*Plan, error) {
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	if e.mode.Interleave != NoInterleave {
+		return nil, errors.New("sakura: templates cut contiguous leaves and need a mode without interleaving")
+	}
+	e.warn(t)
+	t.Fanouts = append([]int(nil), t.Fanouts...)
+	p := &Plan{e: e, t: t, trailers: make(map[int][]byte), readSize: min(t.LeafSize, maxReadSize)}
+	for _, f := range t.Fanouts {
+		if max := e.MaxDegree; max > 0 && f > max {
+			return nil, fmt.Errorf("sakura: template fanout %d exceeds the encoder's MaxDegree of %d", f, max)
+		}
+		// With kangaroo hopping, the first child is nested rather than coded
+		// as a value, hence both counts.
+		for _, n := range []int{f, f - 1} {
+			p.trailers[n] = appendTrailer(nil, e.mode, n)
+		}
+	}
+	return p, nil
+}
+
+// Template returns the template of the plan.
+func (p *Plan) Template() Template {
+	t := p.t
+	t.Fanouts = append([]int(nil), t.Fanouts...)
+	return t
+}
+
+// Bytes returns the root of the tree of the plan over data.
+func (p *Plan) Bytes(data []byte) ([]byte, error) {
+	return p.final(p.t.Bytes(data))
+}
+
+// Ranger returns the root of the tree of the plan over the first size bytes of
+// the object read by r.
+func (p *Plan) Ranger(r Ranger, size int64) ([]byte, error) {
+	if size < 0 {
+		return nil, errors.New("sakura: negative size")
+	}
+	return p.final(p.t.Ranger(r, size))
+}
+
+func (p *Plan) final(hop Hop) ([]byte, error) {
+	j := newJob(p.e)
+	j.trailers, j.readSize = p.trailers, p.readSize
+	return j.traced("sakura.Final", hop, func() ([]byte, error) {
+		return j.run(hop, true)
+	})
+}
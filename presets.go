@@ -0,0 +1,111 @@
+package sakura
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"hash"
+)
+
+// Mode128 returns a hashing mode for a security strength of 128 bits: SHA3-256,
+// whose 256-bit outputs and sponge capacity of 512 bits resist collisions and
+// preimages of roots and chaining values up to that strength, with kangaroo
+// hopping, nodes aligned to 8 bytes like KangarooTwelve, and no interleaving.
+// Leaves of DefaultLeafSize bytes, as used by NewHash and HashFile, suit it.
+func Mode128() HashingMode {
+	return presetMode(func() hash.Hash { return sha3.New256() })
+}
+
+// Mode256 is like Mode128 for a security strength of 256 bits, with SHA3-512,
+// whose outputs are 512 bits and sponge capacity 1024 bits.
+func Mode256() HashingMode {
+	return presetMode(func() hash.Hash { return sha3.New512() })
+}
+
+func presetMode(h Hasher) HashingMode {
+	return HashingMode{Hash: h, Kangaroo: true, Alignment: 8, Interleave: NoInterleave}
+}
+
+// NewShake128Mode returns the pairing the Sakura paper assumes for SHAKE128:
+// kangaroo hopping, with nodes aligned to the 168-byte rate of the sponge so
+// that the chaining values after the first leaf start on a block of their own,
+// and no interleaving. Roots and chaining values are 32 bytes, the output size
+// for the 128-bit security strength of SHAKE128.
+func NewShake128Mode() HashingMode {
+	return shakeMode(sha3.NewSHAKE128, 32)
+}
+
+// NewShake256Mode is like NewShake128Mode for SHAKE256, whose rate is 136
+// bytes, with roots and chaining values of 64 bytes.
+func NewShake256Mode() HashingMode {
+	return shakeMode(sha3.NewSHAKE256, 64)
+}
+
+func shakeMode(f func() *sha3.SHAKE, size int) HashingMode {
+	h := func() hash.Hash { return &shakeHash{SHAKE: f(), new: f, size: size} }
+	return HashingMode{Hash: h, Kangaroo: true, Alignment: uint8(f().BlockSize()), Interleave: NoInterleave}
+}
+
+// shakeHash is a hash.Hash with a fixed output size of a SHAKE function.
+type shakeHash struct {
+	*sha3.SHAKE
+	new  func() *sha3.SHAKE
+	size int
+}
+
+func (s *shakeHash) Size() int { return s.size }
+
+// Sum reads the output from a copy of the state, so that writes may continue.
+func (s *shakeHash) Sum(b []byte) []byte {
+	state, err := s.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	c := s.new()
+	if err := c.UnmarshalBinary(state); err != nil {
+		panic(err)
+	}
+	out := make([]byte, s.size)
+	c.Read(out)
+	return append(b, out...)
+}
+
+// sha2Domain begins the outer hash of every node in the SHA-2 modes.
+const sha2Domain = "sakura.sha2"
+
+// SHA256Mode returns a sound mode for users restricted to SHA-2, with SHA-256
+// for a security strength of 128 bits. Leaves, inner nodes and final nodes are
+// told apart by the frame bits of the coding as in every mode, but SHA-256 is a
+// Merkle–Damgård hash: from the hash of a node alone, anyone can compute the
+// hash of the node extended by its padding and more bytes, which a tree whose
+// first leaf holds the old node would code as its final node. Every node is
+// therefore hashed twice, h(domain || h(node)), so that no hash of a node is a
+// state that can be extended. Such modes have fingerprints of their own. Nodes
+// are aligned to the 64-byte blocks of SHA-256 with kangaroo hopping.
+func SHA256Mode() HashingMode {
+	return sha2Mode(sha256.New)
+}
+
+// SHA512Mode is like SHA256Mode with SHA-512, for a security strength of 256
+// bits, and nodes aligned to its 128-byte blocks.
+func SHA512Mode() HashingMode {
+	return sha2Mode(sha512.New)
+}
+
+func sha2Mode(f func() hash.Hash) HashingMode {
+	h := func() hash.Hash { return &sha2Hash{Hash: f(), new: f} }
+	return HashingMode{Hash: h, Kangaroo: true, Alignment: uint8(f().BlockSize()), Interleave: NoInterleave}
+}
+
+// sha2Hash hashes the domain string and the digest of its input again.
+type sha2Hash struct {
+	hash.Hash
+	new func() hash.Hash
+}
+
+func (s *sha2Hash) Sum(b []byte) []byte {
+	o := s.new()
+	o.Write([]byte(sha2Domain))
+	o.Write(s.Hash.Sum(nil))
+	return o.Sum(b)
+}
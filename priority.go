@@ -0,0 +1,114 @@
+package sakura
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// PriorityHop is a hop whose subtree is scheduled ahead of, or behind, the
+// rest of the tree when hashed in parallel, such as the subtree whose value a
+// caller waits on while the others catch up in the background. Nodes of
+// higher priority are handed to the workers first, and hops that do not
+// implement PriorityHop take the priority of their parent, 0 at the root.
+// Nodes of equal priority are hashed in tree order, as without priorities.
+//
+// Priorities only order the work: they never change the hash of the tree,
+// and serial encoders ignore them.
+type PriorityHop interface {
+	Hop
+	// Priority returns the priority of the hop and the hops below it.
+	Priority() int
+}
+
+// priority returns the priority of hop, whose parent has priority parent.
+func priority(hop Hop, parent int) int {
+	switch p := hop.(type) {
+	case PriorityHop:
+		return p.Priority()
+	case interface{ priority(int) int }:
+		// A wrapper, such as that of Synchronize.
+		return p.priority(parent)
+	}
+	return parent
+}
+
+// readyQueue holds the tasks of a parallel job that are ready to be hashed,
+// the highest priority, then the deepest under ScheduleLatency, and then the
+// earliest queued first. Pushing never blocks, so that the walk reaches the
+// nodes of high priority without waiting for those queued before them to be
+// hashed.
+type readyQueue struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	tasks  taskHeap
+	seq    int
+	closed bool
+}
+
+func newReadyQueue(s Schedule) *readyQueue {
+	q := &readyQueue{tasks: taskHeap{deepest: s == ScheduleLatency}}
+	q.cond.L = &q.mu
+	return q
+}
+
+// push queues t.
+func (q *readyQueue) push(t *task) {
+	q.mu.Lock()
+	t.seq = q.seq
+	q.seq++
+	heap.Push(&q.tasks, t)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// pop returns the next task, blocking until there is one, or nil once the
+// queue is closed and empty.
+func (q *readyQueue) pop() *task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.tasks.Len() == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.tasks.Len() == 0 {
+		return nil
+	}
+	return heap.Pop(&q.tasks).(*task)
+}
+
+// close makes pop return nil once the queued tasks are taken.
+func (q *readyQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// taskHeap implements heap.Interface for readyQueue.
+type taskHeap struct {
+	tasks   []*task
+	deepest bool // Whether deeper tasks come first, for ScheduleLatency.
+}
+
+func (h *taskHeap) Len() int { return len(h.tasks) }
+
+func (h *taskHeap) Less(a, b int) bool {
+	ta, tb := h.tasks[a], h.tasks[b]
+	if ta.priority != tb.priority {
+		return ta.priority > tb.priority
+	}
+	if h.deepest && ta.level != tb.level {
+		return ta.level > tb.level
+	}
+	return ta.seq < tb.seq
+}
+
+func (h *taskHeap) Swap(a, b int) { h.tasks[a], h.tasks[b] = h.tasks[b], h.tasks[a] }
+func (h *taskHeap) Push(x any)    { h.tasks = append(h.tasks, x.(*task)) }
+
+func (h *taskHeap) Pop() any {
+	old := h.tasks
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	h.tasks = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,480 @@
+package sakura
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrProofMismatch is returned when a proof does not lead to the expected
+	// root.
+	ErrProofMismatch = errors.New("sakura: proof does not match the root")
+
+	// ErrMalformedProof is returned when the shape of a proof is inconsistent
+	// with the position of its leaf or with the hashing mode.
+	ErrMalformedProof = errors.New("sakura: malformed proof")
+
+	// ErrNotLeaf is returned when asked to prove a hop that is not a message
+	// hop.
+	ErrNotLeaf = errors.New("sakura: hop is not a message hop")
+)
+
+// Proof is an inclusion proof for a leaf of a tree: the leaf, a message hop,
+// together with the proof recomputes the root.
+//
+// A proof holds every node on the path from the leaf to the root, from the
+// leaf up. The verifier codes each node itself, with the value computed from
+// the node below in place of the child on the path, so a proof can only
+// succeed for data that sits exactly at the position of Leaf.
+//
+// Encoder.ProveLeaf proves a leaf by its index and Encoder.Prove by its node
+// ID; VerifyProof, or Encoder.VerifyProof in the mode of the encoder, checks
+// the leaf against the root. MarshalBinary encodes a proof for the wire.
+type Proof struct {
+	Mode  ModeHeader  // Header of the mode of the tree.
+	Leaf  NodeID      // ID of the proven message hop.
+	Nodes []ProofNode // Nodes on the path, starting with the node holding the leaf.
+}
+
+// ProofNode describes a node on the path of a proof.
+//
+// A node consists of a hop and, with kangaroo hopping, of the chain of first
+// children nested in it. Hops lists the chaining hops of that chain from the
+// top of the node down, and Message holds the message bits of the message hop
+// at the bottom of the chain, if there is one and it is not the proven leaf.
+type ProofNode struct {
+	Hops    []ProofHop
+	Message []byte
+}
+
+// ProofHop describes a chaining hop within a ProofNode.
+type ProofHop struct {
+	Degree int
+	// Values holds the chaining values of the children that are coded in the
+	// node, in child order. The value of the child on the path is nil.
+	Values [][]byte
+}
+
+// Prove returns an inclusion proof for the message hop identified by leaf in
+// the tree rooted at root. It returns ErrInvalidNodeID if leaf does not name a
+// hop of the tree.
+//
+// Chaining values of the other children along the path are computed with the
+// encoder unless they are cached. The message hops nested in the nodes on the
+// path, and the leaf itself, are read while building the proof, so they must
+// not have been read before.
+func (e *Encoder) Prove(root Hop, leaf NodeID) (*Proof, error) {
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	hop := root
+	for k, i := range leaf {
+		chaining, err := isChaining(hop)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		if chaining {
+			if n, err = degree(hop, leaf[:k]); err != nil {
+				return nil, err
+			}
+		}
+		if i < 0 || i >= n {
+			return nil, ErrInvalidNodeID
+		}
+		if hop, err = child(hop, leaf[:k], i); err != nil {
+			return nil, err
+		}
+	}
+	if c, err := isChaining(hop); err != nil || c {
+		return nil, ErrNotLeaf
+	}
+
+	p := &Proof{Mode: e.mode.Header(), Leaf: append(NodeID{}, leaf...)}
+	hop, start := root, 0
+	for _, seg := range nodeSegments(e.mode, leaf) {
+		n, next, err := e.proofNode(hop, leaf[:start], seg)
+		if err != nil {
+			return nil, err
+		}
+		p.Nodes = append(p.Nodes, n)
+		hop, start = next, start+len(seg)
+	}
+	// The nodes were collected from the root down.
+	for i, j := 0, len(p.Nodes)-1; i < j; i, j = i+1, j-1 {
+		p.Nodes[i], p.Nodes[j] = p.Nodes[j], p.Nodes[i]
+	}
+	if err := e.checkProofSize(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ProveLeaf is like Prove, but for the message hop at index leaf of the tree
+// rooted at root, counting the message hops in tree order as ProveRange does,
+// so that a chunk is proven by its position alone. It returns
+// ErrInvalidNodeID if the tree has no such leaf. It is the Prove(hop,
+// leafIndex) of content-addressed stores, whose proofs Encoder.VerifyProof
+// checks.
+func (e *Encoder) ProveLeaf(root Hop, leaf int) (*Proof, error) {
+	id, err := leafID(root, leaf)
+	if err != nil {
+		return nil, err
+	}
+	return e.Prove(root, id)
+}
+
+// leafID returns the ID of the message hop at index leaf of tree, in tree
+// order.
+func leafID(tree Hop, leaf int) (NodeID, error) {
+	var id NodeID
+	n := 0
+	err := Walk(tree, func(i NodeID, hop Hop) error {
+		if _, ok := hop.(MessageHop); !ok {
+			return nil
+		}
+		if n == leaf {
+			id = append(NodeID{}, i...)
+			return SkipAll
+		}
+		n++
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		return nil, ErrInvalidNodeID
+	}
+	return id, nil
+}
+
+// nodeSegments splits the path to a leaf into the parts that lie within one
+// node each, from the root down. Every segment but the last ends with the
+// index of a child whose chaining value is coded in the node; the last one
+// leads to the leaf through nested first children only.
+func nodeSegments(mode HashingMode, leaf NodeID) []NodeID {
+	var segs []NodeID
+	start := 0
+	for k, i := range leaf {
+		if mode.Kangaroo && i == 0 {
+			continue
+		}
+		segs = append(segs, leaf[start:k+1])
+		start = k + 1
+	}
+	return append(segs, leaf[start:])
+}
+
+// proofNode describes the node of hop, whose ID is id and which holds the
+// given segment of the path, and returns the hop at which the next node on the
+// path starts.
+func (e *Encoder) proofNode(hop Hop, id NodeID, seg NodeID) (ProofNode, Hop, error) {
+	var n ProofNode
+	var next Hop
+	exit := -1 // Level at which the path leaves the node.
+	if len(seg) > 0 && (!e.mode.Kangaroo || seg[len(seg)-1] != 0) {
+		exit = len(seg) - 1
+	}
+	for level := 0; ; level++ {
+		chaining, err := isChaining(hop)
+		if err != nil {
+			return n, nil, err
+		}
+		if !chaining {
+			if exit < 0 && level == len(seg) {
+				// The proven leaf. Read it so that the tree is left as
+				// hashing it would leave it.
+				return n, nil, copyLeaf(io.Discard, hop, id, -1)
+			}
+			buf := bytes.NewBuffer([]byte{}) // Message must not be nil, even if empty.
+			if err := copyLeaf(buf, hop, id, -1); err != nil {
+				return n, nil, err
+			}
+			n.Message = buf.Bytes()
+			return n, next, nil
+		}
+		d, err := degree(hop, id)
+		if err != nil {
+			return n, nil, err
+		}
+		first := 0
+		if e.mode.Kangaroo && d > 0 {
+			first = 1
+		}
+		ph := ProofHop{Degree: d}
+		for i := first; i < d; i++ {
+			c, err := child(hop, id, i)
+			if err != nil {
+				return n, nil, err
+			}
+			if level == exit && i == seg[exit] {
+				ph.Values = append(ph.Values, nil)
+				next = c
+				continue
+			}
+			cv, err := e.Inner(c)
+			if err != nil {
+				return n, nil, err
+			}
+			ph.Values = append(ph.Values, cv)
+		}
+		n.Hops = append(n.Hops, ph)
+		if first == 0 {
+			return n, next, nil
+		}
+		if hop, err = child(hop, id, 0); err != nil {
+			return n, nil, err
+		}
+		id = id.Child(0)
+	}
+}
+
+// Root returns the root that the proof leads to when the leaf holds the given
+// message bits. It returns ErrModeMismatch if the proof was made for another
+// mode, and ErrMalformedProof if the proof is inconsistent.
+func (p *Proof) Root(mode HashingMode, leaf []byte) ([]byte, error) {
+	return p.root(&Encoder{mode: mode}, leaf, nil)
+}
+
+// root is Root with the nodes hashed by e, which has the mode of the proof.
+// If path is not nil, it is called with the ID and chaining value of every
+// inner node on the path, from the leaf up.
+func (p *Proof) root(e *Encoder, leaf []byte, path func(id NodeID, cv []byte)) ([]byte, error) {
+	mode := e.mode
+	if mode.Hash == nil {
+		return nil, ErrNoHash
+	}
+	if !p.Mode.Matches(mode) {
+		return nil, ErrModeMismatch
+	}
+	segs := nodeSegments(mode, p.Leaf)
+	if len(segs) != len(p.Nodes) {
+		return nil, ErrMalformedProof
+	}
+	size := mode.Hash().Size()
+	j := newJob(e)
+	x := leaf
+	end := len(p.Leaf)
+	for k := range p.Nodes {
+		n := &p.Nodes[k]
+		seg := segs[len(segs)-1-k]
+		hop, err := proofHop(mode, n, seg, k == 0, x, size)
+		if err != nil {
+			return nil, err
+		}
+		// The node starts at the hop whose ID is the path above seg.
+		end -= len(seg)
+		final := k == len(p.Nodes)-1
+		if x, err = j.serial(hop, p.Leaf[:end], final, 0); err != nil {
+			return nil, err
+		}
+		if path != nil && !final {
+			path(p.Leaf[:end], x)
+		}
+	}
+	return x, nil
+}
+
+// proofHop rebuilds the hop of a proof node holding the given segment of the
+// path, with x as the value on the path: the message bits of the leaf for the
+// node holding it, and a chaining value for the other nodes.
+func proofHop(mode HashingMode, n *ProofNode, seg NodeID, holdsLeaf bool, x []byte, size int) (Hop, error) {
+	exit := -1
+	if !holdsLeaf {
+		exit = len(seg) - 1
+	}
+	// The node holding the leaf nests it below its chain, others need to be
+	// at least deep enough to reach the exit.
+	if holdsLeaf && (len(n.Hops) != len(seg) || n.Message != nil) || len(n.Hops) <= exit {
+		return nil, ErrMalformedProof
+	}
+
+	var bottom Hop
+	switch {
+	case holdsLeaf:
+		bottom = messageLeaf(x)
+	case n.Message != nil:
+		bottom = messageLeaf(n.Message)
+	}
+	hop := bottom
+	for level := len(n.Hops) - 1; level >= 0; level-- {
+		ph := &n.Hops[level]
+		first := 0
+		if mode.Kangaroo && ph.Degree > 0 {
+			first = 1
+		}
+		if ph.Degree < 0 || len(ph.Values) != ph.Degree-first {
+			return nil, ErrMalformedProof
+		}
+		c := &chainingLeaves{}
+		if first == 1 {
+			if hop == nil {
+				return nil, ErrMalformedProof
+			}
+			c.kids = append(c.kids, hop)
+		} else if hop != nil {
+			return nil, ErrMalformedProof
+		}
+		for i := first; i < ph.Degree; i++ {
+			v := ph.Values[i-first]
+			onPath := level == exit && i == seg[exit]
+			switch {
+			case onPath && v == nil:
+				v = x
+			case onPath || len(v) != size:
+				return nil, ErrMalformedProof
+			}
+			c.kids = append(c.kids, &storedLeaf{cv: v})
+		}
+		if level == exit && seg[exit] >= ph.Degree {
+			return nil, ErrMalformedProof
+		}
+		hop = c
+	}
+	if hop == nil {
+		return nil, ErrMalformedProof
+	}
+	return hop, nil
+}
+
+// VerifyProof checks that the leaf with the given message bits is part of the
+// tree with the given root, hashed in mode, comparing roots in constant time.
+func VerifyProof(mode HashingMode, root []byte, proof *Proof, leaf []byte) error {
+	got, err := proof.Root(mode, leaf)
+	if err != nil {
+		return err
+	}
+	return compareRoots(got, root, ErrProofMismatch)
+}
+
+// VerifyProof is like the function VerifyProof in the mode of e, for callers
+// that prove and verify with the same encoder: it checks a proof returned by
+// ProveLeaf or Prove against the root returned by Final.
+func (e *Encoder) VerifyProof(root []byte, proof *Proof, leaf []byte) error {
+	return VerifyProof(e.mode, root, proof, leaf)
+}
+
+// VerifyProofTranscript is like VerifyProof, but writes a transcript of the
+// verification to w, so that a failure can be diagnosed and the verification
+// audited with any implementation of the hash function. Every node the proof
+// rebuilds is written as a line of the format of Encoder.Audit with the coded
+// node included, its node ID naming the position of the node in the tree,
+// from the node holding the leaf up to the final node. A last line
+//
+//	root <computed root in hex> <expected root in hex> ok|mismatch
+//
+// follows if the proof leads to a root at all.
+func VerifyProofTranscript(mode HashingMode, root []byte, proof *Proof, leaf []byte, w io.Writer) error {
+	got, err := proof.root(&Encoder{mode: mode, Audit: w, AuditInputs: true}, leaf, nil)
+	if err != nil {
+		return err
+	}
+	err = compareRoots(got, root, ErrProofMismatch)
+	result := "ok"
+	if err != nil {
+		result = "mismatch"
+	}
+	if _, werr := fmt.Fprintf(w, "root %x %x %s\n", got, root, result); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// proofVersion is the version of the serialized proof format.
+const proofVersion = 2
+
+// MarshalBinary encodes the proof.
+func (p *Proof) MarshalBinary() ([]byte, error) {
+	b := appendModeHeader([]byte{proofVersion}, p.Mode)
+	b = binary.AppendUvarint(b, uint64(len(p.Leaf)))
+	for _, i := range p.Leaf {
+		b = binary.AppendUvarint(b, uint64(i))
+	}
+	b = binary.AppendUvarint(b, uint64(len(p.Nodes)))
+	for _, n := range p.Nodes {
+		b = binary.AppendUvarint(b, uint64(len(n.Hops)))
+		for _, h := range n.Hops {
+			b = binary.AppendUvarint(b, uint64(h.Degree))
+			b = binary.AppendUvarint(b, uint64(len(h.Values)))
+			for _, v := range h.Values {
+				b = appendBytes(b, v)
+			}
+		}
+		if n.Message == nil {
+			b = append(b, 0)
+		} else {
+			b = appendBytes(append(b, 1), n.Message)
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary, within
+// DefaultDecodeLimits.
+func (p *Proof) UnmarshalBinary(data []byte) error {
+	q, err := DefaultDecodeLimits.UnmarshalProof(data)
+	if err != nil {
+		return err
+	}
+	*p = *q
+	return nil
+}
+
+// UnmarshalProof decodes a proof encoded by Proof.MarshalBinary within the
+// limits l. The length of the leaf ID and the number of hops of every proof
+// node count against MaxDepth, and the hops of all nodes against MaxNodes.
+// It returns a *DecodeError for malformed input.
+//
+// Proofs of version 1, which record no mode, are decoded with a zero Mode,
+// and those whose mode header is of version 1 with a zero fingerprint. No mode
+// matches them until they are migrated by MigrateProof.
+func (l DecodeLimits) UnmarshalProof(data []byte) (*Proof, error) {
+	d := newDecoder("proof", data)
+	d.limit(l.check("bytes", l.MaxBytes, int64(len(data)), nil))
+	var q Proof
+	if d.versions(1, proofVersion) > 1 {
+		q.Mode, _, _ = d.readModeHeader(1)
+	}
+	q.Leaf = make(NodeID, d.count())
+	d.limit(l.check("depth", int64(l.MaxDepth), int64(len(q.Leaf)), nil))
+	for k := range q.Leaf {
+		q.Leaf[k] = d.int()
+	}
+	q.Nodes = make([]ProofNode, d.count())
+	hops := 0
+	for k := range q.Nodes {
+		n := &q.Nodes[k]
+		n.Hops = make([]ProofHop, d.count())
+		hops += len(n.Hops)
+		d.limit(l.check("depth", int64(l.MaxDepth), int64(len(n.Hops)), q.Leaf))
+		d.limit(l.check("nodes", int64(l.MaxNodes), int64(hops), q.Leaf))
+		for m := range n.Hops {
+			h := &n.Hops[m]
+			h.Degree = d.int()
+			h.Values = make([][]byte, d.count())
+			for i := range h.Values {
+				if v := d.bytes(); len(v) > 0 {
+					h.Values[i] = v
+				}
+			}
+		}
+		switch d.byte() {
+		case 0:
+		case 1:
+			n.Message = d.bytes()
+			if n.Message == nil {
+				n.Message = []byte{}
+			}
+		default:
+			d.fail("invalid message flag")
+		}
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
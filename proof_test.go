@@ -0,0 +1,109 @@
+package sakura_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chlin501/sakura"
+	"github.com/chlin501/sakura/sakuratest"
+)
+
+// leafData returns the bits of the leaves of t in tree order.
+func leafData(t *sakuratest.Tree) [][]byte {
+	if t.Kind == sakuratest.Leaf {
+		return [][]byte{t.Data}
+	}
+	var data [][]byte
+	for _, k := range t.Kids {
+		data = append(data, leafData(k)...)
+	}
+	return data
+}
+
+func TestProveVerify(t *testing.T) {
+	for seed := uint64(0); seed < 100; seed++ {
+		g := sakuratest.New(seed, sakuratest.Config{MaxLeafSize: 64})
+		mode, tree := g.Mode(), g.Tree()
+		e := sakura.New(mode)
+		root, err := e.Final(tree.Hop())
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		for i, data := range leafData(tree) {
+			p, err := e.ProveLeaf(tree.Hop(), i)
+			if err != nil {
+				t.Fatalf("seed %d: ProveLeaf(%d): %v", seed, i, err)
+			}
+			b, err := p.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var q sakura.Proof
+			if err := q.UnmarshalBinary(b); err != nil {
+				t.Fatalf("seed %d: UnmarshalBinary(%d): %v", seed, i, err)
+			}
+			if err := sakura.VerifyProof(mode, root, &q, data); err != nil {
+				t.Fatalf("seed %d: VerifyProof(%d): %v", seed, i, err)
+			}
+			forged := append([]byte{1}, data...)
+			if err := sakura.VerifyProof(mode, root, &q, forged); !errors.Is(err, sakura.ErrProofMismatch) {
+				t.Fatalf("seed %d: forged leaf %d: got %v, want ErrProofMismatch", seed, i, err)
+			}
+		}
+		if _, err := e.ProveLeaf(tree.Hop(), tree.Leaves()); !errors.Is(err, sakura.ErrInvalidNodeID) {
+			t.Errorf("seed %d: ProveLeaf past the last leaf: got %v, want ErrInvalidNodeID", seed, err)
+		}
+	}
+}
+
+func TestVerifyProofOtherMode(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	e := sakura.New(sakura.Mode128())
+	root, err := e.Final(sakura.BuildTree(splitLeaves(data, 8), 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := e.ProveLeaf(sakura.BuildTree(splitLeaves(data, 8), 2), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sakura.VerifyProof(sakura.Mode128(), root, p, []byte("k brown ")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.VerifyProof(root, p, []byte("k brown ")); err != nil {
+		t.Fatalf("Encoder.VerifyProof: %v", err)
+	}
+	if err := e.VerifyProof(root, p, []byte("k brawn ")); !errors.Is(err, sakura.ErrProofMismatch) {
+		t.Errorf("Encoder.VerifyProof of a forged leaf: got %v, want ErrProofMismatch", err)
+	}
+	if err := sakura.VerifyProof(sakura.Mode256(), root, p, []byte("k brown ")); !errors.Is(err, sakura.ErrModeMismatch) {
+		t.Errorf("other mode: got %v, want ErrModeMismatch", err)
+	}
+}
+
+func TestTreeRoundTrip(t *testing.T) {
+	for seed := uint64(0); seed < 100; seed++ {
+		g := sakuratest.New(seed, sakuratest.Config{})
+		mode, tree := g.Mode(), g.Tree()
+		e := sakura.New(mode)
+		root, err := e.Final(tree.Hop())
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		b, err := e.MarshalTree(tree.Hop())
+		if err != nil {
+			t.Fatalf("seed %d: MarshalTree: %v", seed, err)
+		}
+		hop, err := sakura.UnmarshalTree(b, mode)
+		if err != nil {
+			t.Fatalf("seed %d: UnmarshalTree: %v", seed, err)
+		}
+		got, err := e.Final(hop)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if string(got) != string(root) {
+			t.Errorf("seed %d: read tree has another root", seed)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// proofBatchVersion is the version of the serialized proof batch format.
+const proofBatchVersion = 1
+
+// ProofBatch is a list of inclusion proofs from the same tree, such as those
+// of a batch audit, whose encoding shares what the proofs have in common.
+//
+// Proofs of nearby leaves hold the same nodes towards the root, and the same
+// chaining values where their paths part, so MarshalBinary writes every
+// distinct node and chaining value once and refers back to it from later
+// proofs. Decoding reconstructs every proof in full, with the shared nodes and
+// values backed by the same memory, so the decoded proofs must be treated as
+// read-only.
+type ProofBatch []*Proof
+
+// MarshalBinary encodes the batch. All proofs must have the same mode
+// header.
+func (b ProofBatch) MarshalBinary() ([]byte, error) {
+	var mode ModeHeader
+	if len(b) > 0 {
+		mode = b[0].Mode
+	}
+	out := appendModeHeader([]byte{proofBatchVersion}, mode)
+	out = binary.AppendUvarint(out, uint64(len(b)))
+	nodes := make(map[string]int)  // Indices of the nodes written, by encoding.
+	values := make(map[string]int) // Indices of the values written.
+	for _, p := range b {
+		if p.Mode != mode {
+			return nil, errors.New("sakura: proofs of a batch have different modes")
+		}
+		out = binary.AppendUvarint(out, uint64(len(p.Leaf)))
+		for _, i := range p.Leaf {
+			out = binary.AppendUvarint(out, uint64(i))
+		}
+		out = binary.AppendUvarint(out, uint64(len(p.Nodes)))
+		for _, n := range p.Nodes {
+			key := string(appendProofNode(nil, &n, nil))
+			if k, ok := nodes[key]; ok {
+				out = binary.AppendUvarint(out, uint64(k+1))
+				continue
+			}
+			nodes[key] = len(nodes)
+			out = appendProofNode(append(out, 0), &n, values)
+		}
+	}
+	return out, nil
+}
+
+// appendProofNode appends the encoding of n to b. Unless values is nil, a
+// chaining value found in it is written as a reference, and the others are
+// added to it. A value is written as 0 for the one on the path, 1 followed by
+// its bytes, or the index of a value written before plus 2.
+func appendProofNode(b []byte, n *ProofNode, values map[string]int) []byte {
+	b = binary.AppendUvarint(b, uint64(len(n.Hops)))
+	for _, h := range n.Hops {
+		b = binary.AppendUvarint(b, uint64(h.Degree))
+		b = binary.AppendUvarint(b, uint64(len(h.Values)))
+		for _, v := range h.Values {
+			k, ok := values[string(v)]
+			switch {
+			case v == nil:
+				b = append(b, 0)
+			case ok:
+				b = binary.AppendUvarint(b, uint64(k+2))
+			default:
+				if values != nil {
+					values[string(v)] = len(values)
+				}
+				b = appendBytes(append(b, 1), v)
+			}
+		}
+	}
+	if n.Message == nil {
+		return append(b, 0)
+	}
+	return appendBytes(append(b, 1), n.Message)
+}
+
+// UnmarshalBinary decodes a batch encoded by MarshalBinary, within
+// DefaultDecodeLimits.
+func (b *ProofBatch) UnmarshalBinary(data []byte) error {
+	q, err := DefaultDecodeLimits.UnmarshalProofBatch(data)
+	if err != nil {
+		return err
+	}
+	*b = q
+	return nil
+}
+
+// UnmarshalProofBatch decodes a batch encoded by ProofBatch.MarshalBinary
+// within the limits l. Leaf IDs and nodes count against MaxDepth as with
+// UnmarshalProof, and the hops of all proofs against MaxNodes, a shared node
+// counting once for every proof that holds it, so that references cannot
+// expand a small input into proofs that are costly to verify. The proofs
+// count against MaxLeaves. It returns a *DecodeError for malformed input.
+func (l DecodeLimits) UnmarshalProofBatch(data []byte) (ProofBatch, error) {
+	d := newDecoder("proof batch", data)
+	d.limit(l.check("bytes", l.MaxBytes, int64(len(data)), nil))
+	d.version(proofBatchVersion)
+	mode, _ := d.modeHeader()
+	b := make(ProofBatch, d.count())
+	d.limit(l.check("leaves", int64(l.MaxLeaves), int64(len(b)), nil))
+	var nodes []ProofNode
+	var values [][]byte
+	hops := 0
+	for k := range b {
+		p := &Proof{Mode: mode}
+		p.Leaf = make(NodeID, d.count())
+		d.limit(l.check("depth", int64(l.MaxDepth), int64(len(p.Leaf)), nil))
+		for m := range p.Leaf {
+			p.Leaf[m] = d.int()
+		}
+		p.Nodes = make([]ProofNode, d.count())
+		for m := range p.Nodes {
+			ref := d.int()
+			switch {
+			case ref == 0:
+				nodes = append(nodes, d.proofNode(&values))
+				p.Nodes[m] = nodes[len(nodes)-1]
+			case ref <= len(nodes):
+				p.Nodes[m] = nodes[ref-1]
+			default:
+				d.fail("invalid node reference")
+			}
+			hops += len(p.Nodes[m].Hops)
+			d.limit(l.check("depth", int64(l.MaxDepth), int64(len(p.Nodes[m].Hops)), p.Leaf))
+			d.limit(l.check("nodes", int64(l.MaxNodes), int64(hops), p.Leaf))
+		}
+		b[k] = p
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// proofNode reads a node encoded by appendProofNode, resolving and adding to
+// values.
+func (d *decoder) proofNode(values *[][]byte) ProofNode {
+	var n ProofNode
+	n.Hops = make([]ProofHop, d.count())
+	for m := range n.Hops {
+		h := &n.Hops[m]
+		h.Degree = d.int()
+		h.Values = make([][]byte, d.count())
+		for i := range h.Values {
+			switch v := d.int(); {
+			case v == 0:
+			case v == 1:
+				if h.Values[i] = d.bytes(); h.Values[i] == nil {
+					h.Values[i] = []byte{}
+				}
+				*values = append(*values, h.Values[i])
+			case v-2 < len(*values):
+				h.Values[i] = (*values)[v-2]
+			default:
+				d.fail("invalid value reference")
+			}
+		}
+	}
+	switch d.byte() {
+	case 0:
+	case 1:
+		if n.Message = d.bytes(); n.Message == nil {
+			n.Message = []byte{}
+		}
+	default:
+		d.fail("invalid message flag")
+	}
+	return n
+}
@@ -0,0 +1,71 @@
+package sakura
+
+import "encoding"
+
+// countLeaf records that the job reads another message hop, whose ID is id,
+// and fails with a *LimitError if that exceeds the MaxLeaves of the encoder.
+func (j *job) countLeaf(id NodeID) error {
+	max := j.e.MaxLeaves
+	if max <= 0 {
+		return nil
+	}
+	if n := j.leaves.Add(1); n > int64(max) {
+		return &LimitError{Node: id, Limit: "leaves", Max: int64(max), Value: n}
+	}
+	return nil
+}
+
+// countBytes records that the job read n more bytes from message hops, and
+// fails with a *LimitError, whose Node the caller sets, if that exceeds the
+// MaxBytes of the encoder.
+func (j *job) countBytes(n int) error {
+	max := j.e.MaxBytes
+	if max <= 0 {
+		return nil
+	}
+	if total := j.bytes.Add(int64(n)); total > max {
+		return &LimitError{Limit: "bytes", Max: max, Value: total}
+	}
+	return nil
+}
+
+// checkStreamSize fails with a *LimitError if a stream of size bytes, cut into
+// leaves of leafSize bytes, exceeds the MaxBytes or MaxLeaves of e, and with a
+// *NodeSizeError if the final node of the tree Writer builds for it exceeds
+// its MaxNodeBytes.
+func (e *Encoder) checkStreamSize(size int64, leafSize int) error {
+	if max := e.MaxBytes; max > 0 && size > max {
+		return &LimitError{Node: NodeID{}, Limit: "bytes", Max: max, Value: size}
+	}
+	n := leafCount(size, leafSize)
+	if max := e.MaxLeaves; max > 0 && n > max {
+		return &LimitError{Node: NodeID{}, Limit: "leaves", Max: int64(max), Value: int64(n)}
+	}
+	if max := e.MaxNodeBytes; max > 0 && n > 1 && e.mode.Hash != nil {
+		values, first := n, int64(0)
+		if e.mode.Kangaroo {
+			values, first = n-1, int64(leafSize)
+		}
+		if size := int64(values)*int64(e.mode.Hash().Size()) + first; size > max {
+			return &NodeSizeError{Node: NodeID{}, Degree: n, Size: size, Max: max}
+		}
+	}
+	return nil
+}
+
+// checkProofSize fails with a *LimitError if the encoding of p exceeds the
+// MaxProofBytes of e.
+func (e *Encoder) checkProofSize(p encoding.BinaryMarshaler) error {
+	max := e.MaxProofBytes
+	if max <= 0 {
+		return nil
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if len(b) > max {
+		return &LimitError{Node: NodeID{}, Limit: "proof bytes", Max: int64(max), Value: int64(len(b))}
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package sakura
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// RangeProof is a proof for a contiguous range of leaves of a tree: together
+// with the message bits of the leaves, it recomputes the root.
+//
+// The proof is the tree pruned to the range: every subtree that holds no leaf
+// of the range is given by its chaining value, except where kangaroo hopping
+// nests it in the node of its parent, in which case it is expanded down to the
+// message hop it nests. Only the borders of the range contribute chaining
+// values, so a range of k leaves takes about as many values as two inclusion
+// proofs instead of k of them.
+type RangeProof struct {
+	Mode   ModeHeader  // Header of the mode of the tree.
+	Leaves []NodeID    // IDs of the proven message hops, in tree order.
+	Nodes  []RangeNode // Hops of the pruned tree, in pre-order.
+}
+
+// RangeNodeKind is the kind of a RangeNode.
+type RangeNodeKind byte
+
+const (
+	// RangeStored is a subtree outside the range, given by its chaining value.
+	RangeStored RangeNodeKind = iota
+
+	// RangeMessage is a message hop outside the range that is nested in the
+	// node of its parent, given by its message bits.
+	RangeMessage
+
+	// RangeChaining is a chaining hop, followed by its children.
+	RangeChaining
+
+	// RangeLeaf is a proven leaf, whose message bits the verifier supplies.
+	RangeLeaf
+)
+
+// RangeNode is a hop of the pruned tree of a RangeProof.
+type RangeNode struct {
+	Kind   RangeNodeKind
+	Degree int    // Number of children of a RangeChaining hop.
+	Value  []byte // Chaining value of a RangeStored subtree, or bits of a RangeMessage.
+}
+
+// ProveRange returns a proof for the leaves r of the tree rooted at root,
+// which must be a non-empty range of them.
+//
+// Like Prove, it computes the chaining values of the subtrees outside the
+// range with the encoder unless they are cached, and reads the message hops it
+// expands, including the proven leaves, so they must not have been read
+// before.
+func (e *Encoder) ProveRange(root Hop, r LeafRange) (*RangeProof, error) {
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	// spans holds the leaves of every subtree, by ID.
+	spans := make(map[string]LeafRange)
+	leaf := 0
+	err := Walk(root, func(id NodeID, hop Hop) error {
+		spans[id.String()] = LeafRange{Start: leaf}
+		if _, ok := hop.(MessageHop); ok {
+			leaf++
+		}
+		return nil
+	}, func(id NodeID, _ Hop) error {
+		s := spans[id.String()]
+		s.End = leaf
+		spans[id.String()] = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if r.Start < 0 || r.Start >= r.End || r.End > leaf {
+		return nil, errors.New("sakura: invalid leaf range")
+	}
+	p := &RangeProof{Mode: e.mode.Header()}
+	rp := rangeProver{e: e, r: r, spans: spans, p: p}
+	if err := rp.visit(root, NodeID{}, true); err != nil {
+		return nil, err
+	}
+	if err := e.checkProofSize(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// rangeProver collects the nodes of a RangeProof.
+type rangeProver struct {
+	e     *Encoder
+	r     LeafRange
+	spans map[string]LeafRange
+	p     *RangeProof
+}
+
+// visit adds the pruned subtree of hop, whose ID is id. nested is set for the
+// root and for the hops nested in the node of their parent, which cannot be
+// given by their chaining value.
+func (rp *rangeProver) visit(hop Hop, id NodeID, nested bool) error {
+	s := rp.spans[id.String()]
+	// An empty subtree is inside if it sits between two leaves of the range.
+	inside := s.Start < rp.r.End && (s.End > rp.r.Start || s.Start == s.End && s.Start > rp.r.Start)
+	if !inside && !nested {
+		cv, err := rp.e.Inner(hop)
+		if err != nil {
+			return err
+		}
+		rp.p.Nodes = append(rp.p.Nodes, RangeNode{Kind: RangeStored, Value: cv})
+		return nil
+	}
+	chaining, err := isChaining(hop)
+	if err != nil {
+		return err
+	}
+	if !chaining {
+		if inside {
+			// Read the leaf so that the tree is left as hashing it would
+			// leave it.
+			if err := copyLeaf(io.Discard, hop, id, -1); err != nil {
+				return err
+			}
+			rp.p.Leaves = append(rp.p.Leaves, append(NodeID{}, id...))
+			rp.p.Nodes = append(rp.p.Nodes, RangeNode{Kind: RangeLeaf})
+			return nil
+		}
+		buf := bytes.NewBuffer([]byte{}) // Message must not be nil, even if empty.
+		if err := copyLeaf(buf, hop, id, -1); err != nil {
+			return err
+		}
+		rp.p.Nodes = append(rp.p.Nodes, RangeNode{Kind: RangeMessage, Value: buf.Bytes()})
+		return nil
+	}
+	d, err := degree(hop, id)
+	if err != nil {
+		return err
+	}
+	rp.p.Nodes = append(rp.p.Nodes, RangeNode{Kind: RangeChaining, Degree: d})
+	for i := 0; i < d; i++ {
+		c, err := child(hop, id, i)
+		if err != nil {
+			return err
+		}
+		if err := rp.visit(c, id.Child(i), i == 0 && rp.e.mode.Kangaroo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Root returns the root that the proof leads to when the proven leaves hold
+// the given message bits, in tree order. It returns ErrModeMismatch if the
+// proof was made for another mode, and ErrMalformedProof if the proof is
+// inconsistent, such as when its leaves are not contiguous or do not sit at
+// the IDs of Leaves.
+func (p *RangeProof) Root(mode HashingMode, leaves [][]byte) ([]byte, error) {
+	if mode.Hash == nil {
+		return nil, ErrNoHash
+	}
+	if !p.Mode.Matches(mode) {
+		return nil, ErrModeMismatch
+	}
+	if len(p.Leaves) == 0 || len(leaves) != len(p.Leaves) || len(p.Nodes) == 0 || p.Nodes[0].Kind == RangeStored {
+		return nil, ErrMalformedProof
+	}
+	rb := rangeBuilder{mode: mode, size: mode.Hash().Size(), p: p, leaves: leaves}
+	hop, err := rb.hop(NodeID{})
+	if err != nil {
+		return nil, err
+	}
+	if rb.next != len(p.Nodes) || rb.leaf != len(p.Leaves) {
+		return nil, ErrMalformedProof
+	}
+	return New(mode).Final(hop)
+}
+
+// rangeBuilder rebuilds the pruned tree of a RangeProof.
+type rangeBuilder struct {
+	mode   HashingMode
+	size   int
+	p      *RangeProof
+	leaves [][]byte
+	next   int // Index of the next node.
+	leaf   int // Index of the next proven leaf.
+}
+
+// hop rebuilds the hop with the given ID from the next node.
+func (rb *rangeBuilder) hop(id NodeID) (Hop, error) {
+	if rb.next >= len(rb.p.Nodes) {
+		return nil, ErrMalformedProof
+	}
+	n := &rb.p.Nodes[rb.next]
+	rb.next++
+	// Between the first and the last proven leaf, every leaf is proven.
+	inRange := rb.leaf > 0 && rb.leaf < len(rb.p.Leaves)
+	switch n.Kind {
+	case RangeStored:
+		if inRange || len(n.Value) != rb.size {
+			return nil, ErrMalformedProof
+		}
+		return &storedLeaf{cv: n.Value}, nil
+	case RangeMessage:
+		if inRange || n.Value == nil {
+			return nil, ErrMalformedProof
+		}
+		return messageLeaf(n.Value), nil
+	case RangeLeaf:
+		if rb.leaf >= len(rb.p.Leaves) || !rb.p.Leaves[rb.leaf].Equal(id) {
+			return nil, ErrMalformedProof
+		}
+		rb.leaf++
+		return messageLeaf(rb.leaves[rb.leaf-1]), nil
+	case RangeChaining:
+		// Every child takes at least one node.
+		if n.Degree < 0 || n.Degree > len(rb.p.Nodes)-rb.next {
+			return nil, ErrMalformedProof
+		}
+		c := &chainingLeaves{}
+		for i := 0; i < n.Degree; i++ {
+			kid, err := rb.hop(id.Child(i))
+			if err != nil {
+				return nil, err
+			}
+			c.kids = append(c.kids, kid)
+		}
+		return c, nil
+	}
+	return nil, ErrMalformedProof
+}
+
+// VerifyRangeProof checks that the leaves with the given message bits, in tree
+// order, are the leaves proof.Leaves of the tree with the given root, hashed
+// in mode, comparing roots in constant time. As with VerifyProof, the caller
+// checks that proof.Leaves are the leaves it expects.
+func VerifyRangeProof(mode HashingMode, root []byte, proof *RangeProof, leaves [][]byte) error {
+	got, err := proof.Root(mode, leaves)
+	if err != nil {
+		return err
+	}
+	return compareRoots(got, root, ErrProofMismatch)
+}
+
+// rangeProofVersion is the version of the serialized range proof format.
+const rangeProofVersion = 1
+
+// MarshalBinary encodes the proof.
+func (p *RangeProof) MarshalBinary() ([]byte, error) {
+	b := appendModeHeader([]byte{rangeProofVersion}, p.Mode)
+	b = binary.AppendUvarint(b, uint64(len(p.Leaves)))
+	for _, id := range p.Leaves {
+		b = binary.AppendUvarint(b, uint64(len(id)))
+		for _, i := range id {
+			b = binary.AppendUvarint(b, uint64(i))
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(p.Nodes)))
+	for _, n := range p.Nodes {
+		b = append(b, byte(n.Kind))
+		switch n.Kind {
+		case RangeStored, RangeMessage:
+			b = appendBytes(b, n.Value)
+		case RangeChaining:
+			b = binary.AppendUvarint(b, uint64(n.Degree))
+		}
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary, within
+// DefaultDecodeLimits.
+func (p *RangeProof) UnmarshalBinary(data []byte) error {
+	q, err := DefaultDecodeLimits.UnmarshalRangeProof(data)
+	if err != nil {
+		return err
+	}
+	*p = *q
+	return nil
+}
+
+// UnmarshalRangeProof decodes a proof encoded by RangeProof.MarshalBinary
+// within the limits l. The length of every leaf ID counts against MaxDepth,
+// the nodes against MaxNodes and the proven leaves against MaxLeaves. It returns a *DecodeError for malformed
+// input.
+func (l DecodeLimits) UnmarshalRangeProof(data []byte) (*RangeProof, error) {
+	d := newDecoder("range proof", data)
+	d.limit(l.check("bytes", l.MaxBytes, int64(len(data)), nil))
+	d.version(rangeProofVersion)
+	var q RangeProof
+	q.Mode, _ = d.modeHeader()
+	q.Leaves = make([]NodeID, d.count())
+	d.limit(l.check("leaves", int64(l.MaxLeaves), int64(len(q.Leaves)), nil))
+	for k := range q.Leaves {
+		id := make(NodeID, d.count())
+		d.limit(l.check("depth", int64(l.MaxDepth), int64(len(id)), nil))
+		for m := range id {
+			id[m] = d.int()
+		}
+		q.Leaves[k] = id
+	}
+	q.Nodes = make([]RangeNode, d.count())
+	d.limit(l.check("nodes", int64(l.MaxNodes), int64(len(q.Nodes)), nil))
+	for k := range q.Nodes {
+		n := &q.Nodes[k]
+		n.Kind = RangeNodeKind(d.byte())
+		switch n.Kind {
+		case RangeStored, RangeMessage:
+			if n.Value = d.bytes(); n.Value == nil {
+				n.Value = []byte{}
+			}
+		case RangeChaining:
+			n.Degree = d.int()
+		case RangeLeaf:
+		default:
+			d.fail("invalid node kind")
+		}
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
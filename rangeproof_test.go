@@ -0,0 +1,112 @@
+package sakura_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chlin501/sakura"
+	"github.com/chlin501/sakura/sakuratest"
+)
+
+func TestProveRange(t *testing.T) {
+	for seed := uint64(0); seed < 60; seed++ {
+		g := sakuratest.New(seed, sakuratest.Config{MaxLeafSize: 32})
+		mode, tree := g.Mode(), g.Tree()
+		e := sakura.New(mode)
+		root, err := e.Final(tree.Hop())
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		data := leafData(tree)
+		n := len(data)
+		// Every range of small trees, and those at the edges of larger ones.
+		var ranges []sakura.LeafRange
+		for start := 0; start < n; start++ {
+			for end := start + 1; end <= n; end++ {
+				if n <= 12 || start == 0 || end == n || end == start+1 {
+					ranges = append(ranges, sakura.LeafRange{Start: start, End: end})
+				}
+			}
+		}
+		for _, r := range ranges {
+			p, err := e.ProveRange(tree.Hop(), r)
+			if err != nil {
+				t.Fatalf("seed %d: ProveRange(%v): %v", seed, r, err)
+			}
+			b, err := p.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var q sakura.RangeProof
+			if err := q.UnmarshalBinary(b); err != nil {
+				t.Fatalf("seed %d: UnmarshalBinary(%v): %v", seed, r, err)
+			}
+			leaves := data[r.Start:r.End]
+			if len(q.Leaves) != r.Len() {
+				t.Fatalf("seed %d: %v: proof of %d leaves", seed, r, len(q.Leaves))
+			}
+			if err := sakura.VerifyRangeProof(mode, root, &q, leaves); err != nil {
+				t.Fatalf("seed %d: VerifyRangeProof(%v): %v", seed, r, err)
+			}
+			forged := append([][]byte(nil), leaves...)
+			forged[len(forged)-1] = append([]byte{1}, forged[len(forged)-1]...)
+			if err := sakura.VerifyRangeProof(mode, root, &q, forged); !errors.Is(err, sakura.ErrProofMismatch) {
+				t.Fatalf("seed %d: forged range %v: got %v, want ErrProofMismatch", seed, r, err)
+			}
+		}
+		for _, r := range []sakura.LeafRange{{Start: 0, End: 0}, {Start: -1, End: 1}, {Start: 0, End: n + 1}} {
+			if _, err := e.ProveRange(tree.Hop(), r); err == nil {
+				t.Fatalf("seed %d: ProveRange(%v) succeeded", seed, r)
+			}
+		}
+	}
+}
+
+func TestProveRangeKangaroo(t *testing.T) {
+	mode := sakura.Mode128()
+	e := sakura.New(mode)
+	// Leaf 0 is nested in the final node and leaf 2 in the node of its
+	// parent, so a proof of leaves 3 and 4 expands them both.
+	leaves := [][]byte{[]byte("nested 0"), []byte("one"), []byte("nested 2"), []byte("three"), []byte("four")}
+	tree := func() sakura.Hop {
+		var hops []sakura.Hop
+		for _, l := range leaves {
+			hops = append(hops, sakura.GatherBytes(l))
+		}
+		return chain{chain{hops[0], hops[1]}, chain{hops[2], hops[3]}, hops[4]}
+	}
+	root, err := e.Final(tree())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := e.ProveRange(tree(), sakura.LeafRange{Start: 3, End: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := 0
+	for _, n := range p.Nodes {
+		if n.Kind == sakura.RangeMessage {
+			messages++
+		}
+	}
+	if messages != 2 {
+		t.Errorf("proof expands %d nested leaves, want 2", messages)
+	}
+	if err := sakura.VerifyRangeProof(mode, root, p, leaves[3:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sakura.VerifyRangeProof(sakura.Mode256(), root, p, leaves[3:]); !errors.Is(err, sakura.ErrModeMismatch) {
+		t.Errorf("other mode: got %v, want ErrModeMismatch", err)
+	}
+	if err := sakura.VerifyRangeProof(mode, root, p, leaves[4:]); !errors.Is(err, sakura.ErrMalformedProof) {
+		t.Errorf("missing leaf: got %v, want ErrMalformedProof", err)
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(b); n++ {
+		var q sakura.RangeProof
+		malformed(t, "truncated range proof", q.UnmarshalBinary(b[:n]))
+	}
+}
@@ -0,0 +1,82 @@
+package sakura
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Ranger reads byte ranges of an object, typically one held by a remote object
+// store and read with ranged GET requests.
+type Ranger interface {
+	// ReadRange returns a reader for the n bytes of the object starting at
+	// offset off. The reader is closed once it has been read.
+	ReadRange(off, n int64) (io.ReadCloser, error)
+}
+
+// HashRanger returns the root of the first size bytes of the object read by r,
+// cut into leaves of leafSize bytes. Every leaf is fetched with its own call
+// to ReadRange when it is hashed, so with Parallelism the leaves are fetched
+// concurrently and only the leaves in flight are held in memory.
+//
+// The tree has the same shape as the one built by Writer, so the root is the
+// one a Writer with the same leaf size computes for the same bytes, including
+// the root of the empty message when size is zero, for which ReadRange is not
+// called.
+func (e *Encoder) HashRanger(r Ranger, size int64, leafSize int) ([]byte, error) {
+	if leafSize <= 0 || size < 0 {
+		return nil, errors.New("sakura: invalid size or leaf size")
+	}
+	return e.Final(sequentialTree(RangeLeaves(r, size, leafSize)))
+}
+
+// rangeLeaf is a message hop that reads a range of an object, requesting it on
+// the first read.
+type rangeLeaf struct {
+	r      Ranger
+	off, n int64
+	rc     io.ReadCloser
+	read   int64
+	done   bool
+	cv     []byte
+}
+
+func (l *rangeLeaf) Read(p []byte) (int, error) {
+	if l.done || l.n == 0 {
+		return 0, io.EOF
+	}
+	if l.rc == nil {
+		rc, err := l.r.ReadRange(l.off, l.n)
+		if err != nil {
+			return 0, err
+		}
+		l.rc = rc
+	}
+	if max := l.n - l.read; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := l.rc.Read(p)
+	l.read += int64(n)
+	if err == io.EOF && l.read < l.n {
+		err = io.ErrUnexpectedEOF
+	}
+	if l.read == l.n && err == nil {
+		err = io.EOF
+	}
+	if err != nil {
+		l.done = true
+		if cerr := l.rc.Close(); cerr != nil && err == io.EOF {
+			err = cerr
+		}
+	}
+	return n, err
+}
+
+func (l *rangeLeaf) Label() string {
+	return fmt.Sprintf("bytes %d-%d", l.off, l.off+l.n-1)
+}
+
+func (l *rangeLeaf) Offset() int64                { return l.off }
+func (l *rangeLeaf) Size() int64                  { return l.n }
+func (l *rangeLeaf) ChainingValue() []byte        { return l.cv }
+func (l *rangeLeaf) SetChainingValue(hash []byte) { l.cv = hash }
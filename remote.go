@@ -0,0 +1,105 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+)
+
+// SequentialLeaf returns the ID of the hop of leaf i in the tree of n leaves
+// built by Writer and HashRanger.
+func SequentialLeaf(n, i int) NodeID {
+	if n == 1 {
+		return NodeID{}
+	}
+	return NodeID{i}
+}
+
+// RemoteVerifier reads an object through a Ranger, verifying every byte read
+// against the root that a Writer with the same leaf size computes for it.
+//
+// Only the leaves covering the bytes asked for are fetched, each with a single
+// call to ReadRange, and each is checked with an inclusion proof before any of
+// its bytes are returned. This lets a client check parts of a large remote
+// object without downloading all of it.
+type RemoteVerifier struct {
+	Mode     HashingMode
+	Root     []byte // Root of the object.
+	Size     int64  // Size of the object in bytes.
+	LeafSize int    // Leaf size with which Root was computed.
+	Ranger   Ranger
+
+	// Proof returns an inclusion proof for the leaf of the given index, as
+	// built by Encoder.Prove on the tree of SequentialLeaf IDs.
+	Proof func(leaf int) (*Proof, error)
+}
+
+// leaves returns the number of leaves of the object.
+func (v *RemoteVerifier) leaves() int {
+	return leafCount(v.Size, v.LeafSize)
+}
+
+// ReadAt implements io.ReaderAt. It returns ErrProofMismatch if a leaf does
+// not match the root, in which case none of its bytes are copied to p.
+func (v *RemoteVerifier) ReadAt(p []byte, off int64) (int, error) {
+	if v.LeafSize <= 0 || v.Size < 0 {
+		return 0, errors.New("sakura: invalid size or leaf size")
+	}
+	if off < 0 {
+		return 0, errors.New("sakura: negative offset")
+	}
+	if off >= v.Size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), v.Size)
+	n := 0
+	for i := int(off / int64(v.LeafSize)); int64(i)*int64(v.LeafSize) < end; i++ {
+		data, err := v.Leaf(i)
+		if err != nil {
+			return n, err
+		}
+		start := int64(i) * int64(v.LeafSize)
+		lo, hi := max(off-start, 0), min(end-start, int64(len(data)))
+		n += copy(p[n:], data[lo:hi])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Leaf fetches and verifies leaf i and returns its bytes.
+func (v *RemoteVerifier) Leaf(i int) ([]byte, error) {
+	n := v.leaves()
+	if i < 0 || i >= n {
+		return nil, errors.New("sakura: leaf index out of range")
+	}
+	off := int64(i) * int64(v.LeafSize)
+	size := min(int64(v.LeafSize), v.Size-off)
+	rc, err := v.Ranger.ReadRange(off, size)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, size+1))
+	if cerr := rc.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+	proof, err := v.Proof(i)
+	if err != nil {
+		return nil, err
+	}
+	// The proof must be for the position of the leaf, or it could vouch for
+	// the same bytes elsewhere in the object.
+	if !proof.Leaf.Equal(SequentialLeaf(n, i)) {
+		return nil, ErrMalformedProof
+	}
+	if err := VerifyProof(v.Mode, v.Root, proof, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
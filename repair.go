@@ -0,0 +1,164 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// ByteRange is a range of Len bytes of a stream starting at offset Off.
+type ByteRange struct {
+	Off, Len int64
+}
+
+// LeafHashes returns the chaining values of the leaves of the first size
+// bytes read from r, cut into leaves of leafSize bytes as by RangeLeaves. Each
+// leaf is hashed as an inner node on its own, including a first leaf that
+// kangaroo hopping nests in the final node, and the leaves are hashed on up to
+// Parallelism goroutines. The values can be stored to locate damage with
+// Damaged later.
+func (e *Encoder) LeafHashes(r io.ReaderAt, size int64, leafSize int) ([][]byte, error) {
+	if leafSize <= 0 || size < 0 {
+		return nil, errors.New("sakura: invalid size or leaf size")
+	}
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	leaves := RangeLeaves(readerAtRanger{r}, size, leafSize)
+	cvs := make([][]byte, len(leaves))
+	errs := make([]error, len(leaves))
+	sem := make(chan struct{}, max(e.Parallelism, 1))
+	var wg sync.WaitGroup
+	for i, leaf := range leaves {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			j := newJob(e)
+			j.leaf = i
+			cvs[i], errs[i] = j.serial(leaf, NodeID{i}, false, 1)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cvs, nil
+}
+
+// Damaged compares the first size bytes read from r with the leaf chaining
+// values returned by LeafHashes for the same leaf size when the data was
+// intact, and returns the byte ranges of the leaves that differ, in
+// increasing order and with adjacent ranges merged, so that only those need
+// to be fetched again or repaired. If r holds fewer leaves than stored, the
+// missing leaves are reported as a final range starting at size, of
+// leafSize bytes per missing leaf. Damaged returns no ranges if the data is
+// intact.
+func (e *Encoder) Damaged(r io.ReaderAt, size int64, leafSize int, stored [][]byte) ([]ByteRange, error) {
+	cvs, err := e.LeafHashes(r, size, leafSize)
+	if err != nil {
+		return nil, err
+	}
+	tree := &chainingLeaves{}
+	for _, cv := range cvs {
+		tree.kids = append(tree.kids, &storedLeaf{cv: cv})
+	}
+	diff, err := DiffLeaves(tree, stored)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []ByteRange
+	for _, d := range diff {
+		off := int64(d.Start) * int64(leafSize)
+		ranges = append(ranges, ByteRange{Off: off, Len: min(int64(d.End)*int64(leafSize), size) - off})
+	}
+	if missing := len(stored) - len(cvs); missing > 0 {
+		if k := len(ranges); k > 0 && ranges[k-1].Off+ranges[k-1].Len == size {
+			ranges[k-1].Len += int64(missing) * int64(leafSize)
+		} else {
+			ranges = append(ranges, ByteRange{Off: size, Len: int64(missing) * int64(leafSize)})
+		}
+	}
+	return ranges, nil
+}
+
+// leafValue returns the chaining value of leaf i of a stream in the shape of
+// Writer, whose bits are data.
+func (e *Encoder) leafValue(i int, data []byte) ([]byte, error) {
+	j := newJob(e)
+	j.leaf = i
+	return j.serial(messageLeaf(data), NodeID{i}, false, 1)
+}
+
+// VerifyRange checks the bytes rng of the first size bytes read from r against
+// root, given the leaf chaining values stored for them as returned by
+// LeafHashes for the same leaf size, in the shape of the tree built by Writer.
+// The stored values are checked against root, which makes them the proof path
+// of every leaf, and only the leaves covering rng are read and checked against
+// their values, so that a huge archive can be spot-checked cheaply. The first
+// leaf is read as well where the final node holds its bits, with kangaroo
+// hopping or for a single leaf.
+//
+// VerifyRange returns ErrLengthMismatch if stored does not hold one value per
+// leaf, ErrRootMismatch if the values do not match root and a
+// *LeafMismatchError for the first leaf covering rng that does not match.
+func (e *Encoder) VerifyRange(root []byte, stored [][]byte, r io.ReaderAt, size int64, leafSize int, rng ByteRange) error {
+	if leafSize <= 0 || size < 0 {
+		return errors.New("sakura: invalid size or leaf size")
+	}
+	if rng.Off < 0 || rng.Len < 0 || rng.Off+rng.Len > size {
+		return errors.New("sakura: range out of bounds")
+	}
+	if err := e.checkMode(); err != nil {
+		return err
+	}
+	n := leafCount(size, leafSize)
+	if len(stored) != n {
+		return ErrLengthMismatch
+	}
+	var first []byte
+	nested := n == 1 || e.mode.Kangaroo
+	if nested {
+		var err error
+		if first, err = readLeaf(r, size, leafSize, 0); err != nil {
+			return err
+		}
+	}
+	got, err := e.Final(layerTree(e.mode, stored, first))
+	if err != nil {
+		return err
+	}
+	if err := compareRoots(got, root, ErrRootMismatch); err != nil {
+		return err
+	}
+	if rng.Len == 0 {
+		return nil
+	}
+	for i := int(rng.Off / int64(leafSize)); int64(i)*int64(leafSize) < rng.Off+rng.Len; i++ {
+		if i == 0 && nested {
+			continue // Checked as part of the final node.
+		}
+		data, err := readLeaf(r, size, leafSize, i)
+		if err != nil {
+			return err
+		}
+		cv, err := e.leafValue(i, data)
+		if err != nil {
+			return err
+		}
+		if compareRoots(cv, stored[i], ErrRootMismatch) != nil {
+			return &LeafMismatchError{Leaf: i, Range: ByteRange{Off: int64(i) * int64(leafSize), Len: int64(len(data))}}
+		}
+	}
+	return nil
+}
+
+// readLeaf reads the bytes of leaf i of the first size bytes of r.
+func readLeaf(r io.ReaderAt, size int64, leafSize, i int) ([]byte, error) {
+	off := int64(i) * int64(leafSize)
+	data := make([]byte, min(int64(leafSize), size-off))
+	if _, err := io.ReadFull(io.NewSectionReader(r, off, int64(len(data))), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
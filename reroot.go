@@ -0,0 +1,87 @@
+package sakura
+
+// ReRoot returns the root that the tree with the given root, hashed in mode,
+// has once the message bits of the leaf of proof change from old to leaf. The
+// chaining values of the siblings on the path do not depend on the leaf, so
+// the proof is all the data of the tree that is needed, which lets a client
+// that keeps the proofs of its own leaves update a commitment to a tree it
+// does not hold. ReRoot first checks that old is the leaf at the position of
+// the proof, as VerifyProof does, so that a stale proof fails with
+// ErrProofMismatch instead of leading to the root of another tree.
+//
+// The proof stays valid for the updated tree, with leaf as its leaf. The
+// proofs of other leaves hold the chaining value of a node that the change
+// crosses, unless it only crosses their path: ReRoot brings the others given
+// up to date, so that they also verify against the new root. They must be
+// proofs of the same tree, which cannot be checked without their leaves. If
+// ReRoot fails, none of the proofs is modified.
+func ReRoot(mode HashingMode, root []byte, proof *Proof, old, leaf []byte, others ...*Proof) ([]byte, error) {
+	if err := VerifyProof(mode, root, proof, old); err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte)
+	sum, err := proof.root(&Encoder{mode: mode}, leaf, func(id NodeID, cv []byte) {
+		values[id.String()] = cv
+	})
+	if err != nil {
+		return nil, err
+	}
+	var updates []func()
+	for _, o := range others {
+		u, err := o.rebase(mode, proof.Leaf, leaf, values)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, u...)
+	}
+	for _, u := range updates {
+		u()
+	}
+	return sum, nil
+}
+
+// rebase returns the updates that bring p up to date with a change of the
+// leaf whose ID is changed to the message bits leaf, given the new chaining
+// values of the inner nodes on the path of the change by ID.
+func (p *Proof) rebase(mode HashingMode, changed NodeID, leaf []byte, values map[string][]byte) ([]func(), error) {
+	if !p.Mode.Matches(mode) {
+		return nil, ErrModeMismatch
+	}
+	if p.Leaf.Equal(changed) {
+		return nil, nil
+	}
+	segs := nodeSegments(mode, p.Leaf)
+	if len(segs) != len(p.Nodes) {
+		return nil, ErrMalformedProof
+	}
+	var updates []func()
+	end := len(p.Leaf)
+	for k := range p.Nodes {
+		n := &p.Nodes[k]
+		end -= len(segs[len(segs)-1-k])
+		// The hops of the node are its top hop and the chain of first
+		// children nested below it.
+		id := p.Leaf[:end]
+		for l := range n.Hops {
+			ph := &n.Hops[l]
+			first := 0
+			if mode.Kangaroo && ph.Degree > 0 {
+				first = 1
+			}
+			for i, v := range ph.Values {
+				if v == nil {
+					continue // The child on the path of p.
+				}
+				if cv, ok := values[id.Child(first+i).String()]; ok {
+					updates = append(updates, func() { ph.Values[i] = cv })
+				}
+			}
+			id = id.Child(0)
+		}
+		if n.Message != nil && id.Equal(changed) {
+			m := append([]byte{}, leaf...)
+			updates = append(updates, func() { n.Message = m })
+		}
+	}
+	return updates, nil
+}
@@ -0,0 +1,102 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries the reads of message hops that fail with a transient
+// error, such as an interrupted system call or a timeout of a network file
+// system, so that one flaky read does not fail the whole tree. Only the read
+// of the failed hop is retried: the bits read before the failure are kept, and
+// a hop that implements io.Seeker is first moved back to just after them, in
+// case the failed read advanced it. Other hops are read again as they are.
+// The zero RetryPolicy retries nothing.
+type RetryPolicy struct {
+	// MaxRetries is the number of times the reads of a message hop are
+	// retried before its error is returned.
+	MaxRetries int
+
+	// Backoff is the wait before the first retry of a hop, doubled before
+	// every further one up to MaxBackoff, if positive.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable reports whether a read error is transient. If nil, errors
+	// that wrap syscall.EINTR, syscall.EAGAIN or os.ErrDeadlineExceeded, or
+	// that report a Timeout, are.
+	Retryable func(error) bool
+}
+
+// retryable reports whether err is transient under p.
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	var t interface{ Timeout() bool }
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &t) && t.Timeout()
+}
+
+// leafRetry is the retry state of a message hop being read.
+type leafRetry struct {
+	r     io.Reader
+	s     io.Seeker // Nil unless r can be moved back to start+read.
+	start int64     // Position of r before the first read.
+	tries int
+}
+
+// newLeafRetry returns the retry state of r, or nil if the job retries no
+// reads.
+func (j *job) newLeafRetry(r io.Reader) *leafRetry {
+	if j.e.Retry.MaxRetries <= 0 {
+		return nil
+	}
+	l := &leafRetry{r: r}
+	if s, ok := r.(io.Seeker); ok {
+		if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+			l.s, l.start = s, pos
+		}
+	}
+	return l
+}
+
+// retry waits for the next retry of a read that failed with err after read
+// bytes of the hop were coded, and readies the hop for it. It returns err if
+// the read is not to be retried, errCanceled if the job stops while waiting,
+// and nil otherwise.
+func (j *job) retry(l *leafRetry, read int64, err error) error {
+	p := &j.e.Retry
+	if l == nil || l.tries >= p.MaxRetries || !p.retryable(err) {
+		return err
+	}
+	d := p.Backoff
+	for k := 0; k < l.tries && d > 0 && (p.MaxBackoff <= 0 || d < p.MaxBackoff); k++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, p.MaxBackoff)
+	}
+	l.tries++
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-j.done:
+			return errCanceled
+		}
+	}
+	if l.s != nil {
+		if _, serr := l.s.Seek(l.start+read, io.SeekStart); serr != nil {
+			return err
+		}
+	}
+	if m := j.e.Metrics; m != nil {
+		m.Add(MetricLeafRetries, 1)
+	}
+	return nil
+}
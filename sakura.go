@@ -4,9 +4,10 @@
 package sakura
 
 import (
-	"errors"
 	"hash"
 	"io"
+	"log/slog"
+	"sync"
 )
 
 // Hasher provides a source of hash.Hash implementations.
@@ -18,7 +19,7 @@ type Filter func() (io.Reader, io.Writer)
 
 // BlockSize represents a block size as a mantissa and exponent in the formula:
 //
-//   Pow(2, Exponent) * (2 * Mantissa + 1)
+//	Pow(2, Exponent) * (2 * Mantissa + 1)
 type BlockSize struct {
 	Mantissa uint8
 	Exponent uint8
@@ -29,12 +30,42 @@ func (bs BlockSize) Value() int {
 	return 1 << bs.Exponent * (2*int(bs.Mantissa) + 1)
 }
 
+// NearestBlockSize returns the block size closest to n bytes. Sizes of the
+// form Pow(2, e) * (2 * m + 1) with m at most 255 are represented exactly;
+// others map to the nearest representable size, the smaller one on ties.
+// Values of n below 1 map to a block size of 1 byte.
+func NearestBlockSize(n int) BlockSize {
+	best, diff := BlockSize{}, -1
+	for e := 0; e < 55 && 1<<e <= 2*n; e++ {
+		q := (n>>e - 1) / 2
+		for _, m := range []int{q, q + 1} {
+			m = min(max(m, 0), 255)
+			v := (2*m + 1) << e
+			d := v - n
+			if d < 0 {
+				d = -d
+			}
+			if diff < 0 || d < diff || d == diff && v < best.Value() {
+				best, diff = BlockSize{Mantissa: uint8(m), Exponent: uint8(e)}, d
+			}
+		}
+	}
+	return best
+}
+
 // HashingMode is a Sakura tree mode that describes how the tree is encoded.
 type HashingMode struct {
 	Hash       Hasher    // Source of hash.Hash implementations.
 	Kangaroo   bool      // Does the mode apply Kangaroo hopping, wherein the first node is nested in its parent?
 	Alignment  uint8     // The number of bytes that nodes will be aligned to.
 	Interleave BlockSize // Block size for interleaving values.
+
+	// Coding is the coding of the frame bits of nodes, SakuraCoding if nil.
+	Coding Coding
+
+	// Padding turns the bits of a node into bytes for the hash function,
+	// SimplePadding if nil.
+	Padding Padding
 }
 
 // Hop is a hop in a hop tree.
@@ -64,30 +95,317 @@ type ChainingHop interface {
 	Degree() int
 }
 
+// ChainingHop64 is a chaining hop with a degree that need not fit in an int
+// and children whose lookup can fail. The encoder detects it and uses its
+// methods in preference to those of ChainingHop; a hop may implement either
+// interface or both, but must not also be a MessageHop.
+//
+// Trees are still hashed in memory, so degrees above the largest int fail
+// with a *LimitError, while errors from ChildErr are returned as a
+// *ChildError.
+type ChainingHop64 interface {
+	Hop
+	// ChildErr returns the child hop at index i.
+	ChildErr(i int64) (Hop, error)
+	// Degree64 returns the number of children.
+	Degree64() int64
+}
+
 // MessageHop is a source of message bits.
 type MessageHop interface {
 	io.Reader
 }
 
+// ChainingValueErr is a hop whose cached chaining value is loaded from storage
+// that can fail, such as a store on disk. The encoder calls ChainingValueErr
+// instead of ChainingValue on hops that implement it, and fails with a
+// *CacheError if loading fails.
+type ChainingValueErr interface {
+	Hop
+	// ChainingValueErr returns the cached chaining value of the hop, nil if
+	// there is none, or an error if it could not be loaded.
+	ChainingValueErr() (hash []byte, err error)
+}
+
+// LabeledHop is a hop that carries a descriptive label, such as "chunk 42 of
+// /var/db/x". Labels appear in errors, traces and logs that refer to the hop.
+// They are never part of the hashed data.
+type LabeledHop interface {
+	Hop
+	Label() string
+}
+
+// OffsetHop is a message hop that knows where its bits start in a larger
+// input, such as a leaf cut from a file. Errors reading it report offsets in
+// that input rather than in the hop.
+type OffsetHop interface {
+	MessageHop
+	// Offset returns the position of the first byte of the hop in its input.
+	Offset() int64
+}
+
+// offsetOf returns the offset of hop in its input, or 0 if it is not an
+// OffsetHop.
+func offsetOf(hop any) int64 {
+	if o, ok := hop.(OffsetHop); ok {
+		return o.Offset()
+	}
+	return 0
+}
+
+// label returns the label of hop, or the empty string if it has none.
+func label(hop Hop) string {
+	if l, ok := hop.(LabeledHop); ok {
+		return l.Label()
+	}
+	return ""
+}
+
 // Encoder is a Sakura tree encoder.
+//
+// The exported fields control how the encoder executes. They have no effect on
+// the resulting hashes and must not be changed while a call is in progress.
+//
+// An Encoder is safe for concurrent use by multiple goroutines: every call to
+// Final or Inner keeps its state to itself, and limits such as
+// MaxBufferedBytes and BytesPerSecond apply to each call separately. The
+// Hasher of the mode, the Tracer and the Logger must then be safe for
+// concurrent use as well, and a hop must not be hashed by two calls at once.
+// The hash states and buffers used for nodes are pooled and shared by all
+// calls, so an Encoder must not be copied after first use.
 type Encoder struct {
-	mode HashingMode
-	//pool bithash.Pool
+	// Parallelism is the number of goroutines used to hash nodes. Values below
+	// 2 hash the tree serially on the calling goroutine.
+	//
+	// The hash of a tree never depends on Parallelism: nodes are coded from
+	// the chaining values of their children in child order, whichever worker
+	// computed them and whenever it finished. Parallelism may therefore be
+	// changed freely between runs.
+	Parallelism int
+
+	// Schedule is the order in which a parallel encoder hashes the nodes
+	// that are ready, ScheduleThroughput by default.
+	Schedule Schedule
+
+	// VerifyParallel makes parallel runs hash the tree a second time on the
+	// calling goroutine and fail with ErrNondeterministic if the results
+	// differ. Every message hop that is read must implement io.Seeker so that
+	// it can be read again. It is meant for tests and for validating custom
+	// Hasher and Hop implementations, and doubles the cost of hashing.
+	VerifyParallel bool
+
+	// MaxBufferedBytes caps the total size of the buffers that hold message
+	// bits read from message hops. When the cap is reached, further reads block
+	// until buffers are released. Zero means no cap.
+	MaxBufferedBytes int
+
+	// BytesPerSecond limits the rate at which message bits are read from
+	// message hops, summed over all workers, so that background hashing does
+	// not starve other users of the same storage. Zero means no limit.
+	BytesPerSecond int
+
+	// MaxDepth and MaxDegree, if positive, limit the depth of the hashed tree,
+	// counted in edges from the root, and the degree of its chaining hops.
+	// Trees that exceed them fail with a *LimitError before the offending hop
+	// is descended into, which protects against unbounded recursion and
+	// memory use when hashing untrusted hop structures.
+	MaxDepth  int
+	MaxDegree int
+
+	// MaxLeaves and MaxBytes, if positive, limit the number of message hops
+	// that a call reads and the number of bytes read from them, and those of
+	// the streams of Writers and of SumBytes. They fail with a *LimitError as
+	// soon as the offending leaf or read is reached, so that the work of a
+	// call on untrusted input is bounded whatever the shape of the tree.
+	MaxLeaves int
+	MaxBytes  int64
+
+	// MaxProofBytes, if positive, limits the encoded size of the proofs built
+	// by Prove and ProveRange, which fail with a *LimitError instead of
+	// returning larger proofs.
+	MaxProofBytes int
+
+	// MaxNodeBytes, if positive, limits the coded input of a single node, so
+	// that a chaining hop with millions of children fails with a
+	// *NodeSizeError instead of feeding the hash gigabytes of chaining
+	// values. Chaining hops that can be looked up by index are checked by
+	// their degree before their children are hashed, and the other nodes as
+	// they are coded.
+	MaxNodeBytes int64
+
+	// Buffers, if not nil, provides the buffers that message hops are read
+	// through and the leaf buffers of Writers, and sets the size of the
+	// former unless a Plan chooses it.
+	Buffers *BufferPool
+
+	// Accelerator, if not nil, hashes the leaves of a Writer in batches of
+	// AcceleratorBatch leaves or, if zero, of 64 rounded up to a multiple of
+	// the lanes of a LaneAccelerator, as AcceleratorStats reports, with up to
+	// Parallelism batches, at least one, in flight. Leaves hashed by the
+	// accelerator are not traced, logged, audited, counted, hooked or sent to
+	// Values, and the same trees hash to the same roots with or without it.
+	// Modes with HashPadding cannot be accelerated.
+	Accelerator      Accelerator
+	AcceleratorBatch int
+
+	// Tracer, if not nil, receives spans for every call to Final and Inner and
+	// for every level of the hashed tree.
+	Tracer Tracer
+
+	// Logger, if not nil, receives debug records about mode validation, the
+	// lifecycle of parallel workers and nodes that failed to hash.
+	Logger *slog.Logger
+
+	// Audit, if not nil, receives a transcript of every node hashed, which an
+	// external verifier can replay with any implementation of the hash
+	// function. Each node is written as one line
+	//
+	//	<node ID> final|inner <hash in hex> [<coded node in hex>]
+	//
+	// where the coded node, the exact bytes given to the hash function, is
+	// only included if AuditInputs is set; this buffers every node in memory
+	// while it is hashed. Lines of a parallel run appear in the order nodes
+	// complete. Only one call at a time may write to a given Audit writer, and
+	// a write error fails the call.
+	Audit       io.Writer
+	AuditInputs bool
+
+	// Metrics, if not nil, receives the counts of nodes and bytes hashed.
+	Metrics Metrics
+
+	// Hooks are called before and after every node is hashed.
+	Hooks NodeHooks
+
+	// Values, if not nil, receives the node ID and chaining value of every
+	// inner node as it is hashed, so that indexers and replicas can mirror
+	// the tree in real time. Values of a parallel run are sent in the order
+	// the nodes complete, children always before their parent. The values
+	// that hops report as cached are not sent, nor is the hash of the final
+	// node. Sends block the hashing until they are received, or the call is
+	// canceled, and the encoder never closes the channel. The chaining
+	// values must not be modified.
+	Values chan<- CompletedSubtree
+
+	// Retry, if its MaxRetries is positive, retries the reads of message hops
+	// that fail with transient errors.
+	Retry RetryPolicy
+
+	// PartialResults makes FinalContext and InnerContext remember the chaining
+	// value of every inner node they hash, to return those of the completed
+	// subtrees in the *CanceledError of a call that is canceled.
+	PartialResults bool
+
+	// Strict makes every call fail with an error wrapping ErrNotVetted unless
+	// the mode is one that this package vouches for, as described by
+	// CheckStrict, so that a service can guarantee that no experimental
+	// parameters run in production. NewEncoder also runs CheckHasher on the
+	// hash function of a strict encoder. By default any mode is accepted.
+	Strict bool
+
+	// Warn, if not nil, is called with every Warning about the mode and the
+	// geometry that NewEncoder, NewWriter and Compile find in a configuration
+	// they accept, so that applications can log them.
+	Warn func(Warning)
+
+	mode    HashingMode
+	scratch sync.Pool // Of *scratch, reused by the nodes of all calls.
 }
 
-// New returns a new encoder with the given hashing mode.
+// New returns a new encoder with the given hashing mode. NewEncoder offers the
+// same configuration through options that are validated up front.
 func New(mode HashingMode) *Encoder {
 	return &Encoder{
 		mode: mode,
 	}
 }
 
+// Mode returns the hashing mode of the encoder.
+func (e *Encoder) Mode() HashingMode { return e.mode }
+
+// checkMode validates the hashing mode of the encoder.
+func (e *Encoder) checkMode() error {
+	var err error
+	if e.mode.Hash == nil {
+		err = ErrNoHash
+	} else if _, ok := e.mode.Padding.(HashPadding); ok {
+		if _, ok := e.mode.Hash().(BitHash); !ok {
+			err = ErrNoBitHash
+		}
+	}
+	if err == nil && e.Strict {
+		err = CheckStrict(e.mode)
+	}
+	if e.Logger != nil {
+		e.Logger.Debug("sakura: mode checked",
+			"kangaroo", e.mode.Kangaroo,
+			"alignment", e.mode.Alignment,
+			"interleave_mantissa", e.mode.Interleave.Mantissa,
+			"interleave_exponent", e.mode.Interleave.Exponent,
+			"err", err)
+	}
+	return err
+}
+
 // Final encodes the given hop as a final node and returns the hash.
+//
+// Empty inputs are valid and have well-defined hashes. A message hop that
+// yields no bits is coded as the message hop '1' of an empty message, so its
+// final node is the bit string '11'. A chaining hop with no children is coded
+// with no chaining values and a count of zero, and is not subject to kangaroo
+// hopping, so its final node is the byte 0x00, the interleaving block size and
+// the bits '01'. A nil hop, or a nil child, is not a hop and fails with
+// ErrInvalidHop.
 func (e *Encoder) Final(hop Hop) (hash []byte, err error) {
-	return nil, errors.New("not implemented")
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	j := newJob(e)
+	return j.traced("sakura.Final", hop, func() ([]byte, error) {
+		return j.run(hop, true)
+	})
 }
 
 // Inner encodes the given hop as an inner node and returns the hash.
+//
+// The hash is the chaining value of the hop, and is passed to the
+// SetChainingValue method of the hop and of every descendant that is hashed on
+// the way. Hops that already report a chaining value are not hashed again.
 func (e *Encoder) Inner(hop Hop) (hash []byte, err error) {
-	return nil, errors.New("not implemented")
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	j := newJob(e)
+	return j.traced("sakura.Inner", hop, func() ([]byte, error) {
+		return j.run(hop, false)
+	})
+}
+
+// AppendFinal is like Final, but appends the hash to dst and returns the
+// extended slice, so that callers can reuse a buffer across calls. If dst has
+// room for the hash, the hash is written into it directly and no memory is
+// allocated for it. On error, dst is returned unchanged.
+func (e *Encoder) AppendFinal(dst []byte, hop Hop) ([]byte, error) {
+	if err := e.checkMode(); err != nil {
+		return dst, err
+	}
+	j := newJob(e)
+	j.out = dst
+	sum, err := j.traced("sakura.Final", hop, func() ([]byte, error) {
+		return j.run(hop, true)
+	})
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, sum...), nil
+}
+
+// AppendInner is like Inner, but appends the hash to dst and returns the
+// extended slice. The hops are given chaining values of their own, which do
+// not share memory with dst. On error, dst is returned unchanged.
+func (e *Encoder) AppendInner(dst []byte, hop Hop) ([]byte, error) {
+	sum, err := e.Inner(hop)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, sum...), nil
 }
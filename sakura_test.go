@@ -0,0 +1,173 @@
+package sakura_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/chlin501/sakura"
+	"github.com/chlin501/sakura/sakuratest"
+)
+
+// bare is a hop that is neither a chaining hop nor a message hop.
+type bare struct{}
+
+func (bare) ChainingValue() []byte   { return nil }
+func (bare) SetChainingValue([]byte) {}
+
+// chain is a chaining hop over the given children, keeping no chaining value.
+type chain []sakura.Hop
+
+func (c chain) Degree() int            { return len(c) }
+func (c chain) Child(i int) sakura.Hop { return c[i] }
+func (chain) ChainingValue() []byte    { return nil }
+func (chain) SetChainingValue([]byte)  {}
+
+// both is a hop claiming to be a chaining hop and a message hop at once.
+type both struct {
+	chain
+	io.Reader
+}
+
+// The pinned digests are those of the coded final nodes, hashed directly: the
+// byte 0x07 for the bits '11' of the empty message and the padding bit, and
+// the bytes 00 ff ff 06 for a count of zero, the interleaving block size of
+// NoInterleave and the bits '01' of the empty chaining hop.
+var emptyDigests = []struct {
+	name    string
+	mode    sakura.HashingMode
+	message string
+	chain   string
+}{
+	{
+		"Mode128", sakura.Mode128(),
+		"5223f7670b3b9ba04f57d477478ae77a58190d89f21da0b0be774735e23f9c96",
+		"dc758aef82dff753418fe81f0ccb20dbe8b12eac190c0d098d410ebbb72099d0",
+	},
+	{
+		"SHA-256", sakura.HashingMode{Hash: sha256.New, Interleave: sakura.NoInterleave},
+		"ca358758f6d27e6cf45272937977a748fd88391db679ceda7dc7bf1f005ee879",
+		"5c305451d1df3d50826c8b9fa068475cfff1beac8de97929a322517af35efcef",
+	},
+}
+
+func TestFinalEmpty(t *testing.T) {
+	for _, tc := range emptyDigests {
+		t.Run(tc.name, func(t *testing.T) {
+			e := sakura.New(tc.mode)
+			got, err := e.Final(sakura.GatherBytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(got) != tc.message {
+				t.Errorf("empty message: got %x, want %s", got, tc.message)
+			}
+			got, err = e.Final(chain(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(got) != tc.chain {
+				t.Errorf("empty chaining hop: got %x, want %s", got, tc.chain)
+			}
+		})
+	}
+}
+
+func TestFinalInvalidHop(t *testing.T) {
+	e := sakura.New(sakura.Mode128())
+	for name, hop := range map[string]sakura.Hop{
+		"nil":       nil,
+		"nil child": chain{sakura.GatherBytes([]byte("a")), nil},
+		"neither":   bare{},
+		"both":      both{chain{}, strings.NewReader("a")},
+		"deep":      chain{chain{chain{nil}}},
+	} {
+		if _, err := e.Final(hop); !errors.Is(err, sakura.ErrInvalidHop) {
+			t.Errorf("%s: got %v, want ErrInvalidHop", name, err)
+		}
+	}
+}
+
+// encoder returns an encoder in mode with the given parallelism.
+func encoder(t *testing.T, mode sakura.HashingMode, parallelism int) *sakura.Encoder {
+	t.Helper()
+	e, err := sakura.NewEncoder(sakura.WithMode(mode), sakura.WithParallelism(parallelism))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// splitLeaves cuts data into fresh message hops of leafSize bytes, one empty
+// leaf for no data.
+func splitLeaves(data []byte, leafSize int) []sakura.Hop {
+	var leaves []sakura.Hop
+	for len(data) > 0 {
+		n := min(leafSize, len(data))
+		leaves = append(leaves, sakura.GatherBytes(data[:n]))
+		data = data[n:]
+	}
+	if len(leaves) == 0 {
+		leaves = append(leaves, sakura.GatherBytes())
+	}
+	return leaves
+}
+
+// write feeds data to w in chunks of varying sizes, so that writes straddle
+// the leaves.
+func write(t *testing.T, w interface{ Write([]byte) (int, error) }, data []byte) {
+	t.Helper()
+	for i := 1; len(data) > 0; i = i*3%17 + 1 {
+		n := min(i*7, len(data))
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+}
+
+func TestRootEquivalence(t *testing.T) {
+	for seed := uint64(0); seed < 40; seed++ {
+		g := sakuratest.New(seed, sakuratest.Config{})
+		mode := g.Mode()
+		data := g.Bytes(3000)
+		leafSize := 1 + int(seed%7)*37
+		fanout := 2 + int(seed%4)
+
+		serial, err := sakura.New(mode).Final(sakura.BuildTree(splitLeaves(data, leafSize), fanout))
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		parallel, err := encoder(t, mode, 4).Final(sakura.BuildTree(splitLeaves(data, leafSize), fanout))
+		if err != nil {
+			t.Fatalf("seed %d: parallel: %v", seed, err)
+		}
+		if string(parallel) != string(serial) {
+			t.Errorf("seed %d: parallel root differs from the serial one", seed)
+		}
+		th := sakura.NewTreeHash(mode, leafSize, fanout, 3)
+		write(t, th, data)
+		if got := th.Sum(nil); string(got) != string(serial) {
+			t.Errorf("seed %d: TreeHash root differs from the serial one", seed)
+		}
+
+		// The Writer shape, of BuildTree with a fanout below 2.
+		chained, err := sakura.New(mode).Final(sakura.BuildTree(splitLeaves(data, leafSize), 0))
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		for _, p := range []int{1, 4} {
+			w := sakura.NewWriter(encoder(t, mode, p), leafSize)
+			write(t, w, data)
+			if err := w.Close(); err != nil {
+				t.Fatalf("seed %d: Writer: %v", seed, err)
+			}
+			if string(w.Root()) != string(chained) {
+				t.Errorf("seed %d: Writer with parallelism %d differs from BuildTree", seed, p)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package sakuracoding
+
+// BitString builds a bit string in the Keccak convention. Its methods match
+// those of sakura.BitWriter, so a sakura.Coding can write to it.
+type BitString struct {
+	b     []byte // Whole bytes, followed by the pending bits if nbits > 0.
+	nbits uint   // Number of bits in the last byte of b that are in use.
+}
+
+// Write appends whole bytes, each eight bits of the string.
+func (s *BitString) Write(p []byte) (int, error) {
+	if s.nbits == 0 {
+		s.b = append(s.b, p...)
+		return len(p), nil
+	}
+	for _, c := range p {
+		last := len(s.b) - 1
+		s.b[last] |= c << s.nbits
+		s.b = append(s.b, c>>(8-s.nbits))
+	}
+	return len(p), nil
+}
+
+// WriteBit appends the least significant bit of b.
+func (s *BitString) WriteBit(b byte) {
+	if s.nbits == 0 {
+		s.b = append(s.b, 0)
+	}
+	s.b[len(s.b)-1] |= (b & 1) << s.nbits
+	s.nbits = (s.nbits + 1) % 8
+}
+
+// Bits returns the number of bits in the string.
+func (s *BitString) Bits() int64 {
+	if s.nbits == 0 {
+		return int64(len(s.b)) * 8
+	}
+	return int64(len(s.b)-1)*8 + int64(s.nbits)
+}
+
+// PadSimple appends pad_simple, '1' followed by as many zeros as needed to
+// reach a multiple of align bytes, or only the next byte for an align below 2.
+func (s *BitString) PadSimple(align int) {
+	s.WriteBit(1)
+	s.nbits = 0
+	if align > 1 {
+		if r := len(s.b) % align; r != 0 {
+			s.b = append(s.b, make([]byte, align-r)...)
+		}
+	}
+}
+
+// Padded returns the string followed by '1' and zeros up to a byte boundary,
+// the bytes that are hashed for a node. It does not modify s.
+func (s *BitString) Padded() []byte {
+	b := append([]byte(nil), s.b...)
+	if s.nbits == 0 {
+		return append(b, 1)
+	}
+	b[len(b)-1] |= 1 << s.nbits
+	return b
+}
@@ -0,0 +1,81 @@
+// Package sakuracoding exports the low-level primitives with which sakura codes
+// the nodes of a tree, so that external tools such as formatters, dissectors
+// and bindings for other languages can reproduce the exact coding.
+//
+// Bit strings are mapped to bytes in the Keccak convention: bit i of the
+// string is bit i%8, counting from the least significant, of byte i/8. A node
+// is the concatenation of its hops followed by its frame bits:
+//
+//	final node    ::= node '1'
+//	inner node    ::= node '1' '0'
+//	message hop   ::= message bits '1'
+//	chaining hop  ::= CV* length_encode(nrCVs) mantissa exponent '0'
+//	kangaroo hop  ::= node pad_simple chaining hop
+//
+// and is padded with '1' and zeros to a byte boundary before it is hashed,
+// which BitString.Padded does.
+package sakuracoding
+
+import "errors"
+
+// Frame bits, which end the productions of the coding.
+const (
+	MessageBit  = 1 // Ends a message hop.
+	ChainingBit = 0 // Ends a chaining hop.
+	FinalBit    = 1 // Ends a final node.
+)
+
+// InnerBits end an inner node, in order: pad_simple, which needs no alignment
+// there, and the inner frame bit.
+var InnerBits = [2]byte{1, 0}
+
+// ErrMalformed is returned when parsing input that is not a valid coding.
+var ErrMalformed = errors.New("sakuracoding: malformed coding")
+
+// LengthEncode returns x in big-endian order using the fewest bytes, followed
+// by a byte holding the number of bytes used. Zero is coded as the single
+// byte 0.
+func LengthEncode(x uint64) []byte {
+	return AppendLengthEncode(nil, x)
+}
+
+// AppendLengthEncode appends the length encoding of x to dst.
+func AppendLengthEncode(dst []byte, x uint64) []byte {
+	n := 0
+	for v := x; v > 0; v >>= 8 {
+		n++
+	}
+	for i := n - 1; i >= 0; i-- {
+		dst = append(dst, byte(x>>(8*uint(i))))
+	}
+	return append(dst, byte(n))
+}
+
+// ParseLengthEncode parses a length encoding at the end of b, which is how a
+// chaining hop is read from its end, and returns the value and the bytes of b
+// that precede the encoding. It returns ErrMalformed if b is too short, the
+// value does not fit in 64 bits or is not coded with the fewest bytes.
+func ParseLengthEncode(b []byte) (x uint64, rest []byte, err error) {
+	if len(b) == 0 {
+		return 0, nil, ErrMalformed
+	}
+	n := int(b[len(b)-1])
+	if n > 8 || n >= len(b) {
+		return 0, nil, ErrMalformed
+	}
+	v := b[len(b)-1-n : len(b)-1]
+	if n > 0 && v[0] == 0 {
+		return 0, nil, ErrMalformed
+	}
+	for _, c := range v {
+		x = x<<8 | uint64(c)
+	}
+	return x, b[:len(b)-1-n], nil
+}
+
+// AppendChainingTrailer appends the bytes that follow the chaining values of a
+// chaining hop coding n of them: the length encoding of n and the mantissa and
+// exponent of the interleaving block size. The chaining frame bit follows.
+func AppendChainingTrailer(dst []byte, n uint64, mantissa, exponent byte) []byte {
+	return append(AppendLengthEncode(dst, n), mantissa, exponent)
+}
@@ -0,0 +1,108 @@
+package sakuracompact
+
+import "hash"
+
+// bitWriter streams the bit string of a node into a hash, as the bit writer of
+// sakura does: whole bytes are gathered in a buffer and handed to the hash in
+// batches, and writes larger than the buffer go to the hash directly.
+type bitWriter struct {
+	h     hash.Hash
+	n     int64 // Number of whole bytes written.
+	bits  byte  // Pending bits that do not yet form a whole byte.
+	nbits uint  // Number of pending bits.
+	nbuf  int   // Number of bytes in buf.
+	buf   [256]byte
+}
+
+// zeros holds the padding written by padSimple, whose alignment is at most
+// 255 bytes.
+var zeros [255]byte
+
+// writeByte appends a whole byte to the buffer.
+func (w *bitWriter) writeByte(b byte) {
+	if w.nbuf == len(w.buf) {
+		w.flush()
+	}
+	w.buf[w.nbuf] = b
+	w.nbuf++
+}
+
+// flush writes the buffered bytes to h.
+func (w *bitWriter) flush() {
+	if w.nbuf > 0 {
+		w.h.Write(w.buf[:w.nbuf])
+		w.nbuf = 0
+	}
+}
+
+// Write writes p to the bit string.
+func (w *bitWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	if w.nbits == 0 {
+		if w.nbuf+len(p) <= len(w.buf) {
+			w.nbuf += copy(w.buf[w.nbuf:], p)
+			return len(p), nil
+		}
+		w.flush()
+		return w.h.Write(p)
+	}
+	for _, b := range p {
+		w.writeByte(w.bits | b<<w.nbits)
+		w.bits = b >> (8 - w.nbits)
+	}
+	return len(p), nil
+}
+
+// WriteBit appends the least significant bit of b, for a Coding.
+func (w *bitWriter) WriteBit(b byte) { w.writeBit(b & 1) }
+
+// Bits returns the number of bits written.
+func (w *bitWriter) Bits() int64 { return w.n*8 + int64(w.nbits) }
+
+// writeBit appends a single bit, which must be 0 or 1.
+func (w *bitWriter) writeBit(b byte) {
+	w.bits |= b << w.nbits
+	w.nbits++
+	if w.nbits == 8 {
+		w.writeByte(w.bits)
+		w.n++
+		w.bits, w.nbits = 0, 0
+	}
+}
+
+// padSimple appends a '1' followed by as many zeros as needed to reach a
+// multiple of align bytes. An align of zero only pads to the next byte.
+func (w *bitWriter) padSimple(align int) {
+	w.writeBit(1)
+	for w.nbits != 0 {
+		w.writeBit(0)
+	}
+	if align > 1 {
+		if r := int(w.n % int64(align)); r != 0 {
+			w.Write(zeros[:align-r])
+		}
+	}
+}
+
+// sum terminates the bit string with pad, simple padding if nil, and appends
+// the hash to dst.
+func (w *bitWriter) sum(dst []byte, pad func(h hash.Hash, bits byte, n int)) []byte {
+	if pad != nil {
+		w.flush()
+		pad(w.h, w.bits, int(w.nbits))
+		w.bits, w.nbits = 0, 0
+		return w.h.Sum(dst)
+	}
+	w.writeBit(1)
+	for w.nbits != 0 {
+		w.writeBit(0)
+	}
+	w.flush()
+	return w.h.Sum(dst)
+}
+
+// reset empties w for the next node, keeping its hash.
+func (w *bitWriter) reset() {
+	w.h.Reset()
+	w.n, w.bits, w.nbits, w.nbuf = 0, 0, 0, 0
+}
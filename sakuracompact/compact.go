@@ -0,0 +1,217 @@
+// Package sakuracompact computes the roots of sakura Writers for constrained
+// targets such as TinyGo, embedded systems and WebAssembly in browsers. It
+// imports nothing beyond errors, hash and io of the standard library, and
+// sakuracoding, so that it builds wherever those do; scripts/tinygo.sh builds
+// it with TinyGo for WebAssembly and a microcontroller. The root package
+// offers the same Compact as sakura.Compact, for a sakura.HashingMode.
+package sakuracompact
+
+import (
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+// ErrCompactDone is returned by Compact.Write after Compact.Sum ended the
+// stream.
+var ErrCompactDone = errors.New("sakuracompact: stream already ended")
+
+// BlockSize is an interleaving block size, 2^Exponent * (2*Mantissa + 1)
+// bytes, as sakura.BlockSize, to which it converts.
+type BlockSize struct {
+	Mantissa uint8
+	Exponent uint8
+}
+
+// BitWriter receives the bit string of a node from a Coding. It has the
+// methods of sakura.BitWriter.
+type BitWriter interface {
+	io.Writer
+	WriteBit(b byte) // Appends the least significant bit of b.
+	Bits() int64     // Returns the number of bits written so far.
+}
+
+// Coding codes the frame bits of nodes, as a sakura.Coding does: Message after
+// the n bytes of a message hop, Chaining after the n chaining values of a
+// chaining hop, Kangaroo between a nested node and the chaining hop that
+// follows it, and Final or Inner after the whole node.
+type Coding interface {
+	Message(w BitWriter, n int64)
+	Chaining(w BitWriter, n int, interleave BlockSize)
+	Kangaroo(w BitWriter, alignment int)
+	Final(w BitWriter)
+	Inner(w BitWriter)
+}
+
+// Mode is the hashing mode of a Compact, the fields of a sakura.HashingMode
+// that a Compact uses.
+type Mode struct {
+	Hash       func() hash.Hash
+	Kangaroo   bool
+	Alignment  uint8
+	Interleave BlockSize
+
+	// Coding is the coding of the frame bits, that of the Sakura paper if
+	// nil, and Pad turns the bits of a node into bytes for the hash function,
+	// with simple padding if nil.
+	Coding Coding
+	Pad    func(h hash.Hash, bits byte, n int)
+}
+
+// Compact computes the root that a sakura.Writer with the same leaf size
+// computes for a stream. It starts no goroutines, uses no reflection, and
+// allocates all its buffers when created: the chaining values of the leaves
+// are streamed into the final node as they complete instead of being kept, so
+// that its memory does not grow with the stream. It takes none of the options
+// of a sakura.Encoder, whose features need more than that.
+//
+// A Compact is not safe for concurrent use.
+type Compact struct {
+	mode     Mode
+	leafSize int
+	buf      []byte // Current leaf.
+	first    []byte // First leaf, until a second one begins.
+	leaves   int    // Number of leaves begun.
+	values   int    // Number of chaining values coded in the final node.
+	leaf     bitWriter
+	final    bitWriter
+	cv       []byte // Chaining value of the last leaf.
+	root     []byte
+	trailer  []byte
+	done     bool
+}
+
+// New returns a Compact for mode and leaves of leafSize bytes. It fails if
+// the mode has no hash function or leafSize is not positive.
+func New(mode Mode, leafSize int) (*Compact, error) {
+	if leafSize <= 0 {
+		return nil, errors.New("sakuracompact: non-positive leaf size")
+	}
+	if mode.Hash == nil {
+		return nil, errors.New("sakuracompact: mode has no hash function")
+	}
+	c := &Compact{mode: mode, leafSize: leafSize}
+	c.buf = make([]byte, 0, leafSize)
+	c.first = make([]byte, 0, leafSize)
+	c.leaf.h, c.final.h = mode.Hash(), mode.Hash()
+	c.cv = make([]byte, 0, c.leaf.h.Size())
+	c.root = make([]byte, 0, c.leaf.h.Size())
+	c.trailer = make([]byte, 0, 32)
+	return c, nil
+}
+
+// Write adds p to the stream.
+func (c *Compact) Write(p []byte) (int, error) {
+	if c.done {
+		return 0, ErrCompactDone
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(c.buf) == c.leafSize || c.leaves == 0 {
+			// The current leaf is followed by more data.
+			c.next()
+		}
+		k := min(c.leafSize-len(c.buf), len(p))
+		c.buf = append(c.buf, p[:k]...)
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// next completes the current leaf, if any, and begins the next.
+func (c *Compact) next() {
+	switch c.leaves {
+	case 0:
+	case 1:
+		c.first = append(c.first[:0], c.buf...)
+	case 2:
+		c.begin()
+		c.value(c.buf)
+	default:
+		c.value(c.buf)
+	}
+	c.buf = c.buf[:0]
+	c.leaves++
+}
+
+// begin begins the final node with the first leaf, once a second leaf ends
+// and the final node is known to be a chaining node.
+func (c *Compact) begin() {
+	if !c.mode.Kangaroo {
+		c.value(c.first)
+		return
+	}
+	c.writeLeaf(&c.final, c.first)
+	if cd := c.mode.Coding; cd != nil {
+		cd.Kangaroo(&c.final, int(c.mode.Alignment))
+	} else {
+		c.final.padSimple(int(c.mode.Alignment))
+	}
+}
+
+// value codes the chaining value of leaf data in the final node.
+func (c *Compact) value(data []byte) {
+	c.writeLeaf(&c.leaf, data)
+	if cd := c.mode.Coding; cd != nil {
+		cd.Inner(&c.leaf)
+	} else {
+		c.leaf.writeBit(sakuracoding.InnerBits[0])
+		c.leaf.writeBit(sakuracoding.InnerBits[1])
+	}
+	c.cv = c.leaf.sum(c.cv[:0], c.mode.Pad)
+	c.leaf.reset()
+	c.final.Write(c.cv)
+	c.values++
+}
+
+// writeLeaf writes the message hop of a leaf holding data to w.
+func (c *Compact) writeLeaf(w *bitWriter, data []byte) {
+	w.Write(data)
+	if cd := c.mode.Coding; cd != nil {
+		cd.Message(w, int64(len(data)))
+	} else {
+		w.writeBit(sakuracoding.MessageBit)
+	}
+}
+
+// Sum ends the stream and appends its root to b. Later calls append the same
+// root until Reset.
+func (c *Compact) Sum(b []byte) []byte {
+	if c.done {
+		return append(b, c.root...)
+	}
+	c.done = true
+	if c.leaves <= 1 {
+		c.writeLeaf(&c.final, c.buf)
+	} else {
+		if c.leaves == 2 {
+			c.begin()
+		}
+		c.value(c.buf)
+		if cd := c.mode.Coding; cd != nil {
+			cd.Chaining(&c.final, c.values, c.mode.Interleave)
+		} else {
+			il := c.mode.Interleave
+			c.trailer = sakuracoding.AppendChainingTrailer(c.trailer[:0], uint64(c.values), il.Mantissa, il.Exponent)
+			c.final.Write(c.trailer)
+			c.final.writeBit(sakuracoding.ChainingBit)
+		}
+	}
+	if cd := c.mode.Coding; cd != nil {
+		cd.Final(&c.final)
+	} else {
+		c.final.writeBit(sakuracoding.FinalBit)
+	}
+	c.root = c.final.sum(c.root[:0], c.mode.Pad)
+	return append(b, c.root...)
+}
+
+// Reset starts a new stream, reusing the buffers of c.
+func (c *Compact) Reset() {
+	c.buf, c.first = c.buf[:0], c.first[:0]
+	c.leaves, c.values, c.done = 0, 0, false
+	c.leaf.reset()
+	c.final.reset()
+}
@@ -0,0 +1,50 @@
+package sakuracompact_test
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"hash"
+	"testing"
+
+	"github.com/chlin501/sakura"
+	"github.com/chlin501/sakura/sakuracompact"
+)
+
+func TestCompact(t *testing.T) {
+	none := sakuracompact.BlockSize(sakura.NoInterleave)
+	modes := []struct {
+		name    string
+		mode    sakuracompact.Mode
+		writers sakura.HashingMode
+	}{
+		{"Mode128", sakuracompact.Mode{Hash: func() hash.Hash { return sha3.New256() }, Kangaroo: true, Alignment: 8, Interleave: none}, sakura.Mode128()},
+		{"SHA-256", sakuracompact.Mode{Hash: sha256.New, Interleave: none},
+			sakura.HashingMode{Hash: sha256.New, Interleave: sakura.NoInterleave}},
+	}
+	for _, m := range modes {
+		for _, size := range []int{0, 1, 63, 64, 65, 128, 1000} {
+			data := sakura.Pattern(size)
+			w := sakura.NewWriter(sakura.New(m.writers), 64)
+			if _, err := w.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			c, err := sakuracompact.New(m.mode, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.Write(data)
+			if got := c.Sum(nil); string(got) != string(w.Root()) {
+				t.Errorf("%s, %d bytes: root differs from the Writer one", m.name, size)
+			}
+		}
+	}
+	if _, err := sakuracompact.New(sakuracompact.Mode{}, 64); err == nil {
+		t.Error("New succeeded without a hash function")
+	}
+	if _, err := sakuracompact.New(modes[0].mode, 0); err == nil {
+		t.Error("New succeeded with a leaf size of zero")
+	}
+}
@@ -0,0 +1,26 @@
+// Command tinygo prints the root of its standard input in the mode of Mode128
+// with SHA-256 for SHA3-256, in leaves of 8 KiB, as hashed by sakuracompact. It is the program that
+// scripts/tinygo.sh builds with TinyGo, so that the package is known to build
+// there.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/chlin501/sakura/sakuracompact"
+)
+
+func main() {
+	c, err := sakuracompact.New(sakuracompact.Mode{Hash: sha256.New, Kangaroo: true, Alignment: 8, Interleave: sakuracompact.BlockSize{Mantissa: 0xff, Exponent: 0xff}}, 8192)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := io.Copy(c, os.Stdin); err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		os.Exit(1)
+	}
+	os.Stdout.WriteString(hex.EncodeToString(c.Sum(nil)) + "\n")
+}
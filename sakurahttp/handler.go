@@ -0,0 +1,170 @@
+// Package sakurahttp serves the roots and proofs of a Sakura tree over HTTP,
+// so that clients can verify data against a tree without access to it.
+//
+// The handler answers GET requests with JSON objects. Byte strings are
+// encoded in standard base64:
+//
+//	GET /root
+//		{"root": "...", "size": 42}
+//	GET /proof/inclusion?leaf=3&size=42
+//		{"leaf": 3, "size": 42, "proof": "..."}
+//	GET /proof/consistency?old=17&new=42
+//		{"old": 17, "new": 42, "proof": "..."}
+//
+// Errors are reported as {"error": "..."} with a 4xx or 5xx status.
+//
+// MMRSource serves a sakura.MMR, an append-only range of leaves, with
+// inclusion proofs of its leaves and consistency proofs between its sizes;
+// clients check them with VerifyInclusion and VerifyConsistency against roots
+// they trust, such as those of signed tree heads.
+package sakurahttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// ErrNotFound may be returned by a Source for leaves or sizes that the tree
+// does not have. The handler reports it with status 404.
+var ErrNotFound = errors.New("sakurahttp: not found")
+
+// Source provides the data served by a Handler. Proofs are served in their
+// serialized form, whatever their format; MMRSource is the Source of a
+// sakura.MMR.
+type Source interface {
+	// Root returns the current root and the number of leaves it covers.
+	Root() (root []byte, size uint64, err error)
+
+	// InclusionProof returns a proof that the leaf at the given index is part
+	// of the tree of the given size.
+	InclusionProof(leaf, size uint64) ([]byte, error)
+
+	// ConsistencyProof returns a proof that the tree of size old is a prefix
+	// of the tree of size new.
+	ConsistencyProof(old, new uint64) ([]byte, error)
+}
+
+// Handler serves a Source over HTTP.
+type Handler struct {
+	src Source
+	mux *http.ServeMux
+}
+
+// NewHandler returns a handler serving src.
+func NewHandler(src Source) *Handler {
+	h := &Handler{src: src, mux: http.NewServeMux()}
+	// Methods are checked by get rather than by the patterns of the mux,
+	// which builds with GODEBUG httpmuxgo121=1 would take for paths.
+	h.mux.HandleFunc("/root", get(h.root))
+	h.mux.HandleFunc("/proof/inclusion", get(h.inclusion))
+	h.mux.HandleFunc("/proof/consistency", get(h.consistency))
+	return h
+}
+
+// get returns a handler that serves GET and HEAD requests with f.
+func get(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeStatus(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		f(w, r)
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) root(w http.ResponseWriter, r *http.Request) {
+	root, size, err := h.src.Root()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, struct {
+		Root []byte `json:"root"`
+		Size uint64 `json:"size"`
+	}{root, size})
+}
+
+func (h *Handler) inclusion(w http.ResponseWriter, r *http.Request) {
+	leaf, err1 := uintParam(r, "leaf")
+	size, err2 := uintParam(r, "size")
+	if err := errors.Join(err1, err2); err != nil {
+		writeStatus(w, http.StatusBadRequest, err)
+		return
+	}
+	if leaf >= size {
+		writeStatus(w, http.StatusBadRequest, errors.New("leaf is outside the tree"))
+		return
+	}
+	proof, err := h.src.InclusionProof(leaf, size)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, struct {
+		Leaf  uint64 `json:"leaf"`
+		Size  uint64 `json:"size"`
+		Proof []byte `json:"proof"`
+	}{leaf, size, proof})
+}
+
+func (h *Handler) consistency(w http.ResponseWriter, r *http.Request) {
+	old, err1 := uintParam(r, "old")
+	nw, err2 := uintParam(r, "new")
+	if err := errors.Join(err1, err2); err != nil {
+		writeStatus(w, http.StatusBadRequest, err)
+		return
+	}
+	if old > nw {
+		writeStatus(w, http.StatusBadRequest, errors.New("old size exceeds new size"))
+		return
+	}
+	proof, err := h.src.ConsistencyProof(old, nw)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, struct {
+		Old   uint64 `json:"old"`
+		New   uint64 `json:"new"`
+		Proof []byte `json:"proof"`
+	}{old, nw, proof})
+}
+
+// uintParam parses the query parameter of the given name.
+func uintParam(r *http.Request, name string) (uint64, error) {
+	v, err := strconv.ParseUint(r.URL.Query().Get(name), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid or missing parameter " + strconv.Quote(name))
+	}
+	return v, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError reports an error returned by the source.
+func writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeStatus(w, http.StatusNotFound, err)
+		return
+	}
+	writeStatus(w, http.StatusInternalServerError, err)
+}
+
+func writeStatus(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,110 @@
+package sakurahttp_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chlin501/sakura"
+	"github.com/chlin501/sakura/sakurahttp"
+)
+
+// get fetches path from srv and decodes the JSON response into v, returning
+// the status.
+func get(t *testing.T, srv *httptest.Server, path string, v any) int {
+	t.Helper()
+	resp, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func TestMMRSource(t *testing.T) {
+	mode := sakura.Mode128()
+	m, err := sakura.NewMMR(sakura.New(mode))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := sakurahttp.NewMMRSource(m)
+	srv := httptest.NewServer(sakurahttp.NewHandler(src))
+	defer srv.Close()
+
+	leaf := func(i int) []byte { return []byte(fmt.Sprintf("entry %d", i)) }
+	roots := [][]byte{m.Root()}
+	for i := 0; i < 12; i++ {
+		if _, err := src.Append(leaf(i)); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, m.Root())
+	}
+
+	var head struct {
+		Root []byte
+		Size uint64
+	}
+	if status := get(t, srv, "/root", &head); status != http.StatusOK || head.Size != 12 || string(head.Root) != string(roots[12]) {
+		t.Fatalf("GET /root: status %d, size %d", status, head.Size)
+	}
+
+	for size := uint64(1); size <= 12; size++ {
+		for i := uint64(0); i < size; i++ {
+			var inc struct{ Proof []byte }
+			path := fmt.Sprintf("/proof/inclusion?leaf=%d&size=%d", i, size)
+			if status := get(t, srv, path, &inc); status != http.StatusOK {
+				t.Fatalf("GET %s: status %d", path, status)
+			}
+			if err := sakurahttp.VerifyInclusion(mode, roots[size], size, i, leaf(int(i)), inc.Proof); err != nil {
+				t.Fatalf("VerifyInclusion(%d, %d): %v", i, size, err)
+			}
+			if err := sakurahttp.VerifyInclusion(mode, roots[size], size, i, []byte("forged"), inc.Proof); !errors.Is(err, sakura.ErrProofMismatch) {
+				t.Fatalf("forged leaf (%d, %d): got %v", i, size, err)
+			}
+		}
+	}
+
+	for old := uint64(0); old <= 12; old++ {
+		for size := old; size <= 12; size++ {
+			var con struct{ Proof []byte }
+			path := fmt.Sprintf("/proof/consistency?old=%d&new=%d", old, size)
+			if status := get(t, srv, path, &con); status != http.StatusOK {
+				t.Fatalf("GET %s: status %d", path, status)
+			}
+			if err := sakurahttp.VerifyConsistency(mode, roots[old], old, roots[size], size, con.Proof); err != nil {
+				t.Fatalf("VerifyConsistency(%d, %d): %v", old, size, err)
+			}
+		}
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	m, err := sakura.NewMMR(sakura.New(sakura.Mode128()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := sakurahttp.NewMMRSource(m)
+	src.Append([]byte("only"))
+	srv := httptest.NewServer(sakurahttp.NewHandler(src))
+	defer srv.Close()
+
+	for path, want := range map[string]int{
+		"/proof/inclusion?leaf=0&size=2":  http.StatusNotFound,
+		"/proof/inclusion?leaf=1&size=1":  http.StatusBadRequest,
+		"/proof/inclusion?leaf=x&size=1":  http.StatusBadRequest,
+		"/proof/consistency?old=2&new=1":  http.StatusBadRequest,
+		"/proof/consistency?old=0&new=2":  http.StatusNotFound,
+		"/proof/consistency?old=0&new=-1": http.StatusBadRequest,
+	} {
+		var body struct{ Error string }
+		if status := get(t, srv, path, &body); status != want || body.Error == "" {
+			t.Errorf("GET %s: status %d, error %q; want status %d", path, status, body.Error, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package sakurahttp
+
+import (
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/chlin501/sakura"
+)
+
+// MMRSource is a Source over a sakura.MMR. Its leaves are the leaves of the
+// range and its sizes numbers of leaves; inclusion proofs are encoded by
+// sakura.Proof.MarshalBinary and consistency proofs by
+// sakura.ConsistencyProof.MarshalBinary, and VerifyInclusion and
+// VerifyConsistency check them. Proofs are served for every size the range
+// has had, against the root it had then.
+//
+// An MMRSource is safe for concurrent use, so the range must only be appended
+// to through it while it is served.
+type MMRSource struct {
+	mu sync.Mutex
+	m  *sakura.MMR
+}
+
+// NewMMRSource returns a source serving m.
+func NewMMRSource(m *sakura.MMR) *MMRSource {
+	return &MMRSource{m: m}
+}
+
+// Append adds data as the last leaf of the range, as sakura.MMR.Append does.
+func (s *MMRSource) Append(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m.Append(data)
+}
+
+// Root implements Source.
+func (s *MMRSource) Root() ([]byte, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m.Root(), uint64(s.m.Len()), nil
+}
+
+// InclusionProof implements Source. It returns ErrNotFound for sizes beyond
+// the range, and for leaves whose proof needs values that the range forgot
+// when it was resumed from its peaks.
+func (s *MMRSource) InclusionProof(leaf, size uint64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size > uint64(s.m.Len()) || leaf >= size {
+		return nil, ErrNotFound
+	}
+	p, err := s.m.ProveAt(int(leaf), int(size))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return p.MarshalBinary()
+}
+
+// ConsistencyProof implements Source. It returns ErrNotFound as
+// InclusionProof does.
+func (s *MMRSource) ConsistencyProof(old, new uint64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if new > uint64(s.m.Len()) || old > new {
+		return nil, ErrNotFound
+	}
+	p, err := s.m.ProveConsistency(int(old), int(new))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return p.MarshalBinary()
+}
+
+// notFound reports the proofs that a pruned range cannot make with
+// ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sakura.ErrPruned) {
+		return errors.Join(ErrNotFound, err)
+	}
+	return err
+}
+
+// VerifyInclusion checks that leaf of the range of size leaves whose root,
+// hashed in mode, is root holds the given message bits, using a proof served
+// by an MMRSource, as sakura.VerifyMMRLeaf does. The root and size must come
+// from the client, such as from a signed tree head, rather than from the same
+// response as the proof.
+func VerifyInclusion(mode sakura.HashingMode, root []byte, size, leaf uint64, data, proof []byte) error {
+	if size > math.MaxInt || leaf >= size {
+		return sakura.ErrMalformedProof
+	}
+	p, err := sakura.DefaultDecodeLimits.UnmarshalProof(proof)
+	if err != nil {
+		return err
+	}
+	return sakura.VerifyMMRLeaf(mode, root, int(size), int(leaf), data, p)
+}
+
+// VerifyConsistency checks that the range of old leaves whose root, hashed in
+// mode, is oldRoot is a prefix of the range of new leaves whose root is root,
+// using a proof served by an MMRSource, as sakura.VerifyMMRConsistency does.
+func VerifyConsistency(mode sakura.HashingMode, oldRoot []byte, old uint64, root []byte, new uint64, proof []byte) error {
+	if new > math.MaxInt || old > new {
+		return sakura.ErrMalformedProof
+	}
+	p, err := sakura.DefaultDecodeLimits.UnmarshalConsistencyProof(proof)
+	if err != nil {
+		return err
+	}
+	return sakura.VerifyMMRConsistency(mode, oldRoot, int(old), root, int(new), p)
+}
@@ -0,0 +1,47 @@
+package sakurahttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Ranger reads byte ranges of the object at a URL with Range requests. It
+// implements sakura.Ranger, so that objects served by any HTTP server or
+// object store supporting Range requests can be hashed with
+// Encoder.HashRanger or read through a sakura.RemoteVerifier.
+type Ranger struct {
+	URL    string
+	Client *http.Client // Client used for requests, or nil for http.DefaultClient.
+}
+
+// ReadRange requests the n bytes starting at off. It fails unless the server
+// answers with the requested range.
+func (r *Ranger) ReadRange(off, n int64) (io.ReadCloser, error) {
+	if n == 0 {
+		return io.NopCloser(http.NoBody), nil
+	}
+	req, err := http.NewRequest(http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("sakurahttp: range request for %s: %s", r.URL, resp.Status)
+	}
+	var start int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != off {
+		resp.Body.Close()
+		return nil, fmt.Errorf("sakurahttp: range request for %s: unexpected Content-Range %q", r.URL, resp.Header.Get("Content-Range"))
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,12 @@
+// Package sakurapb is the gRPC verification service of sakura, with the Hash,
+// GetProof and VerifyProof methods described in sakura.proto, for deployments
+// that run sakura as a sidecar rather than linking it. Server implements the
+// service with an encoder and a node store.
+//
+// The Go bindings are generated with protoc and the protoc-gen-go and
+// protoc-gen-go-grpc plugins, and depend on google.golang.org/grpc and
+// google.golang.org/protobuf; run go generate in this directory after editing
+// sakura.proto.
+package sakurapb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sakura.proto
@@ -0,0 +1,203 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: sakura.proto
+
+package sakurapb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Verification_Hash_FullMethodName        = "/sakura.v1.Verification/Hash"
+	Verification_GetProof_FullMethodName    = "/sakura.v1.Verification/GetProof"
+	Verification_VerifyProof_FullMethodName = "/sakura.v1.Verification/VerifyProof"
+)
+
+// VerificationClient is the client API for Verification service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Verification exposes a Sakura encoder and tree store as a service, for
+// deployments that run sakura as a sidecar rather than linking it.
+type VerificationClient interface {
+	// Hash returns the root of the streamed data.
+	Hash(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[HashRequest, HashResponse], error)
+	// GetProof returns an inclusion proof for a leaf of a stored tree.
+	GetProof(ctx context.Context, in *GetProofRequest, opts ...grpc.CallOption) (*GetProofResponse, error)
+	// VerifyProof checks a leaf against a root with an inclusion proof.
+	VerifyProof(ctx context.Context, in *VerifyProofRequest, opts ...grpc.CallOption) (*VerifyProofResponse, error)
+}
+
+type verificationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVerificationClient(cc grpc.ClientConnInterface) VerificationClient {
+	return &verificationClient{cc}
+}
+
+func (c *verificationClient) Hash(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[HashRequest, HashResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Verification_ServiceDesc.Streams[0], Verification_Hash_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[HashRequest, HashResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Verification_HashClient = grpc.ClientStreamingClient[HashRequest, HashResponse]
+
+func (c *verificationClient) GetProof(ctx context.Context, in *GetProofRequest, opts ...grpc.CallOption) (*GetProofResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetProofResponse)
+	err := c.cc.Invoke(ctx, Verification_GetProof_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *verificationClient) VerifyProof(ctx context.Context, in *VerifyProofRequest, opts ...grpc.CallOption) (*VerifyProofResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyProofResponse)
+	err := c.cc.Invoke(ctx, Verification_VerifyProof_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VerificationServer is the server API for Verification service.
+// All implementations must embed UnimplementedVerificationServer
+// for forward compatibility.
+//
+// Verification exposes a Sakura encoder and tree store as a service, for
+// deployments that run sakura as a sidecar rather than linking it.
+type VerificationServer interface {
+	// Hash returns the root of the streamed data.
+	Hash(grpc.ClientStreamingServer[HashRequest, HashResponse]) error
+	// GetProof returns an inclusion proof for a leaf of a stored tree.
+	GetProof(context.Context, *GetProofRequest) (*GetProofResponse, error)
+	// VerifyProof checks a leaf against a root with an inclusion proof.
+	VerifyProof(context.Context, *VerifyProofRequest) (*VerifyProofResponse, error)
+	mustEmbedUnimplementedVerificationServer()
+}
+
+// UnimplementedVerificationServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedVerificationServer struct{}
+
+func (UnimplementedVerificationServer) Hash(grpc.ClientStreamingServer[HashRequest, HashResponse]) error {
+	return status.Error(codes.Unimplemented, "method Hash not implemented")
+}
+func (UnimplementedVerificationServer) GetProof(context.Context, *GetProofRequest) (*GetProofResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetProof not implemented")
+}
+func (UnimplementedVerificationServer) VerifyProof(context.Context, *VerifyProofRequest) (*VerifyProofResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method VerifyProof not implemented")
+}
+func (UnimplementedVerificationServer) mustEmbedUnimplementedVerificationServer() {}
+func (UnimplementedVerificationServer) testEmbeddedByValue()                      {}
+
+// UnsafeVerificationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VerificationServer will
+// result in compilation errors.
+type UnsafeVerificationServer interface {
+	mustEmbedUnimplementedVerificationServer()
+}
+
+func RegisterVerificationServer(s grpc.ServiceRegistrar, srv VerificationServer) {
+	// If the following call panics, it indicates UnimplementedVerificationServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Verification_ServiceDesc, srv)
+}
+
+func _Verification_Hash_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(VerificationServer).Hash(&grpc.GenericServerStream[HashRequest, HashResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Verification_HashServer = grpc.ClientStreamingServer[HashRequest, HashResponse]
+
+func _Verification_GetProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetProofRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VerificationServer).GetProof(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Verification_GetProof_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VerificationServer).GetProof(ctx, req.(*GetProofRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Verification_VerifyProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyProofRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VerificationServer).VerifyProof(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Verification_VerifyProof_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VerificationServer).VerifyProof(ctx, req.(*VerifyProofRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Verification_ServiceDesc is the grpc.ServiceDesc for Verification service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Verification_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sakura.v1.Verification",
+	HandlerType: (*VerificationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetProof",
+			Handler:    _Verification_GetProof_Handler,
+		},
+		{
+			MethodName: "VerifyProof",
+			Handler:    _Verification_VerifyProof_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Hash",
+			Handler:       _Verification_Hash_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "sakura.proto",
+}
@@ -0,0 +1,240 @@
+package sakurapb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"time"
+
+	"github.com/chlin501/sakura"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements VerificationServer with a sakura.Encoder and a
+// sakura.NodeStore for the trees that it serves proofs of.
+//
+// Hash cuts the streamed data into leaves of a fixed size and returns the root
+// of the tree that sakura.BuildTree builds over them; given a store, it also
+// saves the tree there, as a tree file of Encoder.MarshalTree under its root,
+// so that GetProof can prove its leaves later. GetProof reads the tree of a
+// root back with DecodeLimits.ReadTree and proves a leaf with
+// Encoder.ProveLeaf, counting leaves from zero, and VerifyProof checks a
+// proof with sakura.VerifyProof. Proofs are encoded by
+// sakura.Proof.MarshalBinary.
+//
+// Hash applies the limits of the Encoder to the tree of the streamed data as
+// it grows: MaxBytes, MaxLeaves, MaxDepth, MaxDegree and MaxNodeBytes fail the
+// call with codes.ResourceExhausted once the data exceeds them, and
+// BytesPerSecond paces the chunks received.
+type Server struct {
+	UnimplementedVerificationServer
+
+	e        *sakura.Encoder
+	store    sakura.NodeStore
+	leafSize int
+	fanout   int
+
+	// Limits bound the proofs that VerifyProof decodes and the trees that
+	// GetProof reads, sakura.DefaultDecodeLimits for a new server.
+	Limits sakura.DecodeLimits
+
+	// MaxBytes, if positive, limits the data of a Hash call, like the
+	// MaxBytes of the encoder, which bounds it too. A new server with a store
+	// sets it to DefaultMaxBytes, as it holds the data of a call in memory.
+	MaxBytes int64
+}
+
+// DefaultMaxBytes is the MaxBytes of a new server with a store.
+const DefaultMaxBytes = 64 << 20
+
+// NewServer returns a server hashing with e, in leaves of leafSize bytes
+// grouped fanout at a time, and keeping trees in store. Without a store, Hash
+// streams the data through a sakura.TreeHash in memory bounded by the shape
+// of the tree, while with one it holds the data of a call in memory to write
+// its tree, and GetProof fails with codes.FailedPrecondition. It panics if
+// leafSize is not positive or fanout is below 2, as sakura.NewTreeHash does.
+func NewServer(e *sakura.Encoder, leafSize, fanout int, store sakura.NodeStore) *Server {
+	if leafSize <= 0 {
+		panic("sakurapb: non-positive leaf size")
+	}
+	if fanout < 2 {
+		panic("sakurapb: fanout below 2")
+	}
+	s := &Server{e: e, store: store, leafSize: leafSize, fanout: fanout, Limits: sakura.DefaultDecodeLimits}
+	if store != nil {
+		s.MaxBytes = DefaultMaxBytes
+	}
+	return s
+}
+
+// Hash implements VerificationServer.
+func (s *Server) Hash(stream Verification_HashServer) error {
+	th := sakura.NewTreeHash(s.e.Mode(), s.leafSize, s.fanout, s.e.Parallelism)
+	var data []byte // The data of the call, to store its tree.
+	var size uint64
+	start := time.Now()
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		size += uint64(len(req.Chunk))
+		if err := s.checkSize(int64(size)); err != nil {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+		if err := s.pace(stream.Context(), start, size); err != nil {
+			return status.FromContextError(err).Err()
+		}
+		th.Write(req.Chunk)
+		if s.store != nil {
+			data = append(data, req.Chunk...)
+		}
+	}
+	root := th.Sum(nil)
+	if s.store != nil {
+		if err := s.save(root, data); err != nil {
+			return status.Errorf(codes.Internal, "storing tree: %v", err)
+		}
+	}
+	return stream.SendAndClose(&HashResponse{Root: root, Size: size})
+}
+
+// checkSize returns a *sakura.LimitError or *sakura.NodeSizeError if the tree
+// of size bytes that Hash builds exceeds the limits of the server or its
+// encoder. The largest node of the tree is the root, which with kangaroo
+// hopping codes the first leaf and the chaining values of the other children
+// of every hop on the way down to it.
+func (s *Server) checkSize(size int64) error {
+	for _, max := range []int64{s.MaxBytes, s.e.MaxBytes} {
+		if max > 0 && size > max {
+			return &sakura.LimitError{Node: sakura.NodeID{}, Limit: "bytes", Max: max, Value: size}
+		}
+	}
+	leaves := max(1, (size+int64(s.leafSize)-1)/int64(s.leafSize))
+	if max := s.e.MaxLeaves; max > 0 && leaves > int64(max) {
+		return &sakura.LimitError{Node: sakura.NodeID{}, Limit: "leaves", Max: int64(max), Value: leaves}
+	}
+	depth, values := 0, int64(0)
+	for w := leaves; w > 1; w = (w + int64(s.fanout) - 1) / int64(s.fanout) {
+		depth++
+		values += min(w, int64(s.fanout))
+	}
+	if max := s.e.MaxDepth; max > 0 && depth > max {
+		return &sakura.LimitError{Node: sakura.NodeID{}, Limit: "depth", Max: int64(max), Value: int64(depth)}
+	}
+	if depth == 0 {
+		return nil
+	}
+	degree := min(leaves, int64(s.fanout))
+	if max := s.e.MaxDegree; max > 0 && degree > int64(max) {
+		return &sakura.LimitError{Node: sakura.NodeID{}, Limit: "degree", Max: int64(max), Value: degree}
+	}
+	mode := s.e.Mode()
+	if max := s.e.MaxNodeBytes; max > 0 && mode.Hash != nil {
+		first := int64(0)
+		if !mode.Kangaroo {
+			values = degree
+		} else {
+			values -= int64(depth)
+			first = int64(s.leafSize)
+		}
+		if n := values*int64(mode.Hash().Size()) + first; n > max {
+			return &sakura.NodeSizeError{Node: sakura.NodeID{}, Degree: int(degree), Size: n, Max: max}
+		}
+	}
+	return nil
+}
+
+// pace waits until the size bytes received since start are within the
+// BytesPerSecond of the encoder, or ctx is done.
+func (s *Server) pace(ctx context.Context, start time.Time, size uint64) error {
+	rate := s.e.BytesPerSecond
+	if rate <= 0 {
+		return nil
+	}
+	d := time.Until(start.Add(time.Duration(float64(size) / float64(rate) * float64(time.Second))))
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// save stores the tree of data, whose root is root.
+func (s *Server) save(root, data []byte) error {
+	var leaves []sakura.Hop
+	for len(data) > 0 {
+		n := min(s.leafSize, len(data))
+		leaves = append(leaves, sakura.GatherBytes(data[:n]))
+		data = data[n:]
+	}
+	if len(leaves) == 0 {
+		leaves = append(leaves, sakura.GatherBytes()) // The empty stream.
+	}
+	b, err := s.e.MarshalTree(sakura.BuildTree(leaves, s.fanout))
+	if err != nil {
+		return err
+	}
+	return s.store.Put(root, b)
+}
+
+// GetProof implements VerificationServer. It fails with codes.NotFound if the
+// store holds no tree of the root, or the tree has no such leaf.
+func (s *Server) GetProof(ctx context.Context, req *GetProofRequest) (*GetProofResponse, error) {
+	if s.store == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server has no tree store")
+	}
+	b, err := s.store.Get(req.Root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, status.Error(codes.NotFound, "no tree with this root")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "reading tree: %v", err)
+	}
+	tree, err := s.Limits.ReadTree(bytes.NewReader(b), s.e.Mode())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "reading tree: %v", err)
+	}
+	if req.Leaf > math.MaxInt {
+		return nil, status.Error(codes.NotFound, "no such leaf")
+	}
+	p, err := s.e.ProveLeaf(tree, int(req.Leaf))
+	if errors.Is(err, sakura.ErrInvalidNodeID) {
+		return nil, status.Error(codes.NotFound, "no such leaf")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "proving leaf: %v", err)
+	}
+	proof, err := p.MarshalBinary()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "encoding proof: %v", err)
+	}
+	return &GetProofResponse{Proof: proof}, nil
+}
+
+// VerifyProof implements VerificationServer. A proof that does not verify,
+// including one that does not decode, is reported in the response, not as an
+// error.
+func (s *Server) VerifyProof(ctx context.Context, req *VerifyProofRequest) (*VerifyProofResponse, error) {
+	p, err := s.Limits.UnmarshalProof(req.Proof)
+	if err == nil {
+		err = sakura.VerifyProof(s.e.Mode(), req.Root, p, req.Leaf)
+	}
+	if err != nil {
+		return &VerifyProofResponse{Reason: err.Error()}, nil
+	}
+	return &VerifyProofResponse{Valid: true}, nil
+}
@@ -0,0 +1,197 @@
+package sakurapb_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/chlin501/sakura"
+	"github.com/chlin501/sakura/sakurapb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dial serves srv over an in-memory connection and returns a client of it.
+func dial(t *testing.T, srv sakurapb.VerificationServer) sakurapb.VerificationClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	sakurapb.RegisterVerificationServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return sakurapb.NewVerificationClient(conn)
+}
+
+// hash streams data to c in chunks of n bytes.
+func hash(t *testing.T, c sakurapb.VerificationClient, data []byte, n int) *sakurapb.HashResponse {
+	t.Helper()
+	stream, err := c.Hash(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(data) > 0 {
+		k := min(n, len(data))
+		if err := stream.Send(&sakurapb.HashRequest{Chunk: data[:k]}); err != nil {
+			t.Fatal(err)
+		}
+		data = data[k:]
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestServer(t *testing.T) {
+	const leafSize, fanout = 64, 3
+	mode := sakura.Mode128()
+	store := sakura.MapNodeStore{}
+	c := dial(t, sakurapb.NewServer(sakura.New(mode), leafSize, fanout, store))
+	ctx := context.Background()
+
+	for _, size := range []int{0, 1, 64, 65, 1000} {
+		data := sakura.Pattern(size)
+		resp := hash(t, c, data, 100)
+		if resp.Size != uint64(size) {
+			t.Fatalf("size %d: Hash reported %d bytes", size, resp.Size)
+		}
+		var leaves []sakura.Hop
+		for off := 0; off < size || off == 0; off += leafSize {
+			leaves = append(leaves, sakura.GatherBytes(data[off:min(off+leafSize, size)]))
+		}
+		want, err := sakura.New(mode).Final(sakura.BuildTree(leaves, fanout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(resp.Root, want) {
+			t.Fatalf("size %d: Hash root differs from BuildTree", size)
+		}
+
+		for i := range leaves {
+			p, err := c.GetProof(ctx, &sakurapb.GetProofRequest{Root: resp.Root, Leaf: uint64(i)})
+			if err != nil {
+				t.Fatalf("size %d: GetProof(%d): %v", size, i, err)
+			}
+			leaf := data[i*leafSize : min((i+1)*leafSize, size)]
+			v, err := c.VerifyProof(ctx, &sakurapb.VerifyProofRequest{Root: resp.Root, Proof: p.Proof, Leaf: leaf})
+			if err != nil || !v.Valid {
+				t.Fatalf("size %d: VerifyProof(%d): %v %q", size, i, err, v.GetReason())
+			}
+			v, err = c.VerifyProof(ctx, &sakurapb.VerifyProofRequest{Root: resp.Root, Proof: p.Proof, Leaf: []byte("forged")})
+			if err != nil || v.Valid || v.Reason == "" {
+				t.Fatalf("size %d: forged leaf %d: %v %v", size, i, err, v)
+			}
+		}
+		_, err = c.GetProof(ctx, &sakurapb.GetProofRequest{Root: resp.Root, Leaf: uint64(len(leaves))})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("size %d: GetProof past the last leaf: %v", size, err)
+		}
+	}
+
+	_, err := c.GetProof(ctx, &sakurapb.GetProofRequest{Root: make([]byte, 32)})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetProof of an unknown root: %v", err)
+	}
+	v, err := c.VerifyProof(ctx, &sakurapb.VerifyProofRequest{Root: make([]byte, 32), Proof: []byte{0xff}})
+	if err != nil || v.Valid {
+		t.Errorf("VerifyProof of a malformed proof: %v %v", err, v)
+	}
+}
+
+func TestServerWithoutStore(t *testing.T) {
+	c := dial(t, sakurapb.NewServer(sakura.New(sakura.Mode128()), 64, 2, nil))
+	resp := hash(t, c, sakura.Pattern(300), 7)
+	_, err := c.GetProof(context.Background(), &sakurapb.GetProofRequest{Root: resp.Root})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("GetProof without a store: %v", err)
+	}
+}
+
+// hashErr streams data to c in chunks of n bytes and returns the error of the
+// call.
+func hashErr(c sakurapb.VerificationClient, data []byte, n int) error {
+	stream, err := c.Hash(context.Background())
+	if err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		k := min(n, len(data))
+		if err := stream.Send(&sakurapb.HashRequest{Chunk: data[:k]}); err != nil {
+			break // The call failed; CloseAndRecv reports why.
+		}
+		data = data[k:]
+	}
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func TestServerLimits(t *testing.T) {
+	const leafSize = 64
+	for _, tc := range []struct {
+		name   string
+		fanout int
+		limit  func(e *sakura.Encoder, s *sakurapb.Server)
+		ok     int // Largest size hashed, while twice that fails.
+	}{
+		{"server bytes", 2, func(_ *sakura.Encoder, s *sakurapb.Server) { s.MaxBytes = 300 }, 300},
+		{"encoder bytes", 2, func(e *sakura.Encoder, _ *sakurapb.Server) { e.MaxBytes = 300 }, 300},
+		{"leaves", 2, func(e *sakura.Encoder, _ *sakurapb.Server) { e.MaxLeaves = 4 }, 4 * leafSize},
+		{"depth", 2, func(e *sakura.Encoder, _ *sakurapb.Server) { e.MaxDepth = 2 }, 4 * leafSize},
+		{"degree", 4, func(e *sakura.Encoder, _ *sakurapb.Server) { e.MaxDegree = 2 }, 2 * leafSize},
+		{"node bytes", 8, func(e *sakura.Encoder, _ *sakurapb.Server) { e.MaxNodeBytes = 200 }, 3 * leafSize},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, store := range []sakura.NodeStore{nil, sakura.MapNodeStore{}} {
+				e := sakura.New(sakura.Mode128())
+				srv := sakurapb.NewServer(e, leafSize, tc.fanout, store)
+				tc.limit(e, srv)
+				c := dial(t, srv)
+				for _, size := range []int{tc.ok, 2 * tc.ok} {
+					data := sakura.Pattern(size)
+					err := hashErr(c, data, 50)
+					if size == tc.ok && err != nil {
+						t.Errorf("store %v, size %d: %v", store != nil, size, err)
+					}
+					if size > tc.ok && status.Code(err) != codes.ResourceExhausted {
+						t.Errorf("store %v, size %d: got %v, want ResourceExhausted", store != nil, size, err)
+					}
+					if srv.MaxBytes != 0 && srv.MaxBytes != sakurapb.DefaultMaxBytes {
+						continue
+					}
+					// The encoder itself agrees on the tree of the data.
+					var leaves []sakura.Hop
+					for off := 0; off < size; off += leafSize {
+						leaves = append(leaves, sakura.GatherBytes(data[off:min(off+leafSize, size)]))
+					}
+					if _, want := e.Final(sakura.BuildTree(leaves, tc.fanout)); (want == nil) != (err == nil) {
+						t.Errorf("store %v, size %d: Hash got %v, Final %v", store != nil, size, err, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestServerBytesPerSecond(t *testing.T) {
+	e := sakura.New(sakura.Mode128())
+	e.BytesPerSecond = 10000
+	c := dial(t, sakurapb.NewServer(e, 64, 2, nil))
+	start := time.Now()
+	hash(t, c, sakura.Pattern(2000), 100)
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("2000 bytes at 10000 bytes per second took %v", d)
+	}
+}
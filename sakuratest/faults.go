@@ -0,0 +1,303 @@
+package sakuratest
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/chlin501/sakura"
+)
+
+// ErrInjected is the error of the reads that Faults fails when its Err is nil.
+var ErrInjected = errors.New("sakuratest: injected fault")
+
+// Faults describes faults to inject into the hashing of a tree, so that a
+// system built on package sakura can test its handling of corrupted leaves,
+// slow nodes and failed reads end to end. Faults are deterministic: they hit
+// the nodes given by their IDs, whatever the order in which a parallel
+// encoder reaches them, so a failing case is reproduced from its faults.
+//
+// Inject applies them to a tree of hops, InjectRanger to the reads of a
+// sakura.Ranger, and Hooks to the nodes of any tree hashed by an encoder.
+type Faults struct {
+	// Corrupt holds the leaves whose bits are corrupted: the lowest bit of
+	// their first byte is flipped. Empty leaves are left as they are.
+	Corrupt []sakura.NodeID
+
+	// Fail holds the leaves whose reads fail with Err, or ErrInjected if Err
+	// is nil. Times is the number of reads of each that fail before it reads
+	// correctly, so that a RetryPolicy can recover from them, or every read
+	// if zero. Err must wrap os.ErrDeadlineExceeded, or some other error the
+	// policy retries, for the reads to be retried.
+	Fail  []sakura.NodeID
+	Err   error
+	Times int
+
+	// Delay holds the nodes that are delayed by Latency: the reads of leaves
+	// from their start for Inject and InjectRanger, and the hashing of nodes
+	// of any kind for Hooks.
+	Delay   []sakura.NodeID
+	Latency time.Duration
+}
+
+// Inject returns a tree that reads as tree does, but with the faults f. The
+// hops of the tree are wrapped as they are looked up, keeping the chaining
+// values given to them in the hops of tree. Message hops implement io.Seeker,
+// failing with sakura.ErrNotSeekable if theirs does not; the other optional
+// interfaces of the hops of tree are not kept.
+func Inject(tree sakura.Hop, f Faults) sakura.Hop {
+	return newInjector(f).wrap(tree, sakura.NodeID{})
+}
+
+// InjectRanger returns a Ranger that reads r, cut into leaves of leafSize
+// bytes, with the faults f. Leaf i is the node whose ID is {i}, its ID in the
+// tree that sakura.RangeLeaves builds when there are several leaves, and a
+// call to ReadRange reads every leaf its range overlaps: it is delayed once
+// if any of them is, fails if any of them does, and corrupts the first byte
+// of those in the range that are corrupted.
+func InjectRanger(r sakura.Ranger, leafSize int, f Faults) sakura.Ranger {
+	return &faultRanger{r: r, leafSize: int64(leafSize), in: newInjector(f)}
+}
+
+// Hooks returns node hooks that delay the hashing of every node in Delay by
+// Latency before it is coded. Nodes whose hop reports a cached chaining value
+// are not hashed, and so not delayed.
+func (f Faults) Hooks() sakura.NodeHooks {
+	delayed := idSet(f.Delay)
+	return sakura.NodeHooks{Before: func(info sakura.NodeInfo) []byte {
+		if delayed[info.Node.String()] {
+			time.Sleep(f.Latency)
+		}
+		return nil
+	}}
+}
+
+// injector holds the faults of a tree or ranger, and counts the failed reads
+// of every leaf.
+type injector struct {
+	f                      Faults
+	corrupt, fail, delayed map[string]bool
+	mu                     sync.Mutex
+	failed                 map[string]int
+}
+
+func newInjector(f Faults) *injector {
+	return &injector{
+		f:       f,
+		corrupt: idSet(f.Corrupt),
+		fail:    idSet(f.Fail),
+		delayed: idSet(f.Delay),
+		failed:  make(map[string]int),
+	}
+}
+
+// idSet returns the set of the string forms of ids.
+func idSet(ids []sakura.NodeID) map[string]bool {
+	s := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		s[id.String()] = true
+	}
+	return s
+}
+
+// failRead returns the error with which the next read of the leaf id fails,
+// or nil if it does not.
+func (in *injector) failRead(id string) error {
+	if !in.fail[id] {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.f.Times > 0 && in.failed[id] >= in.f.Times {
+		return nil
+	}
+	in.failed[id]++
+	if in.f.Err != nil {
+		return in.f.Err
+	}
+	return ErrInjected
+}
+
+// delay waits for the latency of the faults if the leaf id is delayed.
+func (in *injector) delay(id string) {
+	if in.delayed[id] {
+		time.Sleep(in.f.Latency)
+	}
+}
+
+// wrap returns the hop, whose ID is id, with the faults of in.
+func (in *injector) wrap(hop sakura.Hop, id sakura.NodeID) sakura.Hop {
+	switch h := hop.(type) {
+	case sakura.ChainingHop64:
+		return &faultNode64{Hop: hop, h: h, id: id, in: in}
+	case sakura.ChainingHop:
+		return &faultNode{Hop: hop, h: h, id: id, in: in}
+	case sakura.ChildStream:
+		return &faultStream{Hop: hop, h: h, id: id, in: in}
+	case sakura.MessageHop:
+		return &faultLeaf{Hop: hop, r: h, id: id.String(), in: in}
+	}
+	return hop
+}
+
+// faultNode is a sakura.ChainingHop with faults.
+type faultNode struct {
+	sakura.Hop
+	h    sakura.ChainingHop
+	id   sakura.NodeID
+	in   *injector
+	mu   sync.Mutex
+	kids map[int]sakura.Hop
+}
+
+func (n *faultNode) Degree() int { return n.h.Degree() }
+
+func (n *faultNode) Child(i int) sakura.Hop {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if k, ok := n.kids[i]; ok {
+		return k
+	}
+	if n.kids == nil {
+		n.kids = make(map[int]sakura.Hop)
+	}
+	k := n.in.wrap(n.h.Child(i), n.id.Child(i))
+	n.kids[i] = k
+	return k
+}
+
+// faultNode64 is a sakura.ChainingHop64 with faults.
+type faultNode64 struct {
+	sakura.Hop
+	h    sakura.ChainingHop64
+	id   sakura.NodeID
+	in   *injector
+	mu   sync.Mutex
+	kids map[int64]sakura.Hop
+}
+
+func (n *faultNode64) Degree64() int64 { return n.h.Degree64() }
+
+func (n *faultNode64) ChildErr(i int64) (sakura.Hop, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if k, ok := n.kids[i]; ok {
+		return k, nil
+	}
+	c, err := n.h.ChildErr(i)
+	if err != nil {
+		return nil, err
+	}
+	if n.kids == nil {
+		n.kids = make(map[int64]sakura.Hop)
+	}
+	k := n.in.wrap(c, n.id.Child(int(i)))
+	n.kids[i] = k
+	return k, nil
+}
+
+// faultStream is a sakura.ChildStream with faults.
+type faultStream struct {
+	sakura.Hop
+	h    sakura.ChildStream
+	id   sakura.NodeID
+	in   *injector
+	next int
+}
+
+func (s *faultStream) Next() (sakura.Hop, error) {
+	c, err := s.h.Next()
+	if err != nil {
+		return nil, err
+	}
+	s.next++
+	return s.in.wrap(c, s.id.Child(s.next-1)), nil
+}
+
+// faultLeaf is a sakura.MessageHop with faults.
+type faultLeaf struct {
+	sakura.Hop
+	r   io.Reader
+	id  string
+	in  *injector
+	pos int64
+}
+
+func (l *faultLeaf) Read(p []byte) (int, error) {
+	if l.pos == 0 {
+		l.in.delay(l.id)
+	}
+	if err := l.in.failRead(l.id); err != nil {
+		return 0, err
+	}
+	n, err := l.r.Read(p)
+	if l.pos == 0 && n > 0 && l.in.corrupt[l.id] {
+		p[0] ^= 1
+	}
+	l.pos += int64(n)
+	return n, err
+}
+
+func (l *faultLeaf) Seek(offset int64, whence int) (int64, error) {
+	s, ok := l.r.(io.Seeker)
+	if !ok {
+		return 0, sakura.ErrNotSeekable
+	}
+	pos, err := s.Seek(offset, whence)
+	if err == nil {
+		l.pos = pos
+	}
+	return pos, err
+}
+
+// faultRanger is a sakura.Ranger with faults.
+type faultRanger struct {
+	r        sakura.Ranger
+	leafSize int64
+	in       *injector
+}
+
+func (r *faultRanger) ReadRange(off, n int64) (io.ReadCloser, error) {
+	first, last := off/r.leafSize, (off+max(n, 1)-1)/r.leafSize
+	var corrupt []int64
+	delayed := false
+	for i := first; i <= last; i++ {
+		id := sakura.NodeID{int(i)}.String()
+		if !delayed && r.in.delayed[id] {
+			r.in.delay(id)
+			delayed = true
+		}
+		if err := r.in.failRead(id); err != nil {
+			return nil, err
+		}
+		if r.in.corrupt[id] && i*r.leafSize >= off {
+			corrupt = append(corrupt, i*r.leafSize-off)
+		}
+	}
+	rc, err := r.r.ReadRange(off, n)
+	if err != nil || len(corrupt) == 0 {
+		return rc, err
+	}
+	return &corruptReader{rc: rc, at: corrupt}, nil
+}
+
+// corruptReader flips the lowest bit of the bytes at the offsets at of the
+// reader rc, in increasing order.
+type corruptReader struct {
+	rc  io.ReadCloser
+	at  []int64
+	pos int64
+}
+
+func (c *corruptReader) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	for len(c.at) > 0 && c.at[0] < c.pos+int64(n) {
+		p[c.at[0]-c.pos] ^= 1
+		c.at = c.at[1:]
+	}
+	c.pos += int64(n)
+	return n, err
+}
+
+func (c *corruptReader) Close() error { return c.rc.Close() }
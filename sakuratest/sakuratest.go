@@ -0,0 +1,241 @@
+// Package sakuratest generates random hop trees and hashing modes for
+// property tests of code built on package sakura, so that an integration can
+// be checked against many shapes of trees instead of a few hand-built ones.
+//
+// Generation is deterministic: a Rand created with the same seed and Config
+// returns the same trees and modes on every run and platform, so a failing
+// case is reproduced from its seed.
+//
+//	for seed := uint64(0); seed < 1000; seed++ {
+//		g := sakuratest.New(seed, sakuratest.Config{})
+//		mode, tree := g.Mode(), g.Tree()
+//		root, err := sakura.New(mode).Final(tree.Hop())
+//		// Check root and err against the system under test.
+//	}
+//
+// Faults injects corrupted leaves, delayed nodes and failed reads into such
+// trees, or into those of the system under test, to exercise its error paths.
+package sakuratest
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand/v2"
+
+	"github.com/chlin501/sakura"
+)
+
+// Config bounds the trees that a Rand generates. Zero fields take the
+// defaults given.
+type Config struct {
+	MaxDepth    int // Depth of the deepest leaf, 4 by default.
+	MaxDegree   int // Children of a chaining hop, 8 by default.
+	MaxLeafSize int // Bytes of a leaf, 256 by default.
+
+	// Streams lets chaining hops be generated as sakura.ChildStream hops,
+	// which only encoders read; functions that look children up by index,
+	// such as Prove, fail on them.
+	Streams bool
+}
+
+// Rand generates random trees and modes. It is not safe for concurrent use.
+type Rand struct {
+	r   *rand.Rand
+	cfg Config
+}
+
+// New returns a Rand seeded with seed.
+func New(seed uint64, cfg Config) *Rand {
+	if cfg.MaxDepth <= 0 {
+		cfg.MaxDepth = 4
+	}
+	if cfg.MaxDegree <= 0 {
+		cfg.MaxDegree = 8
+	}
+	if cfg.MaxLeafSize <= 0 {
+		cfg.MaxLeafSize = 256
+	}
+	return &Rand{r: rand.New(rand.NewPCG(seed, 0x5a6b75726174)), cfg: cfg}
+}
+
+// Kind is the kind of hop of a Tree.
+type Kind byte
+
+const (
+	Leaf       Kind = iota // A sakura.MessageHop.
+	Chaining               // A sakura.ChainingHop.
+	Chaining64             // A sakura.ChainingHop64.
+	Stream                 // A sakura.ChildStream.
+)
+
+// Tree is the description of a hop tree, from which Hop builds fresh hops, so
+// that the same tree can be hashed by several encoders, or several times,
+// without chaining values left by one run affecting the next.
+type Tree struct {
+	Kind Kind
+	Data []byte  // Message bits of a leaf.
+	Kids []*Tree // Children of a chaining hop.
+}
+
+// Tree returns a random tree. Leaves may be empty and chaining hops may have
+// no children, and the root is a leaf now and then.
+func (g *Rand) Tree() *Tree {
+	return g.tree(0)
+}
+
+func (g *Rand) tree(depth int) *Tree {
+	// Deeper hops are more likely to be leaves, so that trees stay small.
+	if depth >= g.cfg.MaxDepth || g.r.IntN(g.cfg.MaxDepth+1) <= depth {
+		return &Tree{Kind: Leaf, Data: g.Bytes(g.cfg.MaxLeafSize)}
+	}
+	kinds := []Kind{Chaining, Chaining64}
+	if g.cfg.Streams {
+		kinds = append(kinds, Stream)
+	}
+	t := &Tree{Kind: kinds[g.r.IntN(len(kinds))]}
+	n := g.r.IntN(g.cfg.MaxDegree + 1)
+	for i := 0; i < n; i++ {
+		t.Kids = append(t.Kids, g.tree(depth+1))
+	}
+	return t
+}
+
+// Bytes returns up to max random bytes, at times none.
+func (g *Rand) Bytes(max int) []byte {
+	b := make([]byte, g.r.IntN(max+1))
+	for i := range b {
+		b[i] = byte(g.r.Uint32())
+	}
+	return b
+}
+
+// Mode returns a random hashing mode of this package: a hash function among
+// SHA-256, SHA-512, SHA3-256 and SHAKE, with or without kangaroo hopping,
+// alignment and interleaving, and at times a coding, domain, salt or padding
+// other than the default.
+func (g *Rand) Mode() sakura.HashingMode {
+	hashes := []sakura.Hasher{
+		sha256.New,
+		sha512.New,
+		func() hash.Hash { return sha3.New256() },
+	}
+	var mode sakura.HashingMode
+	switch k := g.r.IntN(len(hashes) + 2); k {
+	case len(hashes):
+		mode = sakura.NewShake128Mode()
+	case len(hashes) + 1:
+		mode = sakura.NewShake256Mode()
+	default:
+		mode = sakura.HashingMode{Hash: hashes[k], Interleave: sakura.NoInterleave}
+	}
+	mode.Kangaroo = g.r.IntN(2) == 0
+	mode.Alignment = 0
+	if mode.Kangaroo {
+		mode.Alignment = []uint8{0, 1, 8, 64, 136}[g.r.IntN(5)]
+	}
+	if g.r.IntN(3) == 0 {
+		mode.Interleave = sakura.NearestBlockSize(1 << g.r.IntN(16))
+	}
+	if g.r.IntN(4) == 0 {
+		mode.Padding = sakura.MultiRatePadding{}
+	}
+	switch g.r.IntN(6) {
+	case 1:
+		mode.Coding, _ = sakura.SuffixCoding([]string{"0", "1", "01", "110"}[g.r.IntN(4)])
+	case 2:
+		mode.Coding, _ = sakura.IntFormatCoding(sakura.IntFormat(g.r.IntN(4)))
+	case 3:
+		mode.Coding = sakura.LeafLengthCoding(nil)
+	}
+	if g.r.IntN(4) == 0 {
+		mode, _ = sakura.WithDomain(mode, fmt.Sprintf("sakuratest/%d", g.r.IntN(100)))
+	}
+	if g.r.IntN(4) == 0 {
+		mode, _ = sakura.Salted(mode, append(g.Bytes(31), 1), sakura.SaltScope(g.r.IntN(2)))
+	}
+	return mode
+}
+
+// Hop returns a new tree of hops described by t. Leaves implement io.Seeker,
+// and all hops keep the chaining values they are given.
+func (t *Tree) Hop() sakura.Hop {
+	switch t.Kind {
+	case Leaf:
+		return sakura.GatherBytes(t.Data)
+	case Stream:
+		return &stream{t: t}
+	}
+	kids := make([]sakura.Hop, len(t.Kids))
+	for i, k := range t.Kids {
+		kids[i] = k.Hop()
+	}
+	if t.Kind == Chaining64 {
+		return &node64{kids: kids}
+	}
+	return &node{kids: kids}
+}
+
+// Leaves returns the number of leaves of t.
+func (t *Tree) Leaves() int {
+	if t.Kind == Leaf {
+		return 1
+	}
+	n := 0
+	for _, k := range t.Kids {
+		n += k.Leaves()
+	}
+	return n
+}
+
+// Size returns the number of bytes of the leaves of t.
+func (t *Tree) Size() int64 {
+	n := int64(len(t.Data))
+	for _, k := range t.Kids {
+		n += k.Size()
+	}
+	return n
+}
+
+// node is a sakura.ChainingHop.
+type node struct {
+	kids []sakura.Hop
+	cv   []byte
+}
+
+func (n *node) Child(i int) sakura.Hop       { return n.kids[i] }
+func (n *node) Degree() int                  { return len(n.kids) }
+func (n *node) ChainingValue() []byte        { return n.cv }
+func (n *node) SetChainingValue(hash []byte) { n.cv = hash }
+
+// node64 is a sakura.ChainingHop64.
+type node64 struct {
+	kids []sakura.Hop
+	cv   []byte
+}
+
+func (n *node64) ChildErr(i int64) (sakura.Hop, error) { return n.kids[i], nil }
+func (n *node64) Degree64() int64                      { return int64(len(n.kids)) }
+func (n *node64) ChainingValue() []byte                { return n.cv }
+func (n *node64) SetChainingValue(hash []byte)         { n.cv = hash }
+
+// stream is a sakura.ChildStream, building its children as they are read.
+type stream struct {
+	t    *Tree
+	next int
+	cv   []byte
+}
+
+func (s *stream) Next() (sakura.Hop, error) {
+	if s.next == len(s.t.Kids) {
+		return nil, io.EOF
+	}
+	s.next++
+	return s.t.Kids[s.next-1].Hop(), nil
+}
+
+func (s *stream) ChainingValue() []byte        { return s.cv }
+func (s *stream) SetChainingValue(hash []byte) { s.cv = hash }
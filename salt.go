@@ -0,0 +1,97 @@
+package sakura
+
+import (
+	"encoding/hex"
+	"errors"
+	"hash"
+)
+
+// SaltScope is where a salted mode absorbs its salt.
+type SaltScope byte
+
+const (
+	// SaltEveryNode absorbs the salt before the bits of every node, as a key
+	// of the hash function, so that without the salt no one can find nodes
+	// that collide in chaining values, as hash-flooding attacks on tables
+	// keyed by chaining values do, the work of such a search growing with
+	// each tree instead of being done once for all.
+	SaltEveryNode SaltScope = iota
+
+	// SaltRoot absorbs the salt after the frame bits of the final node only,
+	// which costs nothing below the root: chaining values are those of the
+	// unsalted mode, so leaves and subtrees can still be deduplicated, while
+	// roots of the same data under different salts cannot be linked.
+	SaltRoot
+)
+
+// Salted returns mode with salt absorbed at the nodes selected by scope, which
+// makes roots depend on the salt: the same data hashed under two salts gives
+// roots that cannot be linked without knowing both. The salt, typically 16 or
+// more random bytes per dataset, is part of the mode, so its fingerprint, and
+// the headers of proofs and serialized trees, tell different salts apart. It
+// must be kept next to the root to verify data, and secret for the protection
+// against hash flooding.
+//
+// With SaltEveryNode the salt is written at the start of every hash state, so
+// alignment applies to the bits of a node after the salt; a salt of a multiple
+// of the block size of the hash keeps nodes aligned to its blocks. With
+// SaltRoot it is appended after the frame bits of the final node by its Coding,
+// which wraps the Coding of mode.
+func Salted(mode HashingMode, salt []byte, scope SaltScope) (HashingMode, error) {
+	if mode.Hash == nil {
+		return HashingMode{}, ErrNoHash
+	}
+	if len(salt) == 0 {
+		return HashingMode{}, errors.New("sakura: empty salt")
+	}
+	salt = append([]byte{}, salt...)
+	switch scope {
+	case SaltEveryNode:
+		h := mode.Hash
+		mode.Hash = func() hash.Hash {
+			s := &saltedHash{Hash: h(), salt: salt}
+			s.Write(salt)
+			return s
+		}
+	case SaltRoot:
+		c := mode.Coding
+		if c == nil {
+			c = SakuraCoding{}
+		}
+		mode.Coding = saltCoding{Coding: c, salt: salt}
+	default:
+		return HashingMode{}, errors.New("sakura: invalid salt scope")
+	}
+	return mode, nil
+}
+
+// saltedHash is a hash.Hash that absorbs a salt first after every Reset.
+type saltedHash struct {
+	hash.Hash
+	salt []byte
+}
+
+func (s *saltedHash) Reset() {
+	s.Hash.Reset()
+	s.Hash.Write(s.salt)
+}
+
+// saltCoding is a Coding that appends a salt to final nodes. Since every final
+// node ends with the same bytes, the coding stays as decodable as the one it
+// wraps.
+type saltCoding struct {
+	Coding
+	salt []byte
+}
+
+func (c saltCoding) Final(w BitWriter) {
+	c.Coding.Final(w)
+	w.Write(c.salt)
+}
+
+// MessageLength passes the length of a message hop on to the wrapped coding.
+func (c saltCoding) MessageLength(w BitWriter, n int64) { codeMessage(c.Coding, w, n) }
+
+func (c saltCoding) Name() string {
+	return c.Coding.Name() + "+sakura.salt:" + hex.EncodeToString(c.salt)
+}
@@ -0,0 +1,33 @@
+package sakura
+
+import "strconv"
+
+// Schedule is the policy by which a parallel encoder orders the nodes that are
+// ready to be hashed. Nodes of a higher priority, as set by PriorityHop, come
+// first under either policy, and the policy never changes the hash of a tree.
+type Schedule byte
+
+const (
+	// ScheduleThroughput hashes the ready nodes in tree order, so that the
+	// message hops are read in the order of the message, which suits the
+	// readahead of files and streams, and the workers are kept busy on the
+	// leaves. It is the default.
+	ScheduleThroughput Schedule = iota
+
+	// ScheduleLatency hashes the deepest ready nodes first, those on the
+	// longest chain of nodes left to the root, so that the levels above the
+	// leaves complete as early as possible and the root follows soon after
+	// the last leaf is read. A Writer, whose leaves all hang from the final
+	// node, gets the same from SetStreaming.
+	ScheduleLatency
+)
+
+func (s Schedule) String() string {
+	switch s {
+	case ScheduleThroughput:
+		return "throughput"
+	case ScheduleLatency:
+		return "latency"
+	}
+	return "Schedule(" + strconv.Itoa(int(s)) + ")"
+}
@@ -0,0 +1,32 @@
+package sakura
+
+import "hash"
+
+// scratch holds the state used to hash a single node: the hash function, the
+// bit writer and the buffers for message bits and frame bits. Scratches are
+// kept in a pool on the encoder and reused from node to node, so that hashing a
+// tree of many small leaves does not allocate for every node. A scratch serves
+// one node at a time; nested nodes and nodes hashed by other workers take
+// their own from the pool.
+type scratch struct {
+	h       hash.Hash
+	w       bitWriter
+	read    []byte // Buffer for the bits of message hops.
+	trailer []byte // Coded trailer of a chaining hop.
+}
+
+// getScratch returns a scratch for e, ready to hash a node.
+func (e *Encoder) getScratch() *scratch {
+	if s, ok := e.scratch.Get().(*scratch); ok {
+		return s
+	}
+	return &scratch{h: e.mode.Hash()}
+}
+
+// putScratch resets s and returns it to the pool of e. The hashes computed
+// with s must not be held in its buffers.
+func (e *Encoder) putScratch(s *scratch) {
+	s.h.Reset()
+	s.w = bitWriter{}
+	e.scratch.Put(s)
+}
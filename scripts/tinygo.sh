@@ -0,0 +1,13 @@
+#!/bin/sh
+# Builds package sakuracompact with TinyGo, for WebAssembly and for a
+# microcontroller, through the program in sakuracompact/internal/tinygo. It
+# fails if TinyGo is not installed or either build fails.
+set -e
+cd "$(dirname "$0")/.."
+out=$(mktemp -d)
+trap 'rm -rf "$out"' EXIT
+tinygo version
+tinygo build -o "$out/wasip1.wasm" -target=wasip1 ./sakuracompact/internal/tinygo
+tinygo build -o "$out/wasm.wasm" -target=wasm ./sakuracompact/internal/tinygo
+tinygo build -o "$out/cortex-m.elf" -target=cortex-m-qemu ./sakuracompact/internal/tinygo
+echo "sakuracompact builds with TinyGo"
@@ -0,0 +1,26 @@
+package sakura
+
+import "bytes"
+
+// Snapshot saves the tree rooted at hop, which may be partially hashed, so that
+// Restore can rebuild it later, say in another process. The snapshot is in the
+// tree file format written by WriteTree.
+//
+// Subtrees whose chaining values are cached and coded in their parent's node
+// are complete and are saved as the value alone. The rest of the tree, the
+// pending frontier, is saved in full, including the bits of its message hops,
+// which are read to the end and must not have been read before. Hashing the
+// restored tree with an encoder of the same mode yields the root of the
+// original.
+func (e *Encoder) Snapshot(hop Hop) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := e.WriteTree(&buf, hop); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Restore rebuilds a tree saved by Encoder.Snapshot for the given mode.
+func Restore(data []byte, mode HashingMode) (Hop, error) {
+	return ReadTree(bytes.NewReader(data), mode)
+}
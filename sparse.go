@@ -0,0 +1,119 @@
+package sakura
+
+import (
+	"errors"
+
+	"github.com/chlin501/sakura/sakuracoding"
+)
+
+// holeDomain begins the message of the leaf that codes a hole.
+const holeDomain = "sakura.hole"
+
+// Hole returns a hop that stands for size bytes of a message that are absent,
+// such as a hole in a sparse file or a part of a dataset not yet fetched. It
+// is a chaining hop over a single message hop holding a fixed domain string
+// and size, so its coding is short whatever the size and, being a chaining
+// hop, cannot be mistaken for a leaf holding the same bytes. Replacing a hole
+// by the data it stands for changes the root.
+func Hole(size int64) Hop {
+	return &chainingLeaves{kids: []Hop{messageLeaf(holeMessage(size))}}
+}
+
+// holeMessage returns the message bits of the leaf of a Hole of size bytes.
+func holeMessage(size int64) []byte {
+	return append([]byte(holeDomain), sakuracoding.LengthEncode(uint64(size))...)
+}
+
+// Sparse is a stream in the shape of the one built by Writer of which only
+// some leaves are present, the others being holes. Leaves are filled in any
+// order, each being hashed once, so the root of a partially populated stream
+// can be computed at any time at the cost of the final node. Once every leaf
+// is filled, the root is the one a Writer with the same leaf size returns for
+// the stream.
+type Sparse struct {
+	e        *Encoder
+	size     int64
+	leafSize int
+	data     []byte   // Bits of the first leaf, where the final node holds them.
+	cvs      [][]byte // Chaining values of the leaves, nil for holes.
+}
+
+// NewSparse returns a stream of size bytes, cut into leaves of leafSize bytes,
+// which is all holes and whose root is hashed with e. It panics if leafSize is
+// not positive or size is negative.
+func NewSparse(e *Encoder, size int64, leafSize int) *Sparse {
+	if leafSize <= 0 || size < 0 {
+		panic("sakura: invalid size or leaf size")
+	}
+	n := leafCount(size, leafSize)
+	return &Sparse{e: e, size: size, leafSize: leafSize, cvs: make([][]byte, n)}
+}
+
+// nested reports whether the final node holds the bits of leaf i.
+func (s *Sparse) nested(i int) bool {
+	return i == 0 && (len(s.cvs) == 1 || s.e.mode.Kangaroo)
+}
+
+// leafRange returns the bytes of leaf i in the stream.
+func (s *Sparse) leafRange(i int) ByteRange {
+	off := int64(i) * int64(s.leafSize)
+	return ByteRange{Off: off, Len: min(int64(s.leafSize), s.size-off)}
+}
+
+// Fill sets the bytes of leaf i, which must have the length of the leaf, and
+// hashes it. A leaf that is already present is replaced.
+func (s *Sparse) Fill(i int, data []byte) error {
+	if i < 0 || i >= len(s.cvs) {
+		return errors.New("sakura: leaf index out of range")
+	}
+	if int64(len(data)) != s.leafRange(i).Len {
+		return errors.New("sakura: data does not have the length of the leaf")
+	}
+	if err := s.e.checkMode(); err != nil {
+		return err
+	}
+	cv, err := s.e.leafValue(i, data)
+	if err != nil {
+		return err
+	}
+	if s.nested(i) {
+		s.data = append([]byte{}, data...)
+	}
+	s.cvs[i] = cv
+	return nil
+}
+
+// Holes returns the byte ranges of the leaves not yet filled, in increasing
+// order and with adjacent ranges merged.
+func (s *Sparse) Holes() []ByteRange {
+	var holes []ByteRange
+	for i, cv := range s.cvs {
+		if cv != nil {
+			continue
+		}
+		r := s.leafRange(i)
+		if k := len(holes); k > 0 && holes[k-1].Off+holes[k-1].Len == r.Off {
+			holes[k-1].Len += r.Len
+		} else {
+			holes = append(holes, r)
+		}
+	}
+	return holes
+}
+
+// Root returns the root of the stream, in which every leaf not yet filled is
+// coded as a Hole of its length.
+func (s *Sparse) Root() ([]byte, error) {
+	leaves := make([]Hop, len(s.cvs))
+	for i, cv := range s.cvs {
+		switch {
+		case cv == nil:
+			leaves[i] = Hole(s.leafRange(i).Len)
+		case s.nested(i):
+			leaves[i] = messageLeaf(s.data)
+		default:
+			leaves[i] = &storedLeaf{cv: cv}
+		}
+	}
+	return s.e.Final(sequentialTree(leaves))
+}
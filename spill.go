@@ -0,0 +1,171 @@
+package sakura
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+// spillReadSize is the size of the buffer through which the final node of a
+// spilling Writer reads the spilled chaining values.
+const spillReadSize = 64 << 10
+
+// SetSpill bounds the memory that w takes for the chaining values of its
+// leaves to about maxBytes: once they would exceed it, the values of the
+// leading completed leaves move to a temporary file created in dir, or in the
+// default directory for temporary files if dir is empty. Close streams them
+// back from the file into the final node, which is hashed serially, and
+// removes the file, so a stream of billions of leaves is hashed with the
+// memory of maxBytes and a few buffers. The root is the same as without
+// spilling. Values are spilled in the order of the leaves, so those after a
+// leaf that is still being hashed stay in memory until it completes.
+//
+// SetSpill must be called before the first Write. A spilling writer holds no
+// parity and saves no checkpoints. A writer that is never closed leaves its
+// file behind.
+func (w *Writer) SetSpill(dir string, maxBytes int64) error {
+	if maxBytes <= 0 {
+		return errors.New("sakura: non-positive spill limit")
+	}
+	if w.leaves > 0 || w.closing || w.closed {
+		return errors.New("sakura: spill set after writing")
+	}
+	if w.parity != nil {
+		return errors.New("sakura: parity leaves cannot be spilled")
+	}
+	if w.ck != nil {
+		return errors.New("sakura: checkpoints do not hold spilled chaining values")
+	}
+	if w.pool.final != nil {
+		return errors.New("sakura: a streaming writer holds no chaining values to spill")
+	}
+	if err := w.e.checkMode(); err != nil {
+		return err
+	}
+	p := w.pool
+	p.spillDir, p.spillMax, p.cvSize = dir, maxBytes, w.e.mode.Hash().Size()
+	return nil
+}
+
+// spillValues moves the chaining values of the leading completed leaves to
+// the spill file once those held exceed the spill limit. Only the goroutine
+// of the writer calls it, so the file is never written concurrently.
+func (p *leafPool) spillValues() error {
+	if p.spillMax == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if int64(len(p.cvs))*int64(p.cvSize+24) <= p.spillMax {
+		return nil
+	}
+	k := p.completed()
+	if k == 0 {
+		return nil
+	}
+	if p.spill == nil {
+		f, err := os.CreateTemp(p.spillDir, "sakura-cvs-*")
+		if err != nil {
+			return err
+		}
+		p.spill = f
+	}
+	var b []byte
+	for _, cv := range p.cvs[:k] {
+		if cv == nil {
+			// The nested leaf has no chaining value; its slot is left zero.
+			cv = make([]byte, p.cvSize)
+		}
+		b = append(b, cv...)
+	}
+	if _, err := p.spill.Write(b); err != nil {
+		return err
+	}
+	// Copy the rest so that the spilled values can be collected.
+	p.cvs = append([][]byte(nil), p.cvs[k:]...)
+	p.base += k
+	return nil
+}
+
+// completed returns the number of leading leaves of cvs that are hashed, or
+// nested and not to be hashed.
+func (p *leafPool) completed() int {
+	k := 0
+	for k < len(p.cvs) && (p.cvs[k] != nil || p.base+k == 0 && p.nested) {
+		k++
+	}
+	return k
+}
+
+// removeSpill closes and removes the spill file, if any.
+func (p *leafPool) removeSpill() error {
+	if p.spill == nil {
+		return nil
+	}
+	f := p.spill
+	p.spill = nil
+	err := f.Close()
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// spilledTree returns the final node of a stream of n leaves whose chaining
+// values up to leaf p.base are in the spill file and the others in tail. The
+// first leaf is taken from first instead, the leaf nested by kangaroo
+// hopping, unless it is nil.
+func (p *leafPool) spilledTree(n int, first Hop, tail [][]byte) Hop {
+	return &spilledLeaves{p: p, n: int64(n), first: first, tail: tail}
+}
+
+// spilledLeaves is the final node of a spilling Writer. Its children are
+// looked up in order, so the spilled values are read through one buffer.
+type spilledLeaves struct {
+	p     *leafPool
+	n     int64
+	first Hop
+	tail  [][]byte
+	r     *bufio.Reader
+	next  int64 // Index of the leaf whose value r reads next.
+	cv    []byte
+}
+
+func (s *spilledLeaves) Degree64() int64              { return s.n }
+func (s *spilledLeaves) ChainingValue() []byte        { return s.cv }
+func (s *spilledLeaves) SetChainingValue(hash []byte) { s.cv = hash }
+
+func (s *spilledLeaves) ChildErr(i int64) (Hop, error) {
+	base, size := int64(s.p.base), int64(s.p.cvSize)
+	switch {
+	case i == 0 && s.first != nil:
+		return s.first, nil
+	case i >= base:
+		return &storedLeaf{cv: s.tail[i-base]}, nil
+	}
+	if s.r == nil || i != s.next {
+		s.r = bufio.NewReaderSize(io.NewSectionReader(s.p.spill, i*size, (base-i)*size), spillReadSize)
+	}
+	cv := make([]byte, size)
+	if _, err := io.ReadFull(s.r, cv); err != nil {
+		s.r = nil
+		return nil, err
+	}
+	s.next = i + 1
+	return &storedLeaf{cv: cv}, nil
+}
+
+// finalSerial is like Final, but hashes the final node on the calling
+// goroutine whatever the parallelism, for a hop whose children all report
+// their chaining values, which the parallel scheduler would collect in
+// memory.
+func (e *Encoder) finalSerial(hop Hop) ([]byte, error) {
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	j := newJob(e)
+	return j.traced("sakura.Final", hop, func() ([]byte, error) {
+		return j.serial(hop, NodeID{}, true, 0)
+	})
+}
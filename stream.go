@@ -0,0 +1,128 @@
+package sakura
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// StreamOptions configures Encoder.HashStream.
+type StreamOptions struct {
+	// LeafSize is the leaf size of the Writer that hashes the stream,
+	// DefaultLeafSize if zero.
+	LeafSize int
+
+	// Size, if positive, is the length the stream is expected to have. Only
+	// that many bytes are read, and a stream that ends before is truncated.
+	Size int64
+
+	// IdleTimeout, if positive, is the longest a single read may wait for
+	// data, for sources with read deadlines.
+	IdleTimeout time.Duration
+
+	// Progress, if not nil, is called with the number of bytes hashed so far
+	// after every read that returned data.
+	Progress func(n int64)
+}
+
+// ReadDeadliner is a source with read deadlines, such as a net.Conn.
+type ReadDeadliner interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
+
+// StreamError records the failure of Encoder.HashStream at an offset of the
+// stream. Timeout tells a source that stalled, which may succeed if tried
+// again, from one that failed or was cut short, whose data cannot be trusted.
+type StreamError struct {
+	Offset int64 // Number of bytes hashed before the failure.
+	Err    error
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("sakura: stream failed after %d bytes: %v", e.Offset, e.Err)
+}
+
+func (e *StreamError) Unwrap() error { return e.Err }
+
+// Timeout reports whether a read timed out.
+func (e *StreamError) Timeout() bool {
+	var ne net.Error
+	return errors.Is(e.Err, os.ErrDeadlineExceeded) || errors.As(e.Err, &ne) && ne.Timeout()
+}
+
+// HashStream returns the root of the stream read from r, with leaves as cut
+// by a Writer, for sources such as network connections. Failures are returned
+// as a *StreamError: a read that waited longer than the idle timeout reports
+// Timeout, a stream that ends before its expected size fails with
+// io.ErrUnexpectedEOF, and other read errors are passed on. If ctx is done
+// first, HashStream fails with the error of ctx; sources that implement
+// ReadDeadliner are then interrupted in the middle of a read, and others
+// between reads.
+func (e *Encoder) HashStream(ctx context.Context, r io.Reader, opts StreamOptions) ([]byte, error) {
+	leafSize := opts.LeafSize
+	if leafSize == 0 {
+		leafSize = DefaultLeafSize
+	}
+	if leafSize < 0 || opts.Size < 0 {
+		return nil, errors.New("sakura: negative leaf size or stream size")
+	}
+	dl, _ := r.(ReadDeadliner)
+	if dl != nil {
+		stop := context.AfterFunc(ctx, func() { dl.SetReadDeadline(time.Unix(1, 0)) })
+		defer func() {
+			stop()
+			dl.SetReadDeadline(time.Time{})
+		}()
+	}
+	src := r
+	if opts.Size > 0 {
+		src = io.LimitReader(r, opts.Size)
+	}
+	w := NewWriter(e, leafSize)
+	buf := make([]byte, defaultBufferSize)
+	var n int64
+	fail := func(err error) ([]byte, error) {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, &StreamError{Offset: n, Err: err}
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return fail(err)
+		}
+		if dl != nil && opts.IdleTimeout > 0 {
+			if err := dl.SetReadDeadline(time.Now().Add(opts.IdleTimeout)); err != nil {
+				return fail(err)
+			}
+		}
+		m, err := src.Read(buf)
+		if m > 0 {
+			if _, err := w.Write(buf[:m]); err != nil {
+				return fail(err)
+			}
+			n += int64(m)
+			if opts.Progress != nil {
+				opts.Progress(n)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if opts.Size > 0 && n < opts.Size {
+		return fail(io.ErrUnexpectedEOF)
+	}
+	if err := w.Close(); err != nil {
+		return fail(err)
+	}
+	return w.Root(), nil
+}
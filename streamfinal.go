@@ -0,0 +1,90 @@
+package sakura
+
+import "errors"
+
+// SetStreaming makes w absorb the chaining values of the leaves into the
+// final node as they complete, in the order of the stream, instead of holding
+// them until Close, so that the memory of w stays flat whatever the number of
+// leaves. A value that completes ahead of those of earlier leaves waits for
+// them, so only the values of the leaves in flight are held. The root is the
+// same as without streaming.
+//
+// SetStreaming must be called before the first Write. A streaming writer holds
+// no parity, saves no checkpoints and spills nothing, and its encoder must
+// have no Audit writer, Tracer or Hooks, which cannot see the final node.
+func (w *Writer) SetStreaming() error {
+	if w.leaves > 0 || w.closing || w.closed {
+		return errors.New("sakura: streaming set after writing")
+	}
+	if w.parity != nil {
+		return errors.New("sakura: a streaming writer holds no parity")
+	}
+	if w.ck != nil {
+		return errors.New("sakura: checkpoints do not hold a streamed final node")
+	}
+	if w.pool.spillMax > 0 {
+		return errors.New("sakura: a streaming writer holds no chaining values to spill")
+	}
+	if w.e.Audit != nil || w.e.Tracer != nil || w.e.Hooks.hooked() {
+		return errors.New("sakura: the final node of a streaming writer is not audited, traced or hooked")
+	}
+	if err := w.e.checkMode(); err != nil {
+		return err
+	}
+	w.pool.final = &bitWriter{h: w.e.mode.Hash()}
+	return nil
+}
+
+// drain moves the chaining values of the leading completed leaves out of
+// memory, into the final node of a streaming writer or the spill file.
+func (p *leafPool) drain() error {
+	if p.final == nil {
+		return p.spillValues()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.absorb()
+	return nil
+}
+
+// begin starts the final node of a streaming writer with first, the data of
+// the first leaf, which kangaroo hopping nests in it.
+func (p *leafPool) begin(first []byte) {
+	if p.final == nil {
+		return
+	}
+	writeLeaf(p.final, p.e.mode, first)
+	writeKangaroo(p.final, p.e.mode)
+}
+
+// absorb writes the values of the leading completed leaves to the final node
+// and drops them. p.mu must be held.
+func (p *leafPool) absorb() {
+	k := p.completed()
+	for i, cv := range p.cvs[:k] {
+		if p.base+i == 0 && p.e.mode.Kangaroo {
+			continue // Nested in the final node, which begin started with it.
+		}
+		p.final.Write(cv)
+		p.values++
+	}
+	// Copy the rest so that the absorbed values can be collected.
+	p.cvs = append([][]byte(nil), p.cvs[k:]...)
+	p.base += k
+}
+
+// finalRoot absorbs the values of the remaining leaves, which must all be hashed,
+// and returns the root of the stream.
+func (p *leafPool) finalRoot() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.absorb()
+	var trailer []byte
+	writeChaining(p.final, p.e.mode, p.values, &trailer)
+	root := sumFinal(p.final, p.e.mode, nil)
+	if m := p.e.Metrics; m != nil {
+		m.Add(MetricNodesHashed, 1)
+		m.Add(MetricBytesHashed, p.final.n)
+	}
+	return root
+}
@@ -0,0 +1,116 @@
+package sakura
+
+import "errors"
+
+// ProofWriter hashes a stream of bytes as it is written, cutting it into
+// leaves of a fixed size that are appended to an MMR, and keeps what is needed
+// to prove one leaf chosen in advance, such as the leaf holding an offset that
+// an auditor asked for. Memory stays logarithmic in the length of the stream:
+// the tree is never materialized, only the peaks of the range and the chaining
+// values on the path of the chosen leaf as they complete.
+//
+// The root is that of the MMR over the leaves, not the root a Writer returns:
+// the final node of the two-level shape of Writer holds the chaining values of
+// all leaves, so every proof against it is as long as the stream.
+type ProofWriter struct {
+	m        *MMR
+	leafSize int
+	target   int
+	buf      []byte
+	leaf     []byte // Bits of the chosen leaf, once it is complete.
+	closed   bool
+	err      error
+}
+
+// NewProofWriter returns a ProofWriter that hashes with e, cutting the stream
+// into leaves of leafSize bytes, and proves leaf target, which for a byte at
+// offset off is off/leafSize. It panics if leafSize is not positive or target
+// is negative.
+func NewProofWriter(e *Encoder, leafSize, target int) (*ProofWriter, error) {
+	if leafSize <= 0 || target < 0 {
+		panic("sakura: invalid leaf size or leaf index")
+	}
+	m, err := NewMMR(e)
+	if err != nil {
+		return nil, err
+	}
+	return &ProofWriter{m: m, leafSize: leafSize, target: target}, nil
+}
+
+// Write appends p to the stream, hashing every leaf it completes.
+func (w *ProofWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		if w.buf == nil {
+			w.buf = make([]byte, 0, w.leafSize)
+		}
+		k := copy(w.buf[len(w.buf):w.leafSize], p)
+		w.buf, p, n = w.buf[:len(w.buf)+k], p[k:], n+k
+		if len(w.buf) == w.leafSize {
+			if err := w.append(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// append hashes the leaf being filled.
+func (w *ProofWriter) append() error {
+	i, err := w.m.Append(w.buf)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	if i == w.target {
+		w.leaf = w.buf
+	}
+	w.buf = nil
+	w.m.prune(w.target)
+	return nil
+}
+
+// Close hashes the last, possibly shorter, leaf and the root.
+func (w *ProofWriter) Close() error {
+	if w.closed {
+		return w.err
+	}
+	if w.err == nil && len(w.buf) > 0 {
+		w.append()
+	}
+	w.closed = true
+	return w.err
+}
+
+// Root returns the root of the stream, once Close has succeeded.
+func (w *ProofWriter) Root() []byte {
+	if !w.closed || w.err != nil {
+		return nil
+	}
+	return w.m.Root()
+}
+
+// Len returns the number of leaves hashed so far.
+func (w *ProofWriter) Len() int { return w.m.Len() }
+
+// Proof returns the bits of the chosen leaf and its inclusion proof against
+// Root, which VerifyMMRLeaf checks with Len. It fails if Close has not
+// succeeded or the stream ended before the leaf.
+func (w *ProofWriter) Proof() (leaf []byte, proof *Proof, err error) {
+	if !w.closed || w.err != nil {
+		return nil, nil, errors.New("sakura: proof writer not closed")
+	}
+	if w.target >= w.m.Len() {
+		return nil, nil, errors.New("sakura: leaf index out of range")
+	}
+	if proof, err = w.m.Prove(w.target); err != nil {
+		return nil, nil, err
+	}
+	return w.leaf, proof, nil
+}
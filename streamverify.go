@@ -0,0 +1,154 @@
+package sakura
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLengthMismatch is returned by a StreamVerifier when the stream has more
+// or fewer leaves than its leaf layer.
+var ErrLengthMismatch = errors.New("sakura: stream length does not match the leaf layer")
+
+// LeafMismatchError is returned by a StreamVerifier when a leaf of the stream
+// does not match its chaining value.
+type LeafMismatchError struct {
+	Leaf  int       // Index of the leaf.
+	Range ByteRange // Bytes of the leaf in the stream.
+}
+
+func (e *LeafMismatchError) Error() string {
+	return fmt.Sprintf("sakura: leaf %d (bytes %d-%d) does not match", e.Leaf, e.Range.Off, e.Range.Off+e.Range.Len-1)
+}
+
+// StreamVerifier checks a stream as it arrives against a root and its leaf
+// layer, the chaining values of its leaves concatenated in order as returned
+// by Encoder.LeafHashes, which is the usual way to validate downloads from
+// untrusted peers or caches. The stream is cut into leaves of a fixed size in
+// the shape of Writer, and every leaf is checked as soon as it is complete, so
+// that a bad leaf is detected without waiting for the rest of the stream.
+//
+// The layer itself is checked against the root as soon as possible: when the
+// verifier is created if the root can be computed from the layer alone, and
+// otherwise once the first leaf is complete, which kangaroo hopping nests in
+// the final node, or at Close for a stream of a single leaf. Verified tells how
+// much of the stream has been checked against the root.
+type StreamVerifier struct {
+	e        *Encoder
+	root     []byte
+	leafSize int
+	layer    [][]byte
+	checked  bool   // Whether the layer has been checked against the root.
+	buf      []byte // Bytes of the current leaf.
+	leaf     int    // Index of the current leaf.
+	verified int64
+	err      error
+}
+
+// NewStreamVerifier returns a verifier for a stream with the given root in
+// mode, cut into leaves of leafSize bytes, whose leaf layer is layer. It
+// returns ErrRootMismatch if the layer does not match the root and a
+// *DecodeError if its length is not a positive multiple of the hash size.
+func NewStreamVerifier(mode HashingMode, root []byte, leafSize int, layer []byte) (*StreamVerifier, error) {
+	if mode.Hash == nil {
+		return nil, ErrNoHash
+	}
+	if leafSize <= 0 {
+		return nil, errors.New("sakura: non-positive leaf size")
+	}
+	cvs, err := splitLayer(mode, layer)
+	if err != nil {
+		return nil, err
+	}
+	v := &StreamVerifier{e: New(mode), root: root, leafSize: leafSize, layer: cvs}
+	if len(v.layer) > 1 && !mode.Kangaroo {
+		if err := v.checkRoot(nil); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// checkRoot checks the layer against the root, with first as the bits of the
+// first leaf, which are needed with kangaroo hopping or for a single leaf.
+func (v *StreamVerifier) checkRoot(first []byte) error {
+	got, err := v.e.Final(layerTree(v.e.mode, v.layer, first))
+	if err != nil {
+		return err
+	}
+	if err := compareRoots(got, v.root, ErrRootMismatch); err != nil {
+		return err
+	}
+	v.checked = true
+	return nil
+}
+
+// Write consumes p, checking every leaf that it completes. It fails with a
+// *LeafMismatchError for the first leaf that does not match, ErrRootMismatch
+// if the layer turns out not to match the root, and ErrLengthMismatch if the
+// stream is longer than the layer allows, after which the verifier is
+// unusable.
+func (v *StreamVerifier) Write(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if len(v.buf) == v.leafSize {
+			if v.leaf == len(v.layer)-1 {
+				v.err = ErrLengthMismatch
+				return 0, v.err
+			}
+			if v.err = v.checkLeaf(); v.err != nil {
+				return 0, v.err
+			}
+		}
+		k := min(v.leafSize-len(v.buf), len(p))
+		v.buf = append(v.buf, p[:k]...)
+		p = p[k:]
+	}
+	return n, nil
+}
+
+// checkLeaf checks the current leaf and moves on to the next.
+func (v *StreamVerifier) checkLeaf() error {
+	cv, err := v.e.leafValue(v.leaf, v.buf)
+	if err != nil {
+		return err
+	}
+	if compareRoots(cv, v.layer[v.leaf], ErrRootMismatch) != nil {
+		off := int64(v.leaf) * int64(v.leafSize)
+		return &LeafMismatchError{Leaf: v.leaf, Range: ByteRange{Off: off, Len: int64(len(v.buf))}}
+	}
+	if !v.checked {
+		if err := v.checkRoot(v.buf); err != nil {
+			return err
+		}
+	}
+	v.verified += int64(len(v.buf))
+	v.leaf++
+	v.buf = v.buf[:0]
+	return nil
+}
+
+// Close checks the last leaf and that the stream has as many leaves as the
+// layer. It returns nil only if the whole stream matches the root.
+func (v *StreamVerifier) Close() error {
+	if v.err != nil {
+		return v.err
+	}
+	if v.leaf != len(v.layer)-1 {
+		v.err = ErrLengthMismatch
+		return v.err
+	}
+	v.err = v.checkLeaf()
+	return v.err
+}
+
+// Verified returns the number of bytes at the start of the stream that have
+// been checked against the root.
+func (v *StreamVerifier) Verified() int64 {
+	if !v.checked {
+		return 0
+	}
+	return v.verified
+}
@@ -0,0 +1,69 @@
+package sakura
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrNotVetted is wrapped by the errors of CheckStrict.
+var ErrNotVetted = errors.New("sakura: mode is not allowed in strict mode")
+
+// strictHashSize is the smallest size of chaining values that CheckStrict
+// accepts, for a security strength of 128 bits against collisions.
+const strictHashSize = 32
+
+// CheckStrict reports whether mode is one whose soundness this package
+// vouches for, the modes that strict encoders accept. It rejects:
+//
+//   - alignment without kangaroo hopping, which has no effect;
+//   - codings and paddings other than those of this package, whose frame
+//     bits may not keep nodes apart;
+//   - hash functions with outputs shorter than 32 bytes;
+//   - plain SHA-256 and SHA-512, whose hashes can be extended to those of
+//     longer nodes, as explained for SHA256Mode.
+//
+// Failures wrap ErrNotVetted. Like all checks of a Hasher, it cannot tell a
+// weak hash function from a sound one.
+func CheckStrict(mode HashingMode) error {
+	if mode.Hash == nil {
+		return ErrNoHash
+	}
+	fail := func(reason string) error { return fmt.Errorf("%w: %s", ErrNotVetted, reason) }
+	if mode.Alignment > 1 && !mode.Kangaroo {
+		return fail("alignment without kangaroo hopping")
+	}
+	if !vettedCoding(mode.Coding) {
+		return fail("custom coding")
+	}
+	switch mode.Padding.(type) {
+	case nil, SimplePadding, MultiRatePadding, HashPadding:
+	default:
+		return fail("custom padding")
+	}
+	h := mode.Hash()
+	if h.Size() < strictHashSize {
+		return fail(fmt.Sprintf("hash size of %d bytes", h.Size()))
+	}
+	if t := reflect.TypeOf(h); t == reflect.TypeOf(sha256.New()) || t == reflect.TypeOf(sha512.New()) {
+		return fail("plain SHA-2, use SHA256Mode or SHA512Mode")
+	}
+	return nil
+}
+
+// vettedCoding reports whether c is nil or a coding of this package.
+func vettedCoding(c Coding) bool {
+	switch c := c.(type) {
+	case nil, SakuraCoding, suffixCoding, intCoding:
+		return true
+	case saltCoding:
+		return vettedCoding(c.Coding)
+	case leafLengthCoding:
+		return vettedCoding(c.Coding)
+	case domainCoding:
+		return vettedCoding(c.Coding)
+	}
+	return false
+}
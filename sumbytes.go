@@ -0,0 +1,95 @@
+package sakura
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// SumBytes returns the root of data that a Writer with the given leaf size
+// computes, as the fast path for streams held in memory. Leaves are sliced
+// from data by offset and hashed straight from it as inner nodes without
+// going through hops, on up to Parallelism goroutines, into a single buffer
+// that the final node then absorbs in one write, coded directly in the
+// two-level shape of KangarooTwelve instead of from a tree. An encoder with an
+// Audit writer, a Tracer, Metrics, Hooks, Values, an Accelerator or a rate
+// limit, which must see every leaf, takes the path of a Writer instead.
+func (e *Encoder) SumBytes(data []byte, leafSize int) ([]byte, error) {
+	if leafSize <= 0 {
+		return nil, errors.New("sakura: non-positive leaf size")
+	}
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	if err := e.checkStreamSize(int64(len(data)), leafSize); err != nil {
+		return nil, err
+	}
+	if e.Audit != nil || e.Tracer != nil || e.Metrics != nil || e.Hooks.hooked() || e.Values != nil || e.Accelerator != nil || e.BytesPerSecond > 0 {
+		w := NewWriter(e, leafSize)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return w.Root(), nil
+	}
+	n := leafCount(int64(len(data)), leafSize)
+	if max := e.MaxDegree; max > 0 && n > max {
+		return nil, &LimitError{Node: NodeID{}, Limit: "degree", Max: int64(max), Value: int64(n)}
+	}
+	first := data[:min(leafSize, len(data))]
+	start := 0
+	if n == 1 || e.mode.Kangaroo {
+		start = 1 // The first leaf is nested in the final node.
+	}
+	// The chaining values of the leaves from start, in the order of the final
+	// node.
+	size := e.mode.Hash().Size()
+	slab := make([]byte, (n-start)*size)
+	if start < n {
+		var next atomic.Int64
+		next.Store(int64(start))
+		work := func() {
+			s := e.getScratch()
+			defer e.putScratch(s)
+			s.w.h = s.h
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+				off := (i - start) * size
+				leaf := data[i*leafSize : min((i+1)*leafSize, len(data))]
+				hashLeaf(&s.w, e.mode, leaf, slab[off:off:off+size])
+			}
+		}
+		if workers := min(max(e.Parallelism, 1), n-start); workers == 1 {
+			work()
+		} else {
+			var wg sync.WaitGroup
+			for k := 0; k < workers; k++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					work()
+				}()
+			}
+			wg.Wait()
+		}
+	}
+	s := e.getScratch()
+	defer e.putScratch(s)
+	s.w.h = s.h
+	if start == 1 {
+		writeLeaf(&s.w, e.mode, first)
+	}
+	if n > 1 {
+		if start == 1 {
+			writeKangaroo(&s.w, e.mode)
+		}
+		s.w.Write(slab)
+		writeChaining(&s.w, e.mode, n-start, &s.trailer)
+	}
+	return sumFinal(&s.w, e.mode, nil), nil
+}
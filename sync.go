@@ -0,0 +1,156 @@
+package sakura
+
+import (
+	"io"
+	"reflect"
+	"sync"
+)
+
+// Synchronize returns a hop that wraps the tree rooted at hop and serializes
+// all calls to ChainingValue, SetChainingValue, Child, Degree and Next of its
+// hops through a single mutex. It lets hop implementations that are not safe
+// for concurrent use be hashed by an Encoder with Parallelism.
+//
+// Reads of message hops are not serialized, since every message hop is read
+// by a single worker. Labels and priorities are passed through, and message
+// hops are seekable if the wrapped hop implements io.Seeker. A pointer hop is
+// always wrapped by the same wrapper, so cycles are still detected.
+func Synchronize(hop Hop) Hop {
+	t := &syncTree{wrappers: make(map[Hop]Hop)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.wrap(hop)
+}
+
+// syncTree holds the state shared by the wrappers of a tree.
+type syncTree struct {
+	mu       sync.Mutex
+	wrappers map[Hop]Hop // Wrappers of pointer hops.
+}
+
+// wrap returns the wrapper of hop. It must be called with t.mu held.
+func (t *syncTree) wrap(hop Hop) Hop {
+	if w, ok := t.wrappers[hop]; ok {
+		return w
+	}
+	chaining, err := isChaining(hop)
+	if err != nil {
+		// Leave invalid hops for the encoder to report.
+		return hop
+	}
+	var w Hop
+	if h, ok := hop.(ChildStream); ok {
+		w = &syncStream{syncHop{hop, &t.mu}, h, t}
+	} else if h, ok := hop.(ChainingHop64); ok {
+		w = &syncChaining64{syncHop{hop, &t.mu}, h, t}
+	} else if chaining {
+		w = &syncChaining{syncHop{hop, &t.mu}, hop.(ChainingHop), t}
+	} else {
+		w = &syncMessage{syncHop{hop, &t.mu}, hop.(MessageHop)}
+	}
+	if reflect.TypeOf(hop).Kind() == reflect.Pointer {
+		t.wrappers[hop] = w
+	}
+	return w
+}
+
+// syncHop serializes the Hop methods of a wrapped hop.
+type syncHop struct {
+	hop Hop
+	mu  *sync.Mutex
+}
+
+func (s *syncHop) ChainingValue() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hop.ChainingValue()
+}
+
+func (s *syncHop) ChainingValueErr() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.hop.(ChainingValueErr); ok {
+		return h.ChainingValueErr()
+	}
+	return s.hop.ChainingValue(), nil
+}
+
+func (s *syncHop) SetChainingValue(hash []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hop.SetChainingValue(hash)
+}
+
+func (s *syncHop) Label() string           { return label(s.hop) }
+func (s *syncHop) priority(parent int) int { return priority(s.hop, parent) }
+
+type syncChaining struct {
+	syncHop
+	h    ChainingHop
+	tree *syncTree
+}
+
+func (s *syncChaining) Child(i int) Hop {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.wrap(s.h.Child(i))
+}
+
+func (s *syncChaining) Degree() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.Degree()
+}
+
+type syncChaining64 struct {
+	syncHop
+	h    ChainingHop64
+	tree *syncTree
+}
+
+func (s *syncChaining64) ChildErr(i int64) (Hop, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.h.ChildErr(i)
+	if err != nil {
+		return nil, err
+	}
+	return s.tree.wrap(c), nil
+}
+
+func (s *syncChaining64) Degree64() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.h.Degree64()
+}
+
+type syncStream struct {
+	syncHop
+	h    ChildStream
+	tree *syncTree
+}
+
+func (s *syncStream) Next() (Hop, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.h.Next()
+	if err != nil {
+		return nil, err
+	}
+	return s.tree.wrap(c), nil
+}
+
+type syncMessage struct {
+	syncHop
+	m MessageHop
+}
+
+func (s *syncMessage) Read(p []byte) (int, error) { return s.m.Read(p) }
+func (s *syncMessage) Offset() int64              { return offsetOf(s.m) }
+
+func (s *syncMessage) Seek(offset int64, whence int) (int64, error) {
+	if sk, ok := s.m.(io.Seeker); ok {
+		return sk.Seek(offset, whence)
+	}
+	return 0, ErrNotSeekable
+}
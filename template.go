@@ -0,0 +1,167 @@
+package sakura
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Template describes the geometry of a tree independently of its data, so that
+// protocols can pin the exact tree of an input and any party can rebuild it.
+//
+// The input is cut into leaves of LeafSize bytes, the last of which may be
+// shorter; an empty input is a single empty leaf. While more than one hop
+// remains, the hops are grouped under chaining hops, Fanouts[0] at a time for
+// the first level above the leaves, Fanouts[1] at a time for the next and so
+// on. Once the levels of Fanouts are used up, all remaining hops are grouped
+// under a single root. A single remaining hop is the root itself.
+//
+// The zero Fanouts gives the two-level shape of Writer, so
+//
+//	Template{LeafSize: n}
+//
+// describes the tree whose root a Writer with leaf size n computes.
+type Template struct {
+	LeafSize int
+	Fanouts  []int
+}
+
+// Validate reports whether t describes a tree: the leaf size must be positive
+// and every fanout at least 2.
+func (t Template) Validate() error {
+	if t.LeafSize <= 0 {
+		return errors.New("sakura: template has a non-positive leaf size")
+	}
+	for _, f := range t.Fanouts {
+		if f < 2 {
+			return errors.New("sakura: template has a fanout below 2")
+		}
+	}
+	return nil
+}
+
+// Tree arranges leaves, made of the input cut as described by t, into the
+// tree of t. It panics if t is invalid or there are no leaves.
+func (t Template) Tree(leaves []Hop) Hop {
+	if err := t.Validate(); err != nil {
+		panic(err)
+	}
+	if len(leaves) == 0 {
+		panic("sakura: no leaves")
+	}
+	level := leaves
+	for _, f := range t.Fanouts {
+		if len(level) == 1 {
+			break
+		}
+		level = group(level, f)
+	}
+	return sequentialTree(level)
+}
+
+// Bytes returns the tree of t over data.
+func (t Template) Bytes(data []byte) Hop {
+	if err := t.Validate(); err != nil {
+		panic(err)
+	}
+	var leaves []Hop
+	for off := 0; off < len(data) || off == 0; off += t.LeafSize {
+		leaves = append(leaves, messageLeaf(data[off:min(off+t.LeafSize, len(data))]))
+	}
+	return t.Tree(leaves)
+}
+
+// Ranger returns the tree of t over the first size bytes of the object read by
+// r, whose leaves are fetched when they are hashed.
+func (t Template) Ranger(r Ranger, size int64) Hop {
+	if err := t.Validate(); err != nil {
+		panic(err)
+	}
+	return t.Tree(RangeLeaves(r, size, t.LeafSize))
+}
+
+// group returns chaining hops over level, fanout hops at a time.
+func group(level []Hop, fanout int) []Hop {
+	var next []Hop
+	for i := 0; i < len(level); i += fanout {
+		j := min(i+fanout, len(level))
+		next = append(next, &chainingLeaves{kids: level[i:j:j]})
+	}
+	return next
+}
+
+// templatePrefix starts the encoding of a template and versions it.
+const templatePrefix = "v1:"
+
+// String returns the compact form of t, which DecodeTemplate reads back: the
+// version "v1", the leaf size and the fanouts from the leaves up, as in
+// "v1:4096:16,16", or "v1:4096" without fanouts.
+func (t Template) String() string {
+	b := strconv.AppendInt([]byte(templatePrefix), int64(t.LeafSize), 10)
+	for i, f := range t.Fanouts {
+		if i == 0 {
+			b = append(b, ':')
+		} else {
+			b = append(b, ',')
+		}
+		b = strconv.AppendInt(b, int64(f), 10)
+	}
+	return string(b)
+}
+
+// MarshalText implements encoding.TextMarshaler with the form of String.
+func (t Template) MarshalText() ([]byte, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *Template) UnmarshalText(text []byte) error {
+	u, err := DecodeTemplate(string(text))
+	if err != nil {
+		return err
+	}
+	*t = u
+	return nil
+}
+
+// DecodeTemplate reconstructs a template from its compact form, as returned
+// by Template.String. It returns ErrMalformed for strings that are not in
+// that form, and the error of Template.Validate for invalid templates.
+func DecodeTemplate(s string) (Template, error) {
+	rest, ok := strings.CutPrefix(s, templatePrefix)
+	if !ok {
+		return Template{}, ErrMalformed
+	}
+	leaf, fanouts, hasFanouts := strings.Cut(rest, ":")
+	var t Template
+	var err error
+	if t.LeafSize, err = decimal(leaf); err != nil {
+		return Template{}, err
+	}
+	if hasFanouts {
+		for _, f := range strings.Split(fanouts, ",") {
+			n, err := decimal(f)
+			if err != nil {
+				return Template{}, err
+			}
+			t.Fanouts = append(t.Fanouts, n)
+		}
+	}
+	return t, t.Validate()
+}
+
+// decimal parses a canonical decimal int, without signs or leading zeros, so
+// that every template has a single compact form.
+func decimal(s string) (int, error) {
+	if s == "" || s[0] == '0' && len(s) > 1 || s[0] == '+' || s[0] == '-' {
+		return 0, ErrMalformed
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, ErrMalformed
+	}
+	return n, nil
+}
@@ -0,0 +1,93 @@
+package sakura
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracer receives spans describing the work of an Encoder, so that the time
+// spent hashing a tree can be broken down in an existing tracing system. An
+// adapter for a tracing library only needs to map these calls onto its own
+// span type.
+//
+// Every call to Final or Inner produces one span, named "sakura.Final" or
+// "sakura.Inner", and one child span per level of the tree named
+// "sakura.level". The span of a call has a "label" attribute if the hashed hop
+// is a LabeledHop. A level span starts when the first node of the level starts
+// being hashed and ends when the last one is done. Level spans are reported
+// once the call completes.
+type Tracer interface {
+	// StartSpan starts a span that began at the given time. Parent is nil for
+	// the span of a call, and the span of the call for the spans of its levels.
+	StartSpan(parent Span, name string, start time.Time) Span
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute of the span. Values are of type int64
+	// or string.
+	SetAttribute(key string, value any)
+
+	// End ends the span at the given time, recording err if it is not nil.
+	End(end time.Time, err error)
+}
+
+// levelTrace accumulates the work done on one level of a tree.
+type levelTrace struct {
+	start, end time.Time
+	nodes      int64
+	bytes      int64 // Total size of the coded nodes.
+}
+
+// tracer accumulates the level traces of a job.
+type tracer struct {
+	mu     sync.Mutex
+	levels []levelTrace
+}
+
+// traceNode records that a node of the given level, whose coding was n bytes
+// long, was hashed from start until now.
+func (j *job) traceNode(level int, start time.Time, n int64) {
+	end := time.Now()
+	t := &j.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for len(t.levels) <= level {
+		t.levels = append(t.levels, levelTrace{})
+	}
+	l := &t.levels[level]
+	if l.nodes == 0 || start.Before(l.start) {
+		l.start = start
+	}
+	if end.After(l.end) {
+		l.end = end
+	}
+	l.nodes++
+	l.bytes += n
+}
+
+// traced runs fn, reporting it and the levels it hashed as spans of the given
+// name if the encoder has a Tracer.
+func (j *job) traced(name string, hop Hop, fn func() ([]byte, error)) ([]byte, error) {
+	tr := j.e.Tracer
+	if tr == nil {
+		return fn()
+	}
+	span := tr.StartSpan(nil, name, time.Now())
+	if l := label(hop); l != "" {
+		span.SetAttribute("label", l)
+	}
+	sum, err := fn()
+	j.trace.mu.Lock()
+	levels := j.trace.levels
+	j.trace.mu.Unlock()
+	for i, l := range levels {
+		s := tr.StartSpan(span, "sakura.level", l.start)
+		s.SetAttribute("level", int64(i))
+		s.SetAttribute("nodes", l.nodes)
+		s.SetAttribute("bytes", l.bytes)
+		s.End(l.end, nil)
+	}
+	span.End(time.Now(), err)
+	return sum, err
+}
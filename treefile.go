@@ -0,0 +1,379 @@
+package sakura
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The tree file format stores a hop tree, in full or with completed subtrees
+// reduced to their chaining values. All integers are unsigned varints as
+// written by binary.AppendUvarint.
+//
+//	file     ::= magic version mode header nodes leaves
+//	magic    ::= "SKTR"
+//	version  ::= 0x02
+//	mode     ::= mode header, as returned by EncodeModeHeader
+//	header   ::= count field*
+//	field    ::= tag length value
+//	nodes    ::= count node*
+//	node     ::= 0x00 CV | 0x01 | 0x02 degree
+//	leaves   ::= (length bits)*
+//
+// The header holds optional fields, of which none are defined yet. Nodes are
+// listed in pre-order, so that every chaining node (kind 2) is followed by its
+// degree children, and stored nodes (kind 0) hold a chaining value of the hash
+// size given in the mode. The leaf map lists the bits of the message nodes (kind 1) in the
+// order of the node table, after it, so that the structure of a tree can be
+// read without its data.
+//
+// Compatibility rules: readers reject files with a version they do not know,
+// which is only changed for changes a reader could not otherwise detect.
+// Additions are made through new header fields. A field with an unknown even
+// tag is ignored, while a field with an unknown odd tag makes the file
+// unreadable, so writers mark fields that change the meaning of the file as
+// odd. Known fields keep their tag and layout, and readers ignore bytes that
+// follow the part of a value they know. Version 1 held the mode in header
+// field 1, which is not to be reused, as a mode header of version 1 without
+// its version byte. ReadTree reads files of both versions, and files of
+// version 2 whose mode header is of version 1; MigrateTree rewrites them in
+// the current version.
+const (
+	treeMagic   = "SKTR"
+	treeVersion = 2
+
+	treeFieldModeV1 = 1
+)
+
+// Kinds of nodes in the node table.
+const (
+	treeStored   = 0 // A hop of which only the chaining value is kept.
+	treeMessage  = 1 // A message hop, whose bits are in the leaf map.
+	treeChaining = 2 // A chaining hop, followed by its children.
+)
+
+// WriteTree writes the tree rooted at hop to w in the tree file format.
+// Subtrees whose chaining values are cached and coded in their parent's node
+// are written as the value alone. Message hops in the rest of the tree are
+// read to the end and must not have been read before.
+func (e *Encoder) WriteTree(w io.Writer, hop Hop) error {
+	if err := e.checkMode(); err != nil {
+		return err
+	}
+	b := append([]byte(treeMagic), treeVersion)
+	b = appendModeHeader(b, e.mode.Header())
+	b = append(b, 0) // No header fields.
+
+	var nodes int
+	var table []byte
+	var leaves []Hop // Message hops, in node order.
+	var ids []NodeID // IDs of the leaves.
+	path := make(ancestors)
+	var visit func(hop Hop, id NodeID, nested bool) error
+	visit = func(hop Hop, id NodeID, nested bool) error {
+		nodes++
+		if !nested {
+			cv, err := cachedValue(hop, id)
+			if err != nil {
+				return err
+			}
+			if cv != nil {
+				table = append(append(table, treeStored), cv...)
+				return nil
+			}
+		}
+		chaining, err := isChaining(hop)
+		if err != nil {
+			return err
+		}
+		if !chaining {
+			table = append(table, treeMessage)
+			leaves = append(leaves, hop)
+			ids = append(ids, id)
+			return nil
+		}
+		if err := path.enter(hop, id); err != nil {
+			return err
+		}
+		defer path.exit(hop)
+		n, err := degree(hop, id)
+		if err != nil {
+			return err
+		}
+		table = binary.AppendUvarint(append(table, treeChaining), uint64(n))
+		for i := 0; i < n; i++ {
+			c, err := child(hop, id, i)
+			if err != nil {
+				return err
+			}
+			if err := visit(c, id.Child(i), i == 0 && e.mode.Kangaroo); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// The root is coded in the final node itself, never as a value.
+	if err := visit(hop, NodeID{}, true); err != nil {
+		return err
+	}
+	b = binary.AppendUvarint(b, uint64(nodes))
+	if _, err := w.Write(append(b, table...)); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for i, l := range leaves {
+		buf.Reset()
+		if err := copyLeaf(&buf, l, ids[i], i); err != nil {
+			return err
+		}
+		if _, err := w.Write(binary.AppendUvarint(nil, uint64(buf.Len()))); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalTree returns the tree rooted at hop in the tree file format, as
+// written by WriteTree, for stores that keep trees as single values.
+func (e *Encoder) MarshalTree(hop Hop) ([]byte, error) {
+	var b bytes.Buffer
+	if err := e.WriteTree(&b, hop); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// UnmarshalTree reads a tree returned by Encoder.MarshalTree for the given
+// mode, as ReadTree does.
+func UnmarshalTree(data []byte, mode HashingMode) (Hop, error) {
+	return ReadTree(bytes.NewReader(data), mode)
+}
+
+// ReadTree reads a tree written by Encoder.WriteTree for the given mode,
+// within DefaultDecodeLimits. It returns ErrModeMismatch if the file was
+// written for another mode and a *DecodeError, which matches ErrMalformed, if
+// it is not a valid tree file.
+func ReadTree(r io.Reader, mode HashingMode) (Hop, error) {
+	return DefaultDecodeLimits.ReadTree(r, mode)
+}
+
+// ReadTree is like the function ReadTree, but within the limits l. MaxBytes
+// counts the whole file, MaxDepth the nesting of the node table, MaxNodes its
+// length and MaxLeaves its message and stored leaves.
+func (l DecodeLimits) ReadTree(r io.Reader, mode HashingMode) (Hop, error) {
+	if mode.Hash == nil {
+		return nil, ErrNoHash
+	}
+	t := treeReader{r: bufio.NewReader(r), limits: l}
+	if magic := t.read(len(treeMagic)); t.err == nil && string(magic) != treeMagic {
+		t.fail("not a tree file")
+	}
+	version := t.read(1)[0]
+	if t.err == nil && version != 1 && version != treeVersion {
+		t.fail(fmt.Sprintf("unknown version %d", version))
+	}
+	if version == treeVersion {
+		start := t.off
+		h := binary.AppendUvarint(t.read(5), t.uvarint())
+		if h[0] > 1 { // Mode headers of version 1 have no fingerprint.
+			h = append(h, t.read(len(Fingerprint{}))...)
+		}
+		if err := t.checkMode(h, start, mode); err != nil {
+			return nil, err
+		}
+	}
+	var modeV1 []byte
+	var modeOff int64
+	for n := t.uvarint(); n > 0 && t.err == nil; n-- {
+		tag := t.uvarint()
+		v := t.read(t.length())
+		switch {
+		case t.err != nil:
+		case version == 1 && tag == treeFieldModeV1:
+			modeV1, modeOff = v, t.off-int64(len(v))
+		case tag%2 == 1:
+			t.fail(fmt.Sprintf("unknown required header field %d", tag))
+		}
+	}
+	if version == 1 {
+		if modeV1 == nil {
+			t.fail("no mode field")
+		}
+		// The field holds a mode header of version 1 without its version.
+		if err := t.checkMode(append([]byte{1}, modeV1...), modeOff-1, mode); err != nil {
+			return nil, err
+		}
+	}
+	size := mode.Hash().Size()
+
+	var leaves []*bytesLeaf
+	nodes := t.uvarint()
+	if t.err == nil {
+		t.limit(l.check("nodes", int64(l.MaxNodes), int64(min(nodes, 1<<62)), nil))
+	}
+	var path NodeID // ID of the node being loaded.
+	var leafNodes int64
+	var load func() Hop
+	load = func() Hop {
+		if nodes == 0 && t.err == nil {
+			t.fail("more nodes than counted")
+		}
+		if t.err == nil {
+			t.limit(l.check("depth", int64(l.MaxDepth), int64(len(path)), append(NodeID(nil), path...)))
+		}
+		if t.err != nil {
+			return nil
+		}
+		nodes--
+		kind := t.read(1)[0]
+		if kind == treeStored || kind == treeMessage {
+			leafNodes++
+			t.limit(l.check("leaves", int64(l.MaxLeaves), leafNodes, append(NodeID(nil), path...)))
+			if t.err != nil {
+				return nil
+			}
+		}
+		switch kind {
+		case treeStored:
+			return &storedLeaf{cv: t.read(size)}
+		case treeMessage:
+			leaf := messageLeaf(nil)
+			leaves = append(leaves, leaf)
+			return leaf
+		case treeChaining:
+			c := &chainingLeaves{}
+			n := t.uvarint()
+			for i := 0; uint64(i) < n && t.err == nil; i++ {
+				path = append(path, i)
+				c.kids = append(c.kids, load())
+				path = path[:len(path)-1]
+			}
+			return c
+		default:
+			if t.err == nil {
+				t.fail(fmt.Sprintf("unknown node kind %d", kind))
+			}
+			return nil
+		}
+	}
+	hop := load()
+	if nodes != 0 && t.err == nil {
+		t.fail("fewer nodes than counted")
+	}
+	for _, leaf := range leaves {
+		leaf.Reader = bytes.NewReader(t.read(t.length()))
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+	if _, err := t.r.ReadByte(); err != io.EOF {
+		t.fail("trailing data")
+		return nil, t.err
+	}
+	return hop, nil
+}
+
+// checkMode checks that the mode header h, read at offset off, is the header
+// of mode, unless an error is already recorded.
+func (t *treeReader) checkMode(h []byte, off int64, mode HashingMode) error {
+	if t.err != nil {
+		return t.err
+	}
+	d := newDecoder("tree file", h)
+	if err := d.checkModeHeader(mode); err != nil {
+		if e, ok := err.(*DecodeError); ok {
+			e.Offset += off
+		}
+		return err
+	}
+	return nil
+}
+
+// treeReader reads the parts of a tree file, recording the first error.
+type treeReader struct {
+	r      *bufio.Reader
+	limits DecodeLimits
+	off    int64 // Number of bytes read.
+	err    error
+}
+
+// fail records a *DecodeError for the given reason at the current offset,
+// unless an error is already recorded.
+func (t *treeReader) fail(reason string) {
+	if t.err == nil {
+		t.err = &DecodeError{Format: "tree file", Offset: t.off, Reason: reason}
+	}
+}
+
+// limit records err, if not nil, unless an error is already recorded.
+func (t *treeReader) limit(err error) {
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// ReadByte reads a byte for binary.ReadUvarint, counting it against the size
+// limit.
+func (t *treeReader) ReadByte() (byte, error) {
+	if err := t.limits.check("bytes", t.limits.MaxBytes, t.off+1, nil); err != nil {
+		t.limit(err)
+		return 0, err
+	}
+	c, err := t.r.ReadByte()
+	if err == nil {
+		t.off++
+	}
+	return c, err
+}
+
+func (t *treeReader) uvarint() uint64 {
+	if t.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(t)
+	if err != nil {
+		t.fail("invalid varint")
+	}
+	return v
+}
+
+// length reads a length that can be held in memory.
+func (t *treeReader) length() int {
+	v := t.uvarint()
+	if v > 1<<40 {
+		t.fail("length out of range")
+		return 0
+	}
+	return int(v)
+}
+
+// read reads n bytes. It returns a single zero byte if reading fails, so that
+// callers may index the result.
+func (t *treeReader) read(n int) []byte {
+	if t.err != nil {
+		return []byte{0}
+	}
+	if err := t.limits.check("bytes", t.limits.MaxBytes, t.off+int64(n), nil); err != nil {
+		t.limit(err)
+		return []byte{0}
+	}
+	// Grow the buffer as data arrives, so that a corrupt length cannot
+	// allocate more than the file holds.
+	var buf bytes.Buffer
+	m, err := io.CopyN(&buf, t.r, int64(n))
+	t.off += m
+	if err != nil || m != int64(n) {
+		t.fail("unexpected end of input")
+		return []byte{0}
+	}
+	if n == 0 {
+		return []byte{}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,299 @@
+package sakura
+
+import (
+	"hash"
+	"sync"
+)
+
+// TreeHash is a hash.Hash that computes the root of the written stream in the
+// shape of BuildTree: the stream is cut into leaves of a fixed size, which are
+// grouped fanout at a time under chaining hops, level by level, up to a single
+// root. Its root is the one Encoder.Final returns for
+//
+//	BuildTree(leaves, fanout)
+//
+// over the same leaves, so that large files are hashed by a drop-in
+// replacement for a flat hash without building the tree by hand.
+//
+// Completed leaves are hashed on a pool of goroutines while writing goes on,
+// and the chaining values are folded into their parents, in order, as soon as
+// a group is complete, so that only the pending groups of every level are
+// held, besides the leaves in flight. Like the hashes of the standard
+// library, Sum appends the root to its argument without changing the state,
+// and Reset returns to the empty stream. A TreeHash is not safe for
+// concurrent use.
+type TreeHash struct {
+	e        *Encoder
+	leafSize int
+	fanout   int
+	size     int
+	block    int
+	sem      chan struct{} // Holds a token for every leaf being hashed.
+	wg       sync.WaitGroup
+
+	buf    []byte // Data of the leaf being filled.
+	handed int    // Number of leaves handed to the pool or kept.
+
+	mu     sync.Mutex
+	done   map[int]*treeEntry // Leaves hashed but not yet folded, by index.
+	next   int                // Index of the next leaf to fold.
+	levels []treeLevel        // Pending groups, from the leaves up.
+	err    error              // First error.
+}
+
+// treeLevel is the pending group of a level of a TreeHash.
+type treeLevel struct {
+	group []*treeEntry
+	count int // Number of entries of the level so far.
+}
+
+// treeEntry is a hop of a TreeHash: a leaf or a full group. The bits of a
+// leaf and the children of a group are only kept where the hop may be nested
+// in its parent, or be the root.
+type treeEntry struct {
+	cv   []byte       // Chaining value, unless the hop is only nested.
+	leaf bool         // Whether the hop is a leaf.
+	data []byte       // Bits of a kept leaf.
+	kids []*treeEntry // Children of a kept group.
+}
+
+// NewTreeHash returns a TreeHash that hashes in the given mode, cutting the
+// stream into leaves of leafSize bytes grouped fanout at a time, and hashing
+// leaves on up to workers goroutines, at least one. Size is that of the mode's
+// hash function, and so is BlockSize, as for NewHash. It panics if the mode
+// has no hash function, leafSize is not positive or fanout is below 2;
+// NewHashWith gives the two-level shape of Writer.
+func NewTreeHash(mode HashingMode, leafSize, fanout, workers int) *TreeHash {
+	if mode.Hash == nil {
+		panic(ErrNoHash)
+	}
+	if leafSize <= 0 {
+		panic("sakura: non-positive leaf size")
+	}
+	if fanout < 2 {
+		panic("sakura: fanout below 2")
+	}
+	h := mode.Hash()
+	t := &TreeHash{
+		e:        New(mode),
+		leafSize: leafSize,
+		fanout:   fanout,
+		size:     h.Size(),
+		block:    h.BlockSize(),
+		sem:      make(chan struct{}, max(workers, 1)),
+	}
+	t.Reset()
+	return t
+}
+
+var _ hash.Hash = (*TreeHash)(nil)
+
+// Write adds p to the stream. It never fails.
+func (t *TreeHash) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if t.buf == nil {
+			t.buf = make([]byte, 0, t.leafSize)
+		}
+		k := min(t.leafSize-len(t.buf), len(p))
+		t.buf = append(t.buf, p[:k]...)
+		p = p[k:]
+		if len(t.buf) == t.leafSize {
+			t.hand(t.buf)
+			t.buf = nil
+		}
+	}
+	return n, nil
+}
+
+// hand hashes the complete leaf data in the background, or keeps it if it is
+// only nested in its parent.
+func (t *TreeHash) hand(data []byte) {
+	i := t.handed
+	t.handed++
+	x := &treeEntry{leaf: true}
+	if t.kept(i) {
+		x.data = data
+	}
+	if t.nested(i) {
+		t.fold(i, x)
+		return
+	}
+	t.sem <- struct{}{}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer func() { <-t.sem }()
+		cv, err := t.e.Inner(messageLeaf(data))
+		if err != nil {
+			t.fail(err)
+			return
+		}
+		x.cv = cv
+		t.fold(i, x)
+	}()
+}
+
+// kept reports whether the hop at index i of its level is kept in full: the
+// first hop of a level may be the root, and with kangaroo hopping the first
+// hop of every group is nested in its parent.
+func (t *TreeHash) kept(i int) bool {
+	return i == 0 || t.nested(i)
+}
+
+// nested reports whether the hop at index i of its level is nested in its
+// parent, and so not hashed on its own.
+func (t *TreeHash) nested(i int) bool {
+	return t.e.mode.Kangaroo && i%t.fanout == 0
+}
+
+// fail records err unless an error is already recorded.
+func (t *TreeHash) fail(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = err
+	}
+}
+
+// fold records leaf i and folds the leaves completed in order into the pending
+// groups, hashing every group that is full.
+func (t *TreeHash) fold(i int, x *treeEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done[i] = x
+	for {
+		x, ok := t.done[t.next]
+		if !ok || t.err != nil {
+			return
+		}
+		delete(t.done, t.next)
+		t.next++
+		if err := t.push(0, x); err != nil {
+			t.err = err
+			return
+		}
+	}
+}
+
+// push adds the entry x to the pending group of level l, hashing the group
+// into the level above once it is full.
+func (t *TreeHash) push(l int, x *treeEntry) error {
+	for len(t.levels) <= l {
+		t.levels = append(t.levels, treeLevel{})
+	}
+	lv := &t.levels[l]
+	lv.group = append(lv.group, x)
+	lv.count++
+	if len(lv.group) < t.fanout {
+		return nil
+	}
+	kids := lv.group
+	lv.group = nil
+	i := 0
+	if l+1 < len(t.levels) {
+		i = t.levels[l+1].count
+	}
+	g := &treeEntry{}
+	if t.kept(i) {
+		g.kids = kids
+	}
+	if !t.nested(i) {
+		cv, err := t.e.Inner(t.group(kids))
+		if err != nil {
+			return err
+		}
+		g.cv = cv
+	}
+	return t.push(l+1, g)
+}
+
+// group returns a new chaining hop over the entries of a group.
+func (t *TreeHash) group(group []*treeEntry) Hop {
+	return &chainingLeaves{kids: t.kids(group)}
+}
+
+// kids returns new hops of the entries of a group, the first of which is
+// coded in full if nested.
+func (t *TreeHash) kids(group []*treeEntry) []Hop {
+	hops := make([]Hop, len(group))
+	for i, x := range group {
+		if i == 0 && t.e.mode.Kangaroo {
+			hops[i] = t.hop(x)
+		} else {
+			hops[i] = &storedLeaf{cv: x.cv}
+		}
+	}
+	return hops
+}
+
+// hop returns a new hop of the kept entry x.
+func (t *TreeHash) hop(x *treeEntry) Hop {
+	if x.leaf {
+		return messageLeaf(x.data)
+	}
+	return t.group(x.kids)
+}
+
+// Sum appends the root of the stream written so far to b, waiting for the
+// leaves being hashed. It panics if hashing failed, which leaves held in
+// memory only do if the encoder is misconfigured.
+func (t *TreeHash) Sum(b []byte) []byte {
+	t.wg.Wait()
+	if t.err != nil {
+		panic(t.err)
+	}
+	root, err := t.e.Final(t.root())
+	if err != nil {
+		panic(err)
+	}
+	return append(b, root...)
+}
+
+// root returns the tree of the stream written so far, without changing the
+// state: the pending groups, from the leaves up, each closed under a chaining
+// hop that joins the group of the level above, like the partial groups of
+// BuildTree.
+func (t *TreeHash) root() Hop {
+	top := -1
+	for l, lv := range t.levels {
+		if len(lv.group) > 0 {
+			top = l
+		}
+	}
+	var carry Hop // Hop over the partial group of the level below.
+	if t.buf != nil || t.handed == 0 {
+		carry = messageLeaf(t.buf)
+		if top < 0 {
+			return carry
+		}
+	}
+	for l := 0; ; l++ {
+		group := t.levels[l].group
+		if l == top && carry == nil && len(group) == 1 {
+			return t.hop(group[0])
+		}
+		if len(group) == 0 && carry == nil {
+			continue
+		}
+		kids := t.kids(group)
+		if carry != nil {
+			kids = append(kids, carry)
+		}
+		carry = &chainingLeaves{kids: kids}
+		if l == top {
+			return carry
+		}
+	}
+}
+
+// Reset returns the hash to the empty stream, waiting for the leaves being
+// hashed.
+func (t *TreeHash) Reset() {
+	t.wg.Wait()
+	t.buf, t.handed = nil, 0
+	t.done, t.next, t.levels, t.err = make(map[int]*treeEntry), 0, nil, nil
+}
+
+func (t *TreeHash) Size() int      { return t.size }
+func (t *TreeHash) BlockSize() int { return t.block }
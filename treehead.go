@@ -0,0 +1,175 @@
+package sakura
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrBadSignature is returned when a signed tree head does not verify.
+	ErrBadSignature = errors.New("sakura: invalid tree head signature")
+
+	// ErrUnsupportedKey is returned when verifying with a public key of an
+	// unsupported type.
+	ErrUnsupportedKey = errors.New("sakura: unsupported public key type")
+
+	// ErrMalformed is returned when decoding malformed serialized data.
+	ErrMalformed = errors.New("sakura: malformed data")
+)
+
+// treeHeadDomain prefixes the signed statement of a tree head.
+const treeHeadDomain = "sakura.treehead"
+
+// treeHeadVersion is the version of the serialized tree head.
+const treeHeadVersion = 1
+
+// TreeHead is a statement about the state of an append-only tree: its root
+// after the first Size leaves, as of Timestamp.
+type TreeHead struct {
+	Root      []byte
+	Size      uint64
+	Timestamp time.Time // Kept with millisecond precision.
+}
+
+// statement returns the bytes that are signed for the tree head.
+func (h TreeHead) statement() []byte {
+	b := append([]byte(treeHeadDomain), treeHeadVersion)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(h.Root)))
+	b = append(b, h.Root...)
+	b = binary.BigEndian.AppendUint64(b, h.Size)
+	b = binary.BigEndian.AppendUint64(b, uint64(h.Timestamp.UnixMilli()))
+	return b
+}
+
+// SignedTreeHead is a TreeHead with a signature over it.
+type SignedTreeHead struct {
+	TreeHead
+	Signature []byte
+}
+
+// SignTreeHead signs head with signer. If opts specifies a hash function, the
+// statement is hashed with it before signing, as ECDSA and RSA keys require;
+// otherwise, as for Ed25519 keys, the statement is signed as is. Nil opts
+// stand for crypto.Hash(0), and a *ed25519.Options with SHA-512 signs with
+// Ed25519ph.
+func SignTreeHead(signer crypto.Signer, head TreeHead, opts crypto.SignerOpts) (*SignedTreeHead, error) {
+	head.Timestamp = time.UnixMilli(head.Timestamp.UnixMilli())
+	opts = signerOpts(opts)
+	digest, err := signedDigest(head, opts)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &SignedTreeHead{TreeHead: head, Signature: sig}, nil
+}
+
+// Verify checks the signature of the tree head with pub, which must be an
+// ed25519.PublicKey, *ecdsa.PublicKey or *rsa.PublicKey, using the same opts
+// that were given to SignTreeHead. RSA signatures are PKCS #1 v1.5 unless
+// opts is a *rsa.PSSOptions, and Ed25519 signatures are Ed25519ph only if
+// opts has SHA-512, so that neither verifies as the other.
+func (s *SignedTreeHead) Verify(pub crypto.PublicKey, opts crypto.SignerOpts) error {
+	opts = signerOpts(opts)
+	digest, err := signedDigest(s.TreeHead, opts)
+	if err != nil {
+		return err
+	}
+	ok := false
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		o, isOptions := opts.(*ed25519.Options)
+		if !isOptions {
+			o = &ed25519.Options{Hash: opts.HashFunc()}
+		}
+		ok = ed25519.VerifyWithOptions(k, digest, s.Signature, o) == nil
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, digest, s.Signature)
+	case *rsa.PublicKey:
+		if pss, isPSS := opts.(*rsa.PSSOptions); isPSS {
+			ok = rsa.VerifyPSS(k, opts.HashFunc(), digest, s.Signature, pss) == nil
+		} else {
+			ok = rsa.VerifyPKCS1v15(k, opts.HashFunc(), digest, s.Signature) == nil
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+	if !ok {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// signerOpts returns opts, or crypto.Hash(0) for nil opts, which signers such
+// as ed25519.PrivateKey do not accept.
+func signerOpts(opts crypto.SignerOpts) crypto.SignerOpts {
+	if opts == nil {
+		return crypto.Hash(0)
+	}
+	return opts
+}
+
+// signedDigest returns what is passed to crypto.Signer.Sign for head.
+func signedDigest(head TreeHead, opts crypto.SignerOpts) ([]byte, error) {
+	msg := head.statement()
+	if opts.HashFunc() == 0 {
+		return msg, nil
+	}
+	hf := opts.HashFunc()
+	if !hf.Available() {
+		return nil, errors.New("sakura: signature hash function is not available")
+	}
+	h := hf.New()
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+// MarshalBinary encodes the signed tree head.
+func (s *SignedTreeHead) MarshalBinary() ([]byte, error) {
+	b := s.statement()[len(treeHeadDomain):]
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s.Signature)))
+	return append(b, s.Signature...), nil
+}
+
+// UnmarshalBinary decodes a signed tree head encoded by MarshalBinary. It does
+// not verify the signature. It returns a *DecodeError for malformed input.
+func (s *SignedTreeHead) UnmarshalBinary(data []byte) error {
+	total := len(data)
+	malformed := func(reason string) error {
+		return &DecodeError{Format: "tree head", Offset: int64(total - len(data)), Reason: reason}
+	}
+	if len(data) < 3 {
+		return malformed("unexpected end of input")
+	}
+	if data[0] != treeHeadVersion {
+		return malformed(fmt.Sprintf("unknown version %d", data[0]))
+	}
+	n := int(binary.BigEndian.Uint16(data[1:]))
+	data = data[3:]
+	if len(data) < n+16+2 {
+		return malformed("unexpected end of input")
+	}
+	root := append([]byte(nil), data[:n]...)
+	data = data[n:]
+	size := binary.BigEndian.Uint64(data)
+	ts := int64(binary.BigEndian.Uint64(data[8:]))
+	data = data[16:]
+	m := int(binary.BigEndian.Uint16(data))
+	if len(data) != 2+m {
+		return malformed("signature length does not match")
+	}
+	*s = SignedTreeHead{
+		TreeHead:  TreeHead{Root: root, Size: size, Timestamp: time.UnixMilli(ts)},
+		Signature: append([]byte(nil), data[2:]...),
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package sakura_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chlin501/sakura"
+)
+
+func TestSignTreeHead(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ph := &ed25519.Options{Hash: crypto.SHA512}
+	for _, tc := range []struct {
+		name   string
+		signer crypto.Signer
+		pub    crypto.PublicKey
+		opts   crypto.SignerOpts
+	}{
+		{"Ed25519 nil opts", priv, pub, nil},
+		{"Ed25519", priv, pub, crypto.Hash(0)},
+		{"Ed25519ph", priv, pub, ph},
+		{"ECDSA", ec, &ec.PublicKey, crypto.SHA256},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			head := sakura.TreeHead{Root: sakura.Pattern(32), Size: 7, Timestamp: time.Now()}
+			s, err := sakura.SignTreeHead(tc.signer, head, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Verify(tc.pub, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+			b, err := s.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got sakura.SignedTreeHead
+			if err := got.UnmarshalBinary(b); err != nil {
+				t.Fatal(err)
+			}
+			if err := got.Verify(tc.pub, tc.opts); err != nil {
+				t.Fatalf("decoded tree head: %v", err)
+			}
+			got.Size++
+			if err := got.Verify(tc.pub, tc.opts); !errors.Is(err, sakura.ErrBadSignature) {
+				t.Errorf("other size: got %v, want ErrBadSignature", err)
+			}
+		})
+	}
+
+	// Ed25519 and Ed25519ph signatures do not verify as each other.
+	head := sakura.TreeHead{Root: sakura.Pattern(32), Size: 1}
+	s, err := sakura.SignTreeHead(priv, head, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(pub, ph); !errors.Is(err, sakura.ErrBadSignature) {
+		t.Errorf("Ed25519 signature as Ed25519ph: got %v, want ErrBadSignature", err)
+	}
+	s, err = sakura.SignTreeHead(priv, head, ph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Verify(pub, nil); !errors.Is(err, sakura.ErrBadSignature) {
+		t.Errorf("Ed25519ph signature as Ed25519: got %v, want ErrBadSignature", err)
+	}
+}
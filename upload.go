@@ -0,0 +1,140 @@
+package sakura
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrIncompleteUpload is returned by Upload.Root and Upload.Part while chunks
+// are missing.
+var ErrIncompleteUpload = errors.New("sakura: upload is missing chunks")
+
+// Upload ingests a stream of known size uploaded in chunks that may arrive in
+// any order and concurrently, as the parts of a multipart upload do. Chunks are
+// the leaves of the tree that a Writer with the same leaf size builds for the
+// stream: every chunk but the last has leafSize bytes. Each chunk is hashed as
+// it arrives, on the goroutine that puts it, and only its chaining value is
+// kept, besides the bits of the first chunk, which the final node may hold.
+// The root is computed once the last missing chunk arrives, and equals the
+// root a Writer computes for the whole stream.
+//
+// An Upload is safe for concurrent use.
+type Upload struct {
+	e        *Encoder
+	size     int64
+	leafSize int
+
+	mu      sync.Mutex
+	cvs     [][]byte // Chaining values of the chunks, nil where missing.
+	first   []byte
+	missing int
+	root    []byte
+	err     error
+}
+
+// NewUpload returns an upload of size bytes in chunks of leafSize bytes,
+// hashed with e. A stream of size zero has one chunk, which is empty.
+func NewUpload(e *Encoder, size int64, leafSize int) (*Upload, error) {
+	if leafSize <= 0 || size < 0 {
+		return nil, errors.New("sakura: invalid size or leaf size")
+	}
+	if err := e.checkMode(); err != nil {
+		return nil, err
+	}
+	if err := e.checkStreamSize(size, leafSize); err != nil {
+		return nil, err
+	}
+	n := leafCount(size, leafSize)
+	if max := e.MaxDegree; max > 0 && n > max {
+		return nil, &LimitError{Node: NodeID{}, Limit: "degree", Max: int64(max), Value: int64(n)}
+	}
+	return &Upload{e: e, size: size, leafSize: leafSize, cvs: make([][]byte, n), missing: n}, nil
+}
+
+// Chunks returns the number of chunks of the upload.
+func (u *Upload) Chunks() int { return len(u.cvs) }
+
+// ChunkSize returns the number of bytes of chunk i.
+func (u *Upload) ChunkSize(i int) int {
+	if i == len(u.cvs)-1 {
+		return int(u.size - int64(i)*int64(u.leafSize))
+	}
+	return u.leafSize
+}
+
+// Put hashes chunk i, which must have the size given by ChunkSize, and
+// reports whether it completed the upload. A chunk put again replaces the
+// one put before, until the upload is complete; from then on, chunks are
+// only accepted if they are those of the root. Put does not retain data.
+func (u *Upload) Put(i int, data []byte) (bool, error) {
+	if i < 0 || i >= len(u.cvs) {
+		return false, fmt.Errorf("sakura: chunk %d of an upload of %d chunks", i, len(u.cvs))
+	}
+	if len(data) != u.ChunkSize(i) {
+		return false, fmt.Errorf("sakura: chunk %d has %d bytes instead of %d", i, len(data), u.ChunkSize(i))
+	}
+	j := newJob(u.e)
+	j.leaf = i
+	cv, err := j.serial(messageLeaf(data), NodeID{i}, false, 1)
+	if err != nil {
+		return false, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.missing == 0 {
+		if !bytes.Equal(cv, u.cvs[i]) {
+			return true, &LeafMismatchError{Leaf: i, Range: ByteRange{Off: int64(i) * int64(u.leafSize), Len: int64(len(data))}}
+		}
+		return true, nil
+	}
+	if u.cvs[i] == nil {
+		u.missing--
+	}
+	u.cvs[i] = cv
+	if i == 0 {
+		u.first = append([]byte{}, data...)
+	}
+	if u.missing > 0 {
+		return false, nil
+	}
+	u.root, u.err = u.e.Final(layerTree(u.e.mode, u.cvs, u.first))
+	return true, u.err
+}
+
+// Missing returns the indexes of the chunks not put yet, in increasing order.
+func (u *Upload) Missing() []int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var m []int
+	for i, cv := range u.cvs {
+		if cv == nil {
+			m = append(m, i)
+		}
+	}
+	return m
+}
+
+// Root returns the root of the stream once all chunks are put, and
+// ErrIncompleteUpload before.
+func (u *Upload) Root() ([]byte, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.missing > 0 {
+		return nil, ErrIncompleteUpload
+	}
+	return u.root, u.err
+}
+
+// Part returns the part that the upload holds once all chunks are put, so that
+// it can be merged with others, and ErrIncompleteUpload before.
+func (u *Upload) Part() (*Part, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.missing > 0 {
+		return nil, ErrIncompleteUpload
+	}
+	return &Part{LeafSize: u.leafSize, Size: u.size, Leaves: u.cvs, First: u.first}, nil
+}
@@ -0,0 +1,76 @@
+package sakura
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+)
+
+// VectorFile is a set of test vectors for a hashing mode, in a form that other
+// implementations of Sakura can read to check that they agree with this one.
+// It is written as JSON by WriteVectors.
+//
+// Every vector hashes the first Length bytes of the pattern 0x00, 0x01, ...,
+// 0xFA repeated, that is byte i has the value i % 251. The tree is the
+// two-level shape built by Writer: a single message node for streams of at
+// most one leaf, and otherwise a final chaining node over leaves of LeafSize
+// bytes, the first of which is nested in it with kangaroo hopping.
+type VectorFile struct {
+	Hash      string   `json:"hash"` // Name of the hash function, as given to WriteVectors.
+	Kangaroo  bool     `json:"kangaroo"`
+	Alignment uint8    `json:"alignment"`
+	Mantissa  uint8    `json:"interleave_mantissa"`
+	Exponent  uint8    `json:"interleave_exponent"`
+	Mode      string   `json:"fingerprint"` // Fingerprint of the mode.
+	Pattern   string   `json:"pattern"`
+	Vectors   []Vector `json:"vectors"`
+}
+
+// Vector is a single test vector of a VectorFile.
+type Vector struct {
+	Length   int    `json:"length"`
+	LeafSize int    `json:"leaf_size"`
+	Root     string `json:"root"` // Hexadecimal root.
+}
+
+// Pattern returns the first n bytes of the pattern used by test vectors.
+func Pattern(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i % 251)
+	}
+	return p
+}
+
+// WriteVectors writes a VectorFile for mode to w, with a vector for every
+// length from 0 to maxLen and every one of the given leaf sizes. hashName
+// names the hash function of the mode, for the benefit of readers.
+func WriteVectors(w io.Writer, mode HashingMode, hashName string, maxLen int, leafSizes ...int) error {
+	e := New(mode)
+	if err := e.checkMode(); err != nil {
+		return err
+	}
+	f := VectorFile{
+		Hash:      hashName,
+		Kangaroo:  mode.Kangaroo,
+		Alignment: mode.Alignment,
+		Mantissa:  mode.Interleave.Mantissa,
+		Exponent:  mode.Interleave.Exponent,
+		Mode:      mode.Fingerprint().String(),
+		Pattern:   "i % 251",
+	}
+	data := Pattern(maxLen)
+	for _, leafSize := range leafSizes {
+		for n := 0; n <= maxLen; n++ {
+			sw := NewWriter(e, leafSize)
+			sw.Write(data[:n])
+			if err := sw.Close(); err != nil {
+				return err
+			}
+			f.Vectors = append(f.Vectors, Vector{Length: n, LeafSize: leafSize, Root: hex.EncodeToString(sw.Root())})
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(f)
+}
@@ -0,0 +1,255 @@
+package sakura
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrUnknownFormat is returned by Verifier.Verify for a proof whose format is
+// not registered.
+var ErrUnknownFormat = errors.New("sakura: unknown proof format")
+
+// ProofFormat identifies the format of a proof framed by FrameProof.
+type ProofFormat byte
+
+const (
+	// FormatSakura is a Proof encoded by Proof.MarshalBinary.
+	FormatSakura ProofFormat = iota
+
+	// FormatRFC6962 is an RFC6962Proof, the audit path of a Certificate
+	// Transparency log.
+	FormatRFC6962
+
+	// FormatBitTorrentV2 is a BitTorrentProof, the proof of a block of a file
+	// of a BitTorrent v2 torrent.
+	FormatBitTorrentV2
+
+	// FormatBLAKE2Tree is a BLAKE2TreeProof, the proof of a leaf of a BLAKE2
+	// tree hash.
+	FormatBLAKE2Tree
+)
+
+// FormatVerifier checks that leaf is included in the tree with the given root
+// using proof, the body of a framed proof of its format.
+type FormatVerifier func(root, proof, leaf []byte) error
+
+// FrameProof encodes proof, led by a header byte naming its format, for
+// Verifier.Verify.
+func FrameProof(format ProofFormat, proof encoding.BinaryMarshaler) ([]byte, error) {
+	body, err := proof.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(format)}, body...), nil
+}
+
+// Verifier verifies framed proofs of any of its registered formats, so that a
+// service that accepts proofs from several ecosystems has one entry point for
+// them. A Verifier must not be modified while in use.
+type Verifier struct {
+	// Metrics, if not nil, receives the count of proofs that were rejected.
+	Metrics Metrics
+
+	formats map[ProofFormat]FormatVerifier
+}
+
+// NewVerifier returns a Verifier with the formats of this package registered:
+// FormatSakura proofs checked in mode, FormatRFC6962, FormatBitTorrentV2 and
+// FormatBLAKE2Tree.
+func NewVerifier(mode HashingMode) *Verifier {
+	v := &Verifier{formats: make(map[ProofFormat]FormatVerifier)}
+	v.Register(FormatSakura, func(root, proof, leaf []byte) error {
+		var p Proof
+		if err := p.UnmarshalBinary(proof); err != nil {
+			return err
+		}
+		return VerifyProof(mode, root, &p, leaf)
+	})
+	v.Register(FormatRFC6962, func(root, proof, leaf []byte) error {
+		var p RFC6962Proof
+		if err := p.UnmarshalBinary(proof); err != nil {
+			return err
+		}
+		return VerifyRFC6962(root, &p, leaf)
+	})
+	v.Register(FormatBitTorrentV2, func(root, proof, leaf []byte) error {
+		var p BitTorrentProof
+		if err := p.UnmarshalBinary(proof); err != nil {
+			return err
+		}
+		return VerifyBitTorrent(root, &p, leaf)
+	})
+	v.Register(FormatBLAKE2Tree, func(root, proof, leaf []byte) error {
+		var p BLAKE2TreeProof
+		if err := p.UnmarshalBinary(proof); err != nil {
+			return err
+		}
+		return VerifyBLAKE2TreeProof(root, &p, leaf)
+	})
+	return v
+}
+
+// Register sets the verifier of format, replacing any registered before.
+func (v *Verifier) Register(format ProofFormat, fn FormatVerifier) {
+	v.formats[format] = fn
+}
+
+// Verify checks that leaf is included in the tree with the given root using
+// proof, framed by FrameProof, with the verifier registered for its format.
+func (v *Verifier) Verify(root, proof, leaf []byte) error {
+	err := v.verify(root, proof, leaf)
+	if err != nil && v.Metrics != nil {
+		v.Metrics.Add(MetricVerifyFailures, 1)
+	}
+	return err
+}
+
+func (v *Verifier) verify(root, proof, leaf []byte) error {
+	if len(proof) == 0 {
+		return ErrMalformedProof
+	}
+	fn := v.formats[ProofFormat(proof[0])]
+	if fn == nil {
+		return ErrUnknownFormat
+	}
+	return fn(root, proof[1:], leaf)
+}
+
+// RFC6962Proof is the audit path of a leaf of a Merkle tree of RFC 6962, such
+// as that of a Certificate Transparency log: leaf hashes are SHA-256 of a
+// zero byte and the leaf, and inner hashes SHA-256 of a one byte and the
+// hashes of the children.
+type RFC6962Proof struct {
+	Index int64    // Index of the leaf.
+	Size  int64    // Number of leaves of the tree.
+	Path  [][]byte // Hashes of the siblings on the path, from the leaf up.
+}
+
+// MarshalBinary encodes the proof.
+func (p *RFC6962Proof) MarshalBinary() ([]byte, error) {
+	b := binary.AppendUvarint(nil, uint64(p.Index))
+	b = binary.AppendUvarint(b, uint64(p.Size))
+	b = binary.AppendUvarint(b, uint64(len(p.Path)))
+	for _, h := range p.Path {
+		b = append(b, h...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary.
+func (p *RFC6962Proof) UnmarshalBinary(data []byte) error {
+	d := newDecoder("RFC 6962 proof", data)
+	var q RFC6962Proof
+	q.Index, q.Size = d.int64(), d.int64()
+	q.Path = make([][]byte, d.count())
+	for i := range q.Path {
+		q.Path[i] = d.fixed(sha256.Size)
+	}
+	if err := d.end(); err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
+
+// VerifyRFC6962 checks that leaf is leaf p.Index of the RFC 6962 tree of
+// p.Size leaves with the given root, following the verification of inclusion
+// proofs of RFC 9162, section 2.1.3.2.
+func VerifyRFC6962(root []byte, p *RFC6962Proof, leaf []byte) error {
+	if p.Index < 0 || p.Index >= p.Size {
+		return ErrMalformedProof
+	}
+	fn, sn := p.Index, p.Size-1
+	r := rfc6962Hash(0, leaf, nil)
+	for _, h := range p.Path {
+		if sn == 0 || len(h) != sha256.Size {
+			return ErrMalformedProof
+		}
+		if fn&1 == 1 || fn == sn {
+			r = rfc6962Hash(1, h, r)
+			for fn&1 == 0 && fn != 0 {
+				fn, sn = fn>>1, sn>>1
+			}
+		} else {
+			r = rfc6962Hash(1, r, h)
+		}
+		fn, sn = fn>>1, sn>>1
+	}
+	if sn != 0 {
+		return ErrMalformedProof
+	}
+	return compareRoots(r, root, ErrProofMismatch)
+}
+
+// rfc6962Hash returns the SHA-256 hash of prefix, a and b.
+func rfc6962Hash(prefix byte, a, b []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{prefix})
+	h.Write(a)
+	h.Write(b)
+	return h.Sum(nil)
+}
+
+// BitTorrentBlockSize is the size of the blocks of a file that are the leaves
+// of its BitTorrent v2 Merkle tree. Only the last block of a file may be
+// shorter.
+const BitTorrentBlockSize = 16 << 10
+
+// BitTorrentProof is the proof of a block of a file against the root of its
+// BitTorrent v2 Merkle tree, as of BEP 52: leaf hashes are SHA-256 of the
+// blocks, inner hashes SHA-256 of the hashes of the two children, and the
+// leaves are padded with zero hashes to a power of two.
+type BitTorrentProof struct {
+	Index  int64    // Index of the block in the file.
+	Hashes [][]byte // Hashes of the siblings on the path, from the leaf up.
+}
+
+// MarshalBinary encodes the proof.
+func (p *BitTorrentProof) MarshalBinary() ([]byte, error) {
+	b := binary.AppendUvarint(nil, uint64(p.Index))
+	b = binary.AppendUvarint(b, uint64(len(p.Hashes)))
+	for _, h := range p.Hashes {
+		b = append(b, h...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a proof encoded by MarshalBinary.
+func (p *BitTorrentProof) UnmarshalBinary(data []byte) error {
+	d := newDecoder("BitTorrent proof", data)
+	var q BitTorrentProof
+	q.Index = d.int64()
+	q.Hashes = make([][]byte, d.count())
+	for i := range q.Hashes {
+		q.Hashes[i] = d.fixed(sha256.Size)
+	}
+	if err := d.end(); err != nil {
+		return err
+	}
+	*p = q
+	return nil
+}
+
+// VerifyBitTorrent checks that block is block p.Index of the file whose
+// BitTorrent v2 pieces root is root.
+func VerifyBitTorrent(root []byte, p *BitTorrentProof, block []byte) error {
+	if len(block) == 0 || len(block) > BitTorrentBlockSize || p.Index < 0 || len(p.Hashes) < 63 && p.Index>>len(p.Hashes) != 0 {
+		return ErrMalformedProof
+	}
+	r := sha256.Sum256(block)
+	i := p.Index
+	for _, h := range p.Hashes {
+		if len(h) != sha256.Size {
+			return ErrMalformedProof
+		}
+		if i&1 == 0 {
+			r = sha256.Sum256(append(r[:], h...))
+		} else {
+			r = sha256.Sum256(append(h[:len(h):len(h)], r[:]...))
+		}
+		i >>= 1
+	}
+	return compareRoots(r[:], root, ErrProofMismatch)
+}
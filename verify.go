@@ -0,0 +1,109 @@
+package sakura
+
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+// ErrRootMismatch is returned by Verify and VerifyBytes when the data does not
+// hash to the expected root.
+var ErrRootMismatch = errors.New("sakura: root does not match")
+
+// ErrNeedsData is returned by LayerRoot and VerifyLayer when the root of a
+// stream cannot be computed from its leaf layer alone.
+var ErrNeedsData = errors.New("sakura: root depends on leaf data")
+
+// Verify hashes hop as a final node in mode and checks that the result equals
+// root. The comparison takes time independent of the contents of the roots,
+// so that it does not reveal how much of a forged root was right.
+func Verify(mode HashingMode, root []byte, hop Hop) error {
+	got, err := New(mode).Final(hop)
+	if err != nil {
+		return err
+	}
+	return compareRoots(got, root, ErrRootMismatch)
+}
+
+// VerifyBytes checks that data hashes to root in mode, in the shape of the
+// hash returned by NewHash, with the same care as Verify.
+func VerifyBytes(mode HashingMode, root, data []byte) error {
+	if mode.Hash == nil {
+		return ErrNoHash
+	}
+	w := NewWriter(New(mode), DefaultLeafSize)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return compareRoots(w.Root(), root, ErrRootMismatch)
+}
+
+// LayerRoot returns the root in mode of a stream in the shape of Writer, given
+// only its leaf layer, the chaining values of its leaves concatenated in order
+// as returned by Encoder.LeafHashes. No message data is needed, so a server can
+// check a piece table sent by a client before receiving any of the pieces.
+//
+// This is only possible for streams of more than one leaf without kangaroo
+// hopping. Otherwise the final node holds the bits of the first leaf, and
+// LayerRoot fails with ErrNeedsData. It returns a *DecodeError if the length of
+// the layer is not a positive multiple of the hash size.
+func LayerRoot(mode HashingMode, layer []byte) ([]byte, error) {
+	cvs, err := splitLayer(mode, layer)
+	if err != nil {
+		return nil, err
+	}
+	if len(cvs) == 1 || mode.Kangaroo {
+		return nil, ErrNeedsData
+	}
+	return New(mode).Final(layerTree(mode, cvs, nil))
+}
+
+// VerifyLayer checks that the leaf layer hashes to root in mode as computed by
+// LayerRoot, with the same care as Verify.
+func VerifyLayer(mode HashingMode, root, layer []byte) error {
+	got, err := LayerRoot(mode, layer)
+	if err != nil {
+		return err
+	}
+	return compareRoots(got, root, ErrRootMismatch)
+}
+
+// splitLayer splits a leaf layer into the chaining values of its leaves.
+func splitLayer(mode HashingMode, layer []byte) ([][]byte, error) {
+	if mode.Hash == nil {
+		return nil, ErrNoHash
+	}
+	size := mode.Hash().Size()
+	if len(layer) == 0 || len(layer)%size != 0 {
+		return nil, &DecodeError{Format: "leaf layer", Offset: int64(len(layer) - len(layer)%size), Reason: "length is not a multiple of the hash size"}
+	}
+	cvs := make([][]byte, 0, len(layer)/size)
+	for off := 0; off < len(layer); off += size {
+		cvs = append(cvs, layer[off:off+size:off+size])
+	}
+	return cvs, nil
+}
+
+// layerTree returns the tree of a stream in the shape of Writer with the given
+// chaining values of its leaves, and first as the bits of the first leaf where
+// they are coded in the final node instead: for a single leaf or with kangaroo
+// hopping.
+func layerTree(mode HashingMode, cvs [][]byte, first []byte) Hop {
+	var leaves []Hop
+	if len(cvs) == 1 || mode.Kangaroo {
+		leaves = append(leaves, messageLeaf(first))
+	}
+	for _, cv := range cvs[len(leaves):] {
+		leaves = append(leaves, &storedLeaf{cv: cv})
+	}
+	return sequentialTree(leaves)
+}
+
+// compareRoots returns mismatch unless got and want are equal, in time that
+// only depends on their lengths.
+func compareRoots(got, want []byte, mismatch error) error {
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return mismatch
+	}
+	return nil
+}
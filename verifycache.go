@@ -0,0 +1,111 @@
+package sakura
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+)
+
+// VerifyCache remembers the results of verifying inclusion proofs, so that a
+// service that verifies the same proofs over and over, such as one checking
+// the proofs attached to requests it serves, recomputes each one only once.
+//
+// Results are keyed by the fingerprint of the mode, the root, a SHA-256 digest
+// of the leaf and the encoded proof, and the least recently used results are
+// evicted beyond the size of the cache. Verification is deterministic, so
+// failures are remembered as well. A VerifyCache is safe for concurrent use.
+type VerifyCache struct {
+	// Metrics, if not nil, receives the counts of hits, misses and failed
+	// verifications, remembered or not. It must not be changed while the
+	// cache is in use.
+	Metrics Metrics
+
+	size int
+
+	mu      sync.Mutex
+	entries map[verifyKey]*list.Element
+	lru     list.List // Of *verifyEntry, most recently used first.
+}
+
+// verifyKey is the SHA-256 digest of what a verification depends on.
+type verifyKey [sha256.Size]byte
+
+type verifyEntry struct {
+	key verifyKey
+	err error
+}
+
+// NewVerifyCache returns a cache of up to size results. It panics if size is
+// not positive.
+func NewVerifyCache(size int) *VerifyCache {
+	if size <= 0 {
+		panic("sakura: invalid verify cache size")
+	}
+	return &VerifyCache{size: size, entries: make(map[verifyKey]*list.Element)}
+}
+
+// Len returns the number of results in c.
+func (c *VerifyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// VerifyProof is like the function VerifyProof, but returns the remembered
+// result if the same proof was verified before.
+func (c *VerifyCache) VerifyProof(mode HashingMode, root []byte, proof *Proof, leaf []byte) error {
+	if mode.Hash == nil {
+		return ErrNoHash
+	}
+	enc, err := proof.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	fp := mode.Fingerprint()
+	leafSum := sha256.Sum256(leaf)
+	h := sha256.New()
+	h.Write(fp[:])
+	h.Write(binary.AppendUvarint(nil, uint64(len(root))))
+	h.Write(root)
+	h.Write(leafSum[:])
+	h.Write(enc)
+	var key verifyKey
+	h.Sum(key[:0])
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		err := el.Value.(*verifyEntry).err
+		c.mu.Unlock()
+		c.count(MetricVerifyCacheHits, err)
+		return err
+	}
+	c.mu.Unlock()
+
+	err = VerifyProof(mode, root, proof, leaf)
+	c.count(MetricVerifyCacheMisses, err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.lru.PushFront(&verifyEntry{key: key, err: err})
+		if c.lru.Len() > c.size {
+			last := c.lru.Back()
+			c.lru.Remove(last)
+			delete(c.entries, last.Value.(*verifyEntry).key)
+		}
+	}
+	return err
+}
+
+// count reports a lookup of the given outcome, and a failure if err is not
+// nil, to the metrics of c.
+func (c *VerifyCache) count(lookup string, err error) {
+	if c.Metrics == nil {
+		return
+	}
+	c.Metrics.Add(lookup, 1)
+	if err != nil {
+		c.Metrics.Add(MetricVerifyFailures, 1)
+	}
+}
@@ -0,0 +1,120 @@
+package sakura
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// VerifyingWriter passes the data written to it on to a destination while
+// hashing it, and fails Close with ErrRootMismatch unless the root of all of
+// it is the expected one, which gives safe-download semantics in one type. The
+// destination sees the data before it is verified, so a VerifyingWriter
+// created with CreateVerified, which writes to a temporary file and only
+// renames it into place once the root matches, is what keeps unverified data
+// from being seen.
+type VerifyingWriter struct {
+	dst    io.Writer
+	w      *Writer
+	root   []byte
+	tmp    *os.File // Temporary file of CreateVerified, if any.
+	path   string   // Path the temporary file is renamed to.
+	err    error
+	closed bool
+}
+
+// NewVerifyingWriter returns a writer passing data on to dst whose root, as
+// computed by a Writer with e and the given leaf size, must be root. It panics
+// if leafSize is not positive.
+func NewVerifyingWriter(e *Encoder, leafSize int, root []byte, dst io.Writer) *VerifyingWriter {
+	return &VerifyingWriter{dst: dst, w: NewWriter(e, leafSize), root: root}
+}
+
+// CreateVerified returns a VerifyingWriter for the file at path, which it only
+// creates, replacing any file there, once Close has verified the data. Until
+// then the data goes to a temporary file in the same directory, which Close
+// and Abort remove unless it is renamed to path.
+func CreateVerified(e *Encoder, leafSize int, root []byte, path string) (*VerifyingWriter, error) {
+	if leafSize <= 0 {
+		return nil, errors.New("sakura: non-positive leaf size")
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	v := NewVerifyingWriter(e, leafSize, root, f)
+	v.tmp, v.path = f, path
+	return v, nil
+}
+
+// Write writes p to the destination and hashes it.
+func (v *VerifyingWriter) Write(p []byte) (int, error) {
+	if v.closed {
+		return 0, ErrClosed
+	}
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err := v.dst.Write(p)
+	if err == nil {
+		_, err = v.w.Write(p[:n])
+	}
+	v.err = err
+	return n, err
+}
+
+// Close verifies the root of the data written, and closes the destination if
+// it is an io.Closer. It returns ErrRootMismatch if the root is not the
+// expected one. With CreateVerified, the file is synced and renamed into place
+// only if the root matches.
+func (v *VerifyingWriter) Close() error {
+	if v.closed {
+		return v.err
+	}
+	v.closed = true
+	err := v.err
+	if err == nil {
+		err = v.w.Close()
+	}
+	if err == nil {
+		err = compareRoots(v.w.Root(), v.root, ErrRootMismatch)
+	}
+	if v.tmp != nil {
+		if err == nil {
+			err = v.tmp.Sync()
+		}
+		if cerr := v.tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(v.tmp.Name(), v.path)
+		}
+		if err != nil {
+			os.Remove(v.tmp.Name())
+		}
+	} else if c, ok := v.dst.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	v.err = err
+	return err
+}
+
+// Abort ends the stream without verifying it. With CreateVerified, the
+// temporary file is removed and no file is created at the path.
+func (v *VerifyingWriter) Abort() error {
+	if v.closed {
+		return v.err
+	}
+	v.closed, v.err = true, ErrClosed
+	if v.tmp != nil {
+		v.tmp.Close()
+		return os.Remove(v.tmp.Name())
+	}
+	if c, ok := v.dst.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
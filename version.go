@@ -0,0 +1,124 @@
+package sakura
+
+import (
+	"fmt"
+	"io"
+)
+
+// Format is a serialized artifact of this package. Every artifact records the
+// version of its format, so that data stored by one version of the package
+// is read by later ones, or refused, rather than misread.
+type Format int
+
+const (
+	FormatTree        Format = iota // Tree files of Encoder.WriteTree.
+	FormatProof                     // Proofs of Proof.MarshalBinary.
+	FormatRangeProof                // Proofs of RangeProof.MarshalBinary.
+	FormatProofBatch                // Batches of ProofBatch.MarshalBinary.
+	FormatCheckpoint                // Checkpoints of Writer.Checkpoint.
+	FormatModeHeader                // Mode headers of EncodeModeHeader.
+	FormatProgress                  // Progress of Progress.MarshalBinary.
+	FormatMMRPeaks                  // Peaks of MMR.MarshalPeaks.
+	FormatWitnessFile               // Files of WitnessFile.
+	FormatTreeHead                  // Tree heads of SignedTreeHead.MarshalBinary.
+
+	FormatConsistencyProof // Proofs of ConsistencyProof.MarshalBinary.
+)
+
+// formats describes every Format: its name, the versions that are read, oldest
+// first, of which the last is written, and the bytes that precede the version.
+var formats = [...]struct {
+	name     string
+	versions []byte
+	magic    string
+}{
+	FormatTree:        {"tree file", []byte{1, treeVersion}, treeMagic},
+	FormatProof:       {"proof", []byte{1, proofVersion}, ""},
+	FormatRangeProof:  {"range proof", []byte{rangeProofVersion}, ""},
+	FormatProofBatch:  {"proof batch", []byte{proofBatchVersion}, ""},
+	FormatCheckpoint:  {"checkpoint", []byte{1, checkpointVersion}, ""},
+	FormatModeHeader:  {"mode header", []byte{1, modeHeaderVersion}, ""},
+	FormatProgress:    {"progress", []byte{progressVersion}, ""},
+	FormatMMRPeaks:    {"mmr peaks", []byte{mmrVersion}, ""},
+	FormatWitnessFile: {"witness file", []byte{witnessVersion}, witnessMagic},
+	FormatTreeHead:    {"tree head", []byte{treeHeadVersion}, ""},
+
+	FormatConsistencyProof: {"consistency proof", []byte{consistencyVersion}, ""},
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	if f < 0 || int(f) >= len(formats) {
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+	return formats[f].name
+}
+
+// Versions returns the versions of the format that this package reads, oldest
+// first. The last is the version that it writes, so that peers exchanging
+// artifacts can settle on the newest version both read.
+func (f Format) Versions() []byte {
+	if f < 0 || int(f) >= len(formats) {
+		return nil
+	}
+	return append([]byte(nil), formats[f].versions...)
+}
+
+// Version returns the version of the artifact of the format at the start of
+// data, so that a caller can tell before decoding it whether it is read in
+// place or must be migrated first. It returns a *DecodeError if data does not
+// begin as an artifact of the format, or is of a version not read.
+func (f Format) Version(data []byte) (byte, error) {
+	vs := f.Versions()
+	if vs == nil {
+		return 0, fmt.Errorf("sakura: unknown format %d", int(f))
+	}
+	d := newDecoder(f.String(), data)
+	if magic := formats[f].magic; magic != "" {
+		if m := d.fixed(len(magic)); d.err == nil && string(m) != magic {
+			d.fail("not a " + f.String())
+		}
+	}
+	v := d.versions(vs[0], vs[len(vs)-1])
+	return v, d.err
+}
+
+// MigrateTree reads a tree file written by WriteTree, of any version that
+// ReadTree reads, for the mode of e, within DefaultDecodeLimits, and writes
+// it to w in the current version, so that long-lived trees are upgraded once
+// rather than read through the readers of old versions forever.
+func (e *Encoder) MigrateTree(w io.Writer, r io.Reader) error {
+	hop, err := ReadTree(r, e.mode)
+	if err != nil {
+		return err
+	}
+	return e.WriteTree(w, hop)
+}
+
+// MigrateProof returns the encoding in the current version of the proof in
+// data, encoded by Proof.MarshalBinary of any version, for a tree hashed in
+// mode. Proofs of version 1 record no mode, and those whose mode header is of
+// version 1 no fingerprint; they are given the header of mode once the
+// parameters they do record are checked against it, failing with
+// ErrModeMismatch otherwise. A proof migrated for the wrong hash function
+// then fails verification against its root.
+func MigrateProof(data []byte, mode HashingMode) ([]byte, error) {
+	if mode.Hash == nil {
+		return nil, ErrNoHash
+	}
+	p, err := DefaultDecodeLimits.UnmarshalProof(data)
+	if err != nil {
+		return nil, err
+	}
+	h := mode.Header()
+	if got := p.Mode; got != (ModeHeader{}) {
+		if got.Fingerprint == (Fingerprint{}) {
+			got.Fingerprint = h.Fingerprint
+		}
+		if got != h {
+			return nil, ErrModeMismatch
+		}
+	}
+	p.Mode = h
+	return p.MarshalBinary()
+}
@@ -0,0 +1,78 @@
+package sakura
+
+import "errors"
+
+var (
+	// SkipChildren is used as a return value from a pre-order WalkFunc to
+	// indicate that the children of the hop are to be skipped.
+	SkipChildren = errors.New("sakura: skip children")
+
+	// SkipAll is used as a return value from a WalkFunc to indicate that the
+	// rest of the tree is to be skipped. Walk then returns nil.
+	SkipAll = errors.New("sakura: skip all")
+)
+
+// WalkFunc is the type of the functions called by Walk for each hop.
+//
+// The id argument identifies hop within the tree and is empty for the root.
+// Walk reuses its backing array, so it must be copied to be retained.
+type WalkFunc func(id NodeID, hop Hop) error
+
+// Walk traverses the tree rooted at hop, calling pre before visiting the
+// children of each hop and post after. Either may be nil. Children are visited
+// in index order.
+//
+// If pre returns SkipChildren, the children of the hop are skipped but post is
+// still called for it. If either function returns SkipAll, Walk stops and
+// returns nil. Any other error stops the walk and is returned. Walk reads no
+// message bits and ignores chaining values. A hop that is its own ancestor
+// stops the walk with a *CycleError.
+func Walk(hop Hop, pre, post WalkFunc) error {
+	err := walk(NodeID{}, hop, make(ancestors), pre, post)
+	if err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walk(id NodeID, hop Hop, path ancestors, pre, post WalkFunc) error {
+	chaining, err := isChaining(hop)
+	if err != nil {
+		return err
+	}
+	if err := path.enter(hop, id); err != nil {
+		return err
+	}
+	defer path.exit(hop)
+	skip := false
+	if pre != nil {
+		switch err := pre(id, hop); err {
+		case nil:
+		case SkipChildren:
+			skip = true
+		default:
+			return err
+		}
+	}
+	if chaining && !skip {
+		n, err := degree(hop, id)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			c, err := child(hop, id, i)
+			if err != nil {
+				return err
+			}
+			if err := walk(append(id, i), c, path, pre, post); err != nil {
+				return err
+			}
+		}
+	}
+	if post != nil {
+		if err := post(id, hop); err != nil && err != SkipChildren {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package sakura
+
+import "fmt"
+
+// WarningKind classifies a Warning.
+type WarningKind string
+
+const (
+	// WarnSmallLeaves is a leaf size below the block size of the hash
+	// function, so that most of every block of a leaf is padding and the
+	// chaining values take more hashing than the data.
+	WarnSmallLeaves WarningKind = "small-leaves"
+
+	// WarnAlignment is an alignment that does not divide the block size of
+	// the hash function, so that aligned nodes still straddle its blocks.
+	WarnAlignment WarningKind = "alignment"
+
+	// WarnFanout is a fanout whose nodes hold more than a MiB of chaining
+	// values, which are buffered whole while they are hashed.
+	WarnFanout WarningKind = "fanout"
+)
+
+// maxNodeValues is the size of the chaining values of a node beyond which a
+// fanout is reported.
+const maxNodeValues = 1 << 20
+
+// Warning describes a configuration that is legal but probably a mistake,
+// since it hashes much slower or takes much more memory than a nearby one.
+type Warning struct {
+	Kind    WarningKind
+	Message string
+}
+
+func (w Warning) String() string { return "sakura: " + w.Message }
+
+// Warnings returns the warnings about hashing trees of template t in mode.
+// A zero t, as for a mode on its own, is not checked.
+func Warnings(mode HashingMode, t Template) []Warning {
+	if mode.Hash == nil {
+		return nil
+	}
+	var ws []Warning
+	h := mode.Hash()
+	if a := int(mode.Alignment); a > 1 && mode.Kangaroo && h.BlockSize()%a != 0 {
+		ws = append(ws, Warning{WarnAlignment, fmt.Sprintf("alignment of %d bytes does not divide the hash block size of %d", a, h.BlockSize())})
+	}
+	if t.LeafSize > 0 && t.LeafSize < h.BlockSize() {
+		ws = append(ws, Warning{WarnSmallLeaves, fmt.Sprintf("leaf size of %d bytes is below the hash block size of %d", t.LeafSize, h.BlockSize())})
+	}
+	for _, f := range t.Fanouts {
+		if f > maxNodeValues/h.Size() {
+			ws = append(ws, Warning{WarnFanout, fmt.Sprintf("fanout %d codes %d bytes of chaining values per node", f, f*h.Size())})
+			break
+		}
+	}
+	return ws
+}
+
+// warn passes the warnings about t to the encoder's Warn function, if any.
+func (e *Encoder) warn(t Template) {
+	if e.Warn == nil {
+		return
+	}
+	for _, w := range Warnings(e.mode, t) {
+		e.Warn(w)
+	}
+}
@@ -0,0 +1,229 @@
+package sakura
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// WitnessStore holds the witnesses of the leaves of a tree that a client is to
+// update or prove later: the proof of each leaf, which holds the chaining
+// values of its siblings, instead of the whole tree, from which UpdateLeaf
+// computes the new root of a change and brings all the witnesses up to date.
+type WitnessStore interface {
+	// Witness returns the proof stored for leaf, or an error matching
+	// fs.ErrNotExist if there is none. The caller may modify the proof.
+	Witness(leaf NodeID) (*Proof, error)
+
+	// PutWitnesses stores proofs, replacing those of the same leaves. It
+	// must not retain them. Since the proofs of one tree only hold
+	// together, a store must store all of them or none.
+	PutWitnesses(proofs ...*Proof) error
+
+	// WitnessLeaves returns the IDs of the leaves whose proofs are stored,
+	// in tree order.
+	WitnessLeaves() ([]NodeID, error)
+}
+
+// UpdateLeaf changes the message bits of the leaf whose ID is id, and whose
+// proof is in store, from old to leaf in the tree with the given root, hashed
+// in mode, and returns the new root, as ReRoot does. The proofs of all other
+// leaves in store are brought up to date with it and stored again along with
+// that of the changed leaf.
+func UpdateLeaf(store WitnessStore, mode HashingMode, root []byte, id NodeID, old, leaf []byte) ([]byte, error) {
+	proof, err := store.Witness(id)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := store.WitnessLeaves()
+	if err != nil {
+		return nil, err
+	}
+	others := make([]*Proof, 0, len(ids))
+	for _, o := range ids {
+		if o.Equal(id) {
+			continue
+		}
+		p, err := store.Witness(o)
+		if err != nil {
+			return nil, err
+		}
+		others = append(others, p)
+	}
+	sum, err := ReRoot(mode, root, proof, old, leaf, others...)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.PutWitnesses(append(others, proof)...); err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// The witness file format stores the proofs of a WitnessFile. All integers
+// are unsigned varints as written by binary.AppendUvarint.
+//
+//	file    ::= magic version count proof*
+//	magic   ::= "SKWT"
+//	version ::= 0x01
+//	proof   ::= length bytes, as written by Proof.MarshalBinary
+//
+// Proofs are listed in the tree order of their leaves, one per leaf.
+const (
+	witnessMagic   = "SKWT"
+	witnessVersion = 1
+)
+
+// WitnessFile is a WitnessStore in a flat file. The proofs are held in memory,
+// encoded, and the file is replaced atomically by every PutWitnesses, so that
+// a crash leaves either the witnesses from before the call or those from
+// after. It is safe for concurrent use, but only one WitnessFile may use a
+// given file at a time.
+type WitnessFile struct {
+	path   string
+	mu     sync.Mutex
+	proofs map[string]witnessEntry
+}
+
+// witnessEntry is a proof of a WitnessFile.
+type witnessEntry struct {
+	leaf NodeID
+	b    []byte // Encoded proof.
+}
+
+// OpenWitnessFile returns the WitnessFile at path, reading the proofs in the
+// file if it exists. Otherwise the store is empty, and the file is created
+// by the first PutWitnesses. Proofs are decoded within DefaultDecodeLimits,
+// and a damaged file fails with a *DecodeError.
+func OpenWitnessFile(path string) (*WitnessFile, error) {
+	f := &WitnessFile{path: path, proofs: make(map[string]witnessEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := newDecoder("witness file", data)
+	if magic := d.fixed(len(witnessMagic)); d.err == nil && string(magic) != witnessMagic {
+		d.fail("not a witness file")
+	}
+	d.version(witnessVersion)
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		b := d.bytes()
+		if d.err != nil {
+			break
+		}
+		off := int64(d.size - len(d.b) - len(b))
+		p, err := DefaultDecodeLimits.UnmarshalProof(b)
+		if err != nil {
+			if e, ok := err.(*DecodeError); ok {
+				e.Offset += off
+			}
+			return nil, err
+		}
+		key := p.Leaf.String()
+		if _, ok := f.proofs[key]; ok {
+			d.fail(fmt.Sprintf("second proof of leaf %v", p.Leaf))
+			break
+		}
+		f.proofs[key] = witnessEntry{leaf: p.Leaf, b: b}
+	}
+	if err := d.end(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Witness implements WitnessStore.
+func (f *WitnessFile) Witness(leaf NodeID) (*Proof, error) {
+	f.mu.Lock()
+	e, ok := f.proofs[leaf.String()]
+	f.mu.Unlock()
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return DefaultDecodeLimits.UnmarshalProof(e.b)
+}
+
+// WitnessLeaves implements WitnessStore.
+func (f *WitnessFile) WitnessLeaves() ([]NodeID, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.leaves(), nil
+}
+
+// leaves returns the IDs of the leaves of f in tree order.
+func (f *WitnessFile) leaves() []NodeID {
+	ids := make([]NodeID, 0, len(f.proofs))
+	for _, e := range f.proofs {
+		ids = append(ids, append(NodeID(nil), e.leaf...))
+	}
+	slices.SortFunc(ids, compareIDs)
+	return ids
+}
+
+// PutWitnesses implements WitnessStore. If writing the file fails, f is left
+// as it was.
+func (f *WitnessFile) PutWitnesses(proofs ...*Proof) error {
+	entries := make([]witnessEntry, len(proofs))
+	for i, p := range proofs {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		entries[i] = witnessEntry{leaf: append(NodeID(nil), p.Leaf...), b: b}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := make(map[string]witnessEntry, len(entries))
+	for _, e := range entries {
+		key := e.leaf.String()
+		if _, ok := old[key]; !ok {
+			old[key] = f.proofs[key]
+		}
+		f.proofs[key] = e
+	}
+	if err := f.write(); err != nil {
+		for key, e := range old {
+			if e.b == nil {
+				delete(f.proofs, key)
+			} else {
+				f.proofs[key] = e
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// write replaces the file of f with its proofs.
+func (f *WitnessFile) write() error {
+	b := append([]byte(witnessMagic), witnessVersion)
+	b = binary.AppendUvarint(b, uint64(len(f.proofs)))
+	for _, id := range f.leaves() {
+		b = appendBytes(b, f.proofs[id.String()].b)
+	}
+
+	t, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(t.Name())
+	_, err = t.Write(b)
+	if err == nil {
+		err = t.Sync()
+	}
+	if cerr := t.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(t.Name(), f.path)
+}
@@ -0,0 +1,397 @@
+package sakura
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+var (
+	// ErrClosed is returned when writing to a closed Writer.
+	ErrClosed = errors.New("sakura: write to closed writer")
+
+	// ErrNoData is returned when reading the message bits of a leaf whose
+	// data is no longer held, only its chaining value.
+	ErrNoData = errors.New("sakura: leaf data is not available")
+)
+
+// Writer hashes a stream of bytes as it is written. The stream is cut into
+// leaves of a fixed size, each of which is hashed as an inner node as soon as
+// it is complete. Close hashes the final node, whose chaining hop holds the
+// chaining values of all leaves in order, and makes the root available
+// through Root.
+//
+// A stream of at most one leaf is hashed as a single final node containing the
+// message. In particular, a Writer closed without any writes returns the root
+// of the empty message, the hash of the final node '11' that Encoder.Final
+// returns for an empty message hop. With kangaroo hopping, the first leaf is
+// nested in the final node instead of being hashed on its own. This two-level
+// shape is the one used by KangarooTwelve.
+//
+// Completed leaves are hashed in the background on up to the encoder's
+// Parallelism goroutines, at least one, so that writing overlaps with hashing.
+// The writer never holds more than one leaf per worker besides the leaves it
+// fills: when all workers are busy, Write blocks until one is free, or, once
+// SetNonBlocking is called, returns ErrWouldBlock.
+type Writer struct {
+	e           *Encoder
+	pool        *leafPool
+	leafSize    int
+	first       []byte // Data of the first leaf, until it is known not to be alone.
+	buf         []byte // Data of the leaf being filled, once past the first.
+	pooled      bool   // Whether buf comes from the encoder's Buffers.
+	leaves      int    // Number of leaves started.
+	written     int64  // Number of bytes written.
+	nonBlocking bool
+	deadline    time.Time
+	handed      int  // Number of leaves handed to the pool or kept to be nested.
+	closing     bool // Whether Close has started.
+	root        []byte
+	err         error
+	closed      bool
+	ck          *checkpoint
+	parity      *parityCoder
+}
+
+// NewWriter returns a Writer that hashes with e, cutting the stream into
+// leaves of leafSize bytes. It panics if leafSize is not positive.
+func NewWriter(e *Encoder, leafSize int) *Writer {
+	if leafSize <= 0 {
+		panic("sakura: non-positive leaf size")
+	}
+	e.warn(Template{LeafSize: leafSize})
+	return &Writer{e: e, pool: newLeafPool(e), leafSize: leafSize}
+}
+
+// SetNonBlocking sets whether Write returns ErrWouldBlock instead of waiting
+// when a leaf is complete and all hashing workers are busy. The error then
+// comes with the number of bytes of p that were consumed, and the rest of p
+// may be written again later. Close always waits.
+func (w *Writer) SetNonBlocking(nonBlocking bool) { w.nonBlocking = nonBlocking }
+
+// SetDeadline sets the time after which Write, Close and Checkpoint stop
+// waiting for hashing workers and fail with os.ErrDeadlineExceeded, which
+// reports itself as a timeout like the errors of net.Conn deadlines. Once the
+// deadline has passed, these methods fail immediately until it is extended or
+// cleared with the zero time. A timeout does not harm the writer: Write returns
+// the number of bytes consumed, and all calls may be made again later.
+func (w *Writer) SetDeadline(t time.Time) { w.deadline = t }
+
+// LeafFunc is called by a Writer with the index, offset, length and chaining
+// value of every leaf of the stream once it is hashed.
+type LeafFunc func(leaf int, off int64, n int, cv []byte)
+
+// SetLeafFunc makes w call fn for every leaf of the stream, so that indexes
+// and piece tables can be built in the same pass as the root. The chaining
+// values are those returned by Encoder.LeafHashes: every leaf is hashed as an
+// inner node, including a first leaf that kangaroo hopping nests in the final
+// node and the single leaf of a short stream, which costs hashing that leaf
+// twice. Calls come from the hashing goroutines one at a time, in the order in
+// which the leaves complete, which need not be the order of the stream; the
+// last ones are made before Close returns. fn must not call methods of w and
+// must not modify cv. SetLeafFunc must be called before the first Write.
+func (w *Writer) SetLeafFunc(fn LeafFunc) {
+	w.pool.onLeaf, w.pool.leafSize = fn, w.leafSize
+}
+
+// SetParity makes w add parity leaves of the code p to the tree, so that up to
+// p.Parity damaged leaves per stripe can be reconstructed with
+// Parity.Reconstruct. It must be called before the first Write. The final node
+// then gets one more child after the leaves, a chaining hop over a header leaf
+// coding p and the leaf size, followed by the parity leaves, which
+// distinguishes the root from that of the same stream without parity. The
+// parity leaves are available from ParityLeaves once Close has succeeded. A
+// writer with parity cannot save checkpoints.
+func (w *Writer) SetParity(p Parity) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	if w.leaves > 0 || w.closing || w.closed {
+		return errors.New("sakura: parity set after writing")
+	}
+	if w.pool.spillMax > 0 {
+		return errors.New("sakura: parity leaves cannot be spilled")
+	}
+	if w.pool.final != nil {
+		return errors.New("sakura: a streaming writer holds no parity")
+	}
+	w.parity = newParityCoder(p, w.leafSize)
+	return nil
+}
+
+// ParityLeaves returns the parity leaves of the stream once Close has
+// succeeded on a writer with parity, and nil otherwise.
+func (w *Writer) ParityLeaves() [][]byte {
+	if w.parity == nil || w.Root() == nil {
+		return nil
+	}
+	return w.parity.finish()
+}
+
+// expired reports whether the deadline of w has passed.
+func (w *Writer) expired() bool {
+	return !w.deadline.IsZero() && !time.Now().Before(w.deadline)
+}
+
+// Write hashes p. It fails after a leaf failed to hash, once the writer is
+// closed, if the writer does not block and all workers are busy, when its
+// deadline passes, with a *LimitError or a *NodeSizeError and none of p
+// written if p would take the stream beyond the MaxBytes, MaxLeaves or
+// MaxNodeBytes of the encoder, or if a checkpoint set by SetCheckpoint cannot
+// be saved, in which case all of p has been hashed nonetheless.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed || w.closing {
+		return 0, ErrClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.expired() {
+		return 0, os.ErrDeadlineExceeded
+	}
+	if err := w.e.checkStreamSize(w.written+int64(len(p)), w.leafSize); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if w.leaves == 0 {
+			w.leaves = 1
+		}
+		cur := &w.buf
+		if w.leaves == 1 {
+			cur = &w.first
+		}
+		if len(*cur) == w.leafSize {
+			// The current leaf is full and more data follows.
+			if err := w.flush(!w.nonBlocking); err != nil {
+				if err == ErrWouldBlock || err == os.ErrDeadlineExceeded {
+					return n - len(p), err
+				}
+				w.err = err
+				return 0, err
+			}
+			w.leaves++
+			cur = &w.buf
+		}
+		if *cur == nil && w.leaves > 1 && w.e.Buffers != nil {
+			w.buf, w.pooled = w.e.Buffers.get(w.leafSize)[:0], true
+		}
+		k := min(w.leafSize-len(*cur), len(p))
+		*cur = append(*cur, p[:k]...)
+		p = p[k:]
+		w.written += int64(k)
+	}
+	return n, w.maybeCheckpoint()
+}
+
+// flush hands the current leaf, which is followed by more data, to a hashing
+// worker, waiting for one to be free if wait is set. The first leaf is kept
+// when it is nested in the final node.
+func (w *Writer) flush(wait bool) error {
+	if w.handed == w.leaves {
+		return nil
+	}
+	data := w.buf
+	if w.leaves == 1 {
+		data = w.first
+	}
+	if w.parity != nil && w.parity.n < w.leaves {
+		w.parity.add(data)
+	}
+	if w.leaves == 1 && w.e.mode.Kangaroo && w.pool.onLeaf == nil {
+		w.handed = 1
+		w.pool.nested = true
+		w.pool.begin(w.first)
+		return nil
+	}
+	if err := w.pool.hash(w.leaves-1, data, w.leaves > 1 && w.pooled, wait, w.deadline); err != nil {
+		return err
+	}
+	w.handed = w.leaves
+	if w.leaves == 1 && w.e.mode.Kangaroo {
+		w.pool.begin(w.first)
+	}
+	// The worker holds on to the data, so the next leaf needs a new buffer.
+	w.buf, w.pooled = nil, false
+	return nil
+}
+
+// Close hashes the final node. The root is then available from Root. If Close
+// fails with os.ErrDeadlineExceeded, it may be called again to finish.
+func (w *Writer) Close() (err error) {
+	if w.closed {
+		return w.err
+	}
+	defer func() {
+		if w.closed {
+			if rerr := w.pool.removeSpill(); rerr != nil && w.err == nil {
+				w.root, w.err, err = nil, rerr, rerr
+			}
+		}
+	}()
+	if w.err != nil {
+		w.closed = true
+		return w.err
+	}
+	if w.expired() {
+		return os.ErrDeadlineExceeded
+	}
+	if err := w.pool.unpaused(true, w.deadline); err != nil {
+		return w.closeErr(err)
+	}
+	w.closing = true
+	if w.leaves <= 1 && w.pool.onLeaf != nil {
+		// The single leaf is only hashed for the leaf function.
+		if w.handed == 0 {
+			if err := w.pool.hash(0, w.first, false, true, w.deadline); err != nil {
+				return w.closeErr(err)
+			}
+			w.handed = 1
+		}
+		if _, err := w.pool.wait(w.deadline); err != nil {
+			return w.closeErr(err)
+		}
+	}
+	var leaves []Hop
+	if w.leaves <= 1 || w.e.mode.Kangaroo {
+		leaves = append(leaves, messageLeaf(w.first))
+	}
+	if w.leaves > 1 {
+		if err := w.flush(true); err != nil {
+			return w.closeErr(err)
+		}
+		cvs, err := w.pool.wait(w.deadline)
+		if err != nil {
+			return w.closeErr(err)
+		}
+		if w.pool.final != nil {
+			w.closed = true
+			w.root = w.pool.finalRoot()
+			return nil
+		}
+		if w.pool.spill != nil {
+			w.closed = true
+			var first Hop
+			if w.e.mode.Kangaroo {
+				first = leaves[0]
+			}
+			w.root, w.err = w.e.finalSerial(w.pool.spilledTree(w.leaves, first, cvs))
+			return w.err
+		}
+		if w.e.mode.Kangaroo {
+			cvs = cvs[1:]
+		}
+		for _, cv := range cvs {
+			leaves = append(leaves, &storedLeaf{cv: cv})
+		}
+	}
+	w.closed = true
+	var root Hop
+	if w.parity != nil {
+		if w.parity.n < max(w.leaves, 1) {
+			w.parity.add(w.first)
+		}
+		leaves = append(leaves, parityHop(w.parity.p, w.leafSize, w.parity.finish()))
+		root = &chainingLeaves{kids: leaves}
+	} else {
+		root = sequentialTree(leaves)
+	}
+	w.root, w.err = w.e.Final(root)
+	if w.err == nil && w.ck != nil {
+		if err := os.Remove(w.ck.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return w.err
+}
+
+// sum returns the root of the stream written so far without closing w, so that
+// more data may follow. The last leaf is hashed along with the final node.
+func (w *Writer) sum() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	var leaves []Hop
+	if w.leaves <= 1 || w.e.mode.Kangaroo {
+		leaves = append(leaves, messageLeaf(w.first))
+	}
+	if w.leaves > 1 {
+		cvs, err := w.pool.wait(time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		first := 0
+		if w.e.mode.Kangaroo {
+			first = 1
+		}
+		for i := first; i < w.handed; i++ {
+			leaves = append(leaves, &storedLeaf{cv: cvs[i]})
+		}
+		if w.handed < w.leaves {
+			leaves = append(leaves, messageLeaf(w.buf))
+		}
+	}
+	return w.e.Final(sequentialTree(leaves))
+}
+
+// closeErr returns err as the result of Close, which it records unless err is
+// a timeout, after which Close may be called again.
+func (w *Writer) closeErr(err error) error {
+	if err != os.ErrDeadlineExceeded {
+		w.closed, w.err = true, err
+	}
+	return err
+}
+
+// Root returns the root hash of the stream once Close has succeeded, and nil
+// before.
+func (w *Writer) Root() []byte {
+	if !w.closed || w.err != nil {
+		return nil
+	}
+	return w.root
+}
+
+// sequentialTree returns the tree of the two-level shape used by Writer over
+// the given leaves: the single leaf itself, or a chaining hop over all leaves.
+func sequentialTree(leaves []Hop) Hop {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	return &chainingLeaves{kids: leaves}
+}
+
+// bytesLeaf is a message hop that reads from a byte slice.
+type bytesLeaf struct {
+	*bytes.Reader
+	cv []byte
+}
+
+func messageLeaf(p []byte) *bytesLeaf {
+	return &bytesLeaf{Reader: bytes.NewReader(p)}
+}
+
+func (l *bytesLeaf) ChainingValue() []byte        { return l.cv }
+func (l *bytesLeaf) SetChainingValue(hash []byte) { l.cv = hash }
+
+// storedLeaf is a leaf of which only the chaining value is known.
+type storedLeaf struct {
+	cv []byte
+}
+
+func (l *storedLeaf) ChainingValue() []byte        { return l.cv }
+func (l *storedLeaf) SetChainingValue(hash []byte) { l.cv = hash }
+func (l *storedLeaf) Read([]byte) (int, error)     { return 0, ErrNoData }
+
+// chainingLeaves is a chaining hop over a list of hops.
+type chainingLeaves struct {
+	kids []Hop
+	cv   []byte
+}
+
+func (c *chainingLeaves) Child(i int) Hop              { return c.kids[i] }
+func (c *chainingLeaves) Degree() int                  { return len(c.kids) }
+func (c *chainingLeaves) ChainingValue() []byte        { return c.cv }
+func (c *chainingLeaves) SetChainingValue(hash []byte) { c.cv = hash }